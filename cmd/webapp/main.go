@@ -6,22 +6,27 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"simple-forum/internal/auth"
 	"simple-forum/internal/config"
-	"simple-forum/internal/database"
+	"simple-forum/internal/events"
 	"simple-forum/internal/handler"
+	"simple-forum/internal/metrics"
 	"simple-forum/internal/middleware"
-	"simple-forum/internal/repository"
+	"simple-forum/internal/notify"
 	"simple-forum/internal/service"
+	"simple-forum/internal/telemetry"
 	"simple-forum/internal/template"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/justinas/nosurf"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func main() {
@@ -37,46 +42,168 @@ func run() error {
 		return err
 	}
 
-	// Migrate
-	err = database.Migrate(cfg.DB.Addr, cfg.Path.ToMigrations)
+	// Tracing
+	shutdownTracing, err := telemetry.Setup(context.Background(), telemetry.Config{
+		ServiceName: cfg.Tracing.ServiceName,
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(ctx)
+	}()
+
+	// Logger
+	l := slog.New(telemetry.NewLogHandler(slog.NewJSONHandler(os.Stdout, nil)))
+
+	// Storage
+	store, err := openStorage(l, cfg)
 	if err != nil {
 		return err
 	}
+	defer store.close()
+
+	// Authenticator
+	keyring := auth.NewKeyring()
+	if cfg.JWT.KeysPath != "" {
+		keyring, err = auth.LoadKeyring(cfg.JWT.KeysPath)
+		if err != nil {
+			return fmt.Errorf("failed to load signing keys: %w", err)
+		}
+	}
 
-	// Connection
-	conn, err := database.Connect(cfg.DB.Addr)
+	err = keyring.Add(auth.NewHMACKey(auth.DefaultKeyID, cfg.JWT.Secret))
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
-	// Logger
-	l := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	err = keyring.SetActive(cfg.JWT.ActiveKey)
+	if err != nil {
+		return fmt.Errorf("failed to activate signing key %q: %w", cfg.JWT.ActiveKey, err)
+	}
 
-	// Authenticator
-	a := auth.NewJWTAuthenticator(cfg.JWT.Secret, cfg.JWT.Expiration)
+	var legacyUntil time.Time
+	if cfg.JWT.LegacyUntil != "" {
+		legacyUntil, err = time.Parse(time.RFC3339, cfg.JWT.LegacyUntil)
+		if err != nil {
+			return fmt.Errorf("failed to parse JWT_LEGACY_TOKENS_UNTIL: %w", err)
+		}
+	}
+
+	a := auth.NewJWTAuthenticator(cfg.JWT.Secret, cfg.JWT.Expiration).
+		WithKeyring(keyring).
+		WithClaims(cfg.JWT.Issuer, cfg.JWT.Audience, time.Duration(cfg.JWT.Leeway)*time.Second).
+		WithLegacyTokens(legacyUntil)
+
+	// Identity provider
+	var idp handler.IdentityProvider
+	if cfg.OIDC.Issuer != "" {
+		discoveryCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		idp, err = auth.NewOIDCProvider(discoveryCtx, auth.OIDCConfig{
+			Name:         cfg.OIDC.Name,
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       cfg.OIDC.Scopes,
+			GroupsClaim:  cfg.OIDC.GroupsClaim,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to discover identity provider: %w", err)
+		}
+	}
 
 	// Templates
 	t, err := template.NewTemplates(cfg.Path.ToTemplates, cfg.InProd, a)
 	if err != nil {
 		return fmt.Errorf("failed to create templates: %w", err)
 	}
+	t.WithBaseURL(cfg.Server.BaseURL)
 
-	// Repository
-	postRepository := repository.NewPostRepository(conn)
-	topicRepository := repository.NewTopicRepository(conn)
-	userRepository := repository.NewUserRepository(conn)
+	// Blobs
+	blobs, err := openBlobs(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open blob store: %w", err)
+	}
+
+	// Notifier
+	var notifier service.Notifier = notify.NewLogNotifier(l)
+	if cfg.SMTP.Addr != "" {
+		notifier = notify.NewSMTPNotifier(cfg.SMTP.Addr, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.From)
+	}
 
 	// Service
-	postService := service.NewPostService(postRepository)
-	topicService := service.NewTopicService(topicRepository)
-	userService := service.NewUserService(userRepository)
+	postService := service.NewPostService(store.posts, store.tx, store.bus).
+		WithMentions(store.mentions, store.users).
+		WithNotifier(l, notifier, store.topics, cfg.Server.BaseURL)
+	topicService := service.NewTopicService(store.topics, store.tx, store.bus)
+	loginGuard := service.NewLoginGuard(l, store.throttles, notifier, service.LoginPolicy{
+		Window:           time.Duration(cfg.Login.WindowMinutes) * time.Minute,
+		DelayAfter:       cfg.Login.DelayAfter,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Duration(cfg.Login.MaxDelaySeconds) * time.Second,
+		AccountLockAfter: cfg.Login.AccountLockAfter,
+		IPLockAfter:      cfg.Login.IPLockAfter,
+		LockDuration:     time.Duration(cfg.Login.LockMinutes) * time.Minute,
+	})
+
+	// failures that no longer count are dropped once per window
+	purgeCtx, cancelPurge := context.WithCancel(context.Background())
+	defer cancelPurge()
+	go loginGuard.Run(purgeCtx, time.Duration(cfg.Login.WindowMinutes)*time.Minute)
+
+	userService := service.NewUserService(store.users, store.identities, service.IdentityPolicy{
+		AutoProvision: cfg.OIDC.AutoProvision,
+		RoleMapping:   cfg.OIDC.RoleMapping,
+	}, loginGuard, store.tx)
+	tokenService := service.NewTokenService(store.tokens, store.users)
+	profileService := service.NewProfileService(store.profiles, store.users, blobs).WithMaxAvatarBytes(cfg.Avatar.MaxBytes)
+	attachmentService := service.NewAttachmentService(l, store.attachments, blobs, store.tx).
+		WithMaxBytes(cfg.Attachment.MaxBytes).
+		WithQuota(cfg.Attachment.QuotaBytes).
+		WithTypes(cfg.Attachment.Types)
+	auditService := service.NewAuditService(store.audit)
+	messageService := service.NewMessageService(store.messages, store.blocks, store.users, store.tx).
+		WithMaxParticipants(cfg.Messages.MaxParticipants)
+	t.WithUnreadCounter(messageService)
+
+	// Deleting a post detaches its attachments; the instance that deleted
+	// it removes them right away, and every instance looks for any left now
+	// and then
+	collectCtx, cancelCollect := context.WithCancel(context.Background())
+	defer cancelCollect()
+	go attachmentService.Run(collectCtx, time.Duration(cfg.Attachment.CollectMinutes)*time.Minute)
+	events.Handle(store.bus, func(ctx context.Context, _ events.PostDeleted) {
+		if !events.Remote(ctx) {
+			attachmentService.Wake()
+		}
+	})
+	events.Handle(store.bus, func(ctx context.Context, _ events.TopicDeleted) {
+		if !events.Remote(ctx) {
+			attachmentService.Wake()
+		}
+	})
 
 	// Handlers
 	hh := handler.NewHomeHandler(l, t)
-	ph := handler.NewPostHandler(l, a, t, postService, topicService)
-	th := handler.NewTopicHandler(l, a, t, postService, topicService)
-	uh := handler.NewUserHandler(l, a, t, userService)
+	ph := handler.NewPostHandler(l, a, t, postService, topicService, profileService, attachmentService, auditService)
+	th := handler.NewTopicHandler(l, a, t, topicService, auditService)
+	uh := handler.NewUserHandler(l, a, t, userService, idp, auditService)
+	kh := handler.NewKeyHandler(l, a)
+	prh := handler.NewProfileHandler(l, a, t, profileService, postService, topicService, auditService)
+	tkh := handler.NewTokenHandler(l, t, tokenService, auditService)
+	lh := handler.NewLockoutHandler(l, t, loginGuard, auditService)
+	ah := handler.NewAuditHandler(l, t, auditService)
+	fh := handler.NewFeedHandler(l, postService, topicService, cfg.Server.BaseURL)
+	sh := handler.NewSitemapHandler(l, postService, topicService, cfg.Server.BaseURL)
+	mh := handler.NewMessageHandler(l, t, messageService, auditService)
 
 	// Mux
 	mux := http.NewServeMux()
@@ -87,8 +214,35 @@ func run() error {
 	adminMiddleware := middleware.PermissionMiddleware(l, postService, "admin")
 	authorMiddleware := middleware.PermissionMiddleware(l, postService, "author")
 	sharedMiddleware := middleware.PermissionMiddleware(l, postService, "admin", "author")
-	authMiddleware := middleware.AuthMiddleware(a)
+	authMiddleware := middleware.AuthMiddleware(a, tokenService)
+	readScope := middleware.ScopeMiddleware(service.ScopeRead)
+	postScope := middleware.ScopeMiddleware(service.ScopePost)
+	sessionOnly := middleware.SessionMiddleware()
 	loggingMiddleware := middleware.LoggingMiddleware(l)
+	realIPMiddleware := middleware.RealIPMiddleware(cfg.Server.TrustProxy)
+	requestIDMiddleware := middleware.RequestIDMiddleware()
+	bodyLimitMiddleware := middleware.BodyLimitMiddleware(cfg.Server.MaxBodyBytes)
+	metricsMiddleware := middleware.MetricsMiddleware()
+
+	// Rate limits
+	limiter := middleware.NewRateLimiter(time.Duration(cfg.RateLimit.IdleSeconds) * time.Second)
+	defer limiter.Close()
+
+	strictPolicy := middleware.RatePolicy{
+		Rate:  cfg.RateLimit.StrictPerMinute / 60,
+		Burst: cfg.RateLimit.StrictBurst,
+	}
+	loginLimit := middleware.RateLimitMiddleware(limiter, withPolicy(strictPolicy, "login", middleware.KeyByIP))
+	signupLimit := middleware.RateLimitMiddleware(limiter, withPolicy(strictPolicy, "signup", middleware.KeyByIP))
+	postLimit := middleware.RateLimitMiddleware(limiter, withPolicy(strictPolicy, "post", middleware.KeyByUser))
+	avatarLimit := middleware.RateLimitMiddleware(limiter, withPolicy(strictPolicy, "avatar", middleware.KeyByUser))
+	messageLimit := middleware.RateLimitMiddleware(limiter, withPolicy(strictPolicy, "message", middleware.KeyByUser))
+	relaxedLimit := middleware.RateLimitMiddleware(limiter, middleware.RatePolicy{
+		Name:  "global",
+		Rate:  cfg.RateLimit.RelaxedPerSecond,
+		Burst: cfg.RateLimit.RelaxedBurst,
+		Key:   middleware.KeyByIP,
+	})
 
 	// ToStatic
 	fileserver := http.FileServer(http.Dir(filepath.ToSlash(cfg.Path.ToStatic)))
@@ -100,20 +254,55 @@ func run() error {
 
 	// User
 	mux.HandleFunc("GET /login", uh.GetLogin)
-	mux.HandleFunc("POST /login", uh.PostLogin)
+	mux.HandleFunc("POST /login", loginLimit(http.HandlerFunc(uh.PostLogin)))
+	mux.HandleFunc("GET /login/oidc", uh.GetOIDCLogin)
+	mux.HandleFunc("GET /login/oidc/callback", uh.GetOIDCCallback)
 	mux.HandleFunc("GET /logout", uh.GetLogout)
 	mux.HandleFunc("GET /signup", uh.GetRegister)
-	mux.HandleFunc("POST /signup", uh.PostRegister)
+	mux.HandleFunc("POST /signup", signupLimit(http.HandlerFunc(uh.PostRegister)))
+
+	// Keys
+	mux.HandleFunc("GET /.well-known/jwks.json", kh.GetJWKS)
 
 	// Post
 	mux.HandleFunc("GET /topics/{topicID}/posts/{postID}", ph.GetPost)
-	authMux.HandleFunc("GET /topics/{topicID}/posts/new", ph.GetCreatePost)
-	authMux.HandleFunc("POST /posts", ph.PostCreatePost)
-	authMux.HandleFunc("GET /posts/{postID}/edit", authorMiddleware(http.HandlerFunc(ph.GetEditPost)))
-	authMux.HandleFunc("POST /posts/{postID}/edit", authorMiddleware(http.HandlerFunc(ph.PostEditPost)))
-	authMux.HandleFunc("GET /posts/{postID}/delete", sharedMiddleware(http.HandlerFunc(ph.GetDeletePost)))
-
-	mux.Handle("/user/", http.StripPrefix("/user", authMiddleware(authMux))) // grouping
+	authMux.HandleFunc("GET /topics/{topicID}/posts/new", readScope(http.HandlerFunc(ph.GetCreatePost)))
+	authMux.HandleFunc("POST /posts", postScope(postLimit(http.HandlerFunc(ph.PostCreatePost))))
+	authMux.HandleFunc("GET /posts/{postID}/edit", readScope(authorMiddleware(http.HandlerFunc(ph.GetEditPost))))
+	authMux.HandleFunc("POST /posts/{postID}/edit", postScope(authorMiddleware(http.HandlerFunc(ph.PostEditPost))))
+	authMux.HandleFunc("GET /posts/{postID}/delete", postScope(sharedMiddleware(http.HandlerFunc(ph.GetDeletePost))))
+	authMux.HandleFunc("GET /usernames", readScope(http.HandlerFunc(uh.GetUsernames))) // ?prefix= for completing mentions
+	mux.HandleFunc("GET /attachments/{attachmentID}/{name}", ph.GetAttachment)
+
+	// Account
+	authMux.HandleFunc("GET /account", sessionOnly(http.HandlerFunc(tkh.GetAccount)))
+	authMux.HandleFunc("POST /account/tokens", sessionOnly(http.HandlerFunc(tkh.PostCreateToken)))
+	authMux.HandleFunc("POST /account/tokens/{tokenID}/revoke", sessionOnly(http.HandlerFunc(tkh.PostRevokeToken)))
+
+	// Profile
+	mux.HandleFunc("GET /users/{username}", prh.GetProfile)
+	authMux.HandleFunc("GET /profile", sessionOnly(http.HandlerFunc(prh.GetEditProfile)))
+	authMux.HandleFunc("POST /profile", sessionOnly(http.HandlerFunc(prh.PostEditProfile)))
+	authMux.HandleFunc("POST /profile/avatar", sessionOnly(avatarLimit(http.HandlerFunc(prh.PostAvatar))))
+	authMux.HandleFunc("POST /profile/avatar/delete", sessionOnly(http.HandlerFunc(prh.PostDeleteAvatar)))
+	mux.HandleFunc("GET /avatars/{file}", prh.GetAvatar)               // {userID}-{version}-{size}.jpg
+	mux.HandleFunc("GET /avatars/identicons/{file}", prh.GetIdenticon) // {userID}-{size}.png
+
+	// Messages
+	authMux.HandleFunc("GET /messages", sessionOnly(http.HandlerFunc(mh.GetMessages)))
+	authMux.HandleFunc("GET /messages/new", sessionOnly(http.HandlerFunc(mh.GetNewConversation)))
+	authMux.HandleFunc("POST /messages", sessionOnly(messageLimit(http.HandlerFunc(mh.PostNewConversation))))
+	authMux.HandleFunc("GET /messages/{conversationID}", sessionOnly(http.HandlerFunc(mh.GetConversation)))
+	authMux.HandleFunc("POST /messages/{conversationID}", sessionOnly(messageLimit(http.HandlerFunc(mh.PostReply))))
+	authMux.HandleFunc("POST /messages/{conversationID}/mute", sessionOnly(http.HandlerFunc(mh.PostMute)))
+	authMux.HandleFunc("POST /messages/{conversationID}/leave", sessionOnly(http.HandlerFunc(mh.PostLeave)))
+	authMux.HandleFunc("POST /messages/blocks", sessionOnly(http.HandlerFunc(mh.PostBlock)))
+	authMux.HandleFunc("POST /messages/blocks/{userID}/delete", sessionOnly(http.HandlerFunc(mh.PostUnblock)))
+	adminMux.HandleFunc("GET /messaging", mh.GetMessagingBans)
+	adminMux.HandleFunc("POST /messaging/bans", mh.PostMessagingBan)
+	adminMux.HandleFunc("POST /messaging/bans/{userID}/delete", mh.PostLiftMessagingBan)
+
+	mux.Handle("/user/", http.StripPrefix("/user", authMiddleware(middleware.RouteMiddleware("/user")(authMux)))) // grouping
 
 	// Topic
 	mux.HandleFunc("GET /topics", th.GetTopics)
@@ -124,12 +313,95 @@ func run() error {
 	adminMux.HandleFunc("POST /topics/{topicID}/edit", th.PostEditTopic)
 	adminMux.HandleFunc("GET /topics/{topicID}/delete", th.GetDeleteTopic)
 
-	mux.Handle("/admin/", http.StripPrefix("/admin", authMiddleware(adminMiddleware(adminMux)))) // grouping
+	// Feeds
+	mux.HandleFunc("GET /feeds/latest.atom", fh.GetLatest)
+	mux.HandleFunc("GET /feeds/latest.rss", fh.GetLatest)
+	mux.HandleFunc("GET /feeds/topics/{feed}", fh.GetTopic) // {topicID}.atom or {topicID}.rss
+
+	// Crawlers
+	mux.HandleFunc("GET /robots.txt", sh.GetRobots)
+	mux.HandleFunc("GET /sitemap.xml", sh.GetSitemap)
+	mux.HandleFunc("GET /sitemaps/{file}", sh.GetSitemapFile) // {n}.xml when sitemap.xml is an index
+
+	// Lockouts
+	adminMux.HandleFunc("GET /lockouts", lh.GetLockouts)
+	adminMux.HandleFunc("POST /lockouts/clear", lh.PostClearLockout)
+
+	// Audit
+	adminMux.HandleFunc("GET /audit", ah.GetAudit)
+	adminMux.HandleFunc("GET /audit.csv", ah.GetAuditCSV)
+
+	mux.Handle("/admin/", http.StripPrefix("/admin", authMiddleware(adminMiddleware(middleware.RouteMiddleware("/admin")(adminMux))))) // grouping
+
+	// Metrics
+	var metricsServer *http.Server
+	switch {
+	case cfg.Metrics.Addr != "":
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", metrics.Handler(cfg.Metrics.Token))
+		metricsServer = &http.Server{
+			Addr:              cfg.Metrics.Addr,
+			Handler:           metricsMux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+	case cfg.Metrics.Token != "":
+		mux.Handle("GET /metrics", metrics.Handler(cfg.Metrics.Token))
+	default:
+		l.Warn("Metrics are disabled, set METRICS_ADDR or METRICS_TOKEN to expose them")
+	}
+
+	// Replicas; clients read from the primary for as long as a replica may
+	// lag behind their last write
+	var appHandler http.Handler = mux
+	if store.replicas != nil {
+		appHandler = middleware.PrimaryMiddleware(time.Duration(cfg.DB.ReplicaMaxLag) * time.Second)(mux)
+	}
+
+	// CSRF
+	csrfHandler := nosurf.New(requestIDMiddleware(realIPMiddleware(loggingMiddleware(metricsMiddleware(relaxedLimit(middleware.RouteMiddleware("")(appHandler)))))))
+	// token requests never use the cookie, see AuthMiddleware
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") != ""
+	})
+
+	// Tracing; spans are renamed to the route pattern by RouteMiddleware.
+	// Bodies are limited before the CSRF check, which parses forms
+	tracingHandler := otelhttp.NewHandler(bodyLimitMiddleware(csrfHandler), "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !strings.HasPrefix(r.URL.Path, "/static/") && r.URL.Path != "/metrics"
+		}),
+	)
+
+	// Health; probes bypass CSRF, rate limiting, logging and tracing
+	health := handler.NewHealthHandler(l, 2*time.Second, append(store.checks,
+		handler.HealthCheck{Name: "templates", Check: func(context.Context) error {
+			if !t.Loaded() {
+				return errors.New("template cache is empty")
+			}
+			return nil
+		}},
+	)...)
+
+	rootMux := http.NewServeMux()
+	rootMux.HandleFunc("GET /healthz", health.GetHealthz)
+	rootMux.HandleFunc("GET /readyz", health.GetReadyz)
+	rootMux.Handle("/", tracingHandler)
+
+	// Requests derive their context from baseCtx, so cancelling it aborts
+	// the queries of requests still running when shutdown gives up waiting.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
 
 	// Server
 	server := &http.Server{
-		Addr:         ":" + cfg.Server.Port,
-		Handler:      nosurf.New(loggingMiddleware(mux)),
+		Addr:    ":" + cfg.Server.Port,
+		Handler: rootMux,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
 		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout) * time.Second,
@@ -138,20 +410,41 @@ func run() error {
 	// Listening
 	l.Info("Starting server on port: " + server.Addr)
 
+	if metricsServer != nil {
+		l.Info("Serving metrics on: " + metricsServer.Addr)
+
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				l.Error("Metrics server failed", "error", err)
+			}
+		}()
+	}
+
 	done := make(chan bool)
 
 	go func() {
-		signs := make(chan os.Signal)
+		signs := make(chan os.Signal, 1)
 		signal.Notify(signs, syscall.SIGINT, syscall.SIGTERM)
 
 		<-signs
 		l.Info("Shutting down server gracefully")
 
+		// fail readiness first and give load balancers time to notice
+		health.Drain()
+		time.Sleep(time.Duration(cfg.Server.DrainSeconds) * time.Second)
+
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 
 		if err = server.Shutdown(shutdownCtx); err != nil {
 			l.Error("Server forced to shutdown", "error", err)
+			cancelBase()
+		}
+
+		if metricsServer != nil {
+			if err = metricsServer.Shutdown(shutdownCtx); err != nil {
+				l.Error("Metrics server forced to shutdown", "error", err)
+			}
 		}
 
 		done <- true
@@ -166,3 +459,9 @@ func run() error {
 
 	return nil
 }
+
+func withPolicy(policy middleware.RatePolicy, name string, key middleware.RateKey) middleware.RatePolicy {
+	policy.Name = name
+	policy.Key = key
+	return policy
+}
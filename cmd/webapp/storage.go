@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"simple-forum/internal/blob"
+	"simple-forum/internal/cache"
+	"simple-forum/internal/config"
+	"simple-forum/internal/database"
+	"simple-forum/internal/events"
+	"simple-forum/internal/handler"
+	"simple-forum/internal/metrics"
+	"simple-forum/internal/repository"
+	"simple-forum/internal/repository/memory"
+	"simple-forum/internal/service"
+	"time"
+)
+
+// storage is the backend the services run on, chosen by DB_ADDR.
+type storage struct {
+	posts       service.PostStorage
+	topics      service.TopicStorage
+	users       service.UserStorage
+	identities  service.IdentityStorage
+	tokens      service.TokenStorage
+	profiles    service.ProfileStorage
+	attachments service.AttachmentStorage
+	mentions    service.MentionStorage
+	messages    service.MessageStorage
+	blocks      service.BlockStorage
+	throttles   service.ThrottleStorage
+	audit       service.AuditStorage
+	tx          service.Transactor
+
+	// bus carries the events of the services, to the other instances too
+	// when they share a PostgreSQL database
+	bus events.Bus
+	// replicas take the read-only queries when configured
+	replicas *database.ReplicaSet
+	// checks are the readiness checks of the backend
+	checks []handler.HealthCheck
+	close  func() error
+}
+
+func openStorage(l *slog.Logger, cfg *config.Config) (*storage, error) {
+	var store *storage
+	if cfg.DBDriver() == config.DriverMemory {
+		store = openMemory()
+	} else {
+		var err error
+		store, err = openSQL(l, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Cache.Size > 0 {
+		c := cache.New(l, cfg.Cache.Size, time.Duration(cfg.Cache.TTLSeconds)*time.Second).
+			WithChannel(cache.NewEventChannel(store.bus))
+		if store.replicas != nil {
+			c = c.WithDelayedInvalidation(time.Duration(cfg.DB.ReplicaMaxLag) * time.Second)
+		}
+
+		store.topics = cache.NewTopicStorage(store.topics, c)
+		store.posts = cache.NewPostStorage(store.posts, c)
+		store.tx = cache.NewTransactor(store.tx, c)
+	}
+
+	return store, nil
+}
+
+// openMemory keeps everything in process memory, for demos and end-to-end
+// tests; nothing survives a restart.
+func openMemory() *storage {
+	store := memory.NewStore()
+
+	return &storage{
+		posts:       memory.NewPostRepository(store),
+		topics:      memory.NewTopicRepository(store),
+		users:       memory.NewUserRepository(store),
+		identities:  memory.NewIdentityRepository(store),
+		tokens:      memory.NewTokenRepository(store),
+		profiles:    memory.NewProfileRepository(store),
+		attachments: memory.NewAttachmentRepository(store),
+		mentions:    memory.NewMentionRepository(store),
+		messages:    memory.NewMessageRepository(store),
+		blocks:      memory.NewBlockRepository(store),
+		throttles:   memory.NewThrottleRepository(store),
+		audit:       memory.NewAuditRepository(store),
+		tx:          memory.NewTransactor(store),
+		bus:         events.NewLocalBus(),
+		close:       func() error { return nil },
+	}
+}
+
+// openSQL connects to PostgreSQL or SQLite; both run the same
+// repositories.
+func openSQL(l *slog.Logger, cfg *config.Config) (*storage, error) {
+	driver := cfg.DBDriver()
+
+	// Migrate
+	err := database.Migrate(driver, cfg.DB.Addr, cfg.Path.ToMigrations)
+	if err != nil {
+		return nil, err
+	}
+
+	expectedVersion, err := database.ExpectedVersion(driver, cfg.Path.ToMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	// Connection
+	poolConfig := database.PoolConfig{
+		MaxConns:          cfg.DB.MaxConns,
+		MinConns:          cfg.DB.MinConns,
+		MaxConnLifetime:   time.Duration(cfg.DB.MaxConnLifetime) * time.Minute,
+		MaxConnIdleTime:   time.Duration(cfg.DB.MaxConnIdleTime) * time.Minute,
+		HealthCheckPeriod: time.Duration(cfg.DB.HealthCheckPeriod) * time.Second,
+		StatementCache:    cfg.DB.StatementCacheSize,
+	}
+
+	conn, pool, err := database.Connect(driver, cfg.DB.Addr, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	closers := []func() error{func() error {
+		err := conn.Close()
+		if pool != nil {
+			pool.Close()
+		}
+		return err
+	}}
+	closeConn := func() error {
+		var err error
+		for i := len(closers) - 1; i >= 0; i-- {
+			if closeErr := closers[i](); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}
+
+	err = metrics.RegisterDB(conn, "forum")
+	if err == nil && pool != nil {
+		err = metrics.RegisterPool(pool, "forum")
+	}
+	if err != nil {
+		_ = closeConn()
+		return nil, err
+	}
+
+	replicas, err := openReplicas(l, cfg, poolConfig)
+	if err != nil {
+		_ = closeConn()
+		return nil, err
+	}
+	if replicas != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		go replicas.Run(ctx, time.Duration(cfg.DB.ReplicaCheckPeriod)*time.Second)
+		closers = append(closers, func() error {
+			cancel()
+			return replicas.Close()
+		})
+	}
+
+	var bus events.Bus = events.NewLocalBus()
+	if pool != nil {
+		pgBus := events.NewPostgresBus(l, pool, cfg.Events.Channel)
+		ctx, cancel := context.WithCancel(context.Background())
+		go pgBus.Run(ctx)
+		closers = append(closers, func() error {
+			cancel()
+			return nil
+		})
+		bus = pgBus
+	}
+
+	queryTimeout := time.Duration(cfg.DB.QueryTimeout) * time.Millisecond
+
+	topics := repository.NewTopicRepository(conn, queryTimeout).WithReplicas(replicas)
+	if pool != nil {
+		topics = topics.WithPool(pool)
+	}
+
+	return &storage{
+		posts:       repository.NewPostRepository(conn, queryTimeout).WithReplicas(replicas),
+		topics:      topics,
+		users:       repository.NewUserRepository(conn, queryTimeout),
+		identities:  repository.NewIdentityRepository(conn, queryTimeout),
+		tokens:      repository.NewTokenRepository(conn, queryTimeout),
+		profiles:    repository.NewProfileRepository(conn, queryTimeout),
+		attachments: repository.NewAttachmentRepository(conn, queryTimeout),
+		mentions:    repository.NewMentionRepository(conn, queryTimeout),
+		messages:    repository.NewMessageRepository(conn, queryTimeout),
+		blocks:      repository.NewBlockRepository(conn, queryTimeout),
+		throttles:   repository.NewThrottleRepository(conn, queryTimeout),
+		audit:       repository.NewAuditRepository(conn, queryTimeout).WithReplicas(replicas),
+		tx:          repository.NewTransactor(conn, cfg.DB.TxRetries),
+		bus:         bus,
+		replicas:    replicas,
+		checks: []handler.HealthCheck{
+			{Name: "database", Check: conn.PingContext},
+			{Name: "migrations", Check: func(ctx context.Context) error {
+				return database.CheckVersion(ctx, conn, expectedVersion)
+			}},
+		},
+		close: closeConn,
+	}, nil
+}
+
+// openBlobs opens the store BLOB_DRIVER selects for uploaded files.
+func openBlobs(cfg *config.Config) (service.BlobStore, error) {
+	switch cfg.Blob.Driver {
+	case config.BlobDriverFile:
+		return blob.NewFileStore(cfg.Blob.Dir)
+	case config.BlobDriverS3:
+		return blob.NewS3Store(blob.S3Config{
+			Endpoint:  cfg.Blob.S3Endpoint,
+			Bucket:    cfg.Blob.S3Bucket,
+			Region:    cfg.Blob.S3Region,
+			AccessKey: cfg.Blob.S3AccessKey,
+			SecretKey: cfg.Blob.S3SecretKey,
+			PathStyle: cfg.Blob.S3PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_DRIVER %q", cfg.Blob.Driver)
+	}
+}
+
+// openReplicas connects to the replicas in DB_REPLICA_ADDRS and checks them
+// once, so that healthy ones take queries from the start. It returns nil
+// without replicas.
+func openReplicas(l *slog.Logger, cfg *config.Config, poolConfig database.PoolConfig) (*database.ReplicaSet, error) {
+	var replicas []*database.Replica
+	for _, addr := range cfg.DB.ReplicaAddrs {
+		if addr == "" {
+			continue
+		}
+		if cfg.DBDriver() != config.DriverPostgres {
+			return nil, errors.New("DB_REPLICA_ADDRS needs PostgreSQL in DB_ADDR")
+		}
+
+		name := fmt.Sprintf("replica%d", len(replicas)+1)
+		if u, err := url.Parse(addr); err == nil && u.Host != "" {
+			name = u.Host
+		}
+
+		conn, pool, err := database.Connect(config.DriverPostgres, addr, poolConfig)
+		if err != nil {
+			// the primary can serve everything, so a replica that is down
+			// must not keep the forum from starting
+			l.Warn("Failed to connect to replica", "replica", name, "error", err)
+			continue
+		}
+		replicas = append(replicas, &database.Replica{Name: name, DB: conn, Pool: pool})
+	}
+	if len(replicas) == 0 {
+		return nil, nil
+	}
+
+	set := database.NewReplicaSet(l, replicas, time.Duration(cfg.DB.ReplicaMaxLag)*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	set.Check(ctx)
+
+	err := metrics.RegisterReplicas(set)
+	if err != nil {
+		_ = set.Close()
+		return nil, err
+	}
+	return set, nil
+}
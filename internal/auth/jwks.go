@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sort"
+)
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public part of every asymmetric key in the ring. HMAC
+// keys are shared secrets and are never published.
+func (k *Keyring) JWKS() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range k.keys {
+		jwk := JWK{
+			Kid: key.ID,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+
+		switch public := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/golang-jwt/jwt/v5"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -15,17 +16,51 @@ var (
 )
 
 type JWTAuthenticator struct {
+	// secret verifies HS256 tokens issued before key ids were introduced,
+	// and only until legacyUntil
 	secret      string
+	legacyUntil time.Time
 	expiryHours int
+	keyring     *Keyring
+	issuer      string
+	audience    string
+	leeway      time.Duration
 }
 
 func NewJWTAuthenticator(secret string, expiryHours int) *JWTAuthenticator {
 	return &JWTAuthenticator{
 		secret:      secret,
 		expiryHours: expiryHours,
+		keyring:     NewSecretKeyring(secret),
 	}
 }
 
+// WithLegacyTokens accepts tokens without a "kid" header, signed with the
+// secret, until the given time. They are rejected when it is zero.
+func (a *JWTAuthenticator) WithLegacyTokens(until time.Time) *JWTAuthenticator {
+	a.legacyUntil = until
+	return a
+}
+
+// WithKeyring replaces the keyring tokens are signed and verified with.
+func (a *JWTAuthenticator) WithKeyring(keyring *Keyring) *JWTAuthenticator {
+	a.keyring = keyring
+	return a
+}
+
+// WithClaims sets the issuer and audience written to and required from every
+// token, and the clock skew tolerated when checking exp, nbf and iat.
+func (a *JWTAuthenticator) WithClaims(issuer, audience string, leeway time.Duration) *JWTAuthenticator {
+	a.issuer = issuer
+	a.audience = audience
+	a.leeway = leeway
+	return a
+}
+
+func (a *JWTAuthenticator) JWKS() JWKSet {
+	return a.keyring.JWKS()
+}
+
 func (a *JWTAuthenticator) GenerateToken(userID int, userName, userRole string) (string, error) {
 	if userID == 0 {
 		return "", ErrZeroID
@@ -39,26 +74,63 @@ func (a *JWTAuthenticator) GenerateToken(userID int, userName, userRole string)
 		return "", ErrEmptyRole
 	}
 
+	key, err := a.keyring.Active()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
 	claims := jwt.MapClaims{
 		"user": map[string]interface{}{
 			"id":   userID,
 			"name": userName,
 			"role": userRole,
 		},
-		"exp": time.Now().Add(time.Hour * time.Duration(a.expiryHours)).Unix(),
+		"sub": strconv.Itoa(userID),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(time.Hour * time.Duration(a.expiryHours)).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if a.issuer != "" {
+		claims["iss"] = a.issuer
+	}
+
+	if a.audience != "" {
+		claims["aud"] = a.audience
+	}
 
-	signedToken, err := token.SignedString([]byte(a.secret))
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	signedToken, err := token.SignedString(key.signKey)
 
 	return signedToken, err
 }
 
 func (a *JWTAuthenticator) ValidateToken(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(a.secret), nil
-	})
+	methods := a.keyring.Methods()
+	if a.acceptsLegacy() {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(a.leeway),
+	}
+
+	if a.issuer != "" {
+		options = append(options, jwt.WithIssuer(a.issuer))
+	}
+
+	if a.audience != "" {
+		options = append(options, jwt.WithAudience(a.audience))
+	}
+
+	token, err := jwt.Parse(tokenString, a.keyFunc, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -68,6 +140,35 @@ func (a *JWTAuthenticator) ValidateToken(tokenString string) (jwt.MapClaims, err
 	return claims, nil
 }
 
+// keyFunc picks the verification key by the "kid" header and pins the
+// algorithm to the one the key was registered with, so a token can never
+// choose how it is verified.
+func (a *JWTAuthenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" {
+		if !a.acceptsLegacy() || token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, ErrAlgorithmPinned
+		}
+		return []byte(a.secret), nil
+	}
+
+	key, ok := a.keyring.Key(kid)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrAlgorithmPinned
+	}
+
+	return key.verifyKey, nil
+}
+
+func (a *JWTAuthenticator) acceptsLegacy() bool {
+	return a.secret != "" && time.Now().Before(a.legacyUntil)
+}
+
 func (a *JWTAuthenticator) GetClaimsFromRequest(r *http.Request) (jwt.MapClaims, error) {
 	if r == nil {
 		return nil, ErrNilRequest
@@ -92,6 +92,7 @@ func generateTestToken(secret string, expiryHours int, userID int, userName, use
 		"exp": time.Now().Add(time.Hour * time.Duration(expiryHours)).Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = DefaultKeyID
 	signedToken, _ := token.SignedString([]byte(secret))
 	return signedToken
 }
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultKeyID is the key id under which the JWT_SECRET HMAC key is registered.
+const DefaultKeyID = "default"
+
+var (
+	ErrEmptyKeyID      = errors.New("key id cannot be empty")
+	ErrDuplicateKey    = errors.New("key id already exists")
+	ErrUnknownKey      = errors.New("unknown key id")
+	ErrNoActiveKey     = errors.New("no active signing key")
+	ErrVerifyOnlyKey   = errors.New("key cannot be used for signing")
+	ErrUnsupportedKey  = errors.New("unsupported key type")
+	ErrInvalidPEM      = errors.New("invalid PEM data")
+	ErrAlgorithmPinned = errors.New("token algorithm does not match key")
+)
+
+// Key is a single entry of a Keyring. Keys without a private part can only
+// verify tokens, which is how retired or foreign keys are kept around.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func NewHMACKey(id, secret string) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+func NewEd25519Key(id string, private ed25519.PrivateKey) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodEdDSA,
+		signKey:   private,
+		verifyKey: private.Public(),
+	}
+}
+
+func NewRSAKey(id string, private *rsa.PrivateKey) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodRS256,
+		signKey:   private,
+		verifyKey: &private.PublicKey,
+	}
+}
+
+// ParsePEMKey accepts a PKCS#8 private key or a PKIX public key holding an
+// Ed25519 or RSA key. Public keys produce verification-only entries.
+func ParsePEMKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := private.(type) {
+		case ed25519.PrivateKey:
+			return NewEd25519Key(id, k), nil
+		case *rsa.PrivateKey:
+			return NewRSAKey(id, k), nil
+		}
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewRSAKey(id, private), nil
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := public.(type) {
+		case ed25519.PublicKey:
+			return &Key{ID: id, Method: jwt.SigningMethodEdDSA, verifyKey: k}, nil
+		case *rsa.PublicKey:
+			return &Key{ID: id, Method: jwt.SigningMethodRS256, verifyKey: k}, nil
+		}
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// Keyring holds every key tokens may be verified with and marks one of them
+// as active for signing. Rotating means adding a new key and activating it;
+// tokens signed by the previous key stay valid while it remains in the ring.
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string]*Key
+	active string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*Key)}
+}
+
+// NewSecretKeyring returns a keyring holding only the HMAC key of secret,
+// active under DefaultKeyID.
+func NewSecretKeyring(secret string) *Keyring {
+	key := NewHMACKey(DefaultKeyID, secret)
+	return &Keyring{keys: map[string]*Key{key.ID: key}, active: key.ID}
+}
+
+// LoadKeyring reads every *.pem file in dir, using the file name without
+// extension as the key id.
+func LoadKeyring(dir string) (*Keyring, error) {
+	keyring := NewKeyring()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+		key, err := ParsePEMKey(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key %s: %w", file, err)
+		}
+
+		if err = keyring.Add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return keyring, nil
+}
+
+func (k *Keyring) Add(key *Key) error {
+	if key.ID == "" {
+		return ErrEmptyKeyID
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[key.ID]; ok {
+		return ErrDuplicateKey
+	}
+	k.keys[key.ID] = key
+
+	return nil
+}
+
+func (k *Keyring) Remove(id string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	delete(k.keys, id)
+	if k.active == id {
+		k.active = ""
+	}
+}
+
+func (k *Keyring) SetActive(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if !key.CanSign() {
+		return ErrVerifyOnlyKey
+	}
+	k.active = id
+
+	return nil
+}
+
+// Rotate adds key to the ring and makes it the signing key.
+func (k *Keyring) Rotate(key *Key) error {
+	if err := k.Add(key); err != nil {
+		return err
+	}
+	return k.SetActive(key.ID)
+}
+
+func (k *Keyring) Active() (*Key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[k.active]
+	if !ok {
+		return nil, ErrNoActiveKey
+	}
+	return key, nil
+}
+
+func (k *Keyring) Key(id string) (*Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// Methods returns the algorithms of all keys in the ring.
+func (k *Keyring) Methods() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	seen := make(map[string]struct{})
+	for _, key := range k.keys {
+		seen[key.Method.Alg()] = struct{}{}
+	}
+
+	methods := make([]string, 0, len(seen))
+	for alg := range seen {
+		methods = append(methods, alg)
+	}
+	sort.Strings(methods)
+
+	return methods
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestEd25519Key(t *testing.T, id string) *Key {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %s", err)
+	}
+	return NewEd25519Key(id, private)
+}
+
+func signTestToken(key *Key, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(key.Method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signedToken, _ := token.SignedString(key.signKey)
+	return signedToken
+}
+
+func testClaims(issuer, audience string, notBefore time.Time) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"user": map[string]interface{}{"id": 1, "name": "testuser", "role": "user"},
+		"nbf":  notBefore.Unix(),
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	}
+	if issuer != "" {
+		claims["iss"] = issuer
+	}
+	if audience != "" {
+		claims["aud"] = audience
+	}
+	return claims
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	t.Parallel()
+
+	oldKey := newTestEd25519Key(t, "old")
+	keyring := NewKeyring()
+	if err := keyring.Rotate(oldKey); err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+
+	authenticator := NewJWTAuthenticator("", 1)
+	authenticator.WithKeyring(keyring)
+
+	oldToken, err := authenticator.GenerateToken(1, "testuser", "user")
+	if err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+
+	newKey := newTestEd25519Key(t, "new")
+	if err = keyring.Rotate(newKey); err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+
+	newToken, err := authenticator.GenerateToken(1, "testuser", "user")
+	if err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err = authenticator.ValidateToken(token); err != nil {
+			t.Errorf("%s token: no error expected after rotation, but got %s", name, err)
+		}
+	}
+
+	keyring.Remove("old")
+
+	if _, err = authenticator.ValidateToken(oldToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected %s for removed key, got %v", ErrUnknownKey, err)
+	}
+}
+
+func TestJWTAuthenticator_ValidateTokenPinning(t *testing.T) {
+	t.Parallel()
+
+	edKey := newTestEd25519Key(t, "ed")
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %s", err)
+	}
+	rsaKey := NewRSAKey("rsa", rsaPrivate)
+
+	keyring := NewKeyring()
+	for _, key := range []*Key{edKey, rsaKey, NewHMACKey(DefaultKeyID, "mysecretkey")} {
+		if err = keyring.Add(key); err != nil {
+			t.Fatalf("no error expected, but got %s", err)
+		}
+	}
+
+	authenticator := NewJWTAuthenticator("mysecretkey", 1)
+	authenticator.WithKeyring(keyring).
+		WithClaims("simple-forum", "forum-web", 30*time.Second)
+
+	publicDER, _ := x509.MarshalPKIXPublicKey(edKey.verifyKey)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{
+			name:  "EdDSA Token",
+			token: signTestToken(edKey, "ed", testClaims("simple-forum", "forum-web", time.Now())),
+		},
+		{
+			name:  "RS256 Token",
+			token: signTestToken(rsaKey, "rsa", testClaims("simple-forum", "forum-web", time.Now())),
+		},
+		{
+			name:  "Not Before Within Leeway",
+			token: signTestToken(edKey, "ed", testClaims("simple-forum", "forum-web", time.Now().Add(10*time.Second))),
+		},
+		{
+			name:  "Not Before Beyond Leeway",
+			token: signTestToken(edKey, "ed", testClaims("simple-forum", "forum-web", time.Now().Add(time.Minute))),
+			err:   jwt.ErrTokenNotValidYet,
+		},
+		{
+			name:  "Unknown Key ID",
+			token: signTestToken(edKey, "missing", testClaims("simple-forum", "forum-web", time.Now())),
+			err:   ErrUnknownKey,
+		},
+		{
+			name:  "Algorithm Mismatch",
+			token: signTestToken(rsaKey, "ed", testClaims("simple-forum", "forum-web", time.Now())),
+			err:   ErrAlgorithmPinned,
+		},
+		{
+			name:  "HMAC Signed With Public Key",
+			token: signTestToken(NewHMACKey("ed", string(publicPEM)), "ed", testClaims("simple-forum", "forum-web", time.Now())),
+			err:   ErrAlgorithmPinned,
+		},
+		{
+			name:  "Wrong Issuer",
+			token: signTestToken(edKey, "ed", testClaims("someone-else", "forum-web", time.Now())),
+			err:   jwt.ErrTokenInvalidIssuer,
+		},
+		{
+			name:  "Wrong Audience",
+			token: signTestToken(edKey, "ed", testClaims("simple-forum", "other-app", time.Now())),
+			err:   jwt.ErrTokenInvalidAudience,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := authenticator.ValidateToken(tt.token)
+
+			if tt.err == nil {
+				if err != nil {
+					t.Errorf("%s: no error expected, but got %s", tt.name, err.Error())
+				}
+				if claims == nil {
+					t.Errorf("%s: expected claims, got nil", tt.name)
+				}
+			} else {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("%s: expected %s, got %v", tt.name, tt.err, err)
+				}
+				if claims != nil {
+					t.Errorf("%s: expected no claims, but got %s", tt.name, claims)
+				}
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticator_LegacyTokens(t *testing.T) {
+	t.Parallel()
+
+	legacyKey := NewHMACKey(DefaultKeyID, "mysecretkey")
+	legacyToken := signTestToken(legacyKey, "", testClaims("", "", time.Now()))
+
+	tests := []struct {
+		name  string
+		until time.Time
+		token string
+		err   error
+	}{
+		{
+			name:  "Rejected By Default",
+			token: legacyToken,
+			err:   ErrAlgorithmPinned,
+		},
+		{
+			name:  "Accepted Within Window",
+			until: time.Now().Add(time.Hour),
+			token: legacyToken,
+		},
+		{
+			name:  "Rejected After Window",
+			until: time.Now().Add(-time.Hour),
+			token: legacyToken,
+			err:   ErrAlgorithmPinned,
+		},
+		{
+			name:  "Wrong Secret Within Window",
+			until: time.Now().Add(time.Hour),
+			token: signTestToken(NewHMACKey(DefaultKeyID, "wrongsecret"), "", testClaims("", "", time.Now())),
+			err:   jwt.ErrTokenSignatureInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := NewJWTAuthenticator("mysecretkey", 1)
+			authenticator.WithLegacyTokens(tt.until)
+
+			claims, err := authenticator.ValidateToken(tt.token)
+
+			if tt.err == nil {
+				if err != nil {
+					t.Errorf("%s: no error expected, but got %s", tt.name, err.Error())
+				}
+				if claims == nil {
+					t.Errorf("%s: expected claims, got nil", tt.name)
+				}
+			} else {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("%s: expected %s, got %v", tt.name, tt.err, err)
+				}
+				if claims != nil {
+					t.Errorf("%s: expected no claims, but got %s", tt.name, claims)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyring_JWKS(t *testing.T) {
+	t.Parallel()
+
+	keyring := NewKeyring()
+	_ = keyring.Add(newTestEd25519Key(t, "ed"))
+	_ = keyring.Add(NewHMACKey(DefaultKeyID, "mysecretkey"))
+
+	set := keyring.JWKS()
+
+	if len(set.Keys) != 1 {
+		t.Fatalf("expected 1 published key, got %d", len(set.Keys))
+	}
+	if set.Keys[0].Kid != "ed" || set.Keys[0].Kty != "OKP" || set.Keys[0].Alg != "EdDSA" {
+		t.Errorf("unexpected key %+v", set.Keys[0])
+	}
+}
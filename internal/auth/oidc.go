@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"simple-forum/internal/model"
+	"strconv"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrMissingIDToken = errors.New("token response has no id_token")
+	ErrNonceMismatch  = errors.New("id_token nonce does not match")
+)
+
+type OIDCConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+}
+
+// OIDCProvider is an OpenID Connect relying party using the authorization
+// code flow with PKCE.
+type OIDCProvider struct {
+	name        string
+	groupsClaim string
+	config      oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider fetches the issuer's discovery document, so ctx should
+// carry a deadline.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+
+	return &OIDCProvider{
+		name:        cfg.Name,
+		groupsClaim: cfg.GroupsClaim,
+		config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+// NewOIDCFlow returns random state, nonce and PKCE verifier values for one
+// sign-in attempt.
+func NewOIDCFlow() (state, nonce, verifier string, err error) {
+	state, err = randomString(24)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	nonce, err = randomString(24)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return state, nonce, oauth2.GenerateVerifier(), nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange redeems the authorization code and verifies the returned ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*model.ExternalIdentity, error) {
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrMissingIDToken
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims map[string]interface{}
+	if err = idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	identity := &model.ExternalIdentity{
+		Provider: p.name,
+		Subject:  idToken.Subject,
+	}
+
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+
+	// some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified, _ = strconv.ParseBool(verified)
+	}
+
+	if p.groupsClaim != "" {
+		switch groups := claims[p.groupsClaim].(type) {
+		case []interface{}:
+			for _, group := range groups {
+				if name, ok := group.(string); ok {
+					identity.Groups = append(identity.Groups, name)
+				}
+			}
+		case string:
+			identity.Groups = []string{groups}
+		}
+	}
+
+	return identity, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockOIDCServer is a minimal identity provider: discovery, JWKS and a token
+// endpoint that enforces PKCE for codes handed out by authorize.
+type mockOIDCServer struct {
+	*httptest.Server
+	key        *Key
+	keyring    *Keyring
+	mu         sync.Mutex
+	challenges map[string]string
+	nonces     map[string]string
+	claims     jwt.MapClaims
+}
+
+func newMockOIDCServer(t *testing.T, claims jwt.MapClaims) *mockOIDCServer {
+	t.Helper()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %s", err)
+	}
+
+	m := &mockOIDCServer{
+		key:        NewRSAKey("idp", private),
+		keyring:    NewKeyring(),
+		challenges: make(map[string]string),
+		nonces:     make(map[string]string),
+		claims:     claims,
+	}
+	_ = m.keyring.Add(m.key)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(rw http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(rw http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(rw).Encode(m.keyring.JWKS())
+	})
+	mux.HandleFunc("POST /token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+// authorize plays the part of the user approving the sign-in and returns
+// the code the provider would redirect back with.
+func (m *mockOIDCServer) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("invalid auth url: %s", err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected S256 code challenge, got %q", q.Get("code_challenge_method"))
+	}
+
+	code := "code-" + q.Get("state")
+
+	m.mu.Lock()
+	m.challenges[code] = q.Get("code_challenge")
+	m.nonces[code] = q.Get("nonce")
+	m.mu.Unlock()
+
+	return code
+}
+
+func (m *mockOIDCServer) token(rw http.ResponseWriter, r *http.Request) {
+	code := r.PostFormValue("code")
+
+	m.mu.Lock()
+	challenge, ok := m.challenges[code]
+	nonce := m.nonces[code]
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   m.URL,
+		"aud":   "forum",
+		"sub":   "user-42",
+		"nonce": nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     signTestToken(m.key, m.key.ID, claims),
+	})
+}
+
+func TestOIDCProvider_Exchange(t *testing.T) {
+	t.Parallel()
+
+	server := newMockOIDCServer(t, jwt.MapClaims{
+		"email":              "jane@example.com",
+		"email_verified":     "true",
+		"preferred_username": "jane",
+		"groups":             []string{"staff", "forum-admins"},
+	})
+
+	provider, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		Name:        "corp",
+		Issuer:      server.URL,
+		ClientID:    "forum",
+		RedirectURL: "http://localhost/login/oidc/callback",
+		GroupsClaim: "groups",
+	})
+	if err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+
+	state, nonce, verifier, err := NewOIDCFlow()
+	if err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+
+	code := server.authorize(t, provider.AuthCodeURL(state, nonce, verifier))
+
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		valid    bool
+		err      error
+	}{
+		{
+			name:     "Wrong Verifier",
+			verifier: "not-the-verifier-not-the-verifier-not-the-verifier",
+			nonce:    nonce,
+			valid:    false,
+		},
+		{
+			name:     "Wrong Nonce",
+			verifier: verifier,
+			nonce:    "other",
+			valid:    false,
+			err:      ErrNonceMismatch,
+		},
+		{
+			name:     "Valid Exchange",
+			verifier: verifier,
+			nonce:    nonce,
+			valid:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		identity, err := provider.Exchange(context.Background(), code, tt.verifier, tt.nonce)
+
+		if tt.valid {
+			if err != nil {
+				t.Fatalf("%s: no error expected, but got %s", tt.name, err)
+			}
+			expected := []string{"staff", "forum-admins"}
+			if identity.Provider != "corp" || identity.Subject != "user-42" || identity.Email != "jane@example.com" ||
+				!identity.EmailVerified || identity.PreferredUsername != "jane" || !reflect.DeepEqual(identity.Groups, expected) {
+				t.Errorf("%s: unexpected identity %+v", tt.name, identity)
+			}
+		} else {
+			if err == nil {
+				t.Errorf("%s: expected an error, got nil", tt.name)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("%s: expected %s, got %s", tt.name, tt.err, err)
+			}
+		}
+	}
+}
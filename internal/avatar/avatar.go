@@ -0,0 +1,198 @@
+// Package avatar turns uploaded pictures into square avatars and draws
+// identicons for users without one.
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxPixels is the most pixels an upload may have, which bounds the memory
+// decoding it takes whatever the size of the file.
+const MaxPixels = 25_000_000
+
+var (
+	ErrUnsupportedType = errors.New("avatar must be a JPEG, PNG, GIF or WebP image")
+	ErrTooManyPixels   = errors.New("avatar has too many pixels")
+	ErrInvalidImage    = errors.New("avatar is not a valid image")
+)
+
+// formats are the image types accepted, by the type sniffed from their
+// content, with the name the image package decodes them as.
+var formats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// Process crops data, an image file, to its centre square and scales it to
+// each of sizes, returning JPEGs by size. The type is told by the content
+// rather than any file name, and the pictures are re-encoded, so metadata
+// such as EXIF is left behind. The EXIF orientation is applied first and
+// transparent areas become white.
+func Process(data []byte, sizes []int) (map[int][]byte, error) {
+	format, ok := formats[http.DetectContentType(data)]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	config, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decoded != format {
+		return nil, ErrInvalidImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	crop := image.Rect(0, 0, side, side).Add(bounds.Min).Add(image.Pt((bounds.Dx()-side)/2, (bounds.Dy()-side)/2))
+
+	// the centre square stays the centre square whichever way the picture
+	// is turned, so it is turned after scaling, when it is smallest
+	o := 1
+	if format == "jpeg" {
+		o = orientation(data)
+	}
+
+	images := make(map[int][]byte, len(sizes))
+	for _, size := range sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, orient(dst, o), &jpeg.Options{Quality: 85})
+		if err != nil {
+			return nil, err
+		}
+		images[size] = buf.Bytes()
+	}
+
+	return images, nil
+}
+
+// orientation returns the EXIF orientation of a JPEG, from 1, as stored,
+// to 8, or 1 when it has none.
+func orientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// the image data starts, metadata comes before it
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag of the first IFD of tiff.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := range entries {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			// a SHORT, at the start of the value field
+			if o := int(order.Uint16(tiff[entry+8:])); 1 <= o && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient turns and flips the square img as EXIF orientation o asks for.
+func orient(img *image.RGBA, o int) *image.RGBA {
+	if o == 1 {
+		return img
+	}
+
+	n := img.Bounds().Dx() - 1
+	dst := image.NewRGBA(img.Bounds())
+	for y := 0; y <= n; y++ {
+		for x := 0; x <= n; x++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = n-x, y
+			case 3:
+				sx, sy = n-x, n-y
+			case 4:
+				sx, sy = x, n-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, n-x
+			case 7:
+				sx, sy = n-y, n-x
+			case 8:
+				sx, sy = n-y, x
+			}
+			dst.SetRGBA(x, y, img.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,166 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// halves draws a picture of width w and height h, red on the left and blue
+// on the right.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			if x < w/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+// withOrientation adds an EXIF segment with orientation o after the start
+// of the JPEG data.
+func withOrientation(data []byte, o uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = append(tiff, 0, 1)                         // one entry
+	tiff = append(tiff, 0x01, 0x12, 0, 3, 0, 0, 0, 1) // orientation, SHORT, count 1
+	tiff = binary.BigEndian.AppendUint16(tiff, o)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	return append(append([]byte{0xFF, 0xD8}, app1...), data[2:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xC000 && b < 0x4000
+}
+
+func isBlue(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return b > 0xC000 && r < 0x4000
+}
+
+func TestProcess(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	_ = jpeg.Encode(&buf, halves(80, 40), &jpeg.Options{Quality: 100})
+	data := withOrientation(buf.Bytes(), 6)
+
+	if orientation(data) != 6 {
+		t.Fatalf("expected orientation 6, got %d", orientation(data))
+	}
+
+	images, err := Process(data, []int{16, 32})
+	if err != nil {
+		t.Fatalf("unable to process: %v", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("expected 2 sizes, got %d", len(images))
+	}
+
+	if bytes.Contains(images[32], []byte("Exif")) {
+		t.Error("expected EXIF to be stripped")
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(images[32]))
+	if err != nil || format != "jpeg" || img.Bounds().Dx() != 32 || img.Bounds().Dy() != 32 {
+		t.Fatalf("expected a 32 pixel JPEG, got %s of %v, %v", format, img.Bounds(), err)
+	}
+	// turned clockwise, the left half is on top
+	if !isRed(img.At(16, 4)) || !isBlue(img.At(16, 27)) {
+		t.Errorf("expected red on top and blue below, got %v and %v", img.At(16, 4), img.At(16, 27))
+	}
+}
+
+func TestProcess_Transparent(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+
+	images, err := Process(buf.Bytes(), []int{8})
+	if err != nil {
+		t.Fatalf("unable to process: %v", err)
+	}
+	img, _ := jpeg.Decode(bytes.NewReader(images[8]))
+	if r, g, b, _ := img.At(4, 4).RGBA(); r < 0xF000 || g < 0xF000 || b < 0xF000 {
+		t.Errorf("expected transparency to become white, got %v", img.At(4, 4))
+	}
+}
+
+func TestProcess_Rejected(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, halves(4, 4))
+	valid := buf.Bytes()
+
+	// a PNG header claiming 10000×10000 pixels
+	ihdr := binary.BigEndian.AppendUint32(nil, 10000)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, 10000)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+	chunk := append([]byte("IHDR"), ihdr...)
+	huge := append([]byte("\x89PNG\r\n\x1a\n"), binary.BigEndian.AppendUint32(nil, uint32(len(ihdr)))...)
+	huge = append(huge, chunk...)
+	huge = binary.BigEndian.AppendUint32(huge, crc32.ChecksumIEEE(chunk))
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"text", []byte("hello, this is not a picture"), ErrUnsupportedType},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), ErrUnsupportedType},
+		{"truncated", valid[:len(valid)/2], ErrInvalidImage},
+		{"too many pixels", huge, ErrTooManyPixels},
+	}
+
+	for _, tt := range tests {
+		_, err := Process(tt.data, []int{8})
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestIdenticon(t *testing.T) {
+	t.Parallel()
+
+	first, err := Identicon("1", 64)
+	if err != nil {
+		t.Fatalf("unable to draw identicon: %v", err)
+	}
+	again, _ := Identicon("1", 64)
+	other, _ := Identicon("2", 64)
+	if !bytes.Equal(first, again) || bytes.Equal(first, other) {
+		t.Error("expected the same identicon for a seed and another for another seed")
+	}
+
+	img, err := png.Decode(bytes.NewReader(first))
+	if err != nil || img.Bounds().Dx() != 64 || img.Bounds().Dy() != 64 {
+		t.Fatalf("expected a 64 pixel PNG, got %v, %v", img, err)
+	}
+	// mirrored like a face
+	for y := range 64 {
+		for x := range 32 {
+			if img.At(x, y) != img.At(63-x, y) {
+				t.Fatalf("expected a symmetric identicon, differs at %d,%d", x, y)
+			}
+		}
+	}
+}
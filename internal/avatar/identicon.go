@@ -0,0 +1,45 @@
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+// Identicon draws a PNG avatar of the given size for a user who has not
+// uploaded one: a pattern of 5×5 cells, mirrored like a face, in a colour,
+// both derived from seed. The same seed always gives the same picture.
+func Identicon(seed string, size int) ([]byte, error) {
+	sum := sha256.Sum256([]byte(seed))
+
+	// a colour neither too dark nor too light to stand out on grey
+	fg := color.RGBA{R: 48 + sum[29]%160, G: 48 + sum[30]%160, B: 48 + sum[31]%160, A: 255}
+	bg := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	cell := size / 6
+	margin := (size - 5*cell) / 2
+	for row := range 5 {
+		for col := range 3 {
+			if sum[row*3+col]&1 == 0 {
+				continue
+			}
+			for _, c := range []int{col, 4 - col} {
+				r := image.Rect(0, 0, cell, cell).Add(image.Pt(margin+c*cell, margin+row*cell))
+				draw.Draw(img, r, image.NewUniform(fg), image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
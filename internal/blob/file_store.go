@@ -0,0 +1,166 @@
+// Package blob keeps uploaded files, such as avatars and attachments,
+// outside the database, on the local disk or in an S3-compatible bucket.
+// Blobs are addressed by keys like avatars/12-3f2a-64.jpg, whose slashes
+// separate directories.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Info describes a stored blob.
+type Info struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// FileStore keeps blobs as files below a directory of the local
+// filesystem. It derives the content type of a blob from the extension of
+// its key.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store in dir, which is created if it does not
+// exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// checkKey returns ErrInvalidKey for keys that are empty, absolute or climb
+// out of the store with "..", whichever store they are for.
+func checkKey(key string) error {
+	if key == "" || strings.Contains(key, `\`) || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// path returns the file of key, which has to stay below the directory.
+func (f *FileStore) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(key)), nil
+}
+
+// Put stores what r reads under key, replacing any blob stored there. The
+// content goes to a temporary file first, so a blob is never read half
+// written.
+func (f *FileStore) Put(ctx context.Context, key string, r io.Reader, _ string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	name, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// Open returns the content of the blob under key, which the caller closes,
+// or ErrNotFound.
+func (f *FileStore) Open(ctx context.Context, key string) (io.ReadCloser, *Info, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	name, err := f.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if stat.IsDir() {
+		file.Close()
+		return nil, nil, ErrNotFound
+	}
+
+	return file, &Info{
+		Size:        stat.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     stat.ModTime(),
+	}, nil
+}
+
+// Stat describes the blob under key without opening it, or returns
+// ErrNotFound.
+func (f *FileStore) Stat(ctx context.Context, key string) (*Info, error) {
+	r, info, err := f.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	r.Close()
+	return info, nil
+}
+
+// Delete removes the blob under key. Deleting a missing blob is not an
+// error.
+func (f *FileStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	name, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// store is what FileStore and S3Store have in common.
+type store interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, *Info, error)
+	Stat(ctx context.Context, key string) (*Info, error)
+	Delete(ctx context.Context, key string) error
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create store: %v", err)
+	}
+
+	testStore(t, store)
+}
+
+// testStore checks that store keeps, replaces and deletes blobs and turns
+// away keys that leave it.
+func testStore(t *testing.T, store store) {
+	ctx := context.Background()
+
+	err := store.Put(ctx, "avatars/1-a-64.jpg", strings.NewReader("first"), "image/jpeg")
+	if err != nil {
+		t.Fatalf("unable to put blob: %v", err)
+	}
+	err = store.Put(ctx, "avatars/1-a-64.jpg", strings.NewReader("second"), "image/jpeg")
+	if err != nil {
+		t.Fatalf("unable to replace blob: %v", err)
+	}
+
+	r, info, err := store.Open(ctx, "avatars/1-a-64.jpg")
+	if err != nil {
+		t.Fatalf("unable to open blob: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != "second" || info.Size != 6 || info.ContentType != "image/jpeg" {
+		t.Errorf("expected the second content as image/jpeg, got %q and %+v", content, info)
+	}
+
+	info, err = store.Stat(ctx, "avatars/1-a-64.jpg")
+	if err != nil || info.Size != 6 {
+		t.Errorf("expected the size of the second content, got %+v, %v", info, err)
+	}
+
+	_, _, err = store.Open(ctx, "avatars")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a directory, got %v", err)
+	}
+
+	err = store.Delete(ctx, "avatars/1-a-64.jpg")
+	if err != nil {
+		t.Fatalf("unable to delete blob: %v", err)
+	}
+	_, _, err = store.Open(ctx, "avatars/1-a-64.jpg")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	_, err = store.Stat(ctx, "avatars/1-a-64.jpg")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for the size after delete, got %v", err)
+	}
+	err = store.Delete(ctx, "avatars/1-a-64.jpg")
+	if err != nil {
+		t.Errorf("expected deleting a missing blob to succeed, got %v", err)
+	}
+
+	for _, key := range []string{"", "../escape", "avatars/../../escape", "/etc/passwd", `avatars\..\escape`} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%q: expected ErrInvalidKey, got %v", key, err)
+		}
+		if _, _, err := store.Open(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%q: expected ErrInvalidKey opening, got %v", key, err)
+		}
+	}
+}
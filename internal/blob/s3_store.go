@@ -0,0 +1,325 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// unsignedPayload stands in for the hash of a request body, which spares
+// reading uploads twice. S3 and MinIO accept it over HTTP and HTTPS alike.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Config says where an S3Store keeps its blobs.
+type S3Config struct {
+	// Endpoint is the address of the service, such as
+	// https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket in the path of requests rather than in
+	// the host name, which MinIO and most other stand-ins expect
+	PathStyle bool
+}
+
+// S3Store keeps blobs as objects of a bucket of Amazon S3 or a compatible
+// service such as MinIO. It signs its requests with AWS Signature Version
+// 4 and uses nothing but single-request PUT, GET, HEAD and DELETE, which
+// every compatible service supports.
+type S3Store struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+	now       func() time.Time
+}
+
+// NewS3Store returns a store in the bucket of cfg, which has to exist.
+// The region defaults to us-east-1.
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("no S3 bucket given")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3Store{
+		endpoint:  endpoint,
+		bucket:    cfg.Bucket,
+		region:    region,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		pathStyle: cfg.PathStyle,
+		client:    http.DefaultClient,
+		now:       time.Now,
+	}, nil
+}
+
+// WithClient sets the HTTP client requests go through.
+func (s *S3Store) WithClient(client *http.Client) *S3Store {
+	s.client = client
+	return s
+}
+
+// Put stores what r reads under key, replacing any object stored there.
+// S3 needs the length of an upload before it starts, so unless r can seek
+// it is read into memory first.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	body, size, err := sized(r)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.request(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.do(req, key)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Open returns the content of the object under key, which the caller
+// closes, or ErrNotFound.
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, *Info, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := s.do(req, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, info(resp), nil
+}
+
+// Stat describes the object under key without fetching it, or returns
+// ErrNotFound.
+func (s *S3Store) Stat(ctx context.Context, key string) (*Info, error) {
+	req, err := s.request(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req, key)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return info(resp), nil
+}
+
+// Delete removes the object under key. Deleting a missing object is not an
+// error.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// request returns an unsigned request for the object under key.
+func (s *S3Store) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	u := *s.endpoint
+	prefix := strings.TrimSuffix(u.EscapedPath(), "/")
+	if s.pathStyle {
+		prefix += "/" + escape(s.bucket)
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.RawQuery = ""
+
+	target, err := url.Parse(u.Scheme + "://" + u.Host + prefix + "/" + escape(key))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	return req, nil
+}
+
+// do signs and sends req, turning error responses into errors: a missing
+// object into ErrNotFound, anything else into an error with the code S3
+// gave.
+func (s *S3Store) do(req *http.Request, key string) (*http.Response, error) {
+	s.sign(req, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	_ = xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+
+	if resp.StatusCode == http.StatusNotFound && (body.Code == "" || body.Code == "NoSuchKey") {
+		return nil, ErrNotFound
+	}
+	if body.Code == "" {
+		body.Code = resp.Status
+	}
+	return nil, fmt.Errorf("s3: %s %s: %s %s", req.Method, key, body.Code, body.Message)
+}
+
+// sign adds the Authorization header of AWS Signature Version 4 to req,
+// covering the host and the headers S3 reads: Content-Type, Range and
+// those starting with X-Amz-. The hash of the body is taken from the
+// X-Amz-Content-Sha256 header.
+func (s *S3Store) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := slices.Sorted(maps.Keys(headers))
+
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(req.URL.EscapedPath() + "\n")
+	canonical.WriteString("\n") // no query
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical.WriteString("\n" + signed + "\n")
+	canonical.WriteString(req.Header.Get("X-Amz-Content-Sha256"))
+
+	scope := amzDate[:8] + "/" + s.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{amzDate[:8], s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escape percent-encodes every byte of key but the unreserved characters
+// and slashes, as signing requires.
+func escape(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// sized returns r with the number of bytes left in it, reading r into
+// memory if it cannot seek.
+func sized(r io.Reader) (io.Reader, int64, error) {
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		current, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			end, err := seeker.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, 0, err
+			}
+			_, err = seeker.Seek(current, io.SeekStart)
+			if err != nil {
+				return nil, 0, err
+			}
+			// the client closes request bodies, which are the caller's
+			return io.NopCloser(seeker), end - current, nil
+		}
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+func info(resp *http.Response) *Info {
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &Info{
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		ModTime:     modTime,
+	}
+}
@@ -0,0 +1,203 @@
+// Package cache keeps the results of frequent reads in process memory. The
+// storage decorators in this package fill it and drop entries when the
+// underlying data is written, here and, through a Channel, on the other
+// instances of the forum.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"simple-forum/internal/metrics"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Channel carries invalidations between the instances of the forum.
+// Publish sends keys to the other instances, which pass them to the
+// function given to Subscribe.
+type Channel interface {
+	Publish(ctx context.Context, keys []string) error
+	Subscribe(fn func(keys []string))
+}
+
+// Stats counts the lookups of a Cache since it was created.
+type Stats struct {
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64
+	Invalidations uint64
+	Entries       int
+}
+
+type entry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// Cache is a size-bounded LRU cache whose entries expire after a TTL. Keys
+// have the form kind:id, and a key ending in * invalidates all keys that
+// start with what precedes it.
+type Cache struct {
+	l       *slog.Logger
+	size    int
+	ttl     time.Duration
+	channel Channel
+	delay   time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	// generation changes with every invalidation, so that a value loaded
+	// before one is not stored after it
+	generation uint64
+
+	hits, misses, evictions, invalidations atomic.Uint64
+}
+
+// New creates a Cache of at most size entries that expire after ttl.
+func New(l *slog.Logger, size int, ttl time.Duration) *Cache {
+	return &Cache{
+		l:     l,
+		size:  size,
+		ttl:   ttl,
+		now:   time.Now,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// WithChannel publishes invalidations on channel and applies those of the
+// other instances it delivers.
+func (c *Cache) WithChannel(channel Channel) *Cache {
+	c.channel = channel
+	channel.Subscribe(c.receive)
+	return c
+}
+
+// WithDelayedInvalidation drops invalidated keys a second time after delay.
+// With read replicas this removes entries loaded from a replica that had
+// not yet replayed the write when the keys were first dropped.
+func (c *Cache) WithDelayedInvalidation(delay time.Duration) *Cache {
+	c.delay = delay
+	return c
+}
+
+// Stats returns the counts of lookups, evictions and invalidations.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	entries := c.ll.Len()
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Entries:       entries,
+	}
+}
+
+// get returns the value under key. On a miss it returns the generation to
+// pass to set with the loaded value.
+func (c *Cache) get(key string) (any, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		e := elem.Value.(*entry)
+		if c.now().Before(e.expires) {
+			c.ll.MoveToFront(elem)
+			c.hits.Add(1)
+			metrics.CacheRequests.WithLabelValues(kind(key), metrics.CacheHit).Inc()
+			return e.value, 0, true
+		}
+		c.remove(elem)
+	}
+
+	c.misses.Add(1)
+	metrics.CacheRequests.WithLabelValues(kind(key), metrics.CacheMiss).Inc()
+	return nil, c.generation, false
+}
+
+// set stores value under key unless keys were invalidated since generation
+// was returned by get.
+func (c *Cache) set(key string, value any, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, expires: c.now().Add(c.ttl)})
+
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+		c.evictions.Add(1)
+		metrics.CacheEvictions.Inc()
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*entry).key)
+}
+
+// Invalidate drops keys here and on the other instances.
+func (c *Cache) Invalidate(ctx context.Context, keys ...string) {
+	c.receive(keys)
+
+	if c.channel != nil {
+		err := c.channel.Publish(ctx, keys)
+		if err != nil {
+			c.l.ErrorContext(ctx, "Failed to publish cache invalidation", "keys", keys, "error", err)
+		}
+	}
+}
+
+// receive drops keys, now and once more after the delay.
+func (c *Cache) receive(keys []string) {
+	c.drop(keys)
+
+	if c.delay > 0 {
+		time.AfterFunc(c.delay, func() { c.drop(keys) })
+	}
+}
+
+func (c *Cache) drop(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.invalidations.Add(uint64(len(keys)))
+
+	for _, key := range keys {
+		prefix, ok := strings.CutSuffix(key, "*")
+		if !ok {
+			if elem, ok := c.items[key]; ok {
+				c.remove(elem)
+			}
+			continue
+		}
+
+		for k, elem := range c.items {
+			if strings.HasPrefix(k, prefix) {
+				c.remove(elem)
+			}
+		}
+	}
+}
+
+// kind is the part of key before the id, the label of the cache metrics.
+func kind(key string) string {
+	k, _, _ := strings.Cut(key, ":")
+	return k
+}
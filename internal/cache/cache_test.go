@@ -0,0 +1,269 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"simple-forum/internal/events"
+	"simple-forum/internal/model"
+	"simple-forum/internal/repository/memory"
+	"simple-forum/internal/repository/storagetest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newCache(size int) *Cache {
+	return New(slog.Default(), size, time.Minute)
+}
+
+func TestCache_TTL(t *testing.T) {
+	t.Parallel()
+
+	c := newCache(10)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	_, generation, _ := c.get("topic:1")
+	c.set("topic:1", "value", generation)
+
+	if _, _, ok := c.get("topic:1"); !ok {
+		t.Fatal("expected a hit before the TTL")
+	}
+
+	now = now.Add(time.Minute)
+	if _, _, ok := c.get("topic:1"); ok {
+		t.Error("expected a miss after the TTL")
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 2 || stats.Entries != 0 {
+		t.Errorf("expected 1 hit, 2 misses and no entries, got %+v", stats)
+	}
+}
+
+func TestCache_Size(t *testing.T) {
+	t.Parallel()
+
+	c := newCache(2)
+	for _, key := range []string{"post:1", "post:2"} {
+		_, generation, _ := c.get(key)
+		c.set(key, key, generation)
+	}
+
+	// post:1 is now more recently used than post:2
+	c.get("post:1")
+	_, generation, _ := c.get("post:3")
+	c.set("post:3", "post:3", generation)
+
+	if _, _, ok := c.get("post:2"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	if _, _, ok := c.get("post:1"); !ok {
+		t.Error("expected the recently used entry to be kept")
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Errorf("expected 1 eviction and 2 entries, got %+v", stats)
+	}
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	t.Parallel()
+
+	c := newCache(10)
+	for _, key := range []string{"post:1", "post:2", "posts:1"} {
+		_, generation, _ := c.get(key)
+		c.set(key, key, generation)
+	}
+
+	c.Invalidate(context.Background(), "post:*")
+
+	if _, _, ok := c.get("post:1"); ok {
+		t.Error("expected post:1 to match post:*")
+	}
+	if _, _, ok := c.get("posts:1"); !ok {
+		t.Error("expected posts:1 not to match post:*")
+	}
+}
+
+func TestCache_StaleLoad(t *testing.T) {
+	t.Parallel()
+
+	c := newCache(10)
+
+	// a load that started before an invalidation must not be stored
+	_, generation, _ := c.get("topics")
+	c.Invalidate(context.Background(), "topics")
+	c.set("topics", "stale", generation)
+
+	if _, _, ok := c.get("topics"); ok {
+		t.Error("expected the stale value not to be stored")
+	}
+}
+
+func TestCache_DelayedInvalidation(t *testing.T) {
+	t.Parallel()
+
+	c := newCache(10).WithDelayedInvalidation(10 * time.Millisecond)
+	c.Invalidate(context.Background(), "topics")
+
+	// loaded from a replica that has not caught up yet
+	_, generation, _ := c.get("topics")
+	c.set("topics", "stale", generation)
+
+	time.Sleep(50 * time.Millisecond)
+	if _, _, ok := c.get("topics"); ok {
+		t.Error("expected the value to be dropped again after the delay")
+	}
+}
+
+// channel delivers invalidations to all caches subscribed to it.
+type channel struct {
+	mu          sync.Mutex
+	subscribers []func(keys []string)
+}
+
+func (ch *channel) Publish(_ context.Context, keys []string) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	for _, fn := range ch.subscribers {
+		fn(keys)
+	}
+	return nil
+}
+
+func (ch *channel) Subscribe(fn func(keys []string)) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.subscribers = append(ch.subscribers, fn)
+}
+
+func TestCache_Channel(t *testing.T) {
+	t.Parallel()
+
+	ch := &channel{}
+	first := newCache(10).WithChannel(ch)
+	second := newCache(10).WithChannel(ch)
+
+	_, generation, _ := second.get("topic:1")
+	second.set("topic:1", "value", generation)
+
+	first.Invalidate(context.Background(), "topic:1")
+
+	if _, _, ok := second.get("topic:1"); ok {
+		t.Error("expected the invalidation to reach the other instance")
+	}
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	t.Parallel()
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Storages {
+		store := memory.NewStore()
+		c := newCache(100)
+		return storagetest.Storages{
+			Users:       memory.NewUserRepository(store),
+			Identities:  memory.NewIdentityRepository(store),
+			Topics:      NewTopicStorage(memory.NewTopicRepository(store), c),
+			Posts:       NewPostStorage(memory.NewPostRepository(store), c),
+			Tokens:      memory.NewTokenRepository(store),
+			Profiles:    memory.NewProfileRepository(store),
+			Attachments: memory.NewAttachmentRepository(store),
+			Mentions:    memory.NewMentionRepository(store),
+			Messages:    memory.NewMessageRepository(store),
+			Blocks:      memory.NewBlockRepository(store),
+			Throttles:   memory.NewThrottleRepository(store),
+			Audit:       memory.NewAuditRepository(store),
+			Tx:          NewTransactor(memory.NewTransactor(store), c),
+		}
+	})
+}
+
+func TestStorage_Invalidation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := memory.NewStore()
+	c := newCache(100)
+	topics := NewTopicStorage(memory.NewTopicRepository(store), c)
+	posts := NewPostStorage(memory.NewPostRepository(store), c)
+	tx := NewTransactor(memory.NewTransactor(store), c)
+
+	topic := &model.Topic{Name: "topic", CreatedAt: time.Now(), AuthorId: 1}
+	_, err := topics.InsertTopic(ctx, topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := topics.GetTopicWithPosts(ctx, topic.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Name = "changed by the caller"
+
+	got, _, _ = topics.GetTopicWithPosts(ctx, topic.ID)
+	if got.Name != "topic" {
+		t.Errorf("expected the cached topic to be unaffected by callers, got %q", got.Name)
+	}
+	if stats := c.Stats(); stats.Hits != 1 {
+		t.Errorf("expected the second read to hit, got %+v", stats)
+	}
+
+	post := &model.Post{Title: "post", AuthorId: 1, AuthorName: "admin", TopicId: topic.ID, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	_, err = posts.InsertPost(ctx, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, topicPosts, _ := topics.GetTopicWithPosts(ctx, topic.ID)
+	if len(topicPosts) != 1 {
+		t.Errorf("expected the new post on the topic page, got %d posts", len(topicPosts))
+	}
+
+	// a rolled back edit leaves the cache alone, a committed one clears it
+	errRollback := errors.New("rollback")
+	for _, want := range []error{errRollback, nil} {
+		err = tx.WithinTx(ctx, func(ctx context.Context) error {
+			topic, err := topics.GetTopicByID(ctx, topic.ID)
+			if err != nil {
+				return err
+			}
+			topic.Name = "renamed"
+			err = topics.UpdateTopic(ctx, topic)
+			if err != nil {
+				return err
+			}
+			return want
+		})
+		if !errors.Is(err, want) {
+			t.Fatalf("expected %v, got %v", want, err)
+		}
+
+		got, _ := topics.GetTopicByID(ctx, topic.ID)
+		if (got.Name == "renamed") != (want == nil) {
+			t.Errorf("unexpected name %q after the transaction returned %v", got.Name, want)
+		}
+	}
+}
+
+func TestEventChannel(t *testing.T) {
+	t.Parallel()
+
+	bus := events.NewLocalBus()
+	c := newCache(10).WithChannel(NewEventChannel(bus))
+
+	for _, key := range []string{"topics", "topic:1"} {
+		_, generation, _ := c.get(key)
+		c.set(key, key, generation)
+	}
+
+	c.Invalidate(context.Background(), "topics")
+	if stats := c.Stats(); stats.Invalidations != 1 {
+		t.Errorf("expected the cache to ignore its own invalidation on the bus, got %+v", stats)
+	}
+
+	bus.Publish(context.Background(), events.Reconnected{})
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Errorf("expected a reconnect to drop everything, got %+v", stats)
+	}
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"context"
+	"simple-forum/internal/events"
+)
+
+// eventChannel carries invalidations as events over a bus.
+type eventChannel struct {
+	bus events.Bus
+}
+
+// NewEventChannel returns a Channel that sends invalidations over bus. When
+// the bus has been disconnected the whole cache is dropped, since
+// invalidations may have been missed.
+func NewEventChannel(bus events.Bus) Channel {
+	return eventChannel{bus: bus}
+}
+
+func (c eventChannel) Publish(ctx context.Context, keys []string) error {
+	c.bus.Publish(ctx, events.CacheInvalidated{Keys: keys})
+	return nil
+}
+
+func (c eventChannel) Subscribe(fn func(keys []string)) {
+	events.Handle(c.bus, func(ctx context.Context, event events.CacheInvalidated) {
+		// this instance dropped its own keys before publishing them
+		if events.Remote(ctx) {
+			fn(event.Keys)
+		}
+	})
+	events.Handle(c.bus, func(context.Context, events.Reconnected) {
+		fn([]string{"*"})
+	})
+}
@@ -0,0 +1,282 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"simple-forum/internal/model"
+	"simple-forum/internal/service"
+	"sync"
+)
+
+// TopicStorage caches the topic list, single topics and topics with their
+// posts of the service.TopicStorage it decorates.
+type TopicStorage struct {
+	next  service.TopicStorage
+	cache *Cache
+}
+
+func NewTopicStorage(next service.TopicStorage, cache *Cache) *TopicStorage {
+	return &TopicStorage{next: next, cache: cache}
+}
+
+// PostStorage caches single posts and the posts of a topic of the
+// service.PostStorage it decorates.
+type PostStorage struct {
+	next  service.PostStorage
+	cache *Cache
+}
+
+func NewPostStorage(next service.PostStorage, cache *Cache) *PostStorage {
+	return &PostStorage{next: next, cache: cache}
+}
+
+// Transactor decorates a service.Transactor. Reads inside a transaction
+// bypass the cache, and what the transaction wrote is invalidated once it
+// has committed.
+type Transactor struct {
+	next  service.Transactor
+	cache *Cache
+}
+
+func NewTransactor(next service.Transactor, cache *Cache) *Transactor {
+	return &Transactor{next: next, cache: cache}
+}
+
+const (
+	topicsKey = "topics"
+	// allPostsKey matches the keys of all single posts
+	allPostsKey = "post:*"
+)
+
+func topicKey(id int) string {
+	return fmt.Sprintf("topic:%d", id)
+}
+
+func topicPostsKey(id int) string {
+	return fmt.Sprintf("topic-posts:%d", id)
+}
+
+func postsByTopicKey(id int) string {
+	return fmt.Sprintf("posts:%d", id)
+}
+
+func postKey(id int) string {
+	return fmt.Sprintf("post:%d", id)
+}
+
+func cloneTopic(topic *model.Topic) *model.Topic {
+	clone := *topic
+	return &clone
+}
+
+func clonePost(post *model.Post) *model.Post {
+	clone := *post
+	return &clone
+}
+
+func cloneTopics(topics []*model.Topic) []*model.Topic {
+	clones := make([]*model.Topic, len(topics))
+	for i, topic := range topics {
+		clones[i] = cloneTopic(topic)
+	}
+	return clones
+}
+
+func clonePosts(posts []*model.Post) []*model.Post {
+	clones := make([]*model.Post, len(posts))
+	for i, post := range posts {
+		clones[i] = clonePost(post)
+	}
+	return clones
+}
+
+// pending collects the keys a transaction invalidates.
+type pending struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+type pendingKey struct{}
+
+// cached returns the value under key, or loads and stores it. Values are
+// cloned on the way in and out, so callers may modify what they get.
+func cached[T any](ctx context.Context, c *Cache, key string, clone func(T) T, load func(ctx context.Context) (T, error)) (T, error) {
+	if _, inTx := ctx.Value(pendingKey{}).(*pending); inTx {
+		return load(ctx)
+	}
+
+	value, generation, ok := c.get(key)
+	if ok {
+		return clone(value.(T)), nil
+	}
+
+	loaded, err := load(ctx)
+	if err != nil {
+		return loaded, err
+	}
+
+	c.set(key, clone(loaded), generation)
+	return loaded, nil
+}
+
+// invalidate drops keys, inside a transaction once it has committed.
+func invalidate(ctx context.Context, c *Cache, keys ...string) {
+	if p, inTx := ctx.Value(pendingKey{}).(*pending); inTx {
+		p.mu.Lock()
+		p.keys = append(p.keys, keys...)
+		p.mu.Unlock()
+		return
+	}
+	c.Invalidate(ctx, keys...)
+}
+
+func (t *Transactor) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, inTx := ctx.Value(pendingKey{}).(*pending); inTx {
+		return t.next.WithinTx(ctx, fn)
+	}
+
+	p := &pending{}
+	err := t.next.WithinTx(context.WithValue(ctx, pendingKey{}, p), fn)
+	if err == nil && len(p.keys) > 0 {
+		t.cache.Invalidate(ctx, p.keys...)
+	}
+	return err
+}
+
+func (t *TopicStorage) GetAllTopics(ctx context.Context) ([]*model.Topic, error) {
+	return cached(ctx, t.cache, topicsKey, cloneTopics, t.next.GetAllTopics)
+}
+
+// GetPublicTopicsByAuthorID is not cached; profiles are read far less
+// often than topics.
+func (t *TopicStorage) GetPublicTopicsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Topic, error) {
+	return t.next.GetPublicTopicsByAuthorID(ctx, authorID, limit)
+}
+
+func (t *TopicStorage) GetTopicByID(ctx context.Context, topicID int) (*model.Topic, error) {
+	return cached(ctx, t.cache, topicKey(topicID), cloneTopic, func(ctx context.Context) (*model.Topic, error) {
+		return t.next.GetTopicByID(ctx, topicID)
+	})
+}
+
+func (t *TopicStorage) GetTopicByPostID(ctx context.Context, postID int) (*model.Topic, error) {
+	return t.next.GetTopicByPostID(ctx, postID)
+}
+
+type topicWithPosts struct {
+	topic *model.Topic
+	posts []*model.Post
+}
+
+func cloneTopicWithPosts(t topicWithPosts) topicWithPosts {
+	return topicWithPosts{topic: cloneTopic(t.topic), posts: clonePosts(t.posts)}
+}
+
+func (t *TopicStorage) GetTopicWithPosts(ctx context.Context, topicID int) (*model.Topic, []*model.Post, error) {
+	result, err := cached(ctx, t.cache, topicPostsKey(topicID), cloneTopicWithPosts, func(ctx context.Context) (topicWithPosts, error) {
+		topic, posts, err := t.next.GetTopicWithPosts(ctx, topicID)
+		return topicWithPosts{topic: topic, posts: posts}, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.topic, result.posts, nil
+}
+
+func (t *TopicStorage) InsertTopic(ctx context.Context, topic *model.Topic) (int, error) {
+	id, err := t.next.InsertTopic(ctx, topic)
+	if err != nil {
+		return 0, err
+	}
+
+	invalidate(ctx, t.cache, topicsKey)
+	return id, nil
+}
+
+func (t *TopicStorage) UpdateTopic(ctx context.Context, topic *model.Topic) error {
+	err := t.next.UpdateTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	invalidate(ctx, t.cache, topicsKey, topicKey(topic.ID), topicPostsKey(topic.ID))
+	return nil
+}
+
+func (t *TopicStorage) DeleteTopic(ctx context.Context, topic *model.Topic) error {
+	err := t.next.DeleteTopic(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	// the posts of the topic are deleted with it
+	invalidate(ctx, t.cache, topicsKey, topicKey(topic.ID), topicPostsKey(topic.ID),
+		postsByTopicKey(topic.ID), allPostsKey)
+	return nil
+}
+
+func (p *PostStorage) GetPostsByTopicID(ctx context.Context, topicID int) ([]*model.Post, error) {
+	return cached(ctx, p.cache, postsByTopicKey(topicID), clonePosts, func(ctx context.Context) ([]*model.Post, error) {
+		return p.next.GetPostsByTopicID(ctx, topicID)
+	})
+}
+
+func (p *PostStorage) GetPostByID(ctx context.Context, postID int) (*model.Post, error) {
+	return cached(ctx, p.cache, postKey(postID), clonePost, func(ctx context.Context) (*model.Post, error) {
+		return p.next.GetPostByID(ctx, postID)
+	})
+}
+
+// GetLatestPublicPosts is not cached, as any write to a post or topic may
+// change it.
+func (p *PostStorage) GetLatestPublicPosts(ctx context.Context, limit int) ([]*model.Post, error) {
+	return p.next.GetLatestPublicPosts(ctx, limit)
+}
+
+// GetPublicPosts is not cached for the same reason.
+func (p *PostStorage) GetPublicPosts(ctx context.Context, offset, limit int) ([]*model.Post, error) {
+	return p.next.GetPublicPosts(ctx, offset, limit)
+}
+
+func (p *PostStorage) CountPublicPosts(ctx context.Context) (int, error) {
+	return p.next.CountPublicPosts(ctx)
+}
+
+// GetPublicPostsByAuthorID is not cached, like GetPublicTopicsByAuthorID.
+func (p *PostStorage) GetPublicPostsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Post, error) {
+	return p.next.GetPublicPostsByAuthorID(ctx, authorID, limit)
+}
+
+func (p *PostStorage) CountPublicPostsByAuthorID(ctx context.Context, authorID int) (int, error) {
+	return p.next.CountPublicPostsByAuthorID(ctx, authorID)
+}
+
+func (p *PostStorage) InsertPost(ctx context.Context, post *model.Post) (int, error) {
+	id, err := p.next.InsertPost(ctx, post)
+	if err != nil {
+		return 0, err
+	}
+
+	invalidate(ctx, p.cache, postsByTopicKey(post.TopicId), topicPostsKey(post.TopicId))
+	return id, nil
+}
+
+func (p *PostStorage) UpdatePost(ctx context.Context, post *model.Post) error {
+	err := p.next.UpdatePost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	invalidate(ctx, p.cache, postKey(post.ID), postsByTopicKey(post.TopicId), topicPostsKey(post.TopicId))
+	return nil
+}
+
+func (p *PostStorage) DeletePost(ctx context.Context, post *model.Post) error {
+	err := p.next.DeletePost(ctx, post)
+	if err != nil {
+		return err
+	}
+
+	invalidate(ctx, p.cache, postKey(post.ID), postsByTopicKey(post.TopicId), topicPostsKey(post.TopicId))
+	return nil
+}
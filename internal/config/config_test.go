@@ -0,0 +1,134 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+// defaults returns the configuration with every variable at its default.
+func defaults(t *testing.T) *Config {
+	t.Helper()
+
+	var c Config
+	if err := cleanenv.ReadEnv(&c); err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+	return &c
+}
+
+func TestConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		apply func(c *Config)
+		valid bool
+	}{
+		{
+			name:  "Defaults",
+			apply: func(c *Config) {},
+			valid: true,
+		},
+		{
+			name: "No Replica Checks",
+			apply: func(c *Config) {
+				c.DB.ReplicaAddrs = []string{"postgres://replica:5432/forum"}
+				c.DB.ReplicaCheckPeriod = 0
+			},
+			valid: false,
+		},
+		{
+			name:  "No Replicas To Check",
+			apply: func(c *Config) { c.DB.ReplicaCheckPeriod = 0 },
+			valid: true,
+		},
+		{
+			name:  "No Idle Eviction",
+			apply: func(c *Config) { c.RateLimit.IdleSeconds = 0 },
+			valid: false,
+		},
+		{
+			name:  "Zero Strict Rate",
+			apply: func(c *Config) { c.RateLimit.StrictPerMinute = 0 },
+			valid: false,
+		},
+		{
+			name:  "Negative Relaxed Rate",
+			apply: func(c *Config) { c.RateLimit.RelaxedPerSecond = -1 },
+			valid: false,
+		},
+		{
+			name:  "Zero Burst",
+			apply: func(c *Config) { c.RateLimit.StrictBurst = 0 },
+			valid: false,
+		},
+		{
+			name:  "No Failure Window",
+			apply: func(c *Config) { c.Login.WindowMinutes = 0 },
+			valid: false,
+		},
+		{
+			name:  "Mail Without Base URL",
+			apply: func(c *Config) { c.SMTP.Addr = "smtp.example.com:587" },
+			valid: false,
+		},
+		{
+			name: "Mail With Relative Base URL",
+			apply: func(c *Config) {
+				c.SMTP.Addr = "smtp.example.com:587"
+				c.Server.BaseURL = "/forum"
+			},
+			valid: false,
+		},
+		{
+			name: "Mail With Base URL",
+			apply: func(c *Config) {
+				c.SMTP.Addr = "smtp.example.com:587"
+				c.Server.BaseURL = "https://forum.example"
+			},
+			valid: true,
+		},
+		{
+			name:  "No Collect Interval",
+			apply: func(c *Config) { c.Attachment.CollectMinutes = 0 },
+			valid: false,
+		},
+		{
+			name: "Known Roles",
+			apply: func(c *Config) {
+				c.OIDC.RoleMapping = map[string]string{"forum-admins": "admin", "staff": "user"}
+			},
+			valid: true,
+		},
+		{
+			name: "Unknown Role",
+			apply: func(c *Config) {
+				c.OIDC.RoleMapping = map[string]string{"forum-admins": "superuser"}
+			},
+			valid: false,
+		},
+		{
+			name: "Role In Wrong Case",
+			apply: func(c *Config) {
+				c.OIDC.RoleMapping = map[string]string{"forum-admins": "Admin"}
+			},
+			valid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaults(t)
+			tt.apply(c)
+
+			err := c.validate()
+			if tt.valid && err != nil {
+				t.Errorf("%s: no error expected, but got %s", tt.name, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("%s: expected an error, got nil", tt.name)
+			}
+		})
+	}
+}
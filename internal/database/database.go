@@ -6,44 +6,154 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"simple-forum/internal/config"
+	"strings"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
-func Connect(addr string) (*sql.DB, error) {
-	conn, err := sql.Open("pgx", addr)
-	if err != nil {
-		return nil, err
+// PoolConfig sizes the PostgreSQL connection pool and the prepared
+// statement cache of each of its connections.
+type PoolConfig struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	// StatementCache is how many prepared statements a connection keeps;
+	// zero prepares every query anew.
+	StatementCache int
+}
+
+// Connect opens addr with the driver config.Config.DBDriver selected. For
+// PostgreSQL the connections come from a pgxpool configured by pool, which
+// is returned too so that repositories can batch queries on it. For SQLite
+// the returned pool is nil.
+func Connect(driver, addr string, pool PoolConfig) (*sql.DB, *pgxpool.Pool, error) {
+	var conn *sql.DB
+	var pgxPool *pgxpool.Pool
+
+	// every query becomes a span of the request that issued it
+	spanOptions := otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true})
+
+	if driver == config.DriverSQLite {
+		var err error
+		conn, err = otelsql.Open("sqlite", sqliteDSN(addr), otelsql.WithAttributes(semconv.DBSystemSqlite), spanOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		var err error
+		pgxPool, err = newPool(addr, pool)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		conn = otelsql.OpenDB(stdlib.GetPoolConnector(pgxPool), otelsql.WithAttributes(semconv.DBSystemPostgreSQL), spanOptions)
+		// connections go back to the pool after every query, which keeps them open
+		conn.SetMaxIdleConns(0)
 	}
 
 	// Ensuring a connection is established
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	err = conn.PingContext(ctx)
+	err := conn.PingContext(ctx)
+	if err != nil {
+		_ = conn.Close()
+		if pgxPool != nil {
+			pgxPool.Close()
+		}
+		return nil, nil, err
+	}
+
+	return conn, pgxPool, nil
+}
+
+func newPool(addr string, pool PoolConfig) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(addr)
 	if err != nil {
 		return nil, err
 	}
 
-	return conn, nil
+	// zero keeps the default of pgxpool, or of the address if it sets pool_max_conns etc.
+	if pool.MaxConns > 0 {
+		poolConfig.MaxConns = pool.MaxConns
+	}
+	if pool.MinConns > 0 {
+		poolConfig.MinConns = pool.MinConns
+	}
+	if pool.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = pool.MaxConnLifetime
+	}
+	if pool.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = pool.MaxConnIdleTime
+	}
+	if pool.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = pool.HealthCheckPeriod
+	}
+
+	// a cached statement is prepared once per connection, after that only
+	// its name and arguments are sent
+	poolConfig.ConnConfig.StatementCacheCapacity = pool.StatementCache
+	poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	if pool.StatementCache == 0 {
+		poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeDescribeExec
+	}
+
+	return pgxpool.NewWithConfig(context.Background(), poolConfig)
 }
 
-func Migrate(addr, path string) error {
-	migrationsAbsPath, err := filepath.Abs(path)
+// sqliteDSN turns sqlite://<path> into what the driver opens. Foreign keys
+// are off in SQLite unless enabled on every connection, transactions take
+// the write lock up front so they wait for each other instead of failing,
+// and times are stored as Unix milliseconds so that they compare and sort
+// like timestamps.
+func sqliteDSN(addr string) string {
+	path, query, _ := strings.Cut(strings.TrimPrefix(addr, "sqlite://"), "?")
+
+	params := []string{
+		"_pragma=foreign_keys(1)",
+		"_pragma=busy_timeout(5000)",
+		"_pragma=journal_mode(WAL)",
+		"_txlock=immediate",
+		"_time_integer_format=unix_milli",
+		"_inttotime=1",
+	}
+	if query != "" {
+		params = append(params, query)
+	}
+
+	return path + "?" + strings.Join(params, "&")
+}
+
+func Migrate(driver, addr, path string) error {
+	migrationsAbsPath, err := filepath.Abs(migrationsDir(driver, path))
 	if err != nil {
 		return fmt.Errorf("failed to get absolute path: %w", err)
 	}
 	migrationsSlashPath := filepath.ToSlash(migrationsAbsPath)
 	migrationsPath := fmt.Sprintf("file://%s", migrationsSlashPath)
 
-	m, err := migrate.New(migrationsPath, addr)
+	var m *migrate.Migrate
+	if driver == config.DriverSQLite {
+		m, err = newSQLiteMigrate(migrationsPath, addr)
+	} else {
+		m, err = migrate.New(migrationsPath, addr)
+	}
 	if err != nil {
 		return err
 	}
+	defer m.Close()
 
 	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
 		return err
@@ -51,3 +161,30 @@ func Migrate(addr, path string) error {
 
 	return nil
 }
+
+// newSQLiteMigrate migrates over a connection opened like Connect does, so
+// the migrations run with foreign keys enabled.
+func newSQLiteMigrate(migrationsPath, addr string) (*migrate.Migrate, error) {
+	conn, err := sql.Open("sqlite", sqliteDSN(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := sqlite.WithInstance(conn, &sqlite.Config{})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return migrate.NewWithDatabaseInstance(migrationsPath, config.DriverSQLite, instance)
+}
+
+// migrationsDir returns where the migrations for driver are kept: the
+// PostgreSQL ones in path itself, the others in a directory named after the
+// driver.
+func migrationsDir(driver, path string) string {
+	if driver == config.DriverSQLite {
+		return filepath.Join(path, driver)
+	}
+	return path
+}
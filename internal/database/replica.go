@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lagQuery measures how far a standby is behind. A standby that has
+// replayed everything it received is treated as current, since the time of
+// the last replayed transaction only tells how long the primary was idle.
+const lagQuery = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END::float8`
+
+// Replica is a streaming replica of the primary PostgreSQL database.
+type Replica struct {
+	// Name identifies the replica in logs and metrics
+	Name string
+	DB   *sql.DB
+	Pool *pgxpool.Pool
+
+	healthy atomic.Bool
+	lag     atomic.Int64
+}
+
+// Healthy reports whether the last check reached the replica and found it
+// within the lag limit.
+func (r *Replica) Healthy() bool {
+	return r.healthy.Load()
+}
+
+// Lag is how far behind the primary the replica was at the last check.
+func (r *Replica) Lag() time.Duration {
+	return time.Duration(r.lag.Load())
+}
+
+// ReplicaSet spreads read-only queries over the replicas that are healthy.
+// A replica is taken out of rotation when it does not answer or lags more
+// than maxLag, and put back once it has caught up.
+type ReplicaSet struct {
+	l        *slog.Logger
+	replicas []*Replica
+	maxLag   time.Duration
+	next     atomic.Uint64
+}
+
+// NewReplicaSet creates a ReplicaSet. Replicas are out of rotation until
+// Check has found them healthy.
+func NewReplicaSet(l *slog.Logger, replicas []*Replica, maxLag time.Duration) *ReplicaSet {
+	return &ReplicaSet{l: l, replicas: replicas, maxLag: maxLag}
+}
+
+// Replicas returns all replicas, healthy or not.
+func (s *ReplicaSet) Replicas() []*Replica {
+	return s.replicas
+}
+
+// Pick returns the next healthy replica, or nil when ctx has to see the
+// primary's latest writes, when no replica is healthy, and on a nil set.
+func (s *ReplicaSet) Pick(ctx context.Context) *Replica {
+	if s == nil || len(s.replicas) == 0 || UsesPrimary(ctx) {
+		return nil
+	}
+
+	// count first so that the turns are shared evenly among the healthy
+	// ones rather than the successor of an unhealthy replica taking its turn
+	healthy := 0
+	for _, replica := range s.replicas {
+		if replica.Healthy() {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return nil
+	}
+
+	turn := int(s.next.Add(1) % uint64(healthy))
+	for _, replica := range s.replicas {
+		if !replica.Healthy() {
+			continue
+		}
+		if turn == 0 {
+			return replica
+		}
+		turn--
+	}
+	// a replica went out of rotation while counting
+	return nil
+}
+
+// Check measures every replica once and updates the rotation.
+func (s *ReplicaSet) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, replica := range s.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.check(ctx, replica)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *ReplicaSet) check(ctx context.Context, replica *Replica) {
+	// the pool rather than DB, so that checks do not show up as traces
+	var seconds float64
+	err := replica.Pool.QueryRow(ctx, lagQuery).Scan(&seconds)
+
+	lag := time.Duration(seconds * float64(time.Second))
+	replica.lag.Store(int64(lag))
+
+	healthy := err == nil && (s.maxLag <= 0 || lag <= s.maxLag)
+	if replica.healthy.Swap(healthy) == healthy {
+		return
+	}
+
+	switch {
+	case healthy:
+		s.l.Info("Replica back in rotation", "replica", replica.Name, "lag", lag)
+	case err != nil:
+		s.l.Warn("Replica out of rotation", "replica", replica.Name, "error", err)
+	default:
+		s.l.Warn("Replica out of rotation", "replica", replica.Name, "lag", lag, "max_lag", s.maxLag)
+	}
+}
+
+// Run checks the replicas every interval until ctx is done.
+func (s *ReplicaSet) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, interval)
+			s.Check(checkCtx)
+			cancel()
+		}
+	}
+}
+
+// Close closes the connections to all replicas.
+func (s *ReplicaSet) Close() error {
+	var err error
+	for _, replica := range s.replicas {
+		if closeErr := replica.DB.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		replica.Pool.Close()
+	}
+	return err
+}
+
+type primaryKey struct{}
+
+// WithPrimary marks ctx so that its queries go to the primary, for
+// requests that write or have to see what they or the same client wrote.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// UsesPrimary reports whether ctx was marked by WithPrimary.
+func UsesPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestReplicaSet_Pick(t *testing.T) {
+	t.Parallel()
+
+	a, b, c := &Replica{Name: "a"}, &Replica{Name: "b"}, &Replica{Name: "c"}
+	a.healthy.Store(true)
+	c.healthy.Store(true)
+
+	set := NewReplicaSet(slog.Default(), []*Replica{a, b, c}, time.Second)
+	ctx := context.Background()
+
+	picked := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		picked[set.Pick(ctx).Name]++
+	}
+	if picked["a"] != 5 || picked["c"] != 5 {
+		t.Errorf("expected the healthy replicas in turn, got %v", picked)
+	}
+
+	if replica := set.Pick(WithPrimary(ctx)); replica != nil {
+		t.Errorf("expected the primary for a context marked WithPrimary, got %s", replica.Name)
+	}
+
+	a.healthy.Store(false)
+	c.healthy.Store(false)
+	if replica := set.Pick(ctx); replica != nil {
+		t.Errorf("expected the primary without healthy replicas, got %s", replica.Name)
+	}
+
+	var none *ReplicaSet
+	if replica := none.Pick(ctx); replica != nil {
+		t.Errorf("expected the primary without replicas, got %s", replica.Name)
+	}
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotMigrated    = errors.New("database has not been migrated")
+	ErrDirtyMigration = errors.New("last migration failed and left the schema dirty")
+	ErrSchemaVersion  = errors.New("schema version does not match the migrations")
+)
+
+// ExpectedVersion returns the highest version among the migrations for
+// driver under path.
+func ExpectedVersion(driver, path string) (uint, error) {
+	files, err := filepath.Glob(filepath.Join(migrationsDir(driver, path), "*.up.sql"))
+	if err != nil {
+		return 0, err
+	}
+
+	var expected uint
+	for _, file := range files {
+		prefix, _, _ := strings.Cut(filepath.Base(file), "_")
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		expected = max(expected, uint(version))
+	}
+
+	return expected, nil
+}
+
+// CheckVersion compares the version recorded by golang-migrate with
+// expected.
+func CheckVersion(ctx context.Context, conn *sql.DB, expected uint) error {
+	var version uint
+	var dirty bool
+
+	err := conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotMigrated
+	}
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("%w: version %d", ErrDirtyMigration, version)
+	}
+	if version != expected {
+		return fmt.Errorf("%w: at %d, expected %d", ErrSchemaVersion, version, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"context"
+	"simple-forum/internal/metrics"
+	"sync"
+)
+
+// Bus delivers published events to every subscriber, on this instance and,
+// depending on the implementation, on the others. Subscribers run on the
+// goroutine that delivers the event and must return quickly.
+type Bus interface {
+	Publish(ctx context.Context, event Event)
+	Subscribe(fn func(ctx context.Context, event Event))
+}
+
+// Handle subscribes fn to the events of type E on bus.
+func Handle[E Event](bus Bus, fn func(ctx context.Context, event E)) {
+	bus.Subscribe(func(ctx context.Context, event Event) {
+		if e, ok := event.(E); ok {
+			fn(ctx, e)
+		}
+	})
+}
+
+type remoteKey struct{}
+
+// Remote reports whether the event being delivered with ctx was published
+// by another instance.
+func Remote(ctx context.Context) bool {
+	remote, _ := ctx.Value(remoteKey{}).(bool)
+	return remote
+}
+
+// LocalBus delivers events within the process. It is the bus of a single
+// instance, and the local half of PostgresBus.
+type LocalBus struct {
+	mu          sync.RWMutex
+	subscribers []func(ctx context.Context, event Event)
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{}
+}
+
+func (b *LocalBus) Publish(ctx context.Context, event Event) {
+	metrics.EventsPublished.WithLabelValues(event.EventType()).Inc()
+	b.deliver(ctx, event)
+}
+
+func (b *LocalBus) Subscribe(fn func(ctx context.Context, event Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers = append(b.subscribers, fn)
+}
+
+func (b *LocalBus) deliver(ctx context.Context, event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, fn := range b.subscribers {
+		fn(ctx, event)
+	}
+}
@@ -0,0 +1,117 @@
+// Package events carries what happens in the forum, such as a post being
+// created, between its parts and between the instances of the forum.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Event is something that happened. Its type names it on the wire.
+type Event interface {
+	EventType() string
+}
+
+type PostCreated struct {
+	PostID   int `json:"post_id"`
+	TopicID  int `json:"topic_id"`
+	AuthorID int `json:"author_id"`
+}
+
+type PostUpdated struct {
+	PostID  int `json:"post_id"`
+	TopicID int `json:"topic_id"`
+}
+
+type PostDeleted struct {
+	PostID  int `json:"post_id"`
+	TopicID int `json:"topic_id"`
+}
+
+type TopicCreated struct {
+	TopicID int `json:"topic_id"`
+}
+
+type TopicUpdated struct {
+	TopicID int `json:"topic_id"`
+}
+
+type TopicDeleted struct {
+	TopicID int `json:"topic_id"`
+}
+
+// CacheInvalidated lists cache keys an instance dropped after a write.
+type CacheInvalidated struct {
+	Keys []string `json:"keys"`
+}
+
+// Reconnected is delivered locally when a bus has reconnected after losing
+// its connection, during which events of other instances may have been
+// missed. It is never sent to other instances.
+type Reconnected struct{}
+
+func (PostCreated) EventType() string      { return "post.created" }
+func (PostUpdated) EventType() string      { return "post.updated" }
+func (PostDeleted) EventType() string      { return "post.deleted" }
+func (TopicCreated) EventType() string     { return "topic.created" }
+func (TopicUpdated) EventType() string     { return "topic.updated" }
+func (TopicDeleted) EventType() string     { return "topic.deleted" }
+func (CacheInvalidated) EventType() string { return "cache.invalidated" }
+func (Reconnected) EventType() string      { return "bus.reconnected" }
+
+// decoders holds the events other instances may send, by type.
+var decoders = map[string]func(payload []byte) (Event, error){}
+
+func register[E Event]() {
+	var zero E
+	decoders[zero.EventType()] = func(payload []byte) (Event, error) {
+		var event E
+		err := json.Unmarshal(payload, &event)
+		return event, err
+	}
+}
+
+func init() {
+	register[PostCreated]()
+	register[PostUpdated]()
+	register[PostDeleted]()
+	register[TopicCreated]()
+	register[TopicUpdated]()
+	register[TopicDeleted]()
+	register[CacheInvalidated]()
+}
+
+// envelope is an event as it travels between instances.
+type envelope struct {
+	Type    string          `json:"type"`
+	Origin  string          `json:"origin"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func encode(origin string, event Event) ([]byte, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Type: event.EventType(), Origin: origin, Payload: payload})
+}
+
+// errUnknownType is returned for events of a newer version of the forum.
+var errUnknownType = errors.New("unknown event type")
+
+func decode(data []byte) (string, Event, error) {
+	var env envelope
+	err := json.Unmarshal(data, &env)
+	if err != nil {
+		return "", nil, err
+	}
+
+	decoder, ok := decoders[env.Type]
+	if !ok {
+		return env.Origin, nil, fmt.Errorf("%w %q", errUnknownType, env.Type)
+	}
+
+	event, err := decoder(env.Payload)
+	return env.Origin, event, err
+}
@@ -0,0 +1,87 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	t.Parallel()
+
+	for _, event := range []Event{
+		PostCreated{PostID: 1, TopicID: 2, AuthorID: 3},
+		PostUpdated{PostID: 1, TopicID: 2},
+		PostDeleted{PostID: 1, TopicID: 2},
+		TopicCreated{TopicID: 2},
+		TopicUpdated{TopicID: 2},
+		TopicDeleted{TopicID: 2},
+		CacheInvalidated{Keys: []string{"topics", "post:*"}},
+	} {
+		data, err := encode("origin", event)
+		if err != nil {
+			t.Fatalf("%s: %v", event.EventType(), err)
+		}
+
+		origin, got, err := decode(data)
+		if err != nil {
+			t.Fatalf("%s: %v", event.EventType(), err)
+		}
+		if origin != "origin" || !reflect.DeepEqual(got, event) {
+			t.Errorf("expected %#v from origin, got %#v from %q", event, got, origin)
+		}
+	}
+
+	_, _, err := decode([]byte(`{"type":"post.liked","origin":"newer","payload":{}}`))
+	if err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
+
+func TestHandle(t *testing.T) {
+	t.Parallel()
+
+	bus := NewLocalBus()
+
+	var created []PostCreated
+	Handle(bus, func(ctx context.Context, event PostCreated) {
+		if Remote(ctx) {
+			t.Error("expected a local event")
+		}
+		created = append(created, event)
+	})
+
+	bus.Publish(context.Background(), TopicDeleted{TopicID: 1})
+	bus.Publish(context.Background(), PostCreated{PostID: 2})
+
+	if len(created) != 1 || created[0].PostID != 2 {
+		t.Errorf("expected only the post.created event, got %v", created)
+	}
+}
+
+func TestPostgresBus_Receive(t *testing.T) {
+	t.Parallel()
+
+	bus := NewPostgresBus(slog.Default(), nil, "forum_events")
+
+	var received []TopicDeleted
+	Handle(bus, func(ctx context.Context, event TopicDeleted) {
+		if !Remote(ctx) {
+			t.Error("expected a remote event")
+		}
+		received = append(received, event)
+	})
+
+	own, _ := encode(bus.origin, TopicDeleted{TopicID: 1})
+	other, _ := encode("other", TopicDeleted{TopicID: 2})
+
+	bus.receive(string(own))
+	bus.receive(string(other))
+	bus.receive(`{"type":"topic.archived","origin":"newer","payload":{}}`)
+	bus.receive(`not json`)
+
+	if len(received) != 1 || received[0].TopicID != 2 {
+		t.Errorf("expected only the event of the other instance, got %v", received)
+	}
+}
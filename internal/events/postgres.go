@@ -0,0 +1,153 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"simple-forum/internal/metrics"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxPayload is the limit PostgreSQL sets on the payload of NOTIFY.
+const maxPayload = 8000
+
+// PostgresBus delivers events to the subscribers of this instance right
+// away and sends them to the other instances with NOTIFY. A dedicated
+// connection LISTENs for theirs and is re-established whenever it is lost.
+type PostgresBus struct {
+	l       *slog.Logger
+	local   *LocalBus
+	pool    *pgxpool.Pool
+	channel string
+	// origin tells this instance's notifications from those of others
+	origin string
+}
+
+// NewPostgresBus creates a PostgresBus that notifies on channel through
+// pool. Events of other instances arrive once Run is running.
+func NewPostgresBus(l *slog.Logger, pool *pgxpool.Pool, channel string) *PostgresBus {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return &PostgresBus{
+		l:       l,
+		local:   NewLocalBus(),
+		pool:    pool,
+		channel: channel,
+		origin:  hex.EncodeToString(b),
+	}
+}
+
+func (b *PostgresBus) Subscribe(fn func(ctx context.Context, event Event)) {
+	b.local.Subscribe(fn)
+}
+
+// Publish delivers event locally and notifies the other instances. Failing
+// to notify is logged; the event has happened regardless.
+func (b *PostgresBus) Publish(ctx context.Context, event Event) {
+	b.local.Publish(ctx, event)
+
+	err := b.notify(ctx, event)
+	if err != nil {
+		b.l.ErrorContext(ctx, "Failed to publish event", "type", event.EventType(), "error", err)
+	}
+}
+
+func (b *PostgresBus) notify(ctx context.Context, event Event) error {
+	payload, err := encode(b.origin, event)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxPayload {
+		return fmt.Errorf("payload of %d bytes exceeds the NOTIFY limit", len(payload))
+	}
+
+	// the write the event reports has been made, so send it even if the
+	// request is cancelled meanwhile
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	_, err = b.pool.Exec(ctx, "SELECT pg_notify($1, $2)", b.channel, string(payload))
+	return err
+}
+
+// Run listens for the events of other instances until ctx is done. After
+// reconnecting it delivers Reconnected, as events may have been missed
+// while the connection was down.
+func (b *PostgresBus) Run(ctx context.Context) {
+	const maxBackoff = 30 * time.Second
+	backoff := time.Second
+	connected := false
+
+	for {
+		err := b.listen(ctx, func() {
+			if connected {
+				b.l.Info("Event listener reconnected")
+				b.local.deliver(context.WithValue(context.Background(), remoteKey{}, true), Reconnected{})
+			}
+			connected = true
+			backoff = time.Second
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		b.l.Warn("Event listener disconnected", "error", err, "retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// listen LISTENs on a connection of its own, since the pool may hand its
+// connections to other queries between notifications.
+func (b *PostgresBus) listen(ctx context.Context, listening func()) error {
+	conn, err := pgx.ConnectConfig(ctx, b.pool.Config().ConnConfig.Copy())
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize())
+	if err != nil {
+		return err
+	}
+	listening()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		b.receive(notification.Payload)
+	}
+}
+
+// receive delivers an event another instance sent.
+func (b *PostgresBus) receive(payload string) {
+	origin, event, err := decode([]byte(payload))
+	if origin == b.origin {
+		return
+	}
+	if errors.Is(err, errUnknownType) {
+		// sent by a newer version during a rolling deploy
+		b.l.Debug("Ignoring event", "error", err)
+		return
+	}
+	if err != nil {
+		b.l.Error("Failed to decode event", "error", err)
+		return
+	}
+
+	metrics.EventsReceived.WithLabelValues(event.EventType()).Inc()
+	b.local.deliver(context.WithValue(context.Background(), remoteKey{}, true), event)
+}
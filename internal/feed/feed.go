@@ -0,0 +1,150 @@
+// Package feed renders lists of posts as Atom and RSS 2.0 feeds.
+package feed
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Feed is a feed independent of its format. Links are absolute URLs.
+type Feed struct {
+	Title       string
+	Description string
+	// Link is the page the feed follows, Self the feed itself
+	Link    string
+	Self    string
+	Updated time.Time
+	Entries []Entry
+}
+
+// Entry is an item of a feed. Its ID must not change as long as the entry
+// exists, or readers show it again as new.
+type Entry struct {
+	ID        string
+	Title     string
+	Link      string
+	Author    string
+	Content   string
+	Published time.Time
+	Updated   time.Time
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Link      atomLink    `xml:"link"`
+	Author    atomAuthor  `xml:"author"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// Atom renders f as an Atom 1.0 document.
+func (f *Feed) Atom() ([]byte, error) {
+	doc := atomFeed{
+		ID:       f.Self,
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Updated:  f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.Self},
+			{Rel: "alternate", Type: "text/html", Href: f.Link},
+		},
+	}
+	for _, e := range f.Entries {
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:        e.ID,
+			Title:     e.Title,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: e.Link},
+			Author:    atomAuthor{Name: e.Author},
+			Published: e.Published.UTC().Format(time.RFC3339),
+			Updated:   e.Updated.UTC().Format(time.RFC3339),
+			Content:   atomContent{Type: "text", Body: e.Content},
+		})
+	}
+	return marshal(doc)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// RSS renders f as an RSS 2.0 document. RSS has no date of change for an
+// item, so only the time it was published is given.
+func (f *Feed) RSS() ([]byte, error) {
+	doc := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Description,
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, e := range f.Entries {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			GUID:        rssGUID{IsPermaLink: e.ID == e.Link, Value: e.ID},
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+			Description: e.Content,
+		})
+	}
+	return marshal(doc)
+}
+
+func marshal(doc any) ([]byte, error) {
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
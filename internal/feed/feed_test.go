@@ -0,0 +1,75 @@
+package feed
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testFeed() *Feed {
+	published := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	updated := time.Date(2025, 3, 2, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+
+	return &Feed{
+		Title:       "Go",
+		Description: "All about <Go>",
+		Link:        "https://forum.example/topics/1",
+		Self:        "https://forum.example/feeds/topics/1.atom",
+		Updated:     updated,
+		Entries: []Entry{{
+			ID:        "https://forum.example/topics/1/posts/7",
+			Title:     "Generics & you",
+			Link:      "https://forum.example/topics/1/posts/7",
+			Author:    "alice",
+			Content:   "if a < b {}",
+			Published: published,
+			Updated:   updated,
+		}},
+	}
+}
+
+func TestAtom(t *testing.T) {
+	t.Parallel()
+
+	data, err := testFeed().Atom()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<feed xmlns="http://www.w3.org/2005/Atom">`,
+		`<id>https://forum.example/feeds/topics/1.atom</id>`,
+		`<subtitle>All about &lt;Go&gt;</subtitle>`,
+		`<link rel="self" type="application/atom+xml" href="https://forum.example/feeds/topics/1.atom"></link>`,
+		`<id>https://forum.example/topics/1/posts/7</id>`,
+		`<title>Generics &amp; you</title>`,
+		`<published>2025-03-01T10:00:00Z</published>`,
+		`<updated>2025-03-02T11:30:00Z</updated>`,
+		`<content type="text">if a &lt; b {}</content>`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %s in\n%s", want, data)
+		}
+	}
+}
+
+func TestRSS(t *testing.T) {
+	t.Parallel()
+
+	data, err := testFeed().RSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<rss version="2.0">`,
+		`<lastBuildDate>Sun, 02 Mar 2025 11:30:00 +0000</lastBuildDate>`,
+		`<guid isPermaLink="true">https://forum.example/topics/1/posts/7</guid>`,
+		`<pubDate>Sat, 01 Mar 2025 10:00:00 +0000</pubDate>`,
+		`<description>if a &lt; b {}</description>`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %s in\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"simple-forum/internal/model"
+)
+
+type AuditService interface {
+	Record(ctx context.Context, entry *model.AuditEntry, before, after any) error
+	GetEntries(ctx context.Context, filter model.AuditFilter) ([]*model.AuditEntry, error)
+}
+
+// newAuditEntry fills in the acting user, client address and request id
+// from r.
+func newAuditEntry(r *http.Request, action, targetType string, targetID int) *model.AuditEntry {
+	entry := &model.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         ClientIP(r),
+	}
+
+	entry.RequestID, _ = r.Context().Value("request_id").(string)
+
+	if user, err := userFromContext(r); err == nil {
+		entry.ActorID = user.ID
+		entry.ActorName = user.Name
+	}
+
+	return entry
+}
+
+// recordAudit stores entry. The audited action has already happened at this
+// point, so a failure is logged rather than reported to the user, and the
+// entry is written even if the client has gone away in the meantime.
+func recordAudit(ctx context.Context, l *slog.Logger, as AuditService, entry *model.AuditEntry, before, after any) {
+	err := as.Record(context.WithoutCancel(ctx), entry, before, after)
+	if err != nil {
+		l.ErrorContext(ctx, "Failed to record audit entry", "action", entry.Action, "target_id", entry.TargetID, "error", err.Error())
+	}
+}
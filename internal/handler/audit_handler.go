@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"encoding/csv"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"simple-forum/internal/model"
+	"simple-forum/internal/service"
+	"simple-forum/internal/template"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const auditPageSize = 100
+
+type AuditHandler struct {
+	l  *slog.Logger
+	t  *template.Templates
+	as AuditService
+}
+
+func NewAuditHandler(l *slog.Logger, t *template.Templates, as AuditService) *AuditHandler {
+	return &AuditHandler{l: l, t: t, as: as}
+}
+
+// auditFilter reads the filter from the query. from and to are dates, to
+// includes the whole day.
+func auditFilter(query url.Values) model.AuditFilter {
+	filter := model.AuditFilter{
+		Action:     query.Get("action"),
+		Actor:      query.Get("actor"),
+		TargetType: query.Get("target_type"),
+	}
+
+	if id, err := strconv.Atoi(query.Get("target_id")); err == nil {
+		filter.TargetID = id
+	}
+	if from, err := time.Parse(time.DateOnly, query.Get("from")); err == nil {
+		filter.From = from
+	}
+	if to, err := time.Parse(time.DateOnly, query.Get("to")); err == nil {
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	if offset, err := strconv.Atoi(query.Get("offset")); err == nil && offset > 0 {
+		filter.Offset = offset
+	}
+
+	return filter
+}
+
+func (h *AuditHandler) GetAudit(rw http.ResponseWriter, r *http.Request) {
+	filter := auditFilter(r.URL.Query())
+	// one extra entry tells whether there is a next page
+	filter.Limit = auditPageSize + 1
+
+	entries, err := h.as.GetEntries(r.Context(), filter)
+	if err != nil {
+		msg := "Unable to get audit log"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	query := r.URL.Query()
+	query.Del("offset")
+
+	data := make(map[string]any)
+	data["query"] = query
+	data["actions"] = service.AuditActions
+	data["csv"] = "/admin/audit.csv?" + query.Encode()
+	if len(entries) > auditPageSize {
+		entries = entries[:auditPageSize]
+		query.Set("offset", strconv.Itoa(filter.Offset+auditPageSize))
+		data["next"] = "/admin/audit?" + query.Encode()
+	}
+	if filter.Offset > 0 {
+		query.Set("offset", strconv.Itoa(max(filter.Offset-auditPageSize, 0)))
+		data["previous"] = "/admin/audit?" + query.Encode()
+	}
+	data["entries"] = entries
+
+	err = h.t.Render(rw, r, "audit.page", &model.Page{
+		Data: data,
+	})
+	if err != nil {
+		msg := "Unable to render template"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+}
+
+// csvText keeps spreadsheets from running a cell as a formula, as they do
+// for text starting with one of these characters, by prefixing a quote.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// GetAuditCSV exports all entries matching the filter, ignoring pagination.
+func (h *AuditHandler) GetAuditCSV(rw http.ResponseWriter, r *http.Request) {
+	filter := auditFilter(r.URL.Query())
+	filter.Offset = 0
+
+	entries, err := h.as.GetEntries(r.Context(), filter)
+	if err != nil {
+		msg := "Unable to get audit log"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/csv")
+	rw.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+
+	w := csv.NewWriter(rw)
+	_ = w.Write([]string{"id", "time", "actor_id", "actor", "action", "target_type", "target_id",
+		"before", "after", "ip", "request_id"})
+	for _, e := range entries {
+		_ = w.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.Itoa(e.ActorID),
+			csvText(e.ActorName),
+			csvText(e.Action),
+			csvText(e.TargetType),
+			strconv.Itoa(e.TargetID),
+			csvText(e.Before),
+			csvText(e.After),
+			csvText(e.IP),
+			csvText(e.RequestID),
+		})
+	}
+	w.Flush()
+
+	if err = w.Error(); err != nil {
+		h.l.ErrorContext(r.Context(), "Failed to write audit export", "error", err.Error())
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"simple-forum/internal/model"
+	"testing"
+	"time"
+)
+
+// auditStub returns entries and keeps the filter it was asked with.
+type auditStub struct {
+	entries []*model.AuditEntry
+	filter  model.AuditFilter
+}
+
+func (s *auditStub) Record(ctx context.Context, entry *model.AuditEntry, before, after any) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *auditStub) GetEntries(ctx context.Context, filter model.AuditFilter) ([]*model.AuditEntry, error) {
+	s.filter = filter
+	return s.entries, nil
+}
+
+func TestAuditHandler_GetAuditCSV(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		entry model.AuditEntry
+		cells map[int]string
+	}{
+		{
+			name:  "Plain",
+			entry: model.AuditEntry{ID: 1, CreatedAt: created, ActorID: 2, ActorName: "ada", Action: "post.edit", TargetType: "post", TargetID: 3, After: `{"id":3}`, IP: "10.0.0.1", RequestID: "abc"},
+			cells: map[int]string{0: "1", 1: "2026-03-01T12:30:00Z", 3: "ada", 4: "post.edit", 8: `{"id":3}`, 9: "10.0.0.1"},
+		},
+		{
+			name:  "Formula",
+			entry: model.AuditEntry{ID: 2, CreatedAt: created, ActorName: "=HYPERLINK(\"http://evil\")", Action: "user.login_failed"},
+			cells: map[int]string{3: "'=HYPERLINK(\"http://evil\")"},
+		},
+		{
+			name:  "Plus And Minus",
+			entry: model.AuditEntry{ID: 3, CreatedAt: created, ActorName: "+1", Before: "-2+3"},
+			cells: map[int]string{3: "'+1", 7: "'-2+3"},
+		},
+		{
+			name:  "At",
+			entry: model.AuditEntry{ID: 4, CreatedAt: created, ActorName: "@SUM(A1)"},
+			cells: map[int]string{3: "'@SUM(A1)"},
+		},
+		{
+			name:  "Tab And Carriage Return",
+			entry: model.AuditEntry{ID: 5, CreatedAt: created, ActorName: "\t=1", RequestID: "\r=1"},
+			cells: map[int]string{3: "'\t=1", 10: "'\r=1"},
+		},
+		{
+			name:  "Sign Inside",
+			entry: model.AuditEntry{ID: 6, CreatedAt: created, ActorName: "a=b", IP: "::1"},
+			cells: map[int]string{3: "a=b", 9: "::1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			entry := tt.entry
+			h := NewAuditHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, &auditStub{entries: []*model.AuditEntry{&entry}})
+
+			rw := httptest.NewRecorder()
+			h.GetAuditCSV(rw, httptest.NewRequest(http.MethodGet, "/admin/audit.csv", nil))
+
+			if got := rw.Header().Get("Content-Type"); got != "text/csv" {
+				t.Errorf("expected text/csv, got %q", got)
+			}
+
+			records, err := csv.NewReader(rw.Body).ReadAll()
+			if err != nil {
+				t.Fatalf("unable to read export: %v", err)
+			}
+			if len(records) != 2 || len(records[1]) != 11 {
+				t.Fatalf("expected a header and one row of 11 cells, got %q", records)
+			}
+			for i, want := range tt.cells {
+				if got := records[1][i]; got != want {
+					t.Errorf("cell %s: expected %q, got %q", records[0][i], want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAuditHandler_GetAuditCSVFilter(t *testing.T) {
+	t.Parallel()
+
+	as := new(auditStub)
+	h := NewAuditHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, as)
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/audit.csv?action=post.edit&actor=ada&target_type=post&target_id=3&from=2026-03-01&to=2026-03-02&offset=100", nil)
+	h.GetAuditCSV(httptest.NewRecorder(), r)
+
+	want := model.AuditFilter{
+		Action:     "post.edit",
+		Actor:      "ada",
+		TargetType: "post",
+		TargetID:   3,
+		From:       time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:         time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC),
+	}
+	if as.filter != want {
+		t.Errorf("expected the whole filtered log without offset, %+v, got %+v", want, as.filter)
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+var (
+	ErrNoUser          = errors.New("cant get value from context")
+	ErrInvalidUserType = errors.New("invalid user type")
+	ErrInvalidUserID   = errors.New("invalid user ID type")
+	ErrInvalidUserName = errors.New("invalid user name type")
+)
+
+type contextUser struct {
+	ID   int
+	Name string
+	Role string
+}
+
+// userFromContext reads the user AuthMiddleware stored in the request context.
+func userFromContext(r *http.Request) (*contextUser, error) {
+	userValue := r.Context().Value("user")
+	if userValue == nil {
+		return nil, ErrNoUser
+	}
+
+	user, ok := userValue.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidUserType
+	}
+
+	userIDFloat, ok := user["id"].(float64)
+	if !ok {
+		return nil, ErrInvalidUserID
+	}
+
+	userName, ok := user["name"].(string)
+	if !ok {
+		return nil, ErrInvalidUserName
+	}
+
+	userRole, _ := user["role"].(string)
+
+	return &contextUser{ID: int(userIDFloat), Name: userName, Role: userRole}, nil
+}
+
+// ClientIP returns the host part of r.RemoteAddr, which RealIPMiddleware
+// has already resolved when running behind a proxy. Rate limits, login
+// throttles and the audit log all take the client address from here.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// siteURL returns baseURL, the configured public address of the forum, or
+// the address r was sent to when none is configured.
+func siteURL(baseURL string, r *http.Request) string {
+	if baseURL != "" {
+		return baseURL
+	}
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
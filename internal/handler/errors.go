@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// ServerError answers a request that failed with err. A query cut off by its
+// timeout becomes 504, and one cancelled because the client went away or the
+// server is shutting down becomes 503; neither is logged as an error. The
+// middleware answers with it too, so that timeouts look the same
+// everywhere.
+func ServerError(rw http.ResponseWriter, r *http.Request, l *slog.Logger, msg string, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(rw, "Request timed out", http.StatusGatewayTimeout)
+		l.WarnContext(r.Context(), msg, "error", err.Error())
+	case errors.Is(err, context.Canceled):
+		http.Error(rw, "Request cancelled", http.StatusServiceUnavailable)
+		l.InfoContext(r.Context(), msg, "error", err.Error())
+	default:
+		http.Error(rw, msg, http.StatusInternalServerError)
+		l.ErrorContext(r.Context(), msg, "error", err.Error())
+	}
+}
+
+// NotFound answers 404 for a failed lookup unless the lookup was cut off,
+// see ServerError.
+func NotFound(rw http.ResponseWriter, r *http.Request, l *slog.Logger, msg string, err error) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		ServerError(rw, r, l, msg, err)
+		return
+	}
+	http.Error(rw, msg, http.StatusNotFound)
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerError(t *testing.T) {
+	t.Parallel()
+
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name     string
+		err      error
+		notFound bool
+		status   int
+	}{
+		{name: "Query Failed", err: errors.New("connection reset"), status: http.StatusInternalServerError},
+		{name: "Query Timed Out", err: fmt.Errorf("timeout: %w", context.DeadlineExceeded), status: http.StatusGatewayTimeout},
+		{name: "Request Cancelled", err: context.Canceled, status: http.StatusServiceUnavailable},
+		{name: "Missing Row", err: errors.New("sql: no rows in result set"), notFound: true, status: http.StatusNotFound},
+		{name: "Lookup Timed Out", err: context.DeadlineExceeded, notFound: true, status: http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/topics/1", nil)
+
+		if tt.notFound {
+			NotFound(rw, r, l, "Topic Not Found", tt.err)
+		} else {
+			ServerError(rw, r, l, "Unable to get topic", tt.err)
+		}
+
+		if rw.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, rw.Code)
+		}
+	}
+}
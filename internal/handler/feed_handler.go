@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"simple-forum/internal/feed"
+	"simple-forum/internal/model"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedSize is the number of posts a feed carries.
+const feedSize = 50
+
+var feedTypes = map[string]string{
+	"atom": "application/atom+xml; charset=utf-8",
+	"rss":  "application/rss+xml; charset=utf-8",
+}
+
+type FeedHandler struct {
+	l  *slog.Logger
+	ps PostService
+	ts TopicService
+	// baseURL makes links absolute; the host of the request is used when
+	// it is empty
+	baseURL string
+}
+
+func NewFeedHandler(l *slog.Logger, ps PostService, ts TopicService, baseURL string) *FeedHandler {
+	return &FeedHandler{l: l, ps: ps, ts: ts, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// GetLatest serves the most recently updated posts of all public topics,
+// as latest.atom or latest.rss.
+func (f *FeedHandler) GetLatest(rw http.ResponseWriter, r *http.Request) {
+	format := strings.TrimPrefix(r.URL.Path, "/feeds/latest.")
+
+	posts, err := f.ps.GetLatestPublicPosts(r.Context(), feedSize)
+	if err != nil {
+		ServerError(rw, r, f.l, "Unable to get posts", err)
+		return
+	}
+
+	topics, err := f.ts.GetAllTopics(r.Context())
+	if err != nil {
+		ServerError(rw, r, f.l, "Unable to get topics", err)
+		return
+	}
+
+	base := siteURL(f.baseURL, r)
+	f.serve(rw, r, format, &feed.Feed{
+		Title:       "SimpleForum",
+		Description: "Latest posts on SimpleForum",
+		Link:        base + "/topics",
+		Self:        base + "/feeds/latest." + format,
+	}, posts, topics)
+}
+
+// GetTopic serves the posts of a topic as {topicID}.atom or {topicID}.rss.
+// Private topics have no feed, since feed readers do not sign in.
+func (f *FeedHandler) GetTopic(rw http.ResponseWriter, r *http.Request) {
+	stringID, format, _ := strings.Cut(r.PathValue("feed"), ".")
+	id, err := strconv.Atoi(stringID)
+	if err != nil {
+		http.Error(rw, "Invalid Topic ID", http.StatusBadRequest)
+		return
+	}
+
+	topic, posts, err := f.ts.GetTopicWithPosts(r.Context(), id)
+	if err != nil {
+		NotFound(rw, r, f.l, "Topic Not Found", err)
+		return
+	}
+	if topic.Private {
+		http.Error(rw, "Topic Not Found", http.StatusNotFound)
+		return
+	}
+
+	posts = slices.Clone(posts)
+	slices.SortFunc(posts, func(a, b *model.Post) int {
+		return cmp.Or(b.UpdatedAt.Compare(a.UpdatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	posts = posts[:min(len(posts), feedSize)]
+
+	base := siteURL(f.baseURL, r)
+	f.serve(rw, r, format, &feed.Feed{
+		Title:       topic.Name,
+		Description: topic.Description,
+		Link:        base + topic.Path(),
+		Self:        fmt.Sprintf("%s/feeds/topics/%d.%s", base, topic.ID, format),
+		Updated:     topic.CreatedAt,
+	}, posts, []*model.Topic{topic})
+}
+
+// serve renders posts of topics into doc in format. Rendering is cheap next
+// to the query, so the ETag is computed from the document, which lets
+// http.ServeContent answer conditional requests.
+//
+// Entries link to the canonical path of a post, but are identified by the
+// numeric one, which stays the same when the post or its topic is renamed.
+func (f *FeedHandler) serve(rw http.ResponseWriter, r *http.Request, format string, doc *feed.Feed, posts []*model.Post, topics []*model.Topic) {
+	contentType, ok := feedTypes[format]
+	if !ok {
+		http.Error(rw, "Feed Not Found", http.StatusNotFound)
+		return
+	}
+
+	byID := make(map[int]*model.Topic, len(topics))
+	for _, topic := range topics {
+		byID[topic.ID] = topic
+	}
+
+	base := siteURL(f.baseURL, r)
+	for _, post := range posts {
+		topic, ok := byID[post.TopicId]
+		if !ok {
+			// deleted since the posts were read
+			continue
+		}
+		doc.Entries = append(doc.Entries, feed.Entry{
+			ID:        fmt.Sprintf("%s/topics/%d/posts/%d", base, post.TopicId, post.ID),
+			Title:     post.Title,
+			Link:      base + post.Path(topic),
+			Author:    post.AuthorName,
+			Content:   post.Content,
+			Published: post.CreatedAt,
+			Updated:   post.UpdatedAt,
+		})
+		if post.UpdatedAt.After(doc.Updated) {
+			doc.Updated = post.UpdatedAt
+		}
+	}
+	if doc.Updated.IsZero() {
+		doc.Updated = time.Unix(0, 0)
+	}
+
+	var body []byte
+	var err error
+	if format == "atom" {
+		body, err = doc.Atom()
+	} else {
+		body, err = doc.RSS()
+	}
+	if err != nil {
+		ServerError(rw, r, f.l, "Unable to render feed", err)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("Cache-Control", "public, max-age=300")
+	rw.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+
+	// Last-Modified is left out for the epoch, so for feeds without posts
+	http.ServeContent(rw, r, "", doc.Updated, bytes.NewReader(body))
+}
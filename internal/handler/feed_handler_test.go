@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"simple-forum/internal/model"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errNoTopic = errors.New("topic not found")
+
+// fakeServices serves topics and posts to the feed and sitemap handlers;
+// the methods they do not use panic through the nil interfaces.
+type fakeServices struct {
+	PostService
+	TopicService
+	topics map[int]*model.Topic
+	posts  []*model.Post
+}
+
+func (s *fakeServices) GetLatestPublicPosts(_ context.Context, limit int) ([]*model.Post, error) {
+	var posts []*model.Post
+	for _, post := range s.posts {
+		if !s.topics[post.TopicId].Private && len(posts) < limit {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+func (s *fakeServices) GetTopicWithPosts(_ context.Context, id int) (*model.Topic, []*model.Post, error) {
+	topic, ok := s.topics[id]
+	if !ok {
+		return nil, nil, errNoTopic
+	}
+
+	var posts []*model.Post
+	for _, post := range s.posts {
+		if post.TopicId == id {
+			posts = append(posts, post)
+		}
+	}
+	return topic, posts, nil
+}
+
+func (s *fakeServices) GetAllTopics(context.Context) ([]*model.Topic, error) {
+	return slices.SortedFunc(maps.Values(s.topics), func(a, b *model.Topic) int { return a.ID - b.ID }), nil
+}
+
+func (s *fakeServices) GetPublicPosts(ctx context.Context, offset, limit int) ([]*model.Post, error) {
+	posts, _ := s.GetLatestPublicPosts(ctx, len(s.posts))
+	slices.SortFunc(posts, func(a, b *model.Post) int { return a.ID - b.ID })
+	offset = min(offset, len(posts))
+	return posts[offset:min(offset+limit, len(posts))], nil
+}
+
+func (s *fakeServices) CountPublicPosts(ctx context.Context) (int, error) {
+	posts, _ := s.GetLatestPublicPosts(ctx, len(s.posts))
+	return len(posts), nil
+}
+
+// newFakeServices returns the public topic 1 with posts 2 and 3, and the
+// private topic 2 with post 4.
+func newFakeServices() *fakeServices {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &fakeServices{
+		topics: map[int]*model.Topic{
+			1: {ID: 1, Name: "Go", CreatedAt: created},
+			2: {ID: 2, Name: "Staff", CreatedAt: created, Private: true},
+		},
+		posts: []*model.Post{
+			{ID: 3, Title: "Edited", TopicId: 1, CreatedAt: created, UpdatedAt: created.Add(48 * time.Hour)},
+			{ID: 4, Title: "Secret", TopicId: 2, CreatedAt: created, UpdatedAt: created.Add(24 * time.Hour)},
+			{ID: 2, Title: "First", TopicId: 1, CreatedAt: created, UpdatedAt: created.Add(time.Hour)},
+		},
+	}
+}
+
+func newTestFeedHandler() *FeedHandler {
+	s := newFakeServices()
+	return NewFeedHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), s, s, "https://forum.example/")
+}
+
+func serveFeed(h *FeedHandler, path string, header http.Header) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /feeds/latest.atom", h.GetLatest)
+	mux.HandleFunc("GET /feeds/latest.rss", h.GetLatest)
+	mux.HandleFunc("GET /feeds/topics/{feed}", h.GetTopic)
+
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, r)
+	return rw
+}
+
+func TestFeedHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		contains    []string
+		excludes    []string
+	}{
+		{
+			path: "/feeds/latest.atom", status: http.StatusOK, contentType: "application/atom+xml",
+			contains: []string{
+				"<id>https://forum.example/feeds/latest.atom</id>",
+				"<id>https://forum.example/topics/1/posts/3</id>",
+				"<updated>2025-01-03T00:00:00Z</updated>",
+			},
+			excludes: []string{"Secret"},
+		},
+		{
+			path: "/feeds/latest.rss", status: http.StatusOK, contentType: "application/rss+xml",
+			contains: []string{
+				"<link>https://forum.example/topics/1-go/posts/2-first</link>",
+				`<guid isPermaLink="false">https://forum.example/topics/1/posts/2</guid>`,
+			},
+			excludes: []string{"Secret"},
+		},
+		{
+			path: "/feeds/topics/1.atom", status: http.StatusOK, contentType: "application/atom+xml",
+			contains: []string{"<title>Go</title>", "<id>https://forum.example/feeds/topics/1.atom</id>"},
+		},
+		{path: "/feeds/topics/2.atom", status: http.StatusNotFound},
+		{path: "/feeds/topics/9.rss", status: http.StatusNotFound},
+		{path: "/feeds/topics/1.json", status: http.StatusNotFound},
+		{path: "/feeds/topics/go.atom", status: http.StatusBadRequest},
+	}
+
+	h := newTestFeedHandler()
+	for _, tt := range tests {
+		rw := serveFeed(h, tt.path, nil)
+
+		if rw.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rw.Code)
+			continue
+		}
+		if !strings.HasPrefix(rw.Header().Get("Content-Type"), tt.contentType) {
+			t.Errorf("%s: expected content type %q, got %q", tt.path, tt.contentType, rw.Header().Get("Content-Type"))
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(rw.Body.String(), want) {
+				t.Errorf("%s: expected %s in\n%s", tt.path, want, rw.Body)
+			}
+		}
+		for _, unwanted := range tt.excludes {
+			if strings.Contains(rw.Body.String(), unwanted) {
+				t.Errorf("%s: expected no %s in\n%s", tt.path, unwanted, rw.Body)
+			}
+		}
+	}
+}
+
+func TestFeedHandler_Conditional(t *testing.T) {
+	t.Parallel()
+
+	h := newTestFeedHandler()
+
+	rw := serveFeed(h, "/feeds/topics/1.atom", nil)
+	etag := rw.Header().Get("ETag")
+	lastModified := rw.Header().Get("Last-Modified")
+	if etag == "" || lastModified != "Fri, 03 Jan 2025 00:00:00 GMT" {
+		t.Fatalf("expected an ETag and the time of the last edit, got %q and %q", etag, lastModified)
+	}
+
+	rw = serveFeed(h, "/feeds/topics/1.atom", http.Header{"If-None-Match": {etag}})
+	if rw.Code != http.StatusNotModified {
+		t.Errorf("expected %d for a matching ETag, got %d", http.StatusNotModified, rw.Code)
+	}
+
+	rw = serveFeed(h, "/feeds/topics/1.atom", http.Header{"If-Modified-Since": {lastModified}})
+	if rw.Code != http.StatusNotModified {
+		t.Errorf("expected %d when not modified since, got %d", http.StatusNotModified, rw.Code)
+	}
+
+	rw = serveFeed(h, "/feeds/topics/1.atom", http.Header{"If-None-Match": {`"stale"`}})
+	if rw.Code != http.StatusOK {
+		t.Errorf("expected %d for a stale ETag, got %d", http.StatusOK, rw.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheck is one dependency the application needs to serve requests.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+type HealthHandler struct {
+	l        *slog.Logger
+	checks   []HealthCheck
+	timeout  time.Duration
+	draining atomic.Bool
+}
+
+// NewHealthHandler creates a HealthHandler that runs checks for readiness,
+// each bounded by timeout.
+func NewHealthHandler(l *slog.Logger, timeout time.Duration, checks ...HealthCheck) *HealthHandler {
+	return &HealthHandler{l: l, timeout: timeout, checks: checks}
+}
+
+// Drain makes readiness fail from now on, so load balancers stop sending
+// requests before the server shuts down.
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// GetHealthz reports that the process is alive; it checks nothing else.
+func (h *HealthHandler) GetHealthz(rw http.ResponseWriter, r *http.Request) {
+	h.write(rw, r, http.StatusOK, &healthReport{Status: "ok"})
+}
+
+// GetReadyz runs all checks concurrently and answers 503 if any fails or the
+// server is draining.
+func (h *HealthHandler) GetReadyz(rw http.ResponseWriter, r *http.Request) {
+	report := &healthReport{Status: "ready", Checks: make(map[string]checkResult)}
+
+	if h.draining.Load() {
+		report.Status = "draining"
+		h.write(rw, r, http.StatusServiceUnavailable, report)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result := checkResult{Status: "ok"}
+			if err := check.Check(ctx); err != nil {
+				result = checkResult{Status: "fail", Error: err.Error()}
+			}
+
+			mu.Lock()
+			report.Checks[check.Name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, result := range report.Checks {
+		if result.Status != "ok" {
+			report.Status = "not ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	h.write(rw, r, status, report)
+}
+
+func (h *HealthHandler) write(rw http.ResponseWriter, r *http.Request, status int, report *healthReport) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+
+	err := json.NewEncoder(rw).Encode(report)
+	if err != nil {
+		h.l.ErrorContext(r.Context(), "Unable to encode health report", "error", err.Error())
+		return
+	}
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetReadyz(t *testing.T) {
+	t.Parallel()
+
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ok := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("connection refused") }
+
+	tests := []struct {
+		name     string
+		checks   []HealthCheck
+		draining bool
+		status   int
+		report   string
+		failed   string
+	}{
+		{name: "All Checks Pass", checks: []HealthCheck{{"database", ok}, {"templates", ok}}, status: http.StatusOK, report: "ready"},
+		{name: "Database Down", checks: []HealthCheck{{"database", down}, {"templates", ok}}, status: http.StatusServiceUnavailable, report: "not ready", failed: "database"},
+		{name: "Draining", checks: []HealthCheck{{"database", ok}}, draining: true, status: http.StatusServiceUnavailable, report: "draining"},
+	}
+
+	for _, tt := range tests {
+		h := NewHealthHandler(l, time.Second, tt.checks...)
+		if tt.draining {
+			h.Drain()
+		}
+
+		rw := httptest.NewRecorder()
+		h.GetReadyz(rw, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		if rw.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, rw.Code)
+		}
+
+		var report healthReport
+		if err := json.NewDecoder(rw.Body).Decode(&report); err != nil {
+			t.Fatalf("%s: unable to decode report: %v", tt.name, err)
+		}
+
+		if report.Status != tt.report {
+			t.Errorf("%s: expected report status %q, got %q", tt.name, tt.report, report.Status)
+		}
+
+		if tt.failed != "" && report.Checks[tt.failed].Status != "fail" {
+			t.Errorf("%s: expected check %q to fail, got %+v", tt.name, tt.failed, report.Checks)
+		}
+	}
+}
+
+func TestGetHealthzWhileDraining(t *testing.T) {
+	t.Parallel()
+
+	h := NewHealthHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), time.Second)
+	h.Drain()
+
+	rw := httptest.NewRecorder()
+	h.GetHealthz(rw, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rw.Code != http.StatusOK {
+		t.Errorf("expected liveness to stay %d while draining, got %d", http.StatusOK, rw.Code)
+	}
+}
@@ -23,18 +23,19 @@ func (h *HomeHandler) GetHome(rw http.ResponseWriter, r *http.Request) {
 	err := h.t.Render(rw, r, "home.page", new(model.Page))
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		h.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, h.l, msg, err)
 		return
 	}
 }
 
 func (h *HomeHandler) GetAbout(rw http.ResponseWriter, r *http.Request) {
-	err := h.t.Render(rw, r, "about.page", new(model.Page))
+	err := h.t.Render(rw, r, "about.page", &model.Page{
+		Title:       "About",
+		Description: "What SimpleForum is and how it works.",
+	})
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		h.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, h.l, msg, err)
 		return
 	}
 }
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"simple-forum/internal/auth"
+)
+
+type KeySet interface {
+	JWKS() auth.JWKSet
+}
+
+type KeyHandler struct {
+	l  *slog.Logger
+	ks KeySet
+}
+
+func NewKeyHandler(l *slog.Logger, ks KeySet) *KeyHandler {
+	return &KeyHandler{l: l, ks: ks}
+}
+
+func (k *KeyHandler) GetJWKS(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "public, max-age=300")
+
+	err := json.NewEncoder(rw).Encode(k.ks.JWKS())
+	if err != nil {
+		k.l.ErrorContext(r.Context(), "Unable to encode key set", "error", err.Error())
+		return
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"simple-forum/internal/model"
+	"simple-forum/internal/service"
+	"simple-forum/internal/template"
+	"time"
+)
+
+type LoginGuard interface {
+	GetLockouts(ctx context.Context) ([]*model.LoginThrottle, error)
+	ClearLockout(ctx context.Context, key string) error
+}
+
+type LockoutHandler struct {
+	l  *slog.Logger
+	t  *template.Templates
+	lg LoginGuard
+	as AuditService
+}
+
+func NewLockoutHandler(l *slog.Logger, t *template.Templates, lg LoginGuard, as AuditService) *LockoutHandler {
+	return &LockoutHandler{l: l, t: t, lg: lg, as: as}
+}
+
+func (h *LockoutHandler) GetLockouts(rw http.ResponseWriter, r *http.Request) {
+	lockouts, err := h.lg.GetLockouts(r.Context())
+	if err != nil {
+		msg := "Unable to get lockouts"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	data := make(map[string]any)
+	data["lockouts"] = lockouts
+	data["now"] = time.Now()
+
+	err = h.t.Render(rw, r, "lockouts.page", &model.Page{
+		Data: data,
+	})
+	if err != nil {
+		msg := "Unable to render template"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+}
+
+func (h *LockoutHandler) PostClearLockout(rw http.ResponseWriter, r *http.Request) {
+	key := r.PostFormValue("key")
+	if key == "" {
+		http.Error(rw, "Invalid Lockout", http.StatusBadRequest)
+		return
+	}
+
+	err := h.lg.ClearLockout(r.Context(), key)
+	if err != nil {
+		msg := "Unable to clear lockout"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	entry := newAuditEntry(r, service.AuditLockoutClear, service.AuditTargetLockout, 0)
+	recordAudit(r.Context(), h.l, h.as, entry, map[string]string{"key": key}, nil)
+
+	http.Redirect(rw, r, "/admin/lockouts", http.StatusFound)
+}
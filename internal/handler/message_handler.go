@@ -0,0 +1,463 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"simple-forum/internal/model"
+	"simple-forum/internal/service"
+	"simple-forum/internal/template"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type MessageService interface {
+	StartConversation(ctx context.Context, senderID int, recipients []string, subject, body string) (*model.Conversation, error)
+	Reply(ctx context.Context, senderID, conversationID int, body string) (*model.Message, error)
+	GetConversations(ctx context.Context, userID int) ([]*model.Conversation, error)
+	GetConversation(ctx context.Context, userID, conversationID int) (*model.Conversation, []*model.Message, error)
+	MarkRead(ctx context.Context, userID, conversationID, messageID int) error
+	SetMuted(ctx context.Context, userID, conversationID int, muted bool) error
+	Leave(ctx context.Context, userID, conversationID int) error
+	Block(ctx context.Context, userID int, username string) error
+	Unblock(ctx context.Context, userID, blockedUserID int) error
+	GetBlocks(ctx context.Context, userID int) ([]*model.UserBlock, error)
+	GetMessagingBan(ctx context.Context, userID int) (*model.MessagingBan, error)
+	GetMessagingBans(ctx context.Context) ([]*model.MessagingBan, error)
+	BanFromMessaging(ctx context.Context, adminID int, username, reason string) (*model.MessagingBan, error)
+	LiftMessagingBan(ctx context.Context, userID int) (*model.MessagingBan, error)
+}
+
+type MessageHandler struct {
+	l  *slog.Logger
+	t  *template.Templates
+	ms MessageService
+	as AuditService
+}
+
+func NewMessageHandler(l *slog.Logger, t *template.Templates, ms MessageService, as AuditService) *MessageHandler {
+	return &MessageHandler{l: l, t: t, ms: ms, as: as}
+}
+
+// GetMessages lists the conversations of the user, the newest first, and
+// the users they have blocked.
+func (h *MessageHandler) GetMessages(rw http.ResponseWriter, r *http.Request) {
+	h.renderMessages(rw, r, "")
+}
+
+func (h *MessageHandler) renderMessages(rw http.ResponseWriter, r *http.Request, errorMsg string) {
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	conversations, err := h.ms.GetConversations(r.Context(), user.ID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get conversations", err)
+		return
+	}
+
+	blocks, err := h.ms.GetBlocks(r.Context(), user.ID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get blocked users", err)
+		return
+	}
+
+	ban, err := h.ms.GetMessagingBan(r.Context(), user.ID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get messaging ban", err)
+		return
+	}
+
+	data := make(map[string]any)
+	data["me"] = user.ID
+	data["conversations"] = conversations
+	data["blocks"] = blocks
+	data["ban"] = ban
+
+	err = h.t.Render(rw, r, "messages.page", &model.Page{
+		Title: "Messages",
+		Data:  data,
+		Error: errorMsg,
+	})
+	if err != nil {
+		msg := "Unable to render template"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+}
+
+// GetNewConversation serves the form for a new conversation, addressed to
+// the users named in the to query parameter.
+func (h *MessageHandler) GetNewConversation(rw http.ResponseWriter, r *http.Request) {
+	h.renderNewConversation(rw, r, r.URL.Query().Get("to"), "", "", "")
+}
+
+func (h *MessageHandler) renderNewConversation(rw http.ResponseWriter, r *http.Request, to, subject, body, errorMsg string) {
+	data := make(map[string]any)
+	data["to"] = to
+	data["subject"] = subject
+	data["body"] = body
+
+	err := h.t.Render(rw, r, "new-conversation.page", &model.Page{
+		Title: "New conversation",
+		Data:  data,
+		Error: errorMsg,
+	})
+	if err != nil {
+		msg := "Unable to render template"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+}
+
+func (h *MessageHandler) PostNewConversation(rw http.ResponseWriter, r *http.Request) {
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	if err = r.ParseForm(); err != nil {
+		http.Error(rw, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	to := r.PostFormValue("to")
+	subject := r.PostFormValue("subject")
+	body := r.PostFormValue("body")
+
+	// names are separated by commas, spaces or both
+	recipients := strings.FieldsFunc(to, func(c rune) bool {
+		return c == ',' || unicode.IsSpace(c)
+	})
+
+	conversation, err := h.ms.StartConversation(r.Context(), user.ID, recipients, subject, body)
+	if err != nil {
+		errorMsg := messageError(err)
+		if errorMsg == "" {
+			ServerError(rw, r, h.l, "Failed to start conversation", err)
+			return
+		}
+		h.renderNewConversation(rw, r, to, subject, body, errorMsg)
+		return
+	}
+
+	http.Redirect(rw, r, conversation.Path(), http.StatusFound)
+}
+
+// GetConversation shows the messages of a conversation the user takes part
+// in, marking those after their last visit as new, and records that they
+// have read them all.
+func (h *MessageHandler) GetConversation(rw http.ResponseWriter, r *http.Request) {
+	h.renderConversation(rw, r, "", "")
+}
+
+func (h *MessageHandler) renderConversation(rw http.ResponseWriter, r *http.Request, body, errorMsg string) {
+	id, err := strconv.Atoi(r.PathValue("conversationID"))
+	if err != nil {
+		http.Error(rw, "Invalid Conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	conversation, messages, err := h.ms.GetConversation(r.Context(), user.ID, id)
+	if errors.Is(err, service.ErrConversationNotFound) {
+		http.Error(rw, "Conversation Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get conversation", err)
+		return
+	}
+
+	// marked before rendering, so the unread count in the header is right
+	if len(messages) > 0 {
+		err = h.ms.MarkRead(r.Context(), user.ID, id, messages[len(messages)-1].ID)
+		if err != nil {
+			h.l.WarnContext(r.Context(), "Failed to mark conversation read", "conversation_id", id, "error", err.Error())
+		}
+	}
+
+	data := make(map[string]any)
+	data["me"] = user.ID
+	data["conversation"] = conversation
+	data["messages"] = messages
+	data["body"] = body
+
+	err = h.t.Render(rw, r, "conversation.page", &model.Page{
+		Title: conversation.Subject,
+		Data:  data,
+		Error: errorMsg,
+	})
+	if err != nil {
+		msg := "Unable to render template"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+}
+
+func (h *MessageHandler) PostReply(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("conversationID"))
+	if err != nil {
+		http.Error(rw, "Invalid Conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	if err = r.ParseForm(); err != nil {
+		http.Error(rw, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	body := r.PostFormValue("body")
+
+	message, err := h.ms.Reply(r.Context(), user.ID, id, body)
+	if errors.Is(err, service.ErrConversationNotFound) {
+		http.Error(rw, "Conversation Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errorMsg := messageError(err)
+		if errorMsg == "" {
+			ServerError(rw, r, h.l, "Failed to send message", err)
+			return
+		}
+		h.renderConversation(rw, r, body, errorMsg)
+		return
+	}
+
+	http.Redirect(rw, r, fmt.Sprintf("%s#message-%d", (&model.Conversation{ID: id}).Path(), message.ID), http.StatusFound)
+}
+
+// PostMute mutes the conversation for the user, or unmutes it when muted
+// is false.
+func (h *MessageHandler) PostMute(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("conversationID"))
+	if err != nil {
+		http.Error(rw, "Invalid Conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	muted := r.PostFormValue("muted") != "false"
+
+	err = h.ms.SetMuted(r.Context(), user.ID, id, muted)
+	if errors.Is(err, service.ErrConversationNotFound) {
+		http.Error(rw, "Conversation Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to mute conversation", err)
+		return
+	}
+
+	http.Redirect(rw, r, (&model.Conversation{ID: id}).Path(), http.StatusFound)
+}
+
+func (h *MessageHandler) PostLeave(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("conversationID"))
+	if err != nil {
+		http.Error(rw, "Invalid Conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	err = h.ms.Leave(r.Context(), user.ID, id)
+	if errors.Is(err, service.ErrConversationNotFound) {
+		http.Error(rw, "Conversation Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to leave conversation", err)
+		return
+	}
+
+	http.Redirect(rw, r, "/user/messages", http.StatusFound)
+}
+
+// PostBlock keeps the user named in the form from messaging the user.
+func (h *MessageHandler) PostBlock(rw http.ResponseWriter, r *http.Request) {
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	username := r.PostFormValue("username")
+
+	err = h.ms.Block(r.Context(), user.ID, username)
+	if err != nil {
+		var errorMsg string
+		switch {
+		case errors.Is(err, service.ErrUserNotFound):
+			errorMsg = "There is no user named " + username
+		case errors.Is(err, service.ErrBlockSelf):
+			errorMsg = "You cannot block yourself"
+		default:
+			ServerError(rw, r, h.l, "Unable to block user", err)
+			return
+		}
+		h.renderMessages(rw, r, errorMsg)
+		return
+	}
+
+	http.Redirect(rw, r, "/user/messages", http.StatusFound)
+}
+
+func (h *MessageHandler) PostUnblock(rw http.ResponseWriter, r *http.Request) {
+	blockedUserID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		http.Error(rw, "Invalid User ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	err = h.ms.Unblock(r.Context(), user.ID, blockedUserID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to unblock user", err)
+		return
+	}
+
+	http.Redirect(rw, r, "/user/messages", http.StatusFound)
+}
+
+// messageError turns an error of a message that was not sent into what the
+// sender is told, or "" if it is not their mistake.
+func messageError(err error) string {
+	_, detail, _ := strings.Cut(err.Error(), ": ")
+
+	switch {
+	case errors.Is(err, service.ErrInvalidMessage), errors.Is(err, service.ErrTooManyParticipants):
+		return "Not sent, " + detail
+	case errors.Is(err, service.ErrUserNotFound):
+		return "Not sent, there is no user named " + detail
+	case errors.Is(err, service.ErrRecipientBlocked):
+		return "Not sent, " + detail + " does not accept messages from you"
+	case errors.Is(err, service.ErrNoRecipients):
+		return "Not sent, name someone to send the message to"
+	case errors.Is(err, service.ErrConversationClosed):
+		return "Not sent, everyone else has left the conversation"
+	case errors.Is(err, service.ErrMessagingBanned):
+		return "Not sent, you are banned from sending messages"
+	default:
+		return ""
+	}
+}
+
+// GetMessagingBans lists the users banned from messaging, for admins.
+func (h *MessageHandler) GetMessagingBans(rw http.ResponseWriter, r *http.Request) {
+	h.renderMessagingBans(rw, r, r.URL.Query().Get("username"), "")
+}
+
+func (h *MessageHandler) renderMessagingBans(rw http.ResponseWriter, r *http.Request, username, errorMsg string) {
+	bans, err := h.ms.GetMessagingBans(r.Context())
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get messaging bans", err)
+		return
+	}
+
+	data := make(map[string]any)
+	data["bans"] = bans
+	data["username"] = username
+
+	err = h.t.Render(rw, r, "messaging-bans.page", &model.Page{
+		Title: "Messaging bans",
+		Data:  data,
+		Error: errorMsg,
+	})
+	if err != nil {
+		msg := "Unable to render template"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+}
+
+func (h *MessageHandler) PostMessagingBan(rw http.ResponseWriter, r *http.Request) {
+	admin, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	username := r.PostFormValue("username")
+
+	ban, err := h.ms.BanFromMessaging(r.Context(), admin.ID, username, r.PostFormValue("reason"))
+	if err != nil {
+		var errorMsg string
+		switch {
+		case errors.Is(err, service.ErrUserNotFound):
+			errorMsg = "There is no user named " + username
+		case errors.Is(err, service.ErrBanReasonTooLong):
+			_, errorMsg, _ = strings.Cut(err.Error(), ": ")
+			errorMsg = "Too long, " + errorMsg
+		default:
+			ServerError(rw, r, h.l, "Unable to ban user", err)
+			return
+		}
+		h.renderMessagingBans(rw, r, username, errorMsg)
+		return
+	}
+
+	recordAudit(r.Context(), h.l, h.as, newAuditEntry(r, service.AuditMessagingBan, service.AuditTargetUser, ban.UserID), nil, ban)
+
+	http.Redirect(rw, r, "/admin/messaging", http.StatusFound)
+}
+
+func (h *MessageHandler) PostLiftMessagingBan(rw http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		http.Error(rw, "Invalid User ID", http.StatusBadRequest)
+		return
+	}
+
+	ban, err := h.ms.LiftMessagingBan(r.Context(), userID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to lift messaging ban", err)
+		return
+	}
+
+	if ban != nil {
+		recordAudit(r.Context(), h.l, h.as, newAuditEntry(r, service.AuditMessagingUnban, service.AuditTargetUser, userID), ban, nil)
+	}
+
+	http.Redirect(rw, r, "/admin/messaging", http.StatusFound)
+}
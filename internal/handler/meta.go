@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// summaryLength is about as much of a description as search results show.
+const summaryLength = 160
+
+// summary shortens text to a single line of at most summaryLength
+// characters for the description of a page, cutting at a word boundary.
+func summary(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= summaryLength {
+		return text
+	}
+
+	cut := []rune(text)[:summaryLength-1]
+	if i := strings.LastIndexByte(string(cut), ' '); i > 0 {
+		return string(cut)[:i] + "…"
+	}
+	return string(cut) + "…"
+}
+
+// redirectCanonical answers 301 with the canonical path of a page requested
+// by another, such as an outdated slug, and reports whether it did.
+func redirectCanonical(rw http.ResponseWriter, r *http.Request, path string) bool {
+	if r.URL.Path == path {
+		return false
+	}
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	http.Redirect(rw, r, path, http.StatusMovedPermanently)
+	return true
+}
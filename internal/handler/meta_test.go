@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSummary(t *testing.T) {
+	t.Parallel()
+
+	if got := summary("  A short\n\tdescription "); got != "A short description" {
+		t.Errorf("expected whitespace to be collapsed, got %q", got)
+	}
+
+	long := strings.Repeat("word ", 40)
+	got := summary(long)
+	if len([]rune(got)) > summaryLength || !strings.HasSuffix(got, "word…") {
+		t.Errorf("expected at most %d characters cut after a word, got %q", summaryLength, got)
+	}
+}
+
+func TestRedirectCanonical(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		target   string
+		location string
+	}{
+		{target: "/topics/12-go-generics", location: ""},
+		{target: "/topics/12", location: "/topics/12-go-generics"},
+		{target: "/topics/12-old-name?page=2", location: "/topics/12-go-generics?page=2"},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		redirected := redirectCanonical(rw, httptest.NewRequest(http.MethodGet, tt.target, nil), "/topics/12-go-generics")
+
+		if redirected != (tt.location != "") {
+			t.Errorf("%s: expected redirect %v, got %v", tt.target, tt.location != "", redirected)
+		}
+		if redirected && (rw.Code != http.StatusMovedPermanently || rw.Header().Get("Location") != tt.location) {
+			t.Errorf("%s: expected %d to %s, got %d to %s", tt.target, http.StatusMovedPermanently, tt.location, rw.Code, rw.Header().Get("Location"))
+		}
+	}
+}
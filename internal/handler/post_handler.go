@@ -1,14 +1,24 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"io"
 	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"simple-forum/internal/auth"
+	"simple-forum/internal/blob"
+	"simple-forum/internal/mention"
 	"simple-forum/internal/model"
+	"simple-forum/internal/service"
+	"simple-forum/internal/slug"
 	"simple-forum/internal/template"
 	"strconv"
+	"strings"
 )
 
 type Authenticator interface {
@@ -17,12 +27,35 @@ type Authenticator interface {
 	GetClaimsFromRequest(r *http.Request) (jwt.MapClaims, error)
 }
 
+// signedIn reports whether r comes with a valid session, which private
+// topics require.
+func signedIn(a Authenticator, r *http.Request) bool {
+	_, err := a.GetClaimsFromRequest(r)
+	return err == nil
+}
+
 type PostService interface {
-	GetPostByID(postID int) (*model.Post, error)
-	GetPostsByTopicID(topicID int) ([]*model.Post, error)
-	CreatePost(title, content string, topicID, authorID int, authorName string) error
-	EditPost(title, content string, postID int) error
-	DeletePost(postID int) error
+	GetPostByID(ctx context.Context, postID int) (*model.Post, error)
+	GetPostsByTopicID(ctx context.Context, topicID int) ([]*model.Post, error)
+	GetLatestPublicPosts(ctx context.Context, limit int) ([]*model.Post, error)
+	GetPublicPosts(ctx context.Context, offset, limit int) ([]*model.Post, error)
+	CountPublicPosts(ctx context.Context) (int, error)
+	GetPublicPostsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Post, error)
+	CountPublicPostsByAuthorID(ctx context.Context, authorID int) (int, error)
+	CreatePost(ctx context.Context, title, content string, topicID, authorID int, authorName string) (*model.Post, error)
+	EditPost(ctx context.Context, title, content string, postID int) (*model.Post, error)
+	DeletePost(ctx context.Context, postID int) error
+	GetMentions(ctx context.Context, postID int) ([]*model.Mention, error)
+}
+
+type AttachmentService interface {
+	Store(ctx context.Context, userID int, uploads []service.Upload) ([]*model.Attachment, error)
+	AttachToPost(ctx context.Context, postID int, attachments []*model.Attachment) error
+	Discard(ctx context.Context, attachments []*model.Attachment)
+	GetAttachments(ctx context.Context, postID int) ([]*model.Attachment, error)
+	GetAttachment(ctx context.Context, id int) (*model.Attachment, error)
+	OpenAttachment(ctx context.Context, attachment *model.Attachment) (io.ReadCloser, *blob.Info, error)
+	RemoveAttachments(ctx context.Context, postID int, ids []int) ([]*model.Attachment, error)
 }
 
 type PostHandler struct {
@@ -31,28 +64,53 @@ type PostHandler struct {
 	t  *template.Templates
 	ps PostService
 	ts TopicService
+	pr ProfileService
+	at AttachmentService
+	as AuditService
 }
 
-func NewPostHandler(l *slog.Logger, a *auth.JWTAuthenticator,
-	t *template.Templates, ps PostService, ts TopicService) *PostHandler {
-	return &PostHandler{l: l, a: a, t: t, ps: ps, ts: ts}
+func NewPostHandler(l *slog.Logger, a *auth.JWTAuthenticator, t *template.Templates,
+	ps PostService, ts TopicService, pr ProfileService, at AttachmentService, as AuditService) *PostHandler {
+	return &PostHandler{l: l, a: a, t: t, ps: ps, ts: ts, pr: pr, at: at, as: as}
 }
 
+// GetPost serves a post by the id in its path. The topic in the path is
+// only there to read; a post requested under another topic or slug is
+// redirected to its canonical path.
 func (p *PostHandler) GetPost(rw http.ResponseWriter, r *http.Request) {
-	stringPostID := r.PathValue("postID")
-	id, err := strconv.Atoi(stringPostID)
+	id, err := slug.ID(r.PathValue("postID"))
 	if err != nil {
 		http.Error(rw, "Invalid Post ID", http.StatusBadRequest)
 		return
 	}
 
-	post, err := p.ps.GetPostByID(id)
+	post, err := p.ps.GetPostByID(r.Context(), id)
 	if err != nil {
+		NotFound(rw, r, p.l, "Post Not Found", err)
+		return
+	}
+
+	topic, err := p.ts.GetTopicByID(r.Context(), post.TopicId)
+	if err != nil {
+		NotFound(rw, r, p.l, "Post Not Found", err)
+		return
+	}
+	if topic.Private && !signedIn(p.a, r) {
 		http.Error(rw, "Post Not Found", http.StatusNotFound)
 		return
 	}
 
-	viewData := new(model.Page)
+	if redirectCanonical(rw, r, post.Path(topic)) {
+		return
+	}
+
+	viewData := &model.Page{
+		Title:       post.Title,
+		Description: summary(post.Content),
+		Canonical:   post.Path(topic),
+		OGType:      "article",
+		NoIndex:     topic.Private,
+	}
 	viewData.IsAuthor = false
 
 	claims, err := p.a.GetClaimsFromRequest(r)
@@ -67,16 +125,41 @@ func (p *PostHandler) GetPost(rw http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// the signature is not worth failing the page for
+	profile, err := p.pr.GetProfile(r.Context(), post.AuthorId)
+	if err != nil {
+		p.l.WarnContext(r.Context(), "Unable to get author profile", "author_id", post.AuthorId, "error", err.Error())
+		profile = &model.Profile{UserID: post.AuthorId}
+	}
+
+	attachments, err := p.at.GetAttachments(r.Context(), post.ID)
+	if err != nil {
+		p.l.WarnContext(r.Context(), "Unable to get attachments", "post_id", post.ID, "error", err.Error())
+	}
+
+	// without them the mentions are shown as plain text
+	mentions, err := p.ps.GetMentions(r.Context(), post.ID)
+	if err != nil {
+		p.l.WarnContext(r.Context(), "Unable to get mentions", "post_id", post.ID, "error", err.Error())
+	}
+	names := make([]string, 0, len(mentions))
+	for _, m := range mentions {
+		names = append(names, m.UserName)
+	}
+
 	data := make(map[string]any)
 	data["post"] = post
+	data["content"] = mention.Link(post.Content, names)
+	data["author"] = &model.User{ID: post.AuthorId, Name: post.AuthorName}
+	data["profile"] = profile
+	data["attachments"] = attachments
 
 	viewData.Data = data
 
 	err = p.t.Render(rw, r, "post.page", viewData)
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		p.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, p.l, msg, err)
 		return
 	}
 }
@@ -89,22 +172,27 @@ func (p *PostHandler) GetCreatePost(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	topic, err := p.ts.GetTopicByID(id)
+	topic, err := p.ts.GetTopicByID(r.Context(), id)
 	if err != nil {
-		http.Error(rw, "Topic Not Found", http.StatusNotFound)
+		NotFound(rw, r, p.l, "Topic Not Found", err)
 		return
 	}
 
+	p.renderCreatePost(rw, r, topic, &model.Post{}, "")
+}
+
+func (p *PostHandler) renderCreatePost(rw http.ResponseWriter, r *http.Request, topic *model.Topic, post *model.Post, errorMsg string) {
 	data := make(map[string]any)
 	data["topic"] = topic
+	data["post"] = post
 
-	err = p.t.Render(rw, r, "create-post.page", &model.Page{
-		Data: data,
+	err := p.t.Render(rw, r, "create-post.page", &model.Page{
+		Data:  data,
+		Error: errorMsg,
 	})
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		p.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, p.l, msg, err)
 		return
 	}
 }
@@ -124,41 +212,72 @@ func (p *PostHandler) PostCreatePost(rw http.ResponseWriter, r *http.Request) {
 	userValue := r.Context().Value("user")
 	if userValue == nil {
 		http.Error(rw, msg, http.StatusInternalServerError)
-		p.l.Error(msg, "error", "cant get value from context")
+		p.l.ErrorContext(r.Context(), msg, "error", "cant get value from context")
 		return
 	}
 
 	user, ok := userValue.(map[string]interface{})
 	if !ok {
 		http.Error(rw, msg, http.StatusInternalServerError)
-		p.l.Error(msg, "error", "invalid user type")
+		p.l.ErrorContext(r.Context(), msg, "error", "invalid user type")
 		return
 	}
 
 	userIDFloat, ok := user["id"].(float64)
 	if !ok {
 		http.Error(rw, msg, http.StatusInternalServerError)
-		p.l.Error(msg, "error", "invalid user ID type")
+		p.l.ErrorContext(r.Context(), msg, "error", "invalid user ID type")
 		return
 	}
 
 	userName, ok := user["name"].(string)
 	if !ok {
 		http.Error(rw, msg, http.StatusInternalServerError)
-		p.l.Error(msg, "error", "invalid user name type")
+		p.l.ErrorContext(r.Context(), msg, "error", "invalid user name type")
 		return
 	}
 
 	userID := int(userIDFloat)
 
-	err = p.ps.CreatePost(title, content, id, userID, userName)
+	uploads, closeUploads, err := formUploads(r)
+	if err != nil {
+		ServerError(rw, r, p.l, "Unable to read attachments", err)
+		return
+	}
+	defer closeUploads()
+
+	attachments, err := p.at.Store(r.Context(), userID, uploads)
 	if err != nil {
+		errorMsg := attachmentError(err)
+		if errorMsg == "" {
+			ServerError(rw, r, p.l, "Unable to store attachments", err)
+			return
+		}
+		topic, err := p.ts.GetTopicByID(r.Context(), id)
+		if err != nil {
+			NotFound(rw, r, p.l, "Topic Not Found", err)
+			return
+		}
+		p.renderCreatePost(rw, r, topic, &model.Post{Title: title, Content: content}, errorMsg)
+		return
+	}
+
+	post, err := p.ps.CreatePost(r.Context(), title, content, id, userID, userName)
+	if err != nil {
+		p.at.Discard(r.Context(), attachments)
 		msg = "Unable to create post"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		p.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, p.l, msg, err)
 		return
 	}
 
+	err = p.at.AttachToPost(r.Context(), post.ID, attachments)
+	if err != nil {
+		ServerError(rw, r, p.l, "Unable to save attachments", err)
+		return
+	}
+
+	recordAudit(r.Context(), p.l, p.as, newAuditEntry(r, service.AuditPostCreate, service.AuditTargetPost, post.ID), nil, post)
+
 	redirectedURL := fmt.Sprintf("/topics/%d", id)
 
 	http.Redirect(rw, r, redirectedURL, http.StatusFound)
@@ -172,22 +291,33 @@ func (p *PostHandler) GetEditPost(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	post, err := p.ps.GetPostByID(id)
+	post, err := p.ps.GetPostByID(r.Context(), id)
 	if err != nil {
-		http.Error(rw, "Post Not Found", http.StatusNotFound)
+		NotFound(rw, r, p.l, "Post Not Found", err)
+		return
+	}
+
+	p.renderEditPost(rw, r, post, "")
+}
+
+func (p *PostHandler) renderEditPost(rw http.ResponseWriter, r *http.Request, post *model.Post, errorMsg string) {
+	attachments, err := p.at.GetAttachments(r.Context(), post.ID)
+	if err != nil {
+		ServerError(rw, r, p.l, "Unable to get attachments", err)
 		return
 	}
 
 	data := make(map[string]any)
 	data["post"] = post
+	data["attachments"] = attachments
 
 	err = p.t.Render(rw, r, "edit-post.page", &model.Page{
-		Data: data,
+		Data:  data,
+		Error: errorMsg,
 	})
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		p.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, p.l, msg, err)
 		return
 	}
 }
@@ -203,21 +333,69 @@ func (p *PostHandler) PostEditPost(rw http.ResponseWriter, r *http.Request) {
 	title := r.PostFormValue("title")
 	content := r.PostFormValue("content")
 
-	topic, err := p.ts.GetTopicByPostID(id)
+	topic, err := p.ts.GetTopicByPostID(r.Context(), id)
+	if err != nil {
+		NotFound(rw, r, p.l, "Topic Not Found", err)
+		return
+	}
+
+	before, err := p.ps.GetPostByID(r.Context(), id)
+	if err != nil {
+		NotFound(rw, r, p.l, "Post Not Found", err)
+		return
+	}
+
+	var remove []int
+	for _, value := range r.PostForm["remove_attachment"] {
+		if attachmentID, err := strconv.Atoi(value); err == nil {
+			remove = append(remove, attachmentID)
+		}
+	}
+
+	uploads, closeUploads, err := formUploads(r)
+	if err != nil {
+		ServerError(rw, r, p.l, "Unable to read attachments", err)
+		return
+	}
+	defer closeUploads()
+
+	// added by the author, whoever edits
+	attachments, err := p.at.Store(r.Context(), before.AuthorId, uploads)
 	if err != nil {
-		http.Error(rw, "Topic Not Found", http.StatusNotFound)
+		errorMsg := attachmentError(err)
+		if errorMsg == "" {
+			ServerError(rw, r, p.l, "Unable to store attachments", err)
+			return
+		}
+		edited := *before
+		edited.Title, edited.Content = title, content
+		p.renderEditPost(rw, r, &edited, errorMsg)
 		return
 	}
 
-	err = p.ps.EditPost(title, content, id)
+	after, err := p.ps.EditPost(r.Context(), title, content, id)
 	if err != nil {
+		p.at.Discard(r.Context(), attachments)
 		msg := "Unable to edit post"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		p.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, p.l, msg, err)
 		return
 	}
 
-	redirectedURL := fmt.Sprintf("/topics/%v", topic.ID)
+	err = p.at.AttachToPost(r.Context(), id, attachments)
+	if err != nil {
+		ServerError(rw, r, p.l, "Unable to save attachments", err)
+		return
+	}
+
+	_, err = p.at.RemoveAttachments(r.Context(), id, remove)
+	if err != nil {
+		ServerError(rw, r, p.l, "Unable to remove attachments", err)
+		return
+	}
+
+	recordAudit(r.Context(), p.l, p.as, newAuditEntry(r, service.AuditPostEdit, service.AuditTargetPost, id), before, after)
+
+	redirectedURL := topic.Path()
 
 	http.Redirect(rw, r, redirectedURL, http.StatusFound)
 }
@@ -230,21 +408,157 @@ func (p *PostHandler) GetDeletePost(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	topic, err := p.ts.GetTopicByPostID(id)
+	topic, err := p.ts.GetTopicByPostID(r.Context(), id)
+	if err != nil {
+		NotFound(rw, r, p.l, "Topic Not Found", err)
+		return
+	}
+
+	before, err := p.ps.GetPostByID(r.Context(), id)
 	if err != nil {
-		http.Error(rw, "Topic Not Found", http.StatusNotFound)
+		NotFound(rw, r, p.l, "Post Not Found", err)
 		return
 	}
 
-	err = p.ps.DeletePost(id)
+	err = p.ps.DeletePost(r.Context(), id)
 	if err != nil {
 		msg := "Unable to delete post"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		p.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, p.l, msg, err)
 		return
 	}
 
-	url := fmt.Sprintf("/topics/%v", topic.ID)
+	recordAudit(r.Context(), p.l, p.as, newAuditEntry(r, service.AuditPostDelete, service.AuditTargetPost, id), before, nil)
+
+	url := topic.Path()
 
 	http.Redirect(rw, r, url, http.StatusFound)
 }
+
+// GetAttachment serves a file attached to a post to whoever may read the
+// post. Pictures are shown in the browser, anything else is downloaded
+// under the name it was uploaded with.
+func (p *PostHandler) GetAttachment(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("attachmentID"))
+	if err != nil {
+		http.Error(rw, "Attachment Not Found", http.StatusNotFound)
+		return
+	}
+
+	attachment, err := p.at.GetAttachment(r.Context(), id)
+	if errors.Is(err, service.ErrAttachmentNotFound) {
+		http.Error(rw, "Attachment Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ServerError(rw, r, p.l, "Unable to get attachment", err)
+		return
+	}
+
+	post, err := p.ps.GetPostByID(r.Context(), attachment.PostID)
+	if err != nil {
+		NotFound(rw, r, p.l, "Attachment Not Found", err)
+		return
+	}
+	topic, err := p.ts.GetTopicByID(r.Context(), post.TopicId)
+	if err != nil {
+		NotFound(rw, r, p.l, "Attachment Not Found", err)
+		return
+	}
+	if topic.Private && !signedIn(p.a, r) {
+		http.Error(rw, "Attachment Not Found", http.StatusNotFound)
+		return
+	}
+
+	// compared unescaped, as names may hold anything but slashes
+	if r.PathValue("name") != attachment.Filename {
+		http.Redirect(rw, r, attachment.Path(), http.StatusMovedPermanently)
+		return
+	}
+
+	file, info, err := p.at.OpenAttachment(r.Context(), attachment)
+	if errors.Is(err, service.ErrAttachmentNotFound) {
+		http.Error(rw, "Attachment Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ServerError(rw, r, p.l, "Unable to open attachment", err)
+		return
+	}
+	defer file.Close()
+
+	cacheControl := "public, max-age=86400"
+	if topic.Private {
+		cacheControl = "private, max-age=86400"
+	}
+
+	rw.Header().Set("Cache-Control", cacheControl)
+	rw.Header().Set("Content-Type", attachment.ContentType)
+	rw.Header().Set("Content-Disposition", contentDisposition(attachment))
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	// a file opened in the browser does not run as part of the forum
+	rw.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; sandbox")
+	// the content behind an id never changes
+	rw.Header().Set("ETag", `"`+attachment.SHA256+`"`)
+
+	if rs, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(rw, r, "", info.ModTime, rs)
+		return
+	}
+	rw.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	_, _ = io.Copy(rw, file)
+}
+
+// contentDisposition shows pictures in the browser and has anything else
+// downloaded, under the name the file was uploaded with. Names beyond
+// ASCII are encoded as RFC 6266 asks.
+func contentDisposition(attachment *model.Attachment) string {
+	disposition := "attachment"
+	if attachment.IsImage() {
+		disposition = "inline"
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename})
+}
+
+// formUploads opens the files of the attachments field of a multipart
+// form, which the returned function closes. Other forms have none.
+func formUploads(r *http.Request) ([]service.Upload, func(), error) {
+	var files []multipart.File
+	closeFiles := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+
+	if r.MultipartForm == nil {
+		return nil, closeFiles, nil
+	}
+
+	var uploads []service.Upload
+	for _, header := range r.MultipartForm.File["attachments"] {
+		file, err := header.Open()
+		if err != nil {
+			closeFiles()
+			return nil, nil, err
+		}
+		files = append(files, file)
+		uploads = append(uploads, service.Upload{Filename: header.Filename, Content: file})
+	}
+	return uploads, closeFiles, nil
+}
+
+// attachmentError returns the message to show for an upload the limits
+// turned away, or "" for other errors.
+func attachmentError(err error) string {
+	_, detail, _ := strings.Cut(err.Error(), ": ")
+
+	switch {
+	case errors.Is(err, service.ErrAttachmentTooLarge):
+		return "Too large, " + detail
+	case errors.Is(err, service.ErrAttachmentType):
+		return "Not allowed, " + detail
+	case errors.Is(err, service.ErrAttachmentQuota):
+		return "Out of space for attachments, " + detail
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"simple-forum/internal/auth"
+	"simple-forum/internal/avatar"
+	"simple-forum/internal/blob"
+	"simple-forum/internal/model"
+	"simple-forum/internal/service"
+	"simple-forum/internal/template"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// profileActivity is how many of the newest posts and topics a profile
+// lists.
+const profileActivity = 10
+
+type ProfileService interface {
+	GetUserProfile(ctx context.Context, username string) (*model.User, *model.Profile, error)
+	GetProfile(ctx context.Context, userID int) (*model.Profile, error)
+	UpdateProfile(ctx context.Context, profile *model.Profile) error
+	SetAvatar(ctx context.Context, userID int, r io.Reader) (*model.Profile, error)
+	RemoveAvatar(ctx context.Context, userID int) (*model.Profile, error)
+	OpenAvatar(ctx context.Context, file string) (io.ReadCloser, *blob.Info, error)
+}
+
+type ProfileHandler struct {
+	l  *slog.Logger
+	a  Authenticator
+	t  *template.Templates
+	pr ProfileService
+	ps PostService
+	ts TopicService
+	as AuditService
+}
+
+func NewProfileHandler(l *slog.Logger, a *auth.JWTAuthenticator, t *template.Templates,
+	pr ProfileService, ps PostService, ts TopicService, as AuditService) *ProfileHandler {
+	return &ProfileHandler{l: l, a: a, t: t, pr: pr, ps: ps, ts: ts, as: as}
+}
+
+// GetProfile serves the public profile of a user with their newest posts
+// and topics. Activity in private topics is left out, and so is the email
+// unless the user chose to show it.
+func (h *ProfileHandler) GetProfile(rw http.ResponseWriter, r *http.Request) {
+	user, profile, err := h.pr.GetUserProfile(r.Context(), r.PathValue("username"))
+	if errors.Is(err, service.ErrUserNotFound) {
+		http.Error(rw, "User Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get profile", err)
+		return
+	}
+
+	postCount, err := h.ps.CountPublicPostsByAuthorID(r.Context(), user.ID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to count posts", err)
+		return
+	}
+
+	posts, err := h.ps.GetPublicPostsByAuthorID(r.Context(), user.ID, profileActivity)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get posts", err)
+		return
+	}
+
+	started, err := h.ts.GetPublicTopicsByAuthorID(r.Context(), user.ID, profileActivity)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get topics", err)
+		return
+	}
+
+	// the topics of the posts, for their paths
+	all, err := h.ts.GetAllTopics(r.Context())
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get topics", err)
+		return
+	}
+	topics := make(map[int]*model.Topic, len(all))
+	for _, topic := range all {
+		topics[topic.ID] = topic
+	}
+
+	own := false
+	if claims, err := h.a.GetClaimsFromRequest(r); err == nil {
+		if claimsUser, ok := claims["user"].(map[string]interface{}); ok {
+			id, _ := claimsUser["id"].(float64)
+			own = int(id) == user.ID
+		}
+	}
+
+	data := make(map[string]any)
+	data["user"] = user
+	data["profile"] = profile
+	data["postCount"] = postCount
+	data["posts"] = posts
+	data["topics"] = topics
+	data["started"] = started
+	data["own"] = own
+
+	name := user.Name
+	if profile.DisplayName != "" {
+		name = profile.DisplayName
+	}
+
+	page := &model.Page{
+		Title:       name,
+		Description: summary(profile.Bio),
+		Canonical:   user.Path(),
+		OGType:      "profile",
+		Data:        data,
+	}
+
+	err = h.t.Render(rw, r, "profile.page", page)
+	if err != nil {
+		msg := "Unable to render template"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+}
+
+func (h *ProfileHandler) GetEditProfile(rw http.ResponseWriter, r *http.Request) {
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	profile, err := h.pr.GetProfile(r.Context(), user.ID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get profile", err)
+		return
+	}
+
+	h.renderEditProfile(rw, r, profile, "")
+}
+
+func (h *ProfileHandler) PostEditProfile(rw http.ResponseWriter, r *http.Request) {
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	if err = r.ParseForm(); err != nil {
+		http.Error(rw, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	before, err := h.pr.GetProfile(r.Context(), user.ID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get profile", err)
+		return
+	}
+
+	profile := &model.Profile{
+		UserID:      user.ID,
+		DisplayName: r.PostFormValue("display_name"),
+		Bio:         r.PostFormValue("bio"),
+		Location:    r.PostFormValue("location"),
+		Website:     r.PostFormValue("website"),
+		Signature:   r.PostFormValue("signature"),
+		ShowEmail:   r.PostFormValue("show_email") == "on",
+		// changed by PostAvatar only
+		Avatar: before.Avatar,
+	}
+
+	err = h.pr.UpdateProfile(r.Context(), profile)
+	if err != nil {
+		var errorMsg string
+		switch {
+		case errors.Is(err, service.ErrProfileTooLong):
+			_, errorMsg, _ = strings.Cut(err.Error(), ": ")
+			errorMsg = "Too long, " + errorMsg
+		case errors.Is(err, service.ErrInvalidWebsite):
+			errorMsg = "Website must be an http or https address"
+		default:
+			errorMsg = "Failed to save profile"
+			h.l.ErrorContext(r.Context(), errorMsg, "error", err.Error())
+		}
+		h.renderEditProfile(rw, r, profile, errorMsg)
+		return
+	}
+
+	recordAudit(r.Context(), h.l, h.as, newAuditEntry(r, service.AuditProfileEdit, service.AuditTargetUser, user.ID), before, profile)
+
+	http.Redirect(rw, r, (&model.User{Name: user.Name}).Path(), http.StatusFound)
+}
+
+func (h *ProfileHandler) renderEditProfile(rw http.ResponseWriter, r *http.Request, profile *model.Profile, errorMsg string) {
+	data := make(map[string]any)
+	data["profile"] = profile
+
+	page := &model.Page{
+		Title:   "Edit profile",
+		NoIndex: true,
+		Data:    data,
+		Error:   errorMsg,
+	}
+
+	err := h.t.Render(rw, r, "edit-profile.page", page)
+	if err != nil {
+		msg := "Unable to render template"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+}
+
+// PostAvatar replaces the avatar of the user with the picture uploaded as
+// the avatar field of a multipart form.
+func (h *ProfileHandler) PostAvatar(rw http.ResponseWriter, r *http.Request) {
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	before, err := h.pr.GetProfile(r.Context(), user.ID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get profile", err)
+		return
+	}
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		errorMsg := "Choose a picture to upload"
+		if errors.As(err, &tooLarge) {
+			errorMsg = "The picture is too large"
+		}
+		h.renderEditProfile(rw, r, before, errorMsg)
+		return
+	}
+	defer file.Close()
+
+	after, err := h.pr.SetAvatar(r.Context(), user.ID, file)
+	if err != nil {
+		var errorMsg string
+		switch {
+		case errors.Is(err, service.ErrAvatarTooLarge), errors.Is(err, avatar.ErrTooManyPixels):
+			errorMsg = "The picture is too large"
+		case errors.Is(err, avatar.ErrUnsupportedType):
+			errorMsg = "The picture must be a JPEG, PNG, GIF or WebP image"
+		case errors.Is(err, avatar.ErrInvalidImage):
+			errorMsg = "The picture could not be read"
+		default:
+			errorMsg = "Failed to save avatar"
+			h.l.ErrorContext(r.Context(), errorMsg, "error", err.Error())
+		}
+		h.renderEditProfile(rw, r, before, errorMsg)
+		return
+	}
+
+	recordAudit(r.Context(), h.l, h.as, newAuditEntry(r, service.AuditProfileEdit, service.AuditTargetUser, user.ID), before, after)
+
+	http.Redirect(rw, r, "/user/profile", http.StatusFound)
+}
+
+func (h *ProfileHandler) PostDeleteAvatar(rw http.ResponseWriter, r *http.Request) {
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	before, err := h.pr.GetProfile(r.Context(), user.ID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get profile", err)
+		return
+	}
+
+	after, err := h.pr.RemoveAvatar(r.Context(), user.ID)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to remove avatar", err)
+		return
+	}
+
+	recordAudit(r.Context(), h.l, h.as, newAuditEntry(r, service.AuditProfileEdit, service.AuditTargetUser, user.ID), before, after)
+
+	http.Redirect(rw, r, "/user/profile", http.StatusFound)
+}
+
+// GetAvatar serves an uploaded avatar. Its name changes with every upload,
+// so it may be cached for good.
+func (h *ProfileHandler) GetAvatar(rw http.ResponseWriter, r *http.Request) {
+	file, info, err := h.pr.OpenAvatar(r.Context(), r.PathValue("file"))
+	if errors.Is(err, service.ErrAvatarNotFound) {
+		http.Error(rw, "Avatar Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to get avatar", err)
+		return
+	}
+	defer file.Close()
+
+	rw.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.Header().Set("Content-Type", "image/jpeg")
+
+	if rs, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(rw, r, "", info.ModTime, rs)
+		return
+	}
+	rw.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	_, _ = io.Copy(rw, file)
+}
+
+// GetIdenticon serves the identicon of a user, {userID}-{size}.png, which
+// stands in for the avatar of users who have not uploaded one.
+func (h *ProfileHandler) GetIdenticon(rw http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(r.PathValue("file"), ".png")
+	stringID, stringSize, found := strings.Cut(name, "-")
+	userID, idErr := strconv.Atoi(stringID)
+	size, sizeErr := strconv.Atoi(stringSize)
+	if !ok || !found || idErr != nil || sizeErr != nil || !slices.Contains(model.AvatarSizes, size) {
+		http.Error(rw, "Avatar Not Found", http.StatusNotFound)
+		return
+	}
+
+	image, err := avatar.Identicon(strconv.Itoa(userID), size)
+	if err != nil {
+		ServerError(rw, r, h.l, "Unable to draw identicon", err)
+		return
+	}
+
+	rw.Header().Set("Cache-Control", "public, max-age=86400")
+	rw.Header().Set("Content-Type", "image/png")
+	rw.Header().Set("Content-Length", fmt.Sprint(len(image)))
+	_, _ = rw.Write(image)
+}
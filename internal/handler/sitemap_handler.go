@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"simple-forum/internal/model"
+	"simple-forum/internal/sitemap"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// staticPages are the pages listed in the sitemap besides topics and posts.
+var staticPages = []string{"/home", "/topics", "/about"}
+
+type SitemapHandler struct {
+	l       *slog.Logger
+	ps      PostService
+	ts      TopicService
+	baseURL string
+	// perFile is the most URLs a sitemap lists before it is split up
+	perFile int
+}
+
+func NewSitemapHandler(l *slog.Logger, ps PostService, ts TopicService, baseURL string) *SitemapHandler {
+	return &SitemapHandler{l: l, ps: ps, ts: ts, baseURL: strings.TrimSuffix(baseURL, "/"), perFile: sitemap.MaxURLs}
+}
+
+// GetRobots serves robots.txt, which keeps crawlers out of the pages of
+// signed-in users and points them to the sitemap.
+func (s *SitemapHandler) GetRobots(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.Header().Set("Cache-Control", "public, max-age=86400")
+
+	fmt.Fprint(rw, "User-agent: *\n")
+	for _, path := range []string{"/admin/", "/user/", "/login", "/logout", "/signup"} {
+		fmt.Fprintf(rw, "Disallow: %s\n", path)
+	}
+	fmt.Fprintf(rw, "\nSitemap: %s/sitemap.xml\n", siteURL(s.baseURL, r))
+}
+
+// GetSitemap serves the sitemap of the public pages, topics and posts, or
+// an index of the sitemaps served by GetSitemapFile when they are more
+// than a sitemap may list.
+func (s *SitemapHandler) GetSitemap(rw http.ResponseWriter, r *http.Request) {
+	pages, topics, posts, err := s.count(r.Context(), siteURL(s.baseURL, r))
+	if err != nil {
+		ServerError(rw, r, s.l, "Unable to build sitemap", err)
+		return
+	}
+
+	total := len(pages) + posts
+	if total <= s.perFile {
+		s.serveFile(rw, r, pages, topics, 0, total)
+		return
+	}
+
+	var sitemaps []sitemap.URL
+	for i := range (total + s.perFile - 1) / s.perFile {
+		sitemaps = append(sitemaps, sitemap.URL{Loc: fmt.Sprintf("%s/sitemaps/%d.xml", siteURL(s.baseURL, r), i+1)})
+	}
+
+	body, err := sitemap.Index(sitemaps)
+	if err != nil {
+		ServerError(rw, r, s.l, "Unable to render sitemap", err)
+		return
+	}
+	s.write(rw, body)
+}
+
+// GetSitemapFile serves the {n}.xml sitemap of the index, counting from 1.
+func (s *SitemapHandler) GetSitemapFile(rw http.ResponseWriter, r *http.Request) {
+	stringN, ok := strings.CutSuffix(r.PathValue("file"), ".xml")
+	n, err := strconv.Atoi(stringN)
+	if !ok || err != nil || n < 1 {
+		http.Error(rw, "Sitemap Not Found", http.StatusNotFound)
+		return
+	}
+
+	pages, topics, posts, err := s.count(r.Context(), siteURL(s.baseURL, r))
+	if err != nil {
+		ServerError(rw, r, s.l, "Unable to build sitemap", err)
+		return
+	}
+
+	start := (n - 1) * s.perFile
+	if start >= len(pages)+posts {
+		http.Error(rw, "Sitemap Not Found", http.StatusNotFound)
+		return
+	}
+	s.serveFile(rw, r, pages, topics, start, min(start+s.perFile, len(pages)+posts))
+}
+
+// count returns the URLs of the static pages and public topics, which
+// come first, the public topics by id, and the number of public posts,
+// which follow them.
+func (s *SitemapHandler) count(ctx context.Context, base string) ([]sitemap.URL, map[int]*model.Topic, int, error) {
+	all, err := s.ts.GetAllTopics(ctx)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	posts, err := s.ps.CountPublicPosts(ctx)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var pages []sitemap.URL
+	for _, path := range staticPages {
+		pages = append(pages, sitemap.URL{Loc: base + path})
+	}
+	topics := make(map[int]*model.Topic, len(all))
+	for _, topic := range all {
+		if !topic.Private {
+			pages = append(pages, sitemap.URL{Loc: base + topic.Path()})
+			topics[topic.ID] = topic
+		}
+	}
+	return pages, topics, posts, nil
+}
+
+// serveFile serves the URLs from start up to end, where pages are followed
+// by the public posts.
+func (s *SitemapHandler) serveFile(rw http.ResponseWriter, r *http.Request, pages []sitemap.URL, topics map[int]*model.Topic, start, end int) {
+	urls := slices.Clone(pages[min(start, len(pages)):min(end, len(pages))])
+
+	if end > len(pages) {
+		offset := max(start-len(pages), 0)
+		posts, err := s.ps.GetPublicPosts(r.Context(), offset, end-len(pages)-offset)
+		if err != nil {
+			ServerError(rw, r, s.l, "Unable to build sitemap", err)
+			return
+		}
+
+		base := siteURL(s.baseURL, r)
+		for _, post := range posts {
+			topic, ok := topics[post.TopicId]
+			if !ok {
+				// created or made private since the topics were read
+				continue
+			}
+			urls = append(urls, sitemap.URL{Loc: base + post.Path(topic), LastMod: post.UpdatedAt})
+		}
+	}
+
+	body, err := sitemap.Sitemap(urls)
+	if err != nil {
+		ServerError(rw, r, s.l, "Unable to render sitemap", err)
+		return
+	}
+	s.write(rw, body)
+}
+
+func (s *SitemapHandler) write(rw http.ResponseWriter, body []byte) {
+	rw.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	rw.Header().Set("Cache-Control", "public, max-age=3600")
+	_, _ = rw.Write(body)
+}
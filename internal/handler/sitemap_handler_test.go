@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveSitemap(h *SitemapHandler, path string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /robots.txt", h.GetRobots)
+	mux.HandleFunc("GET /sitemap.xml", h.GetSitemap)
+	mux.HandleFunc("GET /sitemaps/{file}", h.GetSitemapFile)
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, path, nil))
+	return rw
+}
+
+// locs returns the <loc>s of a sitemap or index in order.
+func locs(body string) []string {
+	var locs []string
+	for _, part := range strings.Split(body, "<loc>")[1:] {
+		loc, _, _ := strings.Cut(part, "</loc>")
+		locs = append(locs, loc)
+	}
+	return locs
+}
+
+func TestSitemapHandler(t *testing.T) {
+	t.Parallel()
+
+	s := newFakeServices()
+	h := NewSitemapHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), s, s, "")
+
+	rw := serveSitemap(h, "/sitemap.xml")
+	want := []string{
+		"http://example.com/home",
+		"http://example.com/topics",
+		"http://example.com/about",
+		"http://example.com/topics/1-go",
+		"http://example.com/topics/1-go/posts/2-first",
+		"http://example.com/topics/1-go/posts/3-edited",
+	}
+	if got := locs(rw.Body.String()); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("expected the public pages\n%v, got\n%v", want, got)
+	}
+	if !strings.Contains(rw.Body.String(), "<lastmod>2025-01-03T00:00:00Z</lastmod>") {
+		t.Errorf("expected the time post 3 was edited in\n%s", rw.Body)
+	}
+
+	// split into sitemaps of 5 URLs, the first with the pages and post 2
+	h.perFile = 5
+
+	rw = serveSitemap(h, "/sitemap.xml")
+	index := locs(rw.Body.String())
+	if !strings.Contains(rw.Body.String(), "<sitemapindex") || len(index) != 2 || index[1] != "http://example.com/sitemaps/2.xml" {
+		t.Fatalf("expected an index of 2 sitemaps, got\n%s", rw.Body)
+	}
+
+	files := []string{"/sitemaps/1.xml", "/sitemaps/2.xml"}
+	var got []string
+	for _, file := range files {
+		rw = serveSitemap(h, file)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", file, http.StatusOK, rw.Code)
+		}
+		got = append(got, locs(rw.Body.String())...)
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("expected the sitemaps to list\n%v together, got\n%v", want, got)
+	}
+
+	for _, file := range []string{"/sitemaps/3.xml", "/sitemaps/0.xml", "/sitemaps/one.xml"} {
+		if rw = serveSitemap(h, file); rw.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status %d, got %d", file, http.StatusNotFound, rw.Code)
+		}
+	}
+}
+
+func TestGetRobots(t *testing.T) {
+	t.Parallel()
+
+	s := newFakeServices()
+	h := NewSitemapHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), s, s, "https://forum.example/")
+
+	rw := serveSitemap(h, "/robots.txt")
+	for _, want := range []string{"Disallow: /admin/\n", "Sitemap: https://forum.example/sitemap.xml\n"} {
+		if !strings.Contains(rw.Body.String(), want) {
+			t.Errorf("expected %q in\n%s", want, rw.Body)
+		}
+	}
+}
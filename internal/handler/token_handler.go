@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"simple-forum/internal/model"
+	"simple-forum/internal/service"
+	"simple-forum/internal/template"
+	"strconv"
+	"time"
+)
+
+type TokenService interface {
+	GetTokensByUserID(ctx context.Context, userID int) ([]*model.AccessToken, error)
+	CreateToken(ctx context.Context, userID int, name string, scopes []string, expiresAt *time.Time) (*model.AccessToken, string, error)
+	RevokeToken(ctx context.Context, userID, tokenID int) error
+}
+
+type TokenHandler struct {
+	l  *slog.Logger
+	t  *template.Templates
+	ts TokenService
+	as AuditService
+}
+
+func NewTokenHandler(l *slog.Logger, t *template.Templates, ts TokenService, as AuditService) *TokenHandler {
+	return &TokenHandler{l: l, t: t, ts: ts, as: as}
+}
+
+func (h *TokenHandler) GetAccount(rw http.ResponseWriter, r *http.Request) {
+	h.renderAccount(rw, r, "", "")
+}
+
+// renderAccount shows the token list. newToken is only ever displayed on the
+// response to its creation.
+func (h *TokenHandler) renderAccount(rw http.ResponseWriter, r *http.Request, newToken, errorMsg string) {
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	tokens, err := h.ts.GetTokensByUserID(r.Context(), user.ID)
+	if err != nil {
+		msg := "Unable to get tokens"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	data := make(map[string]any)
+	data["tokens"] = tokens
+	data["scopes"] = service.Scopes
+	data["now"] = time.Now()
+
+	page := &model.Page{
+		Data:  data,
+		Error: errorMsg,
+	}
+	if newToken != "" {
+		page.Flash = newToken
+	}
+
+	err = h.t.Render(rw, r, "account.page", page)
+	if err != nil {
+		msg := "Unable to render template"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+}
+
+func (h *TokenHandler) PostCreateToken(rw http.ResponseWriter, r *http.Request) {
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	if err = r.ParseForm(); err != nil {
+		http.Error(rw, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	name := r.PostFormValue("name")
+	scopes := r.PostForm["scopes"]
+
+	var expiresAt *time.Time
+	if days, err := strconv.Atoi(r.PostFormValue("expires_in_days")); err == nil && days > 0 {
+		expiry := time.Now().AddDate(0, 0, days)
+		expiresAt = &expiry
+	}
+
+	token, value, err := h.ts.CreateToken(r.Context(), user.ID, name, scopes, expiresAt)
+	if err != nil {
+		var errorMsg string
+		switch {
+		case errors.Is(err, service.ErrEmptyTokenName):
+			errorMsg = "Token name cannot be empty"
+		case errors.Is(err, service.ErrNoScopes), errors.Is(err, service.ErrInvalidScope):
+			errorMsg = "Select at least one valid scope"
+		default:
+			errorMsg = "Failed to create token"
+			h.l.ErrorContext(r.Context(), errorMsg, "error", err.Error())
+		}
+		h.renderAccount(rw, r, "", errorMsg)
+		return
+	}
+
+	recordAudit(r.Context(), h.l, h.as, newAuditEntry(r, service.AuditTokenCreate, service.AuditTargetToken, token.ID), nil, token)
+
+	h.renderAccount(rw, r, value, "")
+}
+
+func (h *TokenHandler) PostRevokeToken(rw http.ResponseWriter, r *http.Request) {
+	stringTokenID := r.PathValue("tokenID")
+	id, err := strconv.Atoi(stringTokenID)
+	if err != nil {
+		http.Error(rw, "Invalid Token ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userFromContext(r)
+	if err != nil {
+		msg := "Failed to get user"
+		ServerError(rw, r, h.l, msg, err)
+		return
+	}
+
+	err = h.ts.RevokeToken(r.Context(), user.ID, id)
+	if err != nil {
+		NotFound(rw, r, h.l, "Token Not Found", err)
+		return
+	}
+
+	recordAudit(r.Context(), h.l, h.as, newAuditEntry(r, service.AuditTokenRevoke, service.AuditTargetToken, id), nil, nil)
+
+	http.Redirect(rw, r, "/user/account", http.StatusFound)
+}
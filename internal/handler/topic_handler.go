@@ -1,78 +1,88 @@
 package handler
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"simple-forum/internal/auth"
 	"simple-forum/internal/model"
+	"simple-forum/internal/service"
+	"simple-forum/internal/slug"
 	"simple-forum/internal/template"
+	"slices"
 	"strconv"
 )
 
 type TopicService interface {
-	GetAllTopics() ([]*model.Topic, error)
-	GetTopicByID(id int) (*model.Topic, error)
-	GetTopicByPostID(id int) (*model.Topic, error)
-	CreateTopic(name, description string, authorID int) error
-	EditTopic(id int, name, description string) error
-	DeleteTopic(id int) error
+	GetAllTopics(ctx context.Context) ([]*model.Topic, error)
+	GetTopicByID(ctx context.Context, id int) (*model.Topic, error)
+	GetTopicByPostID(ctx context.Context, id int) (*model.Topic, error)
+	GetTopicWithPosts(ctx context.Context, id int) (*model.Topic, []*model.Post, error)
+	GetPublicTopicsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Topic, error)
+	CreateTopic(ctx context.Context, name, description string, private bool, authorID int) (*model.Topic, error)
+	EditTopic(ctx context.Context, id int, name, description string, private bool) (*model.Topic, error)
+	DeleteTopic(ctx context.Context, id int) error
 }
 
 type TopicHandler struct {
 	l  *slog.Logger
 	a  Authenticator
 	t  *template.Templates
-	ps PostService
 	ts TopicService
+	as AuditService
 }
 
 func NewTopicHandler(l *slog.Logger, a *auth.JWTAuthenticator,
-	t *template.Templates, ps PostService, ts TopicService) *TopicHandler {
-	return &TopicHandler{l: l, a: a, t: t, ps: ps, ts: ts}
+	t *template.Templates, ts TopicService, as AuditService) *TopicHandler {
+	return &TopicHandler{l: l, a: a, t: t, ts: ts, as: as}
 }
 
 func (t *TopicHandler) GetTopics(rw http.ResponseWriter, r *http.Request) {
-	topics, err := t.ts.GetAllTopics()
+	topics, err := t.ts.GetAllTopics(r.Context())
 	if err != nil {
 		msg := "Unable to get topics"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, t.l, msg, err)
 		return
 	}
 
+	if !signedIn(t.a, r) {
+		topics = slices.DeleteFunc(topics, func(topic *model.Topic) bool { return topic.Private })
+	}
+
 	data := make(map[string]any)
 	data["topics"] = topics
 
 	err = t.t.Render(rw, r, "topics.page", &model.Page{
-		Data: data,
+		Data:        data,
+		Title:       "Topics",
+		Description: "All topics discussed on SimpleForum.",
 	})
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, t.l, msg, err)
 		return
 	}
 }
 
 func (t *TopicHandler) GetTopic(rw http.ResponseWriter, r *http.Request) {
-	stringTopicID := r.PathValue("topicID")
-	id, err := strconv.Atoi(stringTopicID)
+	id, err := slug.ID(r.PathValue("topicID"))
 	if err != nil {
 		http.Error(rw, "Invalid Topic ID", http.StatusBadRequest)
 		return
 	}
 
-	topic, err := t.ts.GetTopicByID(id)
+	topic, posts, err := t.ts.GetTopicWithPosts(r.Context(), id)
 	if err != nil {
+		NotFound(rw, r, t.l, "Topic Not Found", err)
+		return
+	}
+
+	if topic.Private && !signedIn(t.a, r) {
 		http.Error(rw, "Topic Not Found", http.StatusNotFound)
 		return
 	}
 
-	posts, err := t.ps.GetPostsByTopicID(id)
-	if err != nil {
-		msg := "Unable to get posts"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", err.Error())
+	if redirectCanonical(rw, r, topic.Path()) {
 		return
 	}
 
@@ -81,12 +91,15 @@ func (t *TopicHandler) GetTopic(rw http.ResponseWriter, r *http.Request) {
 	data["topic"] = topic
 
 	err = t.t.Render(rw, r, "topic.page", &model.Page{
-		Data: data,
+		Data:        data,
+		Title:       topic.Name,
+		Description: summary(topic.Description),
+		Canonical:   topic.Path(),
+		NoIndex:     topic.Private,
 	})
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, t.l, msg, err)
 		return
 	}
 }
@@ -95,8 +108,7 @@ func (t *TopicHandler) GetCreateTopic(rw http.ResponseWriter, r *http.Request) {
 	err := t.t.Render(rw, r, "create-topic.page", new(model.Page))
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, t.l, msg, err)
 		return
 	}
 }
@@ -104,38 +116,40 @@ func (t *TopicHandler) GetCreateTopic(rw http.ResponseWriter, r *http.Request) {
 func (t *TopicHandler) PostCreateTopic(rw http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 	description := r.FormValue("description")
+	private := r.FormValue("private") == "on"
 
 	msg := "Failed to get user"
 
 	userValue := r.Context().Value("user")
 	if userValue == nil {
 		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", "cant get value from context")
+		t.l.ErrorContext(r.Context(), msg, "error", "cant get value from context")
 		return
 	}
 
 	user, ok := userValue.(map[string]interface{})
 	if !ok {
 		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", "invalid user type in context")
+		t.l.ErrorContext(r.Context(), msg, "error", "invalid user type in context")
 		return
 	}
 
 	userIDFloat, ok := user["id"].(float64)
 	if !ok {
 		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", "invalid user ID type")
+		t.l.ErrorContext(r.Context(), msg, "error", "invalid user ID type")
 		return
 	}
 	userID := int(userIDFloat)
-	err := t.ts.CreateTopic(name, description, userID)
+	topic, err := t.ts.CreateTopic(r.Context(), name, description, private, userID)
 	if err != nil {
 		msg = "Unable to create topic"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, t.l, msg, err)
 		return
 	}
 
+	recordAudit(r.Context(), t.l, t.as, newAuditEntry(r, service.AuditTopicCreate, service.AuditTargetTopic, topic.ID), nil, topic)
+
 	http.Redirect(rw, r, "/topics", http.StatusFound)
 }
 
@@ -147,10 +161,10 @@ func (t *TopicHandler) GetEditTopic(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	topic, err := t.ts.GetTopicByID(id)
+	topic, err := t.ts.GetTopicByID(r.Context(), id)
 
 	if err != nil {
-		http.Error(rw, "Topic Not Found", http.StatusNotFound)
+		NotFound(rw, r, t.l, "Topic Not Found", err)
 		return
 	}
 
@@ -162,8 +176,7 @@ func (t *TopicHandler) GetEditTopic(rw http.ResponseWriter, r *http.Request) {
 	})
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, t.l, msg, err)
 		return
 	}
 }
@@ -178,15 +191,23 @@ func (t *TopicHandler) PostEditTopic(rw http.ResponseWriter, r *http.Request) {
 
 	name := r.FormValue("name")
 	description := r.FormValue("description")
+	private := r.FormValue("private") == "on"
+
+	before, err := t.ts.GetTopicByID(r.Context(), id)
+	if err != nil {
+		NotFound(rw, r, t.l, "Topic Not Found", err)
+		return
+	}
 
-	err = t.ts.EditTopic(id, name, description)
+	after, err := t.ts.EditTopic(r.Context(), id, name, description, private)
 	if err != nil {
 		msg := "Unable to edit topic"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, t.l, msg, err)
 		return
 	}
 
+	recordAudit(r.Context(), t.l, t.as, newAuditEntry(r, service.AuditTopicEdit, service.AuditTargetTopic, id), before, after)
+
 	http.Redirect(rw, r, "/topics", http.StatusFound)
 }
 
@@ -198,13 +219,20 @@ func (t *TopicHandler) GetDeleteTopic(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = t.ts.DeleteTopic(id)
+	before, err := t.ts.GetTopicByID(r.Context(), id)
+	if err != nil {
+		NotFound(rw, r, t.l, "Topic Not Found", err)
+		return
+	}
+
+	err = t.ts.DeleteTopic(r.Context(), id)
 	if err != nil {
 		msg := "Unable to delete topic"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		t.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, t.l, msg, err)
 		return
 	}
 
+	recordAudit(r.Context(), t.l, t.as, newAuditEntry(r, service.AuditTopicDelete, service.AuditTargetTopic, id), before, nil)
+
 	http.Redirect(rw, r, "/topics", http.StatusFound)
 }
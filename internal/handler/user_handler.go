@@ -1,38 +1,71 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
 	"simple-forum/internal/auth"
 	"simple-forum/internal/model"
 	"simple-forum/internal/service"
 	"simple-forum/internal/template"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const oidcCookieName = "oidc_flow"
+
 type UserService interface {
-	Login(email, password string) (*model.User, error)
-	Register(username, email, password1, password2 string) error
+	Login(ctx context.Context, email, password, ip string) (*model.User, error)
+	LoginWithIdentity(ctx context.Context, external *model.ExternalIdentity) (*model.User, string, error)
+	Register(ctx context.Context, username, email, password1, password2 string) error
+	SuggestUsernames(ctx context.Context, prefix string) ([]string, error)
+}
+
+type IdentityProvider interface {
+	Name() string
+	AuthCodeURL(state, nonce, verifier string) string
+	Exchange(ctx context.Context, code, verifier, nonce string) (*model.ExternalIdentity, error)
 }
 
 type UserHandler struct {
-	l  *slog.Logger
-	a  Authenticator
-	t  *template.Templates
-	us UserService
+	l   *slog.Logger
+	a   Authenticator
+	t   *template.Templates
+	us  UserService
+	idp IdentityProvider
+	as  AuditService
 }
 
-func NewUserHandler(l *slog.Logger, a *auth.JWTAuthenticator, t *template.Templates, us UserService) *UserHandler {
-	return &UserHandler{l: l, t: t, a: a, us: us}
+// NewUserHandler creates a UserHandler. idp may be nil when single sign-on
+// is not configured.
+func NewUserHandler(l *slog.Logger, a *auth.JWTAuthenticator, t *template.Templates, us UserService,
+	idp IdentityProvider, as AuditService) *UserHandler {
+	return &UserHandler{l: l, t: t, a: a, us: us, idp: idp, as: as}
+}
+
+func (u *UserHandler) loginPage(errorMsg string) *model.Page {
+	page := &model.Page{
+		Error:   errorMsg,
+		Data:    make(map[string]any),
+		Title:   "Log in",
+		NoIndex: true,
+	}
+	if u.idp != nil {
+		page.Data["sso"] = u.idp.Name()
+	}
+	return page
 }
 
 func (u *UserHandler) GetRegister(rw http.ResponseWriter, r *http.Request) {
-	err := u.t.Render(rw, r, "register.page", new(model.Page))
+	err := u.t.Render(rw, r, "register.page", &model.Page{Title: "Sign up", NoIndex: true})
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		u.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, u.l, msg, err)
 		return
 	}
 }
@@ -43,7 +76,7 @@ func (u *UserHandler) PostRegister(rw http.ResponseWriter, r *http.Request) {
 	password1 := r.PostFormValue("password1")
 	password2 := r.PostFormValue("password2")
 
-	err := u.us.Register(username, email, password1, password2)
+	err := u.us.Register(r.Context(), username, email, password1, password2)
 	if err != nil {
 		var errorMsg string
 		switch {
@@ -55,13 +88,14 @@ func (u *UserHandler) PostRegister(rw http.ResponseWriter, r *http.Request) {
 			errorMsg = "Failed to register"
 		}
 		page := &model.Page{
-			Error: errorMsg,
+			Error:   errorMsg,
+			Title:   "Sign up",
+			NoIndex: true,
 		}
 		err = u.t.Render(rw, r, "register.page", page)
 		if err != nil {
 			msg := "Unable to render template"
-			http.Error(rw, msg, http.StatusInternalServerError)
-			u.l.Error(msg, "error", err.Error())
+			ServerError(rw, r, u.l, msg, err)
 			return
 		}
 		return
@@ -71,11 +105,10 @@ func (u *UserHandler) PostRegister(rw http.ResponseWriter, r *http.Request) {
 }
 
 func (u *UserHandler) GetLogin(rw http.ResponseWriter, r *http.Request) {
-	err := u.t.Render(rw, r, "login.page", new(model.Page))
+	err := u.t.Render(rw, r, "login.page", u.loginPage(""))
 	if err != nil {
 		msg := "Unable to render template"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		u.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, u.l, msg, err)
 		return
 	}
 }
@@ -84,35 +117,60 @@ func (u *UserHandler) PostLogin(rw http.ResponseWriter, r *http.Request) {
 	email := r.PostFormValue("email")
 	password := r.PostFormValue("password")
 
-	user, err := u.us.Login(email, password)
+	user, err := u.us.Login(r.Context(), email, password, ClientIP(r))
 	if err != nil {
 		var errorMsg string
+		var retry *service.LoginRetryError
 		switch {
-		case errors.Is(err, service.ErrUserNotFound):
-			errorMsg = "User not found"
-		case errors.Is(err, service.ErrWrongPassword):
-			errorMsg = "Wrong password"
+		case errors.Is(err, service.ErrInvalidCredentials):
+			errorMsg = "Invalid email or password"
+			entry := newAuditEntry(r, service.AuditLoginFailed, service.AuditTargetUser, 0)
+			recordAudit(r.Context(), u.l, u.as, entry, nil, map[string]string{"email": email})
+		case errors.As(err, &retry):
+			errorMsg = "Too many failed attempts, please try again later"
+			if errors.Is(err, service.ErrLoginThrottled) {
+				errorMsg = fmt.Sprintf("Too many failed attempts, please try again in %d seconds", retrySeconds(retry.After))
+			}
+			rw.Header().Set("Retry-After", strconv.Itoa(retrySeconds(retry.After)))
+			rw.WriteHeader(http.StatusTooManyRequests)
 		default:
 			errorMsg = "Failed to login"
+			u.l.ErrorContext(r.Context(), errorMsg, "error", err.Error())
 		}
-		page := &model.Page{
-			Error: errorMsg,
-		}
-		err = u.t.Render(rw, r, "login.page", page)
+		err = u.t.Render(rw, r, "login.page", u.loginPage(errorMsg))
 		if err != nil {
 			msg := "Unable to render template"
-			http.Error(rw, msg, http.StatusInternalServerError)
-			u.l.Error(msg, "error", err.Error())
+			ServerError(rw, r, u.l, msg, err)
 			return
 		}
 		return
 	}
 
+	u.recordLogin(r, user, "password")
+
+	u.startSession(rw, r, user)
+}
+
+// retrySeconds rounds d up to whole seconds for Retry-After.
+func retrySeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// recordLogin audits a successful login. The request carries no session
+// yet, so the actor is taken from user.
+func (u *UserHandler) recordLogin(r *http.Request, user *model.User, method string) {
+	entry := newAuditEntry(r, service.AuditLogin, service.AuditTargetUser, user.ID)
+	entry.ActorID = user.ID
+	entry.ActorName = user.Name
+	recordAudit(r.Context(), u.l, u.as, entry, nil, map[string]string{"method": method})
+}
+
+// startSession issues the token cookie for user and sends them to the topics.
+func (u *UserHandler) startSession(rw http.ResponseWriter, r *http.Request, user *model.User) {
 	token, err := u.a.GenerateToken(user.ID, user.Name, user.Role)
 	if err != nil {
 		msg := "Failed to generate token"
-		http.Error(rw, msg, http.StatusInternalServerError)
-		u.l.Error(msg, "error", err.Error())
+		ServerError(rw, r, u.l, msg, err)
 		return
 	}
 
@@ -129,7 +187,109 @@ func (u *UserHandler) PostLogin(rw http.ResponseWriter, r *http.Request) {
 	http.SetCookie(rw, cookie)
 
 	http.Redirect(rw, r, "/topics", http.StatusFound)
+}
+
+func (u *UserHandler) GetOIDCLogin(rw http.ResponseWriter, r *http.Request) {
+	if u.idp == nil {
+		http.NotFound(rw, r)
+		return
+	}
+
+	state, nonce, verifier, err := auth.NewOIDCFlow()
+	if err != nil {
+		msg := "Failed to start sign-in"
+		ServerError(rw, r, u.l, msg, err)
+		return
+	}
+
+	// the flow values only live for the round trip to the provider
+	cookie := &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    strings.Join([]string{state, nonce, verifier}, "."),
+		Path:     "/login/oidc",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+	}
+
+	http.SetCookie(rw, cookie)
 
+	http.Redirect(rw, r, u.idp.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+func (u *UserHandler) GetOIDCCallback(rw http.ResponseWriter, r *http.Request) {
+	if u.idp == nil {
+		http.NotFound(rw, r)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		http.Error(rw, "Sign-in session expired", http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:   oidcCookieName,
+		Path:   "/login/oidc",
+		MaxAge: -1,
+	})
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || parts[0] != r.URL.Query().Get("state") {
+		http.Error(rw, "Invalid sign-in state", http.StatusBadRequest)
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	if providerErr := r.URL.Query().Get("error"); providerErr != "" {
+		u.l.WarnContext(r.Context(), "Identity provider returned an error", "error", providerErr)
+		u.renderLoginError(rw, r, "Sign-in was cancelled or denied")
+		return
+	}
+
+	external, err := u.idp.Exchange(r.Context(), r.URL.Query().Get("code"), verifier, nonce)
+	if err != nil {
+		u.l.ErrorContext(r.Context(), "Failed to complete sign-in", "error", err.Error())
+		u.renderLoginError(rw, r, "Failed to login")
+		return
+	}
+
+	user, previousRole, err := u.us.LoginWithIdentity(r.Context(), external)
+	if err != nil {
+		var errorMsg string
+		switch {
+		case errors.Is(err, service.ErrIdentityNotLinked):
+			errorMsg = "No forum account is linked to this identity"
+		case errors.Is(err, service.ErrUserEmailAlreadyExists):
+			errorMsg = "Email already exists"
+		default:
+			errorMsg = "Failed to login"
+			u.l.ErrorContext(r.Context(), "Failed to login with identity", "error", err.Error())
+		}
+		u.renderLoginError(rw, r, errorMsg)
+		return
+	}
+
+	if previousRole != user.Role {
+		entry := newAuditEntry(r, service.AuditRoleChange, service.AuditTargetUser, user.ID)
+		entry.ActorName = u.idp.Name()
+		recordAudit(r.Context(), u.l, u.as, entry, map[string]string{"role": previousRole}, map[string]string{"role": user.Role})
+	}
+
+	u.recordLogin(r, user, u.idp.Name())
+
+	u.startSession(rw, r, user)
+}
+
+func (u *UserHandler) renderLoginError(rw http.ResponseWriter, r *http.Request, errorMsg string) {
+	err := u.t.Render(rw, r, "login.page", u.loginPage(errorMsg))
+	if err != nil {
+		msg := "Unable to render template"
+		ServerError(rw, r, u.l, msg, err)
+		return
+	}
 }
 
 func (u *UserHandler) GetLogout(rw http.ResponseWriter, r *http.Request) {
@@ -146,3 +306,25 @@ func (u *UserHandler) GetLogout(rw http.ResponseWriter, r *http.Request) {
 
 	http.Redirect(rw, r, "/home", http.StatusFound)
 }
+
+// GetUsernames serves the usernames starting with the prefix query
+// parameter as a JSON array, for completing mentions in the editor.
+func (u *UserHandler) GetUsernames(rw http.ResponseWriter, r *http.Request) {
+	names, err := u.us.SuggestUsernames(r.Context(), r.URL.Query().Get("prefix"))
+	if err != nil {
+		ServerError(rw, r, u.l, "Unable to suggest usernames", err)
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+
+	err = json.NewEncoder(rw).Encode(names)
+	if err != nil {
+		u.l.ErrorContext(r.Context(), "Unable to encode usernames", "error", err.Error())
+		return
+	}
+}
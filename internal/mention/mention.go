@@ -0,0 +1,48 @@
+// Package mention finds @username mentions in post content and links them
+// to the profiles of the users mentioned.
+package mention
+
+import (
+	"html/template"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// pattern matches @ and a username where it starts a word, so addresses
+// such as ada@example.com are no mentions. Usernames are made of letters,
+// digits, underscores, dots and hyphens; a dot or hyphen at the end is
+// taken as punctuation.
+var pattern = regexp.MustCompile(`(?:^|[^a-zA-Z0-9_.@-])@([a-zA-Z0-9_.-]*[a-zA-Z0-9_])`)
+
+// Find returns the usernames mentioned in text, each once and in the order
+// they first appear.
+func Find(text string) []string {
+	var names []string
+	for _, match := range pattern.FindAllStringSubmatch(text, -1) {
+		if !slices.Contains(names, match[1]) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// Link escapes text for HTML and turns the mentions of names into links to
+// their profiles. Mentions of other names are left as they are.
+func Link(text string, names []string) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[2]-1, match[3]
+		name := text[match[2]:match[3]]
+		if !slices.Contains(names, name) {
+			continue
+		}
+		b.WriteString(template.HTMLEscapeString(text[last:start]))
+		b.WriteString(`<a href="/users/` + template.HTMLEscapeString(name) + `" class="mention">@` + template.HTMLEscapeString(name) + `</a>`)
+		last = end
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+
+	return template.HTML(b.String())
+}
@@ -0,0 +1,37 @@
+package mention
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text  string
+		names []string
+	}{
+		{text: "@ada, meet @bob.", names: []string{"ada", "bob"}},
+		{text: "(@ada) and @ada again", names: []string{"ada"}},
+		{text: "@j.doe-2 and @x_y-", names: []string{"j.doe-2", "x_y"}},
+		{text: "mail ada@example.com or @ada@bob", names: []string{"ada"}},
+		{text: "@ @. @-", names: nil},
+	}
+
+	for _, tt := range tests {
+		if got := Find(tt.text); !slices.Equal(got, tt.names) {
+			t.Errorf("%q: expected %q, got %q", tt.text, tt.names, got)
+		}
+	}
+}
+
+func TestLink(t *testing.T) {
+	t.Parallel()
+
+	got := Link("<b>@ada</b> asks @nobody & @bob.", []string{"ada", "bob"})
+	expected := `&lt;b&gt;<a href="/users/ada" class="mention">@ada</a>&lt;/b&gt; asks @nobody &amp; <a href="/users/bob" class="mention">@bob</a>.`
+	if string(got) != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
@@ -0,0 +1,128 @@
+// Package metrics holds the Prometheus collectors of the forum. They are
+// registered with Registry rather than the global default registry, so only
+// what is defined here is exported.
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "forum"
+
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	LoginBlocked = "blocked"
+)
+
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	TemplateDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "template_render_duration_seconds",
+		Help:      "Time spent rendering each template.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"template"})
+
+	PostsCreated = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "posts_created_total",
+		Help:      "Posts created.",
+	})
+
+	Logins = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by result: success, failure or blocked by the login guard.",
+	}, []string{"result"})
+
+	Registrations = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Users registered with the signup form.",
+	})
+
+	CacheRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups by kind of entry and result: hit or miss.",
+	}, []string{"kind", "result"})
+
+	CacheEvictions = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_evictions_total",
+		Help:      "Cache entries evicted to stay within the size limit.",
+	})
+
+	EventsPublished = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_published_total",
+		Help:      "Events published by this instance, by type.",
+	}, []string{"type"})
+
+	EventsReceived = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Events received from other instances, by type.",
+	}, []string{"type"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterDB exports the pool statistics of db.
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus text format. A non-empty
+// token has to be sent as a bearer token.
+func Handler(token string) http.Handler {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+
+	expected := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
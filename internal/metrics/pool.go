@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector reads the statistics of a pgxpool on every scrape.
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquired, idle, constructing, total, max                  *prometheus.Desc
+	acquires, emptyAcquires, canceledAcquires, acquireSeconds *prometheus.Desc
+}
+
+// RegisterPool exports the statistics of the PostgreSQL connection pool.
+func RegisterPool(pool *pgxpool.Pool, name string) error {
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", metric), help,
+			nil, prometheus.Labels{"db_name": name})
+	}
+
+	return Registry.Register(&poolCollector{
+		pool:             pool,
+		acquired:         desc("acquired_connections", "Connections in use."),
+		idle:             desc("idle_connections", "Connections waiting to be acquired."),
+		constructing:     desc("constructing_connections", "Connections being established."),
+		total:            desc("total_connections", "Connections open or being established."),
+		max:              desc("max_connections", "Maximum size of the pool."),
+		acquires:         desc("acquires_total", "Connections acquired from the pool."),
+		emptyAcquires:    desc("empty_acquires_total", "Acquires that had to wait because no connection was idle."),
+		canceledAcquires: desc("canceled_acquires_total", "Acquires given up because their context was cancelled."),
+		acquireSeconds:   desc("acquire_duration_seconds_total", "Time spent acquiring connections."),
+	})
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.acquired, c.idle, c.constructing, c.total, c.max,
+		c.acquires, c.emptyAcquires, c.canceledAcquires, c.acquireSeconds,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.constructing, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquires, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireSeconds, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
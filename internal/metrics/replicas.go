@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"simple-forum/internal/database"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// replicaCollector reads the state of the read replicas on every scrape.
+type replicaCollector struct {
+	replicas *database.ReplicaSet
+
+	healthy, lag *prometheus.Desc
+}
+
+// RegisterReplicas exports whether each replica is in rotation and how far
+// it lagged behind at the last check.
+func RegisterReplicas(replicas *database.ReplicaSet) error {
+	return Registry.Register(&replicaCollector{
+		replicas: replicas,
+		healthy: prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_replica", "healthy"),
+			"Whether the replica takes read-only queries.", []string{"replica"}, nil),
+		lag: prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_replica", "lag_seconds"),
+			"Replication lag at the last check.", []string{"replica"}, nil),
+	})
+}
+
+func (c *replicaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.healthy
+	ch <- c.lag
+}
+
+func (c *replicaCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, replica := range c.replicas.Replicas() {
+		healthy := 0.0
+		if replica.Healthy() {
+			healthy = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.healthy, prometheus.GaugeValue, healthy, replica.Name)
+		ch <- prometheus.MustNewConstMetric(c.lag, prometheus.GaugeValue, replica.Lag().Seconds(), replica.Name)
+	}
+}
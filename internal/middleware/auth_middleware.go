@@ -4,22 +4,65 @@ import (
 	"context"
 	"github.com/golang-jwt/jwt/v5"
 	"net/http"
+	"simple-forum/internal/model"
+	"strings"
 )
 
 type Authenticator interface {
 	GetClaimsFromRequest(r *http.Request) (jwt.MapClaims, error)
 }
 
-func AuthMiddleware(a Authenticator) func(http.Handler) http.HandlerFunc {
+type TokenAuthenticator interface {
+	Authenticate(ctx context.Context, value string) (*model.User, *model.AccessToken, error)
+}
+
+// AuthMiddleware puts the user into the request context. Requests carrying an
+// Authorization header are authenticated by personal access token only, and
+// the token's scopes are added to the user as "scopes".
+func AuthMiddleware(a Authenticator, ta TokenAuthenticator) func(http.Handler) http.HandlerFunc {
 	return func(next http.Handler) http.HandlerFunc {
 		return func(rw http.ResponseWriter, r *http.Request) {
-			claims, err := a.GetClaimsFromRequest(r)
-			if err != nil {
-				http.Error(rw, "Unauthorized", http.StatusUnauthorized)
-				return
+			// the span covers the lookup only, not the handler
+			lookupCtx, span := tracer.Start(r.Context(), "AuthMiddleware")
+
+			var user map[string]interface{}
+
+			if header := r.Header.Get("Authorization"); header != "" {
+				value, ok := strings.CutPrefix(header, "Bearer ")
+				if !ok {
+					span.End()
+					rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_request"`)
+					http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				owner, token, err := ta.Authenticate(lookupCtx, value)
+				if err != nil {
+					span.End()
+					rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				// same shape as decoded JWT claims
+				user = map[string]interface{}{
+					"id":     float64(owner.ID),
+					"name":   owner.Name,
+					"role":   owner.Role,
+					"scopes": token.Scopes,
+				}
+			} else {
+				claims, err := a.GetClaimsFromRequest(r)
+				if err != nil {
+					span.End()
+					http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				user = claims["user"].(map[string]interface{})
 			}
 
-			user := claims["user"].(map[string]interface{})
+			span.End()
 
 			ctx := context.WithValue(r.Context(), "user", user)
 
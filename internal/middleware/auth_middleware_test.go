@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"simple-forum/internal/model"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type sessionStub struct {
+	claims jwt.MapClaims
+}
+
+func (s sessionStub) GetClaimsFromRequest(r *http.Request) (jwt.MapClaims, error) {
+	if _, err := r.Cookie("token"); err != nil || s.claims == nil {
+		return nil, http.ErrNoCookie
+	}
+	return s.claims, nil
+}
+
+// tokenStub knows one token value; the errors of revoked and expired
+// tokens are only told apart by the service.
+type tokenStub struct {
+	value string
+	owner *model.User
+	token *model.AccessToken
+	err   error
+}
+
+func (s tokenStub) Authenticate(ctx context.Context, value string) (*model.User, *model.AccessToken, error) {
+	if value != s.value {
+		return nil, nil, errors.New("invalid token")
+	}
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	return s.owner, s.token, nil
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Parallel()
+
+	session := sessionStub{claims: jwt.MapClaims{
+		"user": map[string]interface{}{"id": float64(1), "name": "ada", "role": "user"},
+	}}
+	owner := &model.User{ID: 2, Name: "bob", Role: "admin"}
+	token := &model.AccessToken{ID: 1, UserID: 2, Scopes: []string{"read"}}
+
+	tests := []struct {
+		name          string
+		authorization string
+		cookie        bool
+		tokenErr      error
+		status        int
+		challenge     string
+		wantName      string
+		wantScopes    bool
+	}{
+		{name: "Session", cookie: true, status: http.StatusOK, wantName: "ada"},
+		{name: "No Credentials", status: http.StatusUnauthorized},
+		{name: "Token", authorization: "Bearer sfp_valid", status: http.StatusOK, wantName: "bob", wantScopes: true},
+		{name: "Token Wins Over Session", authorization: "Bearer sfp_valid", cookie: true, status: http.StatusOK, wantName: "bob", wantScopes: true},
+		{name: "Missing Bearer", authorization: "sfp_valid", status: http.StatusUnauthorized, challenge: `Bearer error="invalid_request"`},
+		{name: "Basic Scheme", authorization: "Basic YWRhOnNlY3JldA==", status: http.StatusUnauthorized, challenge: `Bearer error="invalid_request"`},
+		{name: "Empty Bearer", authorization: "Bearer ", status: http.StatusUnauthorized, challenge: `Bearer error="invalid_token"`},
+		{name: "Invalid Token", authorization: "Bearer sfp_other", status: http.StatusUnauthorized, challenge: `Bearer error="invalid_token"`},
+		{name: "Invalid Token Ignores Session", authorization: "Bearer sfp_other", cookie: true, status: http.StatusUnauthorized, challenge: `Bearer error="invalid_token"`},
+		{name: "Revoked Token", authorization: "Bearer sfp_valid", tokenErr: errors.New("invalid token"), status: http.StatusUnauthorized, challenge: `Bearer error="invalid_token"`},
+		{name: "Expired Token", authorization: "Bearer sfp_valid", tokenErr: errors.New("token expired"), status: http.StatusUnauthorized, challenge: `Bearer error="invalid_token"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var user map[string]interface{}
+			handler := AuthMiddleware(session, tokenStub{value: "sfp_valid", owner: owner, token: token, err: tt.tokenErr})(
+				http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+					user, _ = r.Context().Value("user").(map[string]interface{})
+				}))
+
+			r := httptest.NewRequest(http.MethodGet, "/topics", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.cookie {
+				r.AddCookie(&http.Cookie{Name: "token", Value: "jwt"})
+			}
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, r)
+
+			if rw.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, rw.Code)
+			}
+			if got := rw.Header().Get("WWW-Authenticate"); got != tt.challenge {
+				t.Errorf("expected challenge %q, got %q", tt.challenge, got)
+			}
+			if tt.status != http.StatusOK {
+				if user != nil {
+					t.Errorf("expected the handler not to run, got user %v", user)
+				}
+				return
+			}
+			if user["name"] != tt.wantName {
+				t.Errorf("expected user %q, got %v", tt.wantName, user["name"])
+			}
+			if _, ok := user["scopes"]; ok != tt.wantScopes {
+				t.Errorf("expected scopes %v, got %v", tt.wantScopes, user["scopes"])
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import "net/http"
+
+// BodyLimitMiddleware fails reading request bodies past maxBytes, so that
+// neither the CSRF check nor a handler parsing a form or upload reads more.
+// Requests announcing a larger body are answered with 413 right away.
+func BodyLimitMiddleware(maxBytes int64) func(http.Handler) http.HandlerFunc {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				http.Error(rw, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(rw, r.Body, maxBytes)
+
+			next.ServeHTTP(rw, r)
+		}
+	}
+}
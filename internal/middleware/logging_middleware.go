@@ -32,12 +32,15 @@ func LoggingMiddleware(l *slog.Logger) func(http.Handler) http.HandlerFunc {
 
 			duration := fmt.Sprintf("%fs", time.Since(start).Seconds())
 
-			l.Info(
+			requestID, _ := r.Context().Value("request_id").(string)
+
+			l.InfoContext(r.Context(),
 				"HTTP request",
 				"status", wrappedRW.statusCode,
 				"method", r.Method,
 				"path", r.URL.Path,
 				"duration", duration,
+				"request_id", requestID,
 			)
 		}
 	}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"simple-forum/internal/metrics"
+	"strconv"
+	"strings"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// route is filled in by the innermost RouteMiddleware that saw the request.
+type route struct {
+	pattern string
+	set     bool
+}
+
+// MetricsMiddleware counts requests and their latency by route pattern,
+// method and status. Routes are only known once a mux has matched, so every
+// mux has to be wrapped in RouteMiddleware.
+func MetricsMiddleware() func(http.Handler) http.HandlerFunc {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			rt := new(route)
+			ctx := context.WithValue(r.Context(), "route", rt)
+
+			wrappedRW := newResponseWriter(rw)
+
+			next.ServeHTTP(wrappedRW, r.WithContext(ctx))
+
+			pattern := rt.pattern
+			if pattern == "" {
+				pattern = "unmatched"
+			}
+			status := strconv.Itoa(wrappedRW.statusCode)
+
+			metrics.HTTPRequests.WithLabelValues(pattern, r.Method, status).Inc()
+			metrics.HTTPDuration.WithLabelValues(pattern, r.Method, status).Observe(time.Since(start).Seconds())
+		}
+	}
+}
+
+// RouteMiddleware reports the pattern the wrapped mux matched to
+// MetricsMiddleware and names the request's trace span after it. prefix is
+// the path the mux is mounted under with http.StripPrefix.
+func RouteMiddleware(prefix string) func(http.Handler) http.HandlerFunc {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(rw, r)
+
+			rt, ok := r.Context().Value("route").(*route)
+			if !ok || rt.set {
+				return
+			}
+			rt.set = true
+
+			// the mux stores the matched pattern in the request it was given
+			if r.Pattern == "" {
+				return
+			}
+			method, path, found := strings.Cut(r.Pattern, " ")
+			if !found {
+				method, path = "", r.Pattern
+			}
+			rt.pattern = strings.TrimSpace(method + " " + prefix + path)
+
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(rt.pattern)
+			span.SetAttributes(semconv.HTTPRoute(prefix + path))
+		}
+	}
+}
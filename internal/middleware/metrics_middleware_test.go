@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"simple-forum/internal/metrics"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddleware_Route(t *testing.T) {
+	t.Parallel()
+
+	ok := func(rw http.ResponseWriter, r *http.Request) {}
+
+	inner := http.NewServeMux()
+	inner.HandleFunc("GET /metrics-test/{id}/edit", ok)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics-test/{id}", ok)
+	mux.Handle("/metrics-admin/", http.StripPrefix("/metrics-admin", RouteMiddleware("/metrics-admin")(inner)))
+
+	handler := MetricsMiddleware()(RouteMiddleware("")(mux))
+
+	tests := []struct {
+		name   string
+		path   string
+		route  string
+		status string
+	}{
+		{name: "Top Level", path: "/metrics-test/1", route: "GET /metrics-test/{id}", status: "200"},
+		{name: "Grouped", path: "/metrics-admin/metrics-test/2/edit", route: "GET /metrics-admin/metrics-test/{id}/edit", status: "200"},
+		{name: "Grouped Not Found", path: "/metrics-admin/nothing", route: "unmatched", status: "404"},
+		{name: "Not Found", path: "/metrics-nothing", route: "unmatched", status: "404"},
+	}
+
+	for _, tt := range tests {
+		counter := metrics.HTTPRequests.WithLabelValues(tt.route, http.MethodGet, tt.status)
+		before := testutil.ToFloat64(counter)
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if got := testutil.ToFloat64(counter) - before; got != 1 {
+			t.Errorf("%s: expected one request counted for %q, got %v", tt.name, tt.route, got)
+		}
+	}
+}
@@ -26,28 +26,28 @@ func PermissionMiddleware(l *slog.Logger, ps handler.PostService, permissions ..
 			user, ok := userValue.(map[string]interface{})
 			if !ok {
 				http.Error(rw, msg, http.StatusInternalServerError)
-				l.Error(msg, "method", r.Method, "path", r.URL.Path)
+				l.ErrorContext(r.Context(), msg, "method", r.Method, "path", r.URL.Path)
 				return
 			}
 
 			userIDFloat, ok := user["id"].(float64)
 			if !ok {
 				http.Error(rw, msg, http.StatusInternalServerError)
-				l.Error(msg, "method", r.Method, "path", r.URL.Path)
+				l.ErrorContext(r.Context(), msg, "method", r.Method, "path", r.URL.Path)
 				return
 			}
 
 			userRole, ok := user["role"].(string)
 			if !ok {
 				http.Error(rw, msg, http.StatusInternalServerError)
-				l.Error(msg, "method", r.Method, "path", r.URL.Path)
+				l.ErrorContext(r.Context(), msg, "method", r.Method, "path", r.URL.Path)
 				return
 			}
 
 			userID := int(userIDFloat)
 
 			if _, ok = requiredPerms["admin"]; ok {
-				if userRole == "admin" {
+				if userRole == "admin" && hasScope(user, "moderate") {
 					next.ServeHTTP(rw, r)
 					return
 				}
@@ -61,9 +61,9 @@ func PermissionMiddleware(l *slog.Logger, ps handler.PostService, permissions ..
 					return
 				}
 
-				post, err := ps.GetPostByID(id)
+				post, err := ps.GetPostByID(r.Context(), id)
 				if err != nil {
-					http.Error(rw, "Post Not Found", http.StatusNotFound)
+					handler.NotFound(rw, r, l, "Post Not Found", err)
 					return
 				}
 
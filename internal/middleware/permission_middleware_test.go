@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"simple-forum/internal/handler"
+	"simple-forum/internal/model"
+	"testing"
+)
+
+// postStub answers GetPostByID with post or err; the other methods of the
+// interface are not used by PermissionMiddleware.
+type postStub struct {
+	handler.PostService
+	post *model.Post
+	err  error
+}
+
+func (p *postStub) GetPostByID(ctx context.Context, postID int) (*model.Post, error) {
+	return p.post, p.err
+}
+
+func TestPermissionMiddleware_Author(t *testing.T) {
+	t.Parallel()
+
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	user := map[string]interface{}{"id": float64(1), "name": "ada", "role": "user"}
+
+	tests := []struct {
+		name   string
+		post   *model.Post
+		err    error
+		status int
+	}{
+		{name: "Own Post", post: &model.Post{ID: 7, AuthorId: 1}, status: http.StatusOK},
+		{name: "Other Author", post: &model.Post{ID: 7, AuthorId: 2}, status: http.StatusForbidden},
+		{name: "Missing Post", err: sql.ErrNoRows, status: http.StatusNotFound},
+		{name: "Lookup Timed Out", err: context.DeadlineExceeded, status: http.StatusGatewayTimeout},
+		{name: "Lookup Cancelled", err: context.Canceled, status: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			next := PermissionMiddleware(l, &postStub{post: tt.post, err: tt.err}, "author")(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest(http.MethodPost, "/posts/7/edit", nil)
+			r.SetPathValue("postID", "7")
+			r = r.WithContext(context.WithValue(r.Context(), "user", user))
+			rw := httptest.NewRecorder()
+
+			next.ServeHTTP(rw, r)
+
+			if rw.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rw.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"simple-forum/internal/database"
+	"time"
+)
+
+const primaryCookieName = "db_primary"
+
+// primaryWriter sets the primary cookie when a request is answered with a
+// redirect.
+type primaryWriter struct {
+	http.ResponseWriter
+	cookie *http.Cookie
+}
+
+func (rw *primaryWriter) WriteHeader(code int) {
+	if code >= 300 && code < 400 {
+		http.SetCookie(rw.ResponseWriter, rw.cookie)
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// PrimaryMiddleware sends the queries of requests that may write to the
+// primary database, and those of the same client for window afterwards,
+// so that a client reads its own writes even from lagging replicas. A
+// request may write if its method is not GET or HEAD, or if it is answered
+// with a redirect, which is how the forms and delete links finish.
+func PrimaryMiddleware(window time.Duration) func(http.Handler) http.HandlerFunc {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			cookie := &http.Cookie{
+				Name:     primaryCookieName,
+				Value:    "1",
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
+				MaxAge:   max(int(window.Seconds()), 1),
+			}
+
+			ctx := r.Context()
+			if _, err := r.Cookie(primaryCookieName); err == nil {
+				ctx = database.WithPrimary(ctx)
+			}
+
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.SetCookie(rw, cookie)
+				next.ServeHTTP(rw, r.WithContext(database.WithPrimary(ctx)))
+				return
+			}
+
+			next.ServeHTTP(&primaryWriter{ResponseWriter: rw, cookie: cookie}, r.WithContext(ctx))
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"simple-forum/internal/database"
+	"testing"
+	"time"
+)
+
+func TestPrimaryMiddleware(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		method      string
+		cookie      bool
+		redirect    bool
+		wantPrimary bool
+		wantCookie  bool
+	}{
+		{name: "Read", method: http.MethodGet},
+		{name: "Write", method: http.MethodPost, wantPrimary: true, wantCookie: true},
+		{name: "Read After Write", method: http.MethodGet, cookie: true, wantPrimary: true},
+		{name: "Redirect", method: http.MethodGet, redirect: true, wantCookie: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var primary bool
+			handler := PrimaryMiddleware(5 * time.Second)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				primary = database.UsesPrimary(r.Context())
+				if tt.redirect {
+					http.Redirect(rw, r, "/topics", http.StatusFound)
+				}
+			}))
+
+			r := httptest.NewRequest(tt.method, "/topics", nil)
+			if tt.cookie {
+				r.AddCookie(&http.Cookie{Name: primaryCookieName, Value: "1"})
+			}
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, r)
+
+			if primary != tt.wantPrimary {
+				t.Errorf("expected primary %v, got %v", tt.wantPrimary, primary)
+			}
+
+			var cookie *http.Cookie
+			for _, c := range rw.Result().Cookies() {
+				if c.Name == primaryCookieName {
+					cookie = c
+				}
+			}
+			if (cookie != nil) != tt.wantCookie {
+				t.Fatalf("expected cookie %v, got %v", tt.wantCookie, cookie)
+			}
+			if cookie != nil && cookie.MaxAge != 5 {
+				t.Errorf("expected cookie to last 5 seconds, got %d", cookie.MaxAge)
+			}
+		})
+	}
+}
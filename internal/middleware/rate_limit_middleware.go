@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"simple-forum/internal/handler"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type RateKey int
+
+const (
+	// KeyByIP limits each client address.
+	KeyByIP RateKey = iota
+	// KeyByUser limits each authenticated user, anonymous requests by address.
+	KeyByUser
+	// KeyByIPAndUser limits each user from each address.
+	KeyByIPAndUser
+)
+
+// RatePolicy describes a token bucket: it holds up to Burst requests and
+// refills at Rate requests per second.
+type RatePolicy struct {
+	Name  string
+	Rate  float64
+	Burst int
+	Key   RateKey
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter keeps one bucket per policy and key. Buckets idle for longer
+// than idleTTL are refilled anyway, so they are evicted by a janitor.
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	idleTTL time.Duration
+	now     func() time.Time
+	done    chan struct{}
+}
+
+// NewRateLimiter starts the eviction janitor; call Close to stop it. Clients
+// are told apart by r.RemoteAddr, see RealIPMiddleware.
+func NewRateLimiter(idleTTL time.Duration) *RateLimiter {
+	rl := &RateLimiter{
+		buckets: make(map[string]*bucket),
+		idleTTL: idleTTL,
+		now:     time.Now,
+		done:    make(chan struct{}),
+	}
+
+	go rl.janitor()
+
+	return rl
+}
+
+func (rl *RateLimiter) Close() {
+	close(rl.done)
+}
+
+func (rl *RateLimiter) janitor() {
+	ticker := time.NewTicker(rl.idleTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rl.evict()
+		case <-rl.done:
+			return
+		}
+	}
+}
+
+func (rl *RateLimiter) evict() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cutoff := rl.now().Add(-rl.idleTTL)
+	for key, b := range rl.buckets {
+		if b.lastSeen.Before(cutoff) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// Allow takes a token from the bucket for key. It returns the tokens left
+// and, when denied, how long until the next one is available.
+func (rl *RateLimiter) Allow(policy RatePolicy, key string) (bool, int, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	id := policy.Name + "|" + key
+
+	b, ok := rl.buckets[id]
+	if !ok {
+		b = &bucket{tokens: float64(policy.Burst), lastSeen: now}
+		rl.buckets[id] = b
+	}
+
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens = math.Min(float64(policy.Burst), b.tokens+elapsed*policy.Rate)
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / policy.Rate * float64(time.Second))
+		return false, 0, wait
+	}
+
+	b.tokens--
+
+	return true, int(b.tokens), 0
+}
+
+func (rl *RateLimiter) key(policy RatePolicy, r *http.Request) string {
+	ip := handler.ClientIP(r)
+	if policy.Key == KeyByIP {
+		return "ip:" + ip
+	}
+
+	user, ok := r.Context().Value("user").(map[string]interface{})
+	if !ok {
+		return "ip:" + ip
+	}
+	userID, ok := user["id"].(float64)
+	if !ok {
+		return "ip:" + ip
+	}
+
+	key := "user:" + strconv.Itoa(int(userID))
+	if policy.Key == KeyByIPAndUser {
+		key += "|ip:" + ip
+	}
+	return key
+}
+
+// RateLimitMiddleware answers 429 once the caller's bucket is empty and
+// reports the bucket state in X-RateLimit-* headers.
+func RateLimitMiddleware(rl *RateLimiter, policy RatePolicy) func(http.Handler) http.HandlerFunc {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			allowed, remaining, retryAfter := rl.Allow(policy, rl.key(policy, r))
+
+			refill := time.Duration(float64(policy.Burst-remaining) / policy.Rate * float64(time.Second))
+
+			rw.Header().Set("X-RateLimit-Limit", strconv.Itoa(policy.Burst))
+			rw.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			rw.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(refill.Seconds()))))
+
+			if !allowed {
+				rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(rw, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		}
+	}
+}
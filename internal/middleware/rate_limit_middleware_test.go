@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestRateLimiter(now *time.Time) *RateLimiter {
+	rl := NewRateLimiter(time.Hour)
+	rl.now = func() time.Time { return *now }
+	return rl
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rl := newTestRateLimiter(&now)
+	defer rl.Close()
+
+	policy := RatePolicy{Name: "login", Rate: 1, Burst: 2, Key: KeyByIP}
+	handler := RateLimitMiddleware(rl, policy)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		advance    time.Duration
+		remoteAddr string
+		status     int
+		remaining  string
+		retryAfter string
+	}{
+		{name: "First Request", remoteAddr: "10.0.0.1:1000", status: http.StatusOK, remaining: "1"},
+		{name: "Burst Used Up", remoteAddr: "10.0.0.1:1001", status: http.StatusOK, remaining: "0"},
+		{name: "Limited", remoteAddr: "10.0.0.1:1002", status: http.StatusTooManyRequests, remaining: "0", retryAfter: "1"},
+		{name: "Other Client", remoteAddr: "10.0.0.2:1000", status: http.StatusOK, remaining: "1"},
+		{name: "Refilled", advance: time.Second, remoteAddr: "10.0.0.1:1003", status: http.StatusOK, remaining: "0"},
+	}
+
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = tt.remoteAddr
+		rw := httptest.NewRecorder()
+
+		handler.ServeHTTP(rw, r)
+
+		if rw.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, rw.Code)
+		}
+		if got := rw.Header().Get("X-RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("%s: expected remaining %s, got %s", tt.name, tt.remaining, got)
+		}
+		if got := rw.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("%s: expected Retry-After %q, got %q", tt.name, tt.retryAfter, got)
+		}
+	}
+}
+
+func TestRateLimiter_KeyByUser(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rl := newTestRateLimiter(&now)
+	defer rl.Close()
+
+	policy := RatePolicy{Name: "post", Rate: 1, Burst: 1, Key: KeyByUser}
+
+	request := func(userID float64, remoteAddr string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/posts", nil)
+		r.RemoteAddr = remoteAddr
+		user := map[string]interface{}{"id": userID}
+		return r.WithContext(context.WithValue(r.Context(), "user", user))
+	}
+
+	if allowed, _, _ := rl.Allow(policy, rl.key(policy, request(1, "10.0.0.1:1"))); !allowed {
+		t.Fatal("expected first request of user 1 to be allowed")
+	}
+	if allowed, _, _ := rl.Allow(policy, rl.key(policy, request(1, "10.0.0.9:1"))); allowed {
+		t.Error("expected user 1 to be limited from another address")
+	}
+	if allowed, _, _ := rl.Allow(policy, rl.key(policy, request(2, "10.0.0.1:1"))); !allowed {
+		t.Error("expected user 2 to have its own bucket")
+	}
+}
+
+func TestRateLimiter_Evict(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rl := newTestRateLimiter(&now)
+	defer rl.Close()
+
+	policy := RatePolicy{Name: "global", Rate: 1, Burst: 1, Key: KeyByIP}
+	rl.Allow(policy, "ip:10.0.0.1")
+
+	now = now.Add(30 * time.Minute)
+	rl.Allow(policy, "ip:10.0.0.2")
+
+	now = now.Add(45 * time.Minute)
+	rl.evict()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if _, ok := rl.buckets["global|ip:10.0.0.1"]; ok {
+		t.Error("expected idle bucket to be evicted")
+	}
+	if _, ok := rl.buckets["global|ip:10.0.0.2"]; !ok {
+		t.Error("expected recent bucket to be kept")
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIPMiddleware sets r.RemoteAddr to the client address from
+// X-Forwarded-For or X-Real-IP when trustProxy is set, so every later
+// handler sees the same client. Only enable it behind a single proxy that
+// appends to X-Forwarded-For or overwrites X-Real-IP.
+func RealIPMiddleware(trustProxy bool) func(http.Handler) http.HandlerFunc {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			if trustProxy {
+				ip := r.Header.Get("X-Real-IP")
+				if forwarded := lastForwarded(r.Header.Values("X-Forwarded-For")); forwarded != "" {
+					ip = forwarded
+				}
+
+				ip = strings.TrimSpace(ip)
+				if net.ParseIP(ip) != nil {
+					r.RemoteAddr = net.JoinHostPort(ip, "0")
+				}
+			}
+
+			next.ServeHTTP(rw, r)
+		}
+	}
+}
+
+// lastForwarded returns the right-most X-Forwarded-For entry, the one the
+// proxy added. Entries left of it come from the client and can be forged.
+func lastForwarded(headers []string) string {
+	for i := len(headers) - 1; i >= 0; i-- {
+		entries := strings.Split(headers[i], ",")
+		for j := len(entries) - 1; j >= 0; j-- {
+			if entry := strings.TrimSpace(entries[j]); entry != "" {
+				return entry
+			}
+		}
+	}
+	return ""
+}
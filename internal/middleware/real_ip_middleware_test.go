@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"simple-forum/internal/handler"
+	"testing"
+)
+
+func TestRealIPMiddleware(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		trustProxy bool
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{name: "Not Trusted", forwarded: []string{"203.0.113.7"}, want: "192.0.2.1"},
+		{name: "Single Entry", trustProxy: true, forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "Forged Entry", trustProxy: true, forwarded: []string{"10.0.0.1, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "Repeated Header", trustProxy: true, forwarded: []string{"10.0.0.1", "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "Trailing Comma", trustProxy: true, forwarded: []string{"203.0.113.7, "}, want: "203.0.113.7"},
+		{name: "Real IP", trustProxy: true, realIP: "203.0.113.8", want: "203.0.113.8"},
+		{name: "Forwarded Wins", trustProxy: true, forwarded: []string{"203.0.113.7"}, realIP: "203.0.113.8", want: "203.0.113.7"},
+		{name: "Not An Address", trustProxy: true, forwarded: []string{"203.0.113.7, unknown"}, want: "192.0.2.1"},
+		{name: "IPv6", trustProxy: true, forwarded: []string{"2001:db8::1"}, want: "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got string
+			handler := RealIPMiddleware(tt.trustProxy)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				got = handler.ClientIP(r)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("expected client %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
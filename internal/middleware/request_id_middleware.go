@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware keeps a well-formed incoming X-Request-ID or generates
+// one, echoes it in the response and stores it in the context as "request_id".
+func RequestIDMiddleware() func(http.Handler) http.HandlerFunc {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get("X-Request-ID")
+			if !validRequestID.MatchString(id) {
+				b := make([]byte, 16)
+				_, _ = rand.Read(b)
+				id = hex.EncodeToString(b)
+			}
+
+			rw.Header().Set("X-Request-ID", id)
+
+			ctx := context.WithValue(r.Context(), "request_id", id)
+
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		}
+	}
+}
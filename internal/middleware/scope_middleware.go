@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+)
+
+// hasScope reports whether the context user may act with scope. Browser
+// sessions carry no scopes and are allowed everything.
+func hasScope(user map[string]interface{}, scope string) bool {
+	scopes, ok := user["scopes"].([]string)
+	if !ok {
+		return true
+	}
+	return slices.Contains(scopes, scope)
+}
+
+// ScopeMiddleware rejects personal access tokens that lack scope.
+func ScopeMiddleware(scope string) func(http.Handler) http.HandlerFunc {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			user, ok := r.Context().Value("user").(map[string]interface{})
+			if !ok {
+				http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !hasScope(user, scope) {
+				rw.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				http.Error(rw, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		}
+	}
+}
+
+// SessionMiddleware rejects personal access tokens altogether, for pages
+// such as token management that only a signed-in browser may use.
+func SessionMiddleware() func(http.Handler) http.HandlerFunc {
+	return func(next http.Handler) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			user, ok := r.Context().Value("user").(map[string]interface{})
+			if !ok {
+				http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if _, isToken := user["scopes"]; isToken {
+				http.Error(rw, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(rw, r)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScopeMiddleware(t *testing.T) {
+	t.Parallel()
+
+	session := map[string]interface{}{"id": float64(1), "name": "ada", "role": "user"}
+	adminSession := map[string]interface{}{"id": float64(2), "name": "bob", "role": "admin"}
+	tokenUser := func(role string, scopes ...string) map[string]interface{} {
+		return map[string]interface{}{"id": float64(3), "name": "cy", "role": role, "scopes": scopes}
+	}
+
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name       string
+		middleware func(http.Handler) http.HandlerFunc
+		user       map[string]interface{}
+		status     int
+		challenge  string
+	}{
+		{name: "No User", middleware: ScopeMiddleware("read"), status: http.StatusUnauthorized},
+		{name: "Session Without Scopes", middleware: ScopeMiddleware("post"), user: session, status: http.StatusOK},
+		{name: "Token With Scope", middleware: ScopeMiddleware("read"), user: tokenUser("user", "read"), status: http.StatusOK},
+		{name: "Token Without Scope", middleware: ScopeMiddleware("post"), user: tokenUser("user", "read"), status: http.StatusForbidden, challenge: `Bearer error="insufficient_scope", scope="post"`},
+		{name: "Token With No Scopes", middleware: ScopeMiddleware("read"), user: tokenUser("user"), status: http.StatusForbidden, challenge: `Bearer error="insufficient_scope", scope="read"`},
+		{name: "Session Page With Session", middleware: SessionMiddleware(), user: session, status: http.StatusOK},
+		{name: "Session Page With Token", middleware: SessionMiddleware(), user: tokenUser("user", "read", "post", "moderate"), status: http.StatusForbidden},
+		{name: "Session Page Without User", middleware: SessionMiddleware(), status: http.StatusUnauthorized},
+		{name: "Admin Session", middleware: PermissionMiddleware(l, nil, "admin"), user: adminSession, status: http.StatusOK},
+		{name: "Admin Token With Moderate", middleware: PermissionMiddleware(l, nil, "admin"), user: tokenUser("admin", "read", "moderate"), status: http.StatusOK},
+		{name: "Admin Token Without Moderate", middleware: PermissionMiddleware(l, nil, "admin"), user: tokenUser("admin", "read", "post"), status: http.StatusForbidden},
+		{name: "User Token With Moderate", middleware: PermissionMiddleware(l, nil, "admin"), user: tokenUser("user", "moderate"), status: http.StatusForbidden},
+		{name: "User Session On Admin Page", middleware: PermissionMiddleware(l, nil, "admin"), user: session, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			called := false
+			handler := tt.middleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/posts", nil)
+			if tt.user != nil {
+				r = r.WithContext(context.WithValue(r.Context(), "user", tt.user))
+			}
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, r)
+
+			if rw.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rw.Code)
+			}
+			if called != (tt.status == http.StatusOK) {
+				t.Errorf("expected the handler to run %v, got %v", tt.status == http.StatusOK, called)
+			}
+			if got := rw.Header().Get("WWW-Authenticate"); got != tt.challenge {
+				t.Errorf("expected challenge %q, got %q", tt.challenge, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+package middleware
+
+import "go.opentelemetry.io/otel"
+
+var tracer = otel.Tracer("simple-forum/internal/middleware")
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Attachment is a file uploaded with a post. Its content is kept as a blob
+// named after its SHA-256, so identical files attached to several posts
+// are stored once.
+type Attachment struct {
+	ID int `json:"id"`
+	// PostID is 0 once the post is deleted, until the attachment is
+	// cleaned up
+	PostID int `json:"post_id"`
+	UserID int `json:"user_id"`
+	// Filename is the name the file was uploaded with, without directories
+	Filename string `json:"filename"`
+	// ContentType is told by the content, not the name
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Pending is true from when the file is stored until its post is
+	// created
+	Pending bool `json:"-"`
+}
+
+// Path is where the attachment is downloaded from. The name is only there
+// for browsers and download tools to save the file under.
+func (a *Attachment) Path() string {
+	return fmt.Sprintf("/attachments/%d/%s", a.ID, url.PathEscape(a.Filename))
+}
+
+// IsImage reports whether the attachment is a picture, shown in the post
+// rather than only linked.
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// HumanSize is the size in the largest unit that keeps it at least 1.
+func (a *Attachment) HumanSize() string {
+	switch {
+	case a.Size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(a.Size)/(1<<20))
+	case a.Size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(a.Size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", a.Size)
+	}
+}
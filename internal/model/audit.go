@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// AuditEntry records one privileged action. Before and After hold JSON
+// snapshots of the target and are empty when there is nothing to show.
+type AuditEntry struct {
+	ID         int64
+	CreatedAt  time.Time
+	ActorID    int
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetID   int
+	Before     string
+	After      string
+	IP         string
+	RequestID  string
+}
+
+// AuditFilter selects audit entries; zero fields match everything.
+type AuditFilter struct {
+	Action     string
+	Actor      string
+	TargetType string
+	TargetID   int
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+type Identity struct {
+	ID        int
+	UserID    int
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+// ExternalIdentity is what an identity provider asserts about a user
+// after a successful sign-in.
+type ExternalIdentity struct {
+	Provider          string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Groups            []string
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// Mention records that a post mentioned a user as @username. It stays when
+// the mention is edited out, so that the user is notified once per post.
+type Mention struct {
+	PostID    int
+	UserID    int
+	UserName  string
+	CreatedAt time.Time
+}
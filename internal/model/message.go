@@ -0,0 +1,77 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Conversation is a private exchange of messages between two or more
+// users. Nobody outside its participants sees it.
+type Conversation struct {
+	ID            int
+	Subject       string
+	CreatedAt     time.Time
+	LastMessageAt time.Time
+	Participants  []*Participant
+
+	// Unread, LastReadID and Muted are those of the participant the
+	// conversation was fetched for
+	Unread     int
+	LastReadID int
+	Muted      bool
+}
+
+// Path is the address of the conversation page.
+func (c *Conversation) Path() string {
+	return fmt.Sprintf("/user/messages/%d", c.ID)
+}
+
+// Participant is a user taking part in a conversation.
+type Participant struct {
+	ConversationID int
+	UserID         int
+	UserName       string
+	// LastReadID is the newest message the participant has seen, 0 before
+	// they opened the conversation
+	LastReadID int
+	// Muted conversations are left out of the unread count next to the
+	// Messages link
+	Muted bool
+	// LeftAt is when the participant left; the conversation is gone for
+	// them from then on
+	LeftAt   *time.Time
+	JoinedAt time.Time
+}
+
+// Active reports whether the participant has not left the conversation.
+func (p *Participant) Active() bool {
+	return p.LeftAt == nil
+}
+
+type Message struct {
+	ID             int
+	ConversationID int
+	UserID         int
+	UserName       string
+	Body           string
+	CreatedAt      time.Time
+}
+
+// UserBlock keeps BlockedUserID from messaging UserID.
+type UserBlock struct {
+	UserID          int
+	BlockedUserID   int
+	BlockedUserName string
+	CreatedAt       time.Time
+}
+
+// MessagingBan keeps a user from sending private messages, set by an
+// admin. They can still read the conversations they are in.
+type MessagingBan struct {
+	UserID   int
+	UserName string
+	// BannedBy is the admin who set the ban, 0 once their account is gone
+	BannedBy  int
+	Reason    string
+	CreatedAt time.Time
+}
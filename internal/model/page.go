@@ -12,4 +12,14 @@ type Page struct {
 	IsAuthor        bool
 	IsAdmin         bool
 	CSRFToken       string
+	// Title, Description and Canonical describe the page to search engines
+	// and link previews. Canonical is the path the page is best known by;
+	// the request path is used when it is empty.
+	Title       string
+	Description string
+	Canonical   string
+	// OGType is the Open Graph type, website unless set
+	OGType string
+	// NoIndex keeps the page out of search engines
+	NoIndex bool
 }
@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"simple-forum/internal/slug"
+	"time"
+)
 
 type Post struct {
 	ID         int
@@ -12,3 +15,9 @@ type Post struct {
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 }
+
+// Path is the canonical address of the post page within topic, with the
+// slugs of both.
+func (p *Post) Path(topic *Topic) string {
+	return topic.Path() + "/posts/" + slug.WithID(p.ID, p.Title)
+}
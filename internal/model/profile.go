@@ -0,0 +1,42 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// AvatarSizes are the square sizes in pixels an avatar is stored in.
+var AvatarSizes = []int{32, 64, 128, 256}
+
+// Profile is what a user tells about themselves on their public page. A
+// user who has not filled it in has an empty one.
+type Profile struct {
+	UserID      int    `json:"user_id"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Location    string `json:"location"`
+	Website     string `json:"website"`
+	// Signature is shown below the user's posts
+	Signature string `json:"signature"`
+	// ShowEmail shows the email on the profile, which is hidden unless the
+	// user chooses otherwise
+	ShowEmail bool `json:"show_email"`
+	// Avatar identifies the uploaded avatar, empty if the user has none
+	Avatar    string    `json:"avatar"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AvatarFile is the name of the uploaded avatar of the given size, which
+// changes with every upload.
+func (p *Profile) AvatarFile(size int) string {
+	return fmt.Sprintf("%d-%s-%d.jpg", p.UserID, p.Avatar, size)
+}
+
+// AvatarPath is the address of the avatar of the given size, or of the
+// user's identicon if they have not uploaded one.
+func (p *Profile) AvatarPath(size int) string {
+	if p.Avatar == "" {
+		return fmt.Sprintf("/avatars/identicons/%d-%d.png", p.UserID, size)
+	}
+	return "/avatars/" + p.AvatarFile(size)
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// LoginThrottle counts recent failed logins for one account ("account:" key
+// prefix) or one client address ("ip:" key prefix).
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+func (t *LoginThrottle) Locked(now time.Time) bool {
+	return t.LockedUntil != nil && now.Before(*t.LockedUntil)
+}
+
+// Kind returns "account" or "ip".
+func (t *LoginThrottle) Kind() string {
+	kind, _, _ := strings.Cut(t.Key, ":")
+	return kind
+}
+
+// Subject returns the email or address the throttle applies to.
+func (t *LoginThrottle) Subject() string {
+	_, subject, _ := strings.Cut(t.Key, ":")
+	return subject
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// AccessToken is a personal access token. Only a hash of the token value
+// is stored, the value itself is shown to the user once.
+type AccessToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	Hash       string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+func (t *AccessToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+}
+
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"simple-forum/internal/slug"
+	"time"
+)
 
 type Topic struct {
 	ID          int
@@ -8,4 +11,13 @@ type Topic struct {
 	Description string
 	CreatedAt   time.Time
 	AuthorId    int
+	// Private topics are shown to signed-in users only and left out of
+	// feeds
+	Private bool
+}
+
+// Path is the canonical address of the topic page, with the slug of its
+// name.
+func (t *Topic) Path() string {
+	return "/topics/" + slug.WithID(t.ID, t.Name)
 }
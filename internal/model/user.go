@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"net/url"
+	"time"
+)
 
 type User struct {
 	ID           int       `json:"id"`
@@ -10,3 +13,8 @@ type User struct {
 	CreatedAt    time.Time `json:"created_at"`
 	Role         string    `json:"role"`
 }
+
+// Path is the address of the user's public profile.
+func (u *User) Path() string {
+	return "/users/" + url.PathEscape(u.Name)
+}
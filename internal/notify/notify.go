@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"simple-forum/internal/model"
+	"strings"
+)
+
+// LogNotifier writes notifications to the log instead of delivering them,
+// for development and deployments without mail.
+type LogNotifier struct {
+	l *slog.Logger
+}
+
+func NewLogNotifier(l *slog.Logger) *LogNotifier {
+	return &LogNotifier{l: l}
+}
+
+func (n *LogNotifier) Notify(user *model.User, subject, body string) error {
+	n.l.Info("Notification", "user_id", user.ID, "email", user.Email, "subject", subject, "body", body)
+	return nil
+}
+
+// SMTPNotifier sends notifications as plain text email.
+type SMTPNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPNotifier creates an SMTPNotifier for addr (host:port). Without a
+// username the server is used unauthenticated.
+func NewSMTPNotifier(addr, username, password, from string) *SMTPNotifier {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPNotifier{addr: addr, from: from, auth: auth}
+}
+
+func (n *SMTPNotifier) Notify(user *model.User, subject, body string) error {
+	if strings.ContainsAny(user.Email, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid notification header")
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		n.from, user.Email, subject, body)
+
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{user.Email}, []byte(msg))
+}
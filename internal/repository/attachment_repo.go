@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"simple-forum/internal/model"
+	"time"
+)
+
+type AttachmentRepository struct {
+	conn    *sql.DB
+	timeout time.Duration
+}
+
+func NewAttachmentRepository(conn *sql.DB, timeout time.Duration) *AttachmentRepository {
+	return &AttachmentRepository{conn: conn, timeout: timeout}
+}
+
+const attachmentColumns = `id, post_id, user_id, filename, content_type, size, sha256, created_at, pending`
+
+func scanAttachment(row interface{ Scan(dest ...any) error }) (*model.Attachment, error) {
+	attachment := new(model.Attachment)
+
+	var postID sql.NullInt64
+
+	err := row.Scan(
+		&attachment.ID,
+		&postID,
+		&attachment.UserID,
+		&attachment.Filename,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.SHA256,
+		&attachment.CreatedAt,
+		&attachment.Pending,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment.PostID = int(postID.Int64)
+
+	return attachment, nil
+}
+
+func (a *AttachmentRepository) queryAttachments(ctx context.Context, query string, args ...any) ([]*model.Attachment, error) {
+	rows, err := executor(ctx, a.conn).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []*model.Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+// GetAttachmentByID returns the attachment or sql.ErrNoRows.
+func (a *AttachmentRepository) GetAttachmentByID(ctx context.Context, id int) (*model.Attachment, error) {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE id = $1`
+
+	return scanAttachment(executor(ctx, a.conn).QueryRowContext(ctx, query, id))
+}
+
+// GetAttachmentsByPostID returns the attachments of the post in the order
+// they were added.
+func (a *AttachmentRepository) GetAttachmentsByPostID(ctx context.Context, postID int) ([]*model.Attachment, error) {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE post_id = $1 ORDER BY id`
+
+	return a.queryAttachments(ctx, query, postID)
+}
+
+// GetDetachedAttachments returns up to limit attachments whose post was
+// deleted, and those still pending since before pendingBefore, oldest
+// first.
+func (a *AttachmentRepository) GetDetachedAttachments(ctx context.Context, pendingBefore time.Time, limit int) ([]*model.Attachment, error) {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachments
+		WHERE post_id IS NULL AND (NOT pending OR created_at < $1) ORDER BY id LIMIT $2`
+
+	return a.queryAttachments(ctx, query, pendingBefore, limit)
+}
+
+// SumAttachmentSizesByUserID returns the bytes the user has uploaded, as
+// many times as they uploaded them.
+func (a *AttachmentRepository) SumAttachmentSizesByUserID(ctx context.Context, userID int) (int64, error) {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	query := `SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = $1`
+
+	var sum int64
+	err := executor(ctx, a.conn).QueryRowContext(ctx, query, userID).Scan(&sum)
+	if err != nil {
+		return 0, err
+	}
+	return sum, nil
+}
+
+func (a *AttachmentRepository) InsertAttachment(ctx context.Context, attachment *model.Attachment) (int, error) {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	query := `INSERT INTO attachments (post_id, user_id, filename, content_type, size, sha256, created_at, pending)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+
+	err := executor(ctx, a.conn).QueryRowContext(ctx, query,
+		nullInt(attachment.PostID),
+		attachment.UserID,
+		attachment.Filename,
+		attachment.ContentType,
+		attachment.Size,
+		attachment.SHA256,
+		attachment.CreatedAt,
+		attachment.Pending).Scan(&attachment.ID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return attachment.ID, nil
+}
+
+// DeleteAttachment removes the attachment, leaving its blob to the caller.
+func (a *AttachmentRepository) DeleteAttachment(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	query := `DELETE FROM attachments WHERE id = $1`
+
+	_, err := executor(ctx, a.conn).ExecContext(ctx, query, id)
+
+	return err
+}
+
+// AttachAttachment gives the pending attachment its post, or returns
+// sql.ErrNoRows if it is no longer pending.
+func (a *AttachmentRepository) AttachAttachment(ctx context.Context, id, postID int) error {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	query := `UPDATE attachments SET post_id = $2, pending = FALSE WHERE id = $1 AND pending`
+
+	result, err := executor(ctx, a.conn).ExecContext(ctx, query, id, postID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SaveAttachmentBlob records that the blob with the given hash is stored,
+// or touches its row if it is, so that a concurrent
+// DeleteUnusedAttachmentBlob waits for the transaction or fails.
+func (a *AttachmentRepository) SaveAttachmentBlob(ctx context.Context, sum string) error {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	query := `INSERT INTO attachment_blobs (sha256) VALUES ($1)
+		ON CONFLICT (sha256) DO UPDATE SET sha256 = EXCLUDED.sha256`
+
+	_, err := executor(ctx, a.conn).ExecContext(ctx, query, sum)
+
+	return err
+}
+
+// DeleteUnusedAttachmentBlob removes the row of the blob with the given
+// hash unless an attachment still has it, and reports whether it did. The
+// blob itself is left to the caller.
+func (a *AttachmentRepository) DeleteUnusedAttachmentBlob(ctx context.Context, sum string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	query := `DELETE FROM attachment_blobs
+		WHERE sha256 = $1 AND NOT EXISTS (SELECT 1 FROM attachments WHERE sha256 = $1)`
+
+	result, err := executor(ctx, a.conn).ExecContext(ctx, query, sum)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"simple-forum/internal/database"
+	"simple-forum/internal/model"
+	"strings"
+	"time"
+)
+
+type AuditRepository struct {
+	conn     *sql.DB
+	replicas *database.ReplicaSet
+	timeout  time.Duration
+}
+
+func NewAuditRepository(conn *sql.DB, timeout time.Duration) *AuditRepository {
+	return &AuditRepository{conn: conn, timeout: timeout}
+}
+
+// WithReplicas sends the repository's read-only queries to replicas.
+func (a *AuditRepository) WithReplicas(replicas *database.ReplicaSet) *AuditRepository {
+	a.replicas = replicas
+	return a
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+func (a *AuditRepository) InsertEntry(ctx context.Context, entry *model.AuditEntry) (int64, error) {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	query := `INSERT INTO audit_log (created_at, actor_id, actor_name, action, target_type, target_id, before, after, ip, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+
+	err := executor(ctx, a.conn).QueryRowContext(ctx, query,
+		entry.CreatedAt,
+		nullInt(entry.ActorID),
+		entry.ActorName,
+		entry.Action,
+		entry.TargetType,
+		nullInt(entry.TargetID),
+		nullString(entry.Before),
+		nullString(entry.After),
+		entry.IP,
+		entry.RequestID,
+	).Scan(&entry.ID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return entry.ID, nil
+}
+
+// GetEntries returns the entries matching filter, newest first.
+func (a *AuditRepository) GetEntries(ctx context.Context, filter model.AuditFilter) ([]*model.AuditEntry, error) {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	var conditions []string
+	var args []any
+
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if filter.Actor != "" {
+		where("actor_name = $%d", filter.Actor)
+	}
+	if filter.TargetType != "" {
+		where("target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		where("target_id = $%d", filter.TargetID)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("created_at < $%d", filter.To)
+	}
+
+	query := `SELECT id, created_at, actor_id, actor_name, action, target_type, target_id, before, after, ip, request_id FROM audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit, filter.Offset)
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+
+	rows, err := reader(ctx, a.conn, a.replicas).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*model.AuditEntry
+	for rows.Next() {
+		entry := new(model.AuditEntry)
+
+		var actorID, targetID sql.NullInt64
+		var before, after sql.NullString
+
+		err = rows.Scan(
+			&entry.ID,
+			&entry.CreatedAt,
+			&actorID,
+			&entry.ActorName,
+			&entry.Action,
+			&entry.TargetType,
+			&targetID,
+			&before,
+			&after,
+			&entry.IP,
+			&entry.RequestID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.ActorID = int(actorID.Int64)
+		entry.TargetID = int(targetID.Int64)
+		entry.Before = before.String
+		entry.After = after.String
+
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simple-forum/internal/config"
+	"simple-forum/internal/database"
+	"simple-forum/internal/model"
+	"testing"
+	"time"
+)
+
+// BenchmarkTopicPage loads what the topic page shows, a topic and its
+// posts, as two queries with and without the statement cache, and as one
+// batch. StdlibShim is the baseline, the database/sql pool of the pgx
+// stdlib driver the repositories used before pgxpool. Like TestConformance
+// it runs against SQLite in a temporary file, which has none of these, and
+// against PostgreSQL when TEST_DB_ADDR is set.
+func BenchmarkTopicPage(b *testing.B) {
+	postgres := os.Getenv("TEST_DB_ADDR")
+	sqlite := "sqlite://" + filepath.Join(b.TempDir(), "forum.db")
+
+	benchmarks := []struct {
+		name   string
+		driver string
+		addr   string
+		cache  int
+		batch  bool
+		shim   bool
+	}{
+		{name: "PostgreSQL/StdlibShim", driver: config.DriverPostgres, addr: postgres, shim: true},
+		{name: "PostgreSQL/Sequential/NoStatementCache", driver: config.DriverPostgres, addr: postgres, cache: 0},
+		{name: "PostgreSQL/Sequential/StatementCache", driver: config.DriverPostgres, addr: postgres, cache: 512},
+		{name: "PostgreSQL/Batch/StatementCache", driver: config.DriverPostgres, addr: postgres, cache: 512, batch: true},
+		{name: "SQLite/Sequential", driver: config.DriverSQLite, addr: sqlite},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			if bm.addr == "" {
+				b.Skip("TEST_DB_ADDR is not set")
+			}
+
+			conn, pool := connect(b, bm.driver, bm.addr, database.PoolConfig{MaxConns: 4, StatementCache: bm.cache})
+			if bm.shim {
+				conn = openShim(b, bm.addr)
+			}
+
+			topics := NewTopicRepository(conn, 5*time.Second)
+			posts := NewPostRepository(conn, 5*time.Second)
+			if bm.batch {
+				topics = topics.WithPool(pool)
+			}
+
+			ctx := context.Background()
+			topicID := seedTopic(ctx, b, NewUserRepository(conn, 5*time.Second), topics, posts, 20)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if bm.batch {
+					_, _, err := topics.GetTopicWithPosts(ctx, topicID)
+					if err != nil {
+						b.Fatal(err)
+					}
+					continue
+				}
+
+				_, err := topics.GetTopicByID(ctx, topicID)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = posts.GetPostsByTopicID(ctx, topicID)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// openShim opens addr the way database.Connect did before pgxpool, through
+// the pgx stdlib driver with the default pool of database/sql.
+func openShim(b *testing.B, addr string) *sql.DB {
+	b.Helper()
+
+	conn, err := sql.Open("pgx", addr)
+	if err != nil {
+		b.Fatalf("unable to open: %v", err)
+	}
+	b.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func seedTopic(ctx context.Context, b *testing.B, users *UserRepository, topics *TopicRepository, posts *PostRepository, n int) int {
+	b.Helper()
+
+	now := time.Now()
+	author := &model.User{
+		Name:         fmt.Sprintf("bench%d", now.UnixNano()),
+		PasswordHash: "hash",
+		CreatedAt:    now,
+		Role:         "user",
+	}
+	author.Email = author.Name + "@example.com"
+
+	_, err := users.InsertUser(ctx, author)
+	if err != nil {
+		b.Fatalf("unable to insert user: %v", err)
+	}
+
+	topic := &model.Topic{Name: author.Name, Description: "description", CreatedAt: now, AuthorId: author.ID}
+	_, err = topics.InsertTopic(ctx, topic)
+	if err != nil {
+		b.Fatalf("unable to insert topic: %v", err)
+	}
+
+	for i := 0; i < n; i++ {
+		post := &model.Post{
+			Title:      fmt.Sprintf("post %d", i),
+			Content:    "content",
+			AuthorId:   author.ID,
+			AuthorName: author.Name,
+			TopicId:    topic.ID,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		_, err = posts.InsertPost(ctx, post)
+		if err != nil {
+			b.Fatalf("unable to insert post: %v", err)
+		}
+	}
+
+	return topic.ID
+}
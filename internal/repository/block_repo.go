@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"simple-forum/internal/model"
+	"time"
+)
+
+type BlockRepository struct {
+	conn    *sql.DB
+	timeout time.Duration
+}
+
+func NewBlockRepository(conn *sql.DB, timeout time.Duration) *BlockRepository {
+	return &BlockRepository{conn: conn, timeout: timeout}
+}
+
+// InsertBlock blocks block.BlockedUserID for block.UserID; blocking a user
+// twice keeps the first block.
+func (b *BlockRepository) InsertBlock(ctx context.Context, block *model.UserBlock) error {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	query := `INSERT INTO user_blocks (user_id, blocked_user_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, blocked_user_id) DO NOTHING`
+
+	_, err := executor(ctx, b.conn).ExecContext(ctx, query, block.UserID, block.BlockedUserID, block.CreatedAt)
+
+	return err
+}
+
+func (b *BlockRepository) DeleteBlock(ctx context.Context, userID, blockedUserID int) error {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	query := `DELETE FROM user_blocks WHERE user_id = $1 AND blocked_user_id = $2`
+
+	_, err := executor(ctx, b.conn).ExecContext(ctx, query, userID, blockedUserID)
+
+	return err
+}
+
+// GetBlocksByUserID returns the users the user has blocked, by name.
+func (b *BlockRepository) GetBlocksByUserID(ctx context.Context, userID int) ([]*model.UserBlock, error) {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	query := `SELECT b.user_id, b.blocked_user_id, u.username, b.created_at
+		FROM user_blocks b
+		JOIN users u ON u.id = b.blocked_user_id
+		WHERE b.user_id = $1
+		ORDER BY u.username`
+
+	rows, err := executor(ctx, b.conn).QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []*model.UserBlock
+	for rows.Next() {
+		block := new(model.UserBlock)
+		err = rows.Scan(&block.UserID, &block.BlockedUserID, &block.BlockedUserName, &block.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+// GetBlockerIDs returns the users who have blocked the user.
+func (b *BlockRepository) GetBlockerIDs(ctx context.Context, blockedUserID int) ([]int, error) {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	query := `SELECT user_id FROM user_blocks WHERE blocked_user_id = $1 ORDER BY user_id`
+
+	rows, err := executor(ctx, b.conn).QueryContext(ctx, query, blockedUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SaveMessagingBan bans ban.UserID from messaging, or replaces the reason
+// of the ban they have.
+func (b *BlockRepository) SaveMessagingBan(ctx context.Context, ban *model.MessagingBan) error {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	query := `INSERT INTO messaging_bans (user_id, banned_by, reason, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET
+			banned_by = excluded.banned_by,
+			reason = excluded.reason,
+			created_at = excluded.created_at`
+
+	var bannedBy sql.NullInt64
+	if ban.BannedBy != 0 {
+		bannedBy = sql.NullInt64{Int64: int64(ban.BannedBy), Valid: true}
+	}
+
+	_, err := executor(ctx, b.conn).ExecContext(ctx, query, ban.UserID, bannedBy, ban.Reason, ban.CreatedAt)
+
+	return err
+}
+
+func (b *BlockRepository) DeleteMessagingBan(ctx context.Context, userID int) error {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	query := `DELETE FROM messaging_bans WHERE user_id = $1`
+
+	_, err := executor(ctx, b.conn).ExecContext(ctx, query, userID)
+
+	return err
+}
+
+const messagingBanColumns = `b.user_id, u.username, b.banned_by, b.reason, b.created_at`
+
+func scanMessagingBan(row interface{ Scan(dest ...any) error }) (*model.MessagingBan, error) {
+	ban := new(model.MessagingBan)
+
+	var bannedBy sql.NullInt64
+
+	err := row.Scan(&ban.UserID, &ban.UserName, &bannedBy, &ban.Reason, &ban.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	ban.BannedBy = int(bannedBy.Int64)
+
+	return ban, nil
+}
+
+// GetMessagingBan returns the ban of the user, or nil if they may message.
+func (b *BlockRepository) GetMessagingBan(ctx context.Context, userID int) (*model.MessagingBan, error) {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	query := `SELECT ` + messagingBanColumns + ` FROM messaging_bans b
+		JOIN users u ON u.id = b.user_id
+		WHERE b.user_id = $1`
+
+	ban, err := scanMessagingBan(executor(ctx, b.conn).QueryRowContext(ctx, query, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ban, nil
+}
+
+// GetMessagingBans returns every ban, newest first.
+func (b *BlockRepository) GetMessagingBans(ctx context.Context) ([]*model.MessagingBan, error) {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	query := `SELECT ` + messagingBanColumns + ` FROM messaging_bans b
+		JOIN users u ON u.id = b.user_id
+		ORDER BY b.created_at DESC, b.user_id`
+
+	rows, err := executor(ctx, b.conn).QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []*model.MessagingBan
+	for rows.Next() {
+		ban, err := scanMessagingBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
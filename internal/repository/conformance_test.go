@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"simple-forum/internal/config"
+	"simple-forum/internal/database"
+	"simple-forum/internal/repository/storagetest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TestConformance runs the storage checks against SQLite in a temporary
+// file, and against PostgreSQL when TEST_DB_ADDR points at a database the
+// test may migrate and write to.
+func TestConformance(t *testing.T) {
+	backends := []struct {
+		name      string
+		driver    string
+		addr      string
+		precision time.Duration
+	}{
+		{name: "PostgreSQL", driver: config.DriverPostgres, addr: os.Getenv("TEST_DB_ADDR")},
+		{name: "SQLite", driver: config.DriverSQLite, addr: "sqlite://" + filepath.Join(t.TempDir(), "forum.db"), precision: time.Millisecond},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			if backend.addr == "" {
+				t.Skip("TEST_DB_ADDR is not set")
+			}
+
+			conn, pool := connect(t, backend.driver, backend.addr, database.PoolConfig{MaxConns: 4, StatementCache: 512})
+
+			storagetest.Run(t, func(t *testing.T) storagetest.Storages {
+				return storagetest.Storages{
+					Users:       NewUserRepository(conn, 5*time.Second),
+					Identities:  NewIdentityRepository(conn, 5*time.Second),
+					Topics:      NewTopicRepository(conn, 5*time.Second).WithPool(pool),
+					Posts:       NewPostRepository(conn, 5*time.Second),
+					Tokens:      NewTokenRepository(conn, 5*time.Second),
+					Profiles:    NewProfileRepository(conn, 5*time.Second),
+					Attachments: NewAttachmentRepository(conn, 5*time.Second),
+					Mentions:    NewMentionRepository(conn, 5*time.Second),
+					Messages:    NewMessageRepository(conn, 5*time.Second),
+					Blocks:      NewBlockRepository(conn, 5*time.Second),
+					Throttles:   NewThrottleRepository(conn, 5*time.Second),
+					Audit:       NewAuditRepository(conn, 5*time.Second),
+					Tx:          NewTransactor(conn, 3),
+					Precision:   backend.precision,
+				}
+			})
+		})
+	}
+}
+
+func connect(tb testing.TB, driver, addr string, cfg database.PoolConfig) (*sql.DB, *pgxpool.Pool) {
+	tb.Helper()
+
+	err := database.Migrate(driver, addr, "../../migrations")
+	if err != nil {
+		tb.Fatalf("unable to migrate: %v", err)
+	}
+
+	conn, pool, err := database.Connect(driver, addr, cfg)
+	if err != nil {
+		tb.Fatalf("unable to connect: %v", err)
+	}
+	tb.Cleanup(func() {
+		_ = conn.Close()
+		if pool != nil {
+			pool.Close()
+		}
+	})
+
+	return conn, pool
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"simple-forum/internal/model"
+	"time"
+)
+
+type IdentityRepository struct {
+	conn    *sql.DB
+	timeout time.Duration
+}
+
+func NewIdentityRepository(conn *sql.DB, timeout time.Duration) *IdentityRepository {
+	return &IdentityRepository{conn: conn, timeout: timeout}
+}
+
+func (i *IdentityRepository) GetIdentity(ctx context.Context, provider, subject string) (*model.Identity, error) {
+	ctx, cancel := withTimeout(ctx, i.timeout)
+	defer cancel()
+
+	query := `SELECT id, user_id, provider, subject, email, created_at FROM user_identities WHERE provider = $1 AND subject = $2`
+
+	identity := new(model.Identity)
+
+	err := executor(ctx, i.conn).QueryRowContext(ctx, query, provider, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+func (i *IdentityRepository) InsertIdentity(ctx context.Context, identity *model.Identity) (int, error) {
+	ctx, cancel := withTimeout(ctx, i.timeout)
+	defer cancel()
+
+	query := `INSERT INTO user_identities (user_id, provider, subject, email, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	err := executor(ctx, i.conn).QueryRowContext(ctx, query,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+		identity.CreatedAt).Scan(&identity.ID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return identity.ID, nil
+}
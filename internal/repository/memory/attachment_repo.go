@@ -0,0 +1,181 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"simple-forum/internal/model"
+	"slices"
+	"time"
+)
+
+type AttachmentRepository struct {
+	store *Store
+}
+
+func NewAttachmentRepository(store *Store) *AttachmentRepository {
+	return &AttachmentRepository{store: store}
+}
+
+func (a *AttachmentRepository) GetAttachmentByID(ctx context.Context, id int) (*model.Attachment, error) {
+	unlock, err := a.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	attachment, ok := a.store.attachments[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &attachment, nil
+}
+
+func (a *AttachmentRepository) GetAttachmentsByPostID(ctx context.Context, postID int) ([]*model.Attachment, error) {
+	return a.filter(ctx, func(attachment *model.Attachment) bool {
+		return attachment.PostID == postID
+	}, -1)
+}
+
+func (a *AttachmentRepository) GetDetachedAttachments(ctx context.Context, pendingBefore time.Time, limit int) ([]*model.Attachment, error) {
+	return a.filter(ctx, func(attachment *model.Attachment) bool {
+		return attachment.PostID == 0 && (!attachment.Pending || attachment.CreatedAt.Before(pendingBefore))
+	}, limit)
+}
+
+// filter returns up to limit attachments keep is true for, or all of them
+// if limit is negative, by id.
+func (a *AttachmentRepository) filter(ctx context.Context, keep func(*model.Attachment) bool, limit int) ([]*model.Attachment, error) {
+	unlock, err := a.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var attachments []*model.Attachment
+	for _, attachment := range a.store.attachments {
+		if keep(&attachment) {
+			attachments = append(attachments, &attachment)
+		}
+	}
+
+	slices.SortFunc(attachments, func(x, y *model.Attachment) int {
+		return cmp.Compare(x.ID, y.ID)
+	})
+	if limit >= 0 && len(attachments) > limit {
+		attachments = attachments[:limit]
+	}
+
+	return attachments, nil
+}
+
+func (a *AttachmentRepository) SumAttachmentSizesByUserID(ctx context.Context, userID int) (int64, error) {
+	unlock, err := a.store.read(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var sum int64
+	for _, attachment := range a.store.attachments {
+		if attachment.UserID == userID {
+			sum += attachment.Size
+		}
+	}
+	return sum, nil
+}
+
+func (a *AttachmentRepository) InsertAttachment(ctx context.Context, attachment *model.Attachment) (int, error) {
+	unlock, err := a.store.write(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if _, ok := a.store.posts[attachment.PostID]; !ok && attachment.PostID != 0 {
+		return 0, fmt.Errorf("%w: key (post_id)=(%d) is not present in table \"posts\"", ErrForeignKeyViolation, attachment.PostID)
+	}
+	if err = a.store.userExists(attachment.UserID); err != nil {
+		return 0, err
+	}
+	if attachment.Size < 0 {
+		return 0, fmt.Errorf("%w \"attachments_size_check\"", ErrCheckViolation)
+	}
+
+	a.store.seq.attachments++
+	attachment.ID = a.store.seq.attachments
+
+	stored := *attachment
+	stored.CreatedAt = timestamp(attachment.CreatedAt)
+	a.store.attachments[attachment.ID] = stored
+
+	return attachment.ID, nil
+}
+
+func (a *AttachmentRepository) DeleteAttachment(ctx context.Context, id int) error {
+	unlock, err := a.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delete(a.store.attachments, id)
+
+	return nil
+}
+
+func (a *AttachmentRepository) AttachAttachment(ctx context.Context, id, postID int) error {
+	unlock, err := a.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	attachment, ok := a.store.attachments[id]
+	if !ok || !attachment.Pending {
+		return sql.ErrNoRows
+	}
+	if _, ok = a.store.posts[postID]; !ok {
+		return fmt.Errorf("%w: key (post_id)=(%d) is not present in table \"posts\"", ErrForeignKeyViolation, postID)
+	}
+
+	attachment.PostID = postID
+	attachment.Pending = false
+	a.store.attachments[id] = attachment
+
+	return nil
+}
+
+func (a *AttachmentRepository) SaveAttachmentBlob(ctx context.Context, sum string) error {
+	unlock, err := a.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	a.store.blobs[sum] = struct{}{}
+
+	return nil
+}
+
+func (a *AttachmentRepository) DeleteUnusedAttachmentBlob(ctx context.Context, sum string) (bool, error) {
+	unlock, err := a.store.write(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if _, ok := a.store.blobs[sum]; !ok {
+		return false, nil
+	}
+	for _, attachment := range a.store.attachments {
+		if attachment.SHA256 == sum {
+			return false, nil
+		}
+	}
+
+	delete(a.store.blobs, sum)
+
+	return true, nil
+}
@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"simple-forum/internal/model"
+)
+
+// AuditRepository keeps the audit log append-only: entries can be added
+// and read, never changed.
+type AuditRepository struct {
+	store *Store
+}
+
+func NewAuditRepository(store *Store) *AuditRepository {
+	return &AuditRepository{store: store}
+}
+
+func (a *AuditRepository) InsertEntry(ctx context.Context, entry *model.AuditEntry) (int64, error) {
+	unlock, err := a.store.write(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	a.store.seq.audit++
+	entry.ID = a.store.seq.audit
+
+	stored := *entry
+	stored.CreatedAt = timestamp(entry.CreatedAt)
+	a.store.audit = append(a.store.audit, stored)
+
+	return entry.ID, nil
+}
+
+// GetEntries returns the entries matching filter, newest first.
+func (a *AuditRepository) GetEntries(ctx context.Context, filter model.AuditFilter) ([]*model.AuditEntry, error) {
+	unlock, err := a.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var entries []*model.AuditEntry
+	skipped := 0
+	for i := len(a.store.audit) - 1; i >= 0; i-- {
+		entry := a.store.audit[i]
+		if !matches(&entry, filter) {
+			continue
+		}
+		if filter.Limit > 0 {
+			if skipped < filter.Offset {
+				skipped++
+				continue
+			}
+			if len(entries) == filter.Limit {
+				break
+			}
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+func matches(entry *model.AuditEntry, filter model.AuditFilter) bool {
+	switch {
+	case filter.Action != "" && entry.Action != filter.Action:
+		return false
+	case filter.Actor != "" && entry.ActorName != filter.Actor:
+		return false
+	case filter.TargetType != "" && entry.TargetType != filter.TargetType:
+		return false
+	case filter.TargetID != 0 && entry.TargetID != filter.TargetID:
+		return false
+	case !filter.From.IsZero() && entry.CreatedAt.Before(filter.From):
+		return false
+	case !filter.To.IsZero() && !entry.CreatedAt.Before(filter.To):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"simple-forum/internal/model"
+	"slices"
+)
+
+type BlockRepository struct {
+	store *Store
+}
+
+func NewBlockRepository(store *Store) *BlockRepository {
+	return &BlockRepository{store: store}
+}
+
+func (b *BlockRepository) InsertBlock(ctx context.Context, block *model.UserBlock) error {
+	unlock, err := b.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = b.store.userExists(block.UserID); err != nil {
+		return err
+	}
+	if err = b.store.userExists(block.BlockedUserID); err != nil {
+		return err
+	}
+	if block.UserID == block.BlockedUserID {
+		return fmt.Errorf("%w \"user_blocks_check\"", ErrCheckViolation)
+	}
+
+	key := blockKey{block.UserID, block.BlockedUserID}
+	if _, ok := b.store.blocks[key]; ok {
+		return nil
+	}
+
+	b.store.blocks[key] = model.UserBlock{
+		UserID:        block.UserID,
+		BlockedUserID: block.BlockedUserID,
+		CreatedAt:     timestamp(block.CreatedAt),
+	}
+
+	return nil
+}
+
+func (b *BlockRepository) DeleteBlock(ctx context.Context, userID, blockedUserID int) error {
+	unlock, err := b.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delete(b.store.blocks, blockKey{userID, blockedUserID})
+
+	return nil
+}
+
+func (b *BlockRepository) GetBlocksByUserID(ctx context.Context, userID int) ([]*model.UserBlock, error) {
+	unlock, err := b.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var blocks []*model.UserBlock
+	for key, block := range b.store.blocks {
+		if key.userID == userID {
+			block.BlockedUserName = b.store.users[block.BlockedUserID].Name
+			blocks = append(blocks, &block)
+		}
+	}
+
+	slices.SortFunc(blocks, func(x, y *model.UserBlock) int {
+		return cmp.Compare(x.BlockedUserName, y.BlockedUserName)
+	})
+
+	return blocks, nil
+}
+
+func (b *BlockRepository) GetBlockerIDs(ctx context.Context, blockedUserID int) ([]int, error) {
+	unlock, err := b.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var ids []int
+	for key := range b.store.blocks {
+		if key.blockedUserID == blockedUserID {
+			ids = append(ids, key.userID)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+func (b *BlockRepository) SaveMessagingBan(ctx context.Context, ban *model.MessagingBan) error {
+	unlock, err := b.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = b.store.userExists(ban.UserID); err != nil {
+		return err
+	}
+	if ban.BannedBy != 0 {
+		if err = b.store.userExists(ban.BannedBy); err != nil {
+			return err
+		}
+	}
+
+	b.store.bans[ban.UserID] = model.MessagingBan{
+		UserID:    ban.UserID,
+		BannedBy:  ban.BannedBy,
+		Reason:    ban.Reason,
+		CreatedAt: timestamp(ban.CreatedAt),
+	}
+
+	return nil
+}
+
+func (b *BlockRepository) DeleteMessagingBan(ctx context.Context, userID int) error {
+	unlock, err := b.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delete(b.store.bans, userID)
+
+	return nil
+}
+
+func (b *BlockRepository) GetMessagingBan(ctx context.Context, userID int) (*model.MessagingBan, error) {
+	unlock, err := b.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ban, ok := b.store.bans[userID]
+	if !ok {
+		return nil, nil
+	}
+	ban.UserName = b.store.users[userID].Name
+	return &ban, nil
+}
+
+func (b *BlockRepository) GetMessagingBans(ctx context.Context) ([]*model.MessagingBan, error) {
+	unlock, err := b.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var bans []*model.MessagingBan
+	for _, ban := range b.store.bans {
+		ban.UserName = b.store.users[ban.UserID].Name
+		bans = append(bans, &ban)
+	}
+
+	slices.SortFunc(bans, func(x, y *model.MessagingBan) int {
+		return cmp.Or(y.CreatedAt.Compare(x.CreatedAt), cmp.Compare(x.UserID, y.UserID))
+	})
+
+	return bans, nil
+}
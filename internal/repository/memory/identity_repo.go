@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"simple-forum/internal/model"
+)
+
+type IdentityRepository struct {
+	store *Store
+}
+
+func NewIdentityRepository(store *Store) *IdentityRepository {
+	return &IdentityRepository{store: store}
+}
+
+func (i *IdentityRepository) GetIdentity(ctx context.Context, provider, subject string) (*model.Identity, error) {
+	unlock, err := i.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return i.find(provider, subject), nil
+}
+
+func (i *IdentityRepository) InsertIdentity(ctx context.Context, identity *model.Identity) (int, error) {
+	unlock, err := i.store.write(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err = i.store.userExists(identity.UserID); err != nil {
+		return 0, err
+	}
+	if i.find(identity.Provider, identity.Subject) != nil {
+		return 0, fmt.Errorf("%w \"user_identities_provider_subject_key\"", ErrUniqueViolation)
+	}
+
+	i.store.seq.identities++
+	identity.ID = i.store.seq.identities
+
+	stored := *identity
+	stored.CreatedAt = timestamp(identity.CreatedAt)
+	i.store.identities[identity.ID] = stored
+
+	return identity.ID, nil
+}
+
+func (i *IdentityRepository) find(provider, subject string) *model.Identity {
+	for _, identity := range i.store.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"context"
+	"simple-forum/internal/model"
+	"simple-forum/internal/repository/storagetest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func open(t *testing.T) storagetest.Storages {
+	store := NewStore()
+	return storagetest.Storages{
+		Users:       NewUserRepository(store),
+		Identities:  NewIdentityRepository(store),
+		Topics:      NewTopicRepository(store),
+		Posts:       NewPostRepository(store),
+		Tokens:      NewTokenRepository(store),
+		Profiles:    NewProfileRepository(store),
+		Attachments: NewAttachmentRepository(store),
+		Mentions:    NewMentionRepository(store),
+		Messages:    NewMessageRepository(store),
+		Blocks:      NewBlockRepository(store),
+		Throttles:   NewThrottleRepository(store),
+		Audit:       NewAuditRepository(store),
+		Tx:          NewTransactor(store),
+	}
+}
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	storagetest.Run(t, open)
+}
+
+func TestSeededAdmin(t *testing.T) {
+	t.Parallel()
+
+	admin, err := NewUserRepository(NewStore()).GetUserByUsername(context.Background(), "admin")
+	if err != nil || admin == nil || admin.ID != 1 || admin.Role != "admin" {
+		t.Errorf("expected the admin account with ID 1, got %+v, %v", admin, err)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	store := NewStore()
+	topics := NewTopicRepository(store)
+	tx := NewTransactor(store)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = topics.InsertTopic(context.Background(), &model.Topic{Name: "topic", CreatedAt: time.Now(), AuthorId: 1})
+		}()
+		go func() {
+			defer wg.Done()
+			_ = tx.WithinTx(context.Background(), func(ctx context.Context) error {
+				_, err := topics.GetAllTopics(ctx)
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+
+	all, err := topics.GetAllTopics(context.Background())
+	if err != nil || len(all) != 50 {
+		t.Errorf("expected 50 topics, got %d, %v", len(all), err)
+	}
+	for i, topic := range all {
+		if topic.ID != i+1 {
+			t.Fatalf("expected IDs 1 to 50 without gaps, got %d at %d", topic.ID, i)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"simple-forum/internal/model"
+	"slices"
+)
+
+type MentionRepository struct {
+	store *Store
+}
+
+func NewMentionRepository(store *Store) *MentionRepository {
+	return &MentionRepository{store: store}
+}
+
+func (m *MentionRepository) InsertMention(ctx context.Context, mention *model.Mention) (bool, error) {
+	unlock, err := m.store.write(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if _, ok := m.store.posts[mention.PostID]; !ok {
+		return false, fmt.Errorf("%w: key (post_id)=(%d) is not present in table \"posts\"", ErrForeignKeyViolation, mention.PostID)
+	}
+	if err = m.store.userExists(mention.UserID); err != nil {
+		return false, err
+	}
+
+	key := mentionKey{mention.PostID, mention.UserID}
+	if _, ok := m.store.mentions[key]; ok {
+		return false, nil
+	}
+
+	m.store.mentions[key] = model.Mention{
+		PostID:    mention.PostID,
+		UserID:    mention.UserID,
+		CreatedAt: timestamp(mention.CreatedAt),
+	}
+
+	return true, nil
+}
+
+func (m *MentionRepository) GetMentionsByPostID(ctx context.Context, postID int) ([]*model.Mention, error) {
+	unlock, err := m.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var mentions []*model.Mention
+	for key, mention := range m.store.mentions {
+		if key.postID == postID {
+			mention.UserName = m.store.users[mention.UserID].Name
+			mentions = append(mentions, &mention)
+		}
+	}
+
+	slices.SortFunc(mentions, func(x, y *model.Mention) int {
+		return cmp.Compare(x.UserName, y.UserName)
+	})
+
+	return mentions, nil
+}
@@ -0,0 +1,317 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"simple-forum/internal/model"
+	"slices"
+	"time"
+)
+
+type MessageRepository struct {
+	store *Store
+}
+
+func NewMessageRepository(store *Store) *MessageRepository {
+	return &MessageRepository{store: store}
+}
+
+func (m *MessageRepository) InsertConversation(ctx context.Context, conversation *model.Conversation) (int, error) {
+	unlock, err := m.store.write(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	m.store.seq.conversations++
+	conversation.ID = m.store.seq.conversations
+
+	m.store.conversations[conversation.ID] = model.Conversation{
+		ID:            conversation.ID,
+		Subject:       conversation.Subject,
+		CreatedAt:     timestamp(conversation.CreatedAt),
+		LastMessageAt: timestamp(conversation.LastMessageAt),
+	}
+
+	return conversation.ID, nil
+}
+
+func (m *MessageRepository) GetConversationByID(ctx context.Context, id int) (*model.Conversation, error) {
+	unlock, err := m.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	conversation, ok := m.store.conversations[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &conversation, nil
+}
+
+func (m *MessageRepository) GetConversationsByUserID(ctx context.Context, userID, limit int) ([]*model.Conversation, error) {
+	unlock, err := m.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var conversations []*model.Conversation
+	for key, participant := range m.store.participants {
+		if key.userID != userID || !participant.Active() {
+			continue
+		}
+
+		conversation := m.store.conversations[key.conversationID]
+		conversation.LastReadID = participant.LastReadID
+		conversation.Muted = participant.Muted
+		conversation.Unread = m.store.unread(participant)
+		conversations = append(conversations, &conversation)
+	}
+
+	slices.SortFunc(conversations, func(x, y *model.Conversation) int {
+		return cmp.Or(y.LastMessageAt.Compare(x.LastMessageAt), cmp.Compare(y.ID, x.ID))
+	})
+	if len(conversations) > limit {
+		conversations = conversations[:limit]
+	}
+
+	return conversations, nil
+}
+
+// unread counts the messages of others after the read marker of the
+// participant.
+func (s *Store) unread(participant model.Participant) int {
+	count := 0
+	for _, message := range s.messages {
+		if message.ConversationID == participant.ConversationID &&
+			message.ID > participant.LastReadID && message.UserID != participant.UserID {
+			count++
+		}
+	}
+	return count
+}
+
+func (m *MessageRepository) SetLastMessageAt(ctx context.Context, id int, at time.Time) error {
+	unlock, err := m.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	conversation, ok := m.store.conversations[id]
+	if ok {
+		conversation.LastMessageAt = timestamp(at)
+		m.store.conversations[id] = conversation
+	}
+
+	return nil
+}
+
+// DeleteConversation removes the conversation with its participants and
+// messages, as ON DELETE CASCADE does.
+func (m *MessageRepository) DeleteConversation(ctx context.Context, id int) error {
+	unlock, err := m.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delete(m.store.conversations, id)
+	for key := range m.store.participants {
+		if key.conversationID == id {
+			delete(m.store.participants, key)
+		}
+	}
+	for messageID, message := range m.store.messages {
+		if message.ConversationID == id {
+			delete(m.store.messages, messageID)
+		}
+	}
+
+	return nil
+}
+
+func (m *MessageRepository) InsertParticipant(ctx context.Context, participant *model.Participant) error {
+	unlock, err := m.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = m.store.conversationExists(participant.ConversationID); err != nil {
+		return err
+	}
+	if err = m.store.userExists(participant.UserID); err != nil {
+		return err
+	}
+
+	key := participantKey{participant.ConversationID, participant.UserID}
+	if _, ok := m.store.participants[key]; ok {
+		return fmt.Errorf("%w \"conversation_participants_pkey\"", ErrUniqueViolation)
+	}
+
+	m.store.participants[key] = model.Participant{
+		ConversationID: participant.ConversationID,
+		UserID:         participant.UserID,
+		JoinedAt:       timestamp(participant.JoinedAt),
+	}
+
+	return nil
+}
+
+func (s *Store) conversationExists(id int) error {
+	if _, ok := s.conversations[id]; !ok {
+		return fmt.Errorf("%w: key (conversation_id)=(%d) is not present in table \"conversations\"", ErrForeignKeyViolation, id)
+	}
+	return nil
+}
+
+func (m *MessageRepository) GetParticipants(ctx context.Context, conversationID int) ([]*model.Participant, error) {
+	return m.participants(ctx, func(key participantKey) bool {
+		return key.conversationID == conversationID
+	})
+}
+
+func (m *MessageRepository) GetParticipantsByUserID(ctx context.Context, userID int) ([]*model.Participant, error) {
+	return m.participants(ctx, func(key participantKey) bool {
+		participant, ok := m.store.participants[participantKey{key.conversationID, userID}]
+		return ok && participant.Active()
+	})
+}
+
+// participants returns the participants keep is true for with their names,
+// by conversation and in the order they joined.
+func (m *MessageRepository) participants(ctx context.Context, keep func(participantKey) bool) ([]*model.Participant, error) {
+	unlock, err := m.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var participants []*model.Participant
+	for key, participant := range m.store.participants {
+		if keep(key) {
+			participant.UserName = m.store.users[participant.UserID].Name
+			participants = append(participants, &participant)
+		}
+	}
+
+	slices.SortFunc(participants, func(x, y *model.Participant) int {
+		return cmp.Or(
+			cmp.Compare(x.ConversationID, y.ConversationID),
+			x.JoinedAt.Compare(y.JoinedAt),
+			cmp.Compare(x.UserID, y.UserID),
+		)
+	})
+
+	return participants, nil
+}
+
+// update changes the participant if they exist, like an UPDATE that may
+// match no row.
+func (m *MessageRepository) update(ctx context.Context, conversationID, userID int, change func(*model.Participant)) error {
+	unlock, err := m.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := participantKey{conversationID, userID}
+	participant, ok := m.store.participants[key]
+	if ok {
+		change(&participant)
+		m.store.participants[key] = participant
+	}
+
+	return nil
+}
+
+func (m *MessageRepository) MarkRead(ctx context.Context, conversationID, userID, messageID int) error {
+	return m.update(ctx, conversationID, userID, func(participant *model.Participant) {
+		participant.LastReadID = max(participant.LastReadID, messageID)
+	})
+}
+
+func (m *MessageRepository) SetMuted(ctx context.Context, conversationID, userID int, muted bool) error {
+	return m.update(ctx, conversationID, userID, func(participant *model.Participant) {
+		participant.Muted = muted
+	})
+}
+
+func (m *MessageRepository) LeaveConversation(ctx context.Context, conversationID, userID int, at time.Time) error {
+	return m.update(ctx, conversationID, userID, func(participant *model.Participant) {
+		if participant.Active() {
+			participant.LeftAt = nullableTimestamp(&at)
+		}
+	})
+}
+
+func (m *MessageRepository) CountUnreadMessages(ctx context.Context, userID int) (int, error) {
+	unlock, err := m.store.read(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	count := 0
+	for key, participant := range m.store.participants {
+		if key.userID == userID && participant.Active() && !participant.Muted {
+			count += m.store.unread(participant)
+		}
+	}
+	return count, nil
+}
+
+func (m *MessageRepository) InsertMessage(ctx context.Context, message *model.Message) (int, error) {
+	unlock, err := m.store.write(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err = m.store.conversationExists(message.ConversationID); err != nil {
+		return 0, err
+	}
+	if err = m.store.userExists(message.UserID); err != nil {
+		return 0, err
+	}
+
+	m.store.seq.messages++
+	message.ID = m.store.seq.messages
+
+	m.store.messages[message.ID] = model.Message{
+		ID:             message.ID,
+		ConversationID: message.ConversationID,
+		UserID:         message.UserID,
+		Body:           message.Body,
+		CreatedAt:      timestamp(message.CreatedAt),
+	}
+
+	return message.ID, nil
+}
+
+func (m *MessageRepository) GetMessagesByConversationID(ctx context.Context, conversationID int) ([]*model.Message, error) {
+	unlock, err := m.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var messages []*model.Message
+	for _, message := range m.store.messages {
+		if message.ConversationID == conversationID {
+			message.UserName = m.store.users[message.UserID].Name
+			messages = append(messages, &message)
+		}
+	}
+
+	slices.SortFunc(messages, func(x, y *model.Message) int {
+		return cmp.Compare(x.ID, y.ID)
+	})
+
+	return messages, nil
+}
@@ -0,0 +1,218 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"simple-forum/internal/model"
+	"slices"
+)
+
+type PostRepository struct {
+	store *Store
+}
+
+func NewPostRepository(store *Store) *PostRepository {
+	return &PostRepository{store: store}
+}
+
+func (p *PostRepository) GetPostsByTopicID(ctx context.Context, topicID int) ([]*model.Post, error) {
+	unlock, err := p.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var posts []*model.Post
+	for _, id := range slices.Sorted(maps.Keys(p.store.posts)) {
+		post := p.store.posts[id]
+		if post.TopicId == topicID {
+			posts = append(posts, &post)
+		}
+	}
+	return posts, nil
+}
+
+func (p *PostRepository) GetPostByID(ctx context.Context, postID int) (*model.Post, error) {
+	unlock, err := p.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	post, ok := p.store.posts[postID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &post, nil
+}
+
+// GetLatestPublicPosts returns up to limit posts outside private topics,
+// most recently updated first.
+func (p *PostRepository) GetLatestPublicPosts(ctx context.Context, limit int) ([]*model.Post, error) {
+	unlock, err := p.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var posts []*model.Post
+	for _, post := range p.store.posts {
+		if topic, ok := p.store.topics[post.TopicId]; ok && !topic.Private {
+			posts = append(posts, &post)
+		}
+	}
+
+	slices.SortFunc(posts, func(a, b *model.Post) int {
+		if c := b.UpdatedAt.Compare(a.UpdatedAt); c != 0 {
+			return c
+		}
+		return b.ID - a.ID
+	})
+	return posts[:min(limit, len(posts))], nil
+}
+
+// GetPublicPosts returns up to limit posts outside private topics in the
+// order they were created, skipping the first offset of them.
+func (p *PostRepository) GetPublicPosts(ctx context.Context, offset, limit int) ([]*model.Post, error) {
+	unlock, err := p.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	posts := p.publicPosts()
+	offset = min(offset, len(posts))
+	return posts[offset:min(offset+limit, len(posts))], nil
+}
+
+// CountPublicPosts returns the number of posts outside private topics.
+func (p *PostRepository) CountPublicPosts(ctx context.Context) (int, error) {
+	unlock, err := p.store.read(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return len(p.publicPosts()), nil
+}
+
+// GetPublicPostsByAuthorID returns up to limit posts of the author outside
+// private topics, newest first.
+func (p *PostRepository) GetPublicPostsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Post, error) {
+	unlock, err := p.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	posts := slices.DeleteFunc(p.publicPosts(), func(post *model.Post) bool { return post.AuthorId != authorID })
+	slices.SortFunc(posts, func(a, b *model.Post) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return b.ID - a.ID
+	})
+	return posts[:min(limit, len(posts))], nil
+}
+
+// CountPublicPostsByAuthorID returns the number of posts of the author
+// outside private topics.
+func (p *PostRepository) CountPublicPostsByAuthorID(ctx context.Context, authorID int) (int, error) {
+	unlock, err := p.store.read(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	count := 0
+	for _, post := range p.publicPosts() {
+		if post.AuthorId == authorID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// publicPosts returns the posts outside private topics by id. The caller
+// holds the lock.
+func (p *PostRepository) publicPosts() []*model.Post {
+	var posts []*model.Post
+	for _, id := range slices.Sorted(maps.Keys(p.store.posts)) {
+		post := p.store.posts[id]
+		if topic, ok := p.store.topics[post.TopicId]; ok && !topic.Private {
+			posts = append(posts, &post)
+		}
+	}
+	return posts
+}
+
+func (p *PostRepository) InsertPost(ctx context.Context, post *model.Post) (int, error) {
+	unlock, err := p.store.write(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err = p.topicExists(post.TopicId); err != nil {
+		return 0, err
+	}
+
+	p.store.seq.posts++
+	post.ID = p.store.seq.posts
+
+	stored := *post
+	stored.CreatedAt = timestamp(post.CreatedAt)
+	stored.UpdatedAt = timestamp(post.UpdatedAt)
+	p.store.posts[post.ID] = stored
+
+	return post.ID, nil
+}
+
+func (p *PostRepository) UpdatePost(ctx context.Context, post *model.Post) error {
+	unlock, err := p.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	stored, ok := p.store.posts[post.ID]
+	if !ok {
+		return nil
+	}
+	if err = p.topicExists(post.TopicId); err != nil {
+		return err
+	}
+
+	stored.Title = post.Title
+	stored.Content = post.Content
+	stored.TopicId = post.TopicId
+	stored.AuthorId = post.AuthorId
+	stored.AuthorName = post.AuthorName
+	stored.UpdatedAt = timestamp(post.UpdatedAt)
+	p.store.posts[post.ID] = stored
+
+	return nil
+}
+
+func (p *PostRepository) DeletePost(ctx context.Context, post *model.Post) error {
+	unlock, err := p.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delete(p.store.posts, post.ID)
+	p.store.detachAttachments(post.ID)
+	p.store.deleteMentions(post.ID)
+
+	return nil
+}
+
+func (p *PostRepository) topicExists(id int) error {
+	if _, ok := p.store.topics[id]; !ok {
+		return fmt.Errorf("%w: key (topic_id)=(%d) is not present in table \"topics\"", ErrForeignKeyViolation, id)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"simple-forum/internal/model"
+	"time"
+)
+
+type ProfileRepository struct {
+	store *Store
+}
+
+func NewProfileRepository(store *Store) *ProfileRepository {
+	return &ProfileRepository{store: store}
+}
+
+func (p *ProfileRepository) GetProfile(ctx context.Context, userID int) (*model.Profile, error) {
+	unlock, err := p.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	profile, ok := p.store.profiles[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &profile, nil
+}
+
+func (p *ProfileRepository) SaveProfile(ctx context.Context, profile *model.Profile) error {
+	unlock, err := p.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = p.store.userExists(profile.UserID); err != nil {
+		return err
+	}
+
+	stored := *profile
+	stored.Avatar = p.store.profiles[profile.UserID].Avatar
+	stored.UpdatedAt = timestamp(profile.UpdatedAt)
+	p.store.profiles[profile.UserID] = stored
+
+	return nil
+}
+
+func (p *ProfileRepository) SaveAvatar(ctx context.Context, userID int, avatar string, updatedAt time.Time) error {
+	unlock, err := p.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = p.store.userExists(userID); err != nil {
+		return err
+	}
+
+	stored := p.store.profiles[userID]
+	stored.UserID = userID
+	stored.Avatar = avatar
+	stored.UpdatedAt = timestamp(updatedAt)
+	p.store.profiles[userID] = stored
+
+	return nil
+}
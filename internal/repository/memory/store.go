@@ -0,0 +1,194 @@
+// Package memory implements the storage interfaces of the service package
+// without a database, for tests and demos. It keeps the guarantees the
+// PostgreSQL schema gives: generated IDs, unique and foreign key
+// constraints, cascading deletes and the same not-found results as the SQL
+// repositories.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"simple-forum/internal/model"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUniqueViolation     = errors.New("duplicate key value violates unique constraint")
+	ErrForeignKeyViolation = errors.New("insert or update violates foreign key constraint")
+	ErrCheckViolation      = errors.New("new row violates check constraint")
+)
+
+// adminPasswordHash is the hash of the admin account created by the first
+// migration.
+const adminPasswordHash = "$2a$10$sMn.IWt9q3EiisAecQoOLOsvnA0wsl2oMRDGcHIrAR6XNOBVpxILK"
+
+type tables struct {
+	users       map[int]model.User
+	topics      map[int]model.Topic
+	posts       map[int]model.Post
+	identities  map[int]model.Identity
+	profiles    map[int]model.Profile
+	attachments map[int]model.Attachment
+	blobs       map[string]struct{}
+	mentions    map[mentionKey]model.Mention
+	tokens      map[int]model.AccessToken
+	throttles   map[string]model.LoginThrottle
+	audit       []model.AuditEntry
+
+	conversations map[int]model.Conversation
+	participants  map[participantKey]model.Participant
+	messages      map[int]model.Message
+	blocks        map[blockKey]model.UserBlock
+	bans          map[int]model.MessagingBan
+}
+
+// mentionKey, participantKey and blockKey are the primary keys of
+// post_mentions, conversation_participants and user_blocks.
+type mentionKey struct{ postID, userID int }
+
+type participantKey struct{ conversationID, userID int }
+
+type blockKey struct{ userID, blockedUserID int }
+
+// sequences hand out IDs. Like PostgreSQL sequences they are not rolled
+// back with a transaction.
+type sequences struct {
+	users, topics, posts, identities, tokens, attachments int
+	conversations, messages                               int
+	audit                                                 int64
+}
+
+// Store holds the tables shared by the repositories of this package. It is
+// safe for concurrent use.
+type Store struct {
+	mu  sync.RWMutex
+	seq sequences
+	tables
+}
+
+// NewStore returns a store in the state a freshly migrated database is in,
+// with the admin account and nothing else.
+func NewStore() *Store {
+	s := &Store{
+		tables: tables{
+			users:       make(map[int]model.User),
+			topics:      make(map[int]model.Topic),
+			posts:       make(map[int]model.Post),
+			identities:  make(map[int]model.Identity),
+			profiles:    make(map[int]model.Profile),
+			attachments: make(map[int]model.Attachment),
+			blobs:       make(map[string]struct{}),
+			mentions:    make(map[mentionKey]model.Mention),
+			tokens:      make(map[int]model.AccessToken),
+			throttles:   make(map[string]model.LoginThrottle),
+
+			conversations: make(map[int]model.Conversation),
+			participants:  make(map[participantKey]model.Participant),
+			messages:      make(map[int]model.Message),
+			blocks:        make(map[blockKey]model.UserBlock),
+			bans:          make(map[int]model.MessagingBan),
+		},
+	}
+
+	s.seq.users++
+	s.users[s.seq.users] = model.User{
+		ID:           s.seq.users,
+		Name:         "admin",
+		Email:        "admin@email.com",
+		PasswordHash: adminPasswordHash,
+		CreatedAt:    timestamp(time.Now()),
+		Role:         "admin",
+	}
+
+	return s
+}
+
+// read and write lock the store for one repository call and return the
+// unlock function. Inside WithinTx the transaction already holds the lock.
+func (s *Store) read(ctx context.Context) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.inTx(ctx) {
+		return func() {}, nil
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock, nil
+}
+
+func (s *Store) write(ctx context.Context) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.inTx(ctx) {
+		return func() {}, nil
+	}
+	s.mu.Lock()
+	return s.mu.Unlock, nil
+}
+
+func (s *Store) snapshot() tables {
+	return tables{
+		users:       maps.Clone(s.users),
+		topics:      maps.Clone(s.topics),
+		posts:       maps.Clone(s.posts),
+		identities:  maps.Clone(s.identities),
+		profiles:    maps.Clone(s.profiles),
+		attachments: maps.Clone(s.attachments),
+		blobs:       maps.Clone(s.blobs),
+		mentions:    maps.Clone(s.mentions),
+		tokens:      maps.Clone(s.tokens),
+		throttles:   maps.Clone(s.throttles),
+		audit:       slices.Clone(s.audit),
+
+		conversations: maps.Clone(s.conversations),
+		participants:  maps.Clone(s.participants),
+		messages:      maps.Clone(s.messages),
+		blocks:        maps.Clone(s.blocks),
+		bans:          maps.Clone(s.bans),
+	}
+}
+
+func (s *Store) userExists(id int) error {
+	if _, ok := s.users[id]; !ok {
+		return fmt.Errorf("%w: key (user_id)=(%d) is not present in table \"users\"", ErrForeignKeyViolation, id)
+	}
+	return nil
+}
+
+// detachAttachments leaves the attachments of the deleted post without
+// one, as ON DELETE SET NULL does.
+func (s *Store) detachAttachments(postID int) {
+	for id, attachment := range s.attachments {
+		if attachment.PostID == postID {
+			attachment.PostID = 0
+			s.attachments[id] = attachment
+		}
+	}
+}
+
+// deleteMentions removes the mentions of the deleted post, as ON DELETE
+// CASCADE does.
+func (s *Store) deleteMentions(postID int) {
+	maps.DeleteFunc(s.mentions, func(key mentionKey, _ model.Mention) bool {
+		return key.postID == postID
+	})
+}
+
+// timestamp drops what a TIMESTAMPTZ column cannot hold: precision below a
+// microsecond and the monotonic clock reading.
+func timestamp(t time.Time) time.Time {
+	return t.Round(time.Microsecond)
+}
+
+func nullableTimestamp(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	ts := timestamp(*t)
+	return &ts
+}
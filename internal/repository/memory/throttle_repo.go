@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"simple-forum/internal/model"
+	"slices"
+	"time"
+)
+
+type ThrottleRepository struct {
+	store *Store
+}
+
+func NewThrottleRepository(store *Store) *ThrottleRepository {
+	return &ThrottleRepository{store: store}
+}
+
+func copyThrottle(throttle model.LoginThrottle) *model.LoginThrottle {
+	throttle.LockedUntil = nullableTimestamp(throttle.LockedUntil)
+	return &throttle
+}
+
+func (t *ThrottleRepository) GetThrottle(ctx context.Context, key string) (*model.LoginThrottle, error) {
+	unlock, err := t.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	throttle, ok := t.store.throttles[key]
+	if !ok {
+		return nil, nil
+	}
+	return copyThrottle(throttle), nil
+}
+
+func (t *ThrottleRepository) GetLockedThrottles(ctx context.Context, now time.Time) ([]*model.LoginThrottle, error) {
+	unlock, err := t.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var throttles []*model.LoginThrottle
+	for _, throttle := range t.store.throttles {
+		if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+			throttles = append(throttles, copyThrottle(throttle))
+		}
+	}
+
+	slices.SortFunc(throttles, func(a, b *model.LoginThrottle) int {
+		return b.LockedUntil.Compare(*a.LockedUntil)
+	})
+
+	return throttles, nil
+}
+
+func (t *ThrottleRepository) CountFailure(ctx context.Context, key string, now, since time.Time) (*model.LoginThrottle, error) {
+	unlock, err := t.store.write(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	throttle, ok := t.store.throttles[key]
+	if !ok || (throttle.LastFailureAt.Before(since) && (throttle.LockedUntil == nil || !throttle.LockedUntil.After(now))) {
+		throttle.Key = key
+		throttle.Failures = 0
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = timestamp(now)
+	t.store.throttles[key] = throttle
+
+	return copyThrottle(throttle), nil
+}
+
+func (t *ThrottleRepository) LockThrottle(ctx context.Context, key string, failures int, now, until time.Time) (bool, error) {
+	unlock, err := t.store.write(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	throttle, ok := t.store.throttles[key]
+	if !ok || throttle.Failures < failures || (throttle.LockedUntil != nil && throttle.LockedUntil.After(now)) {
+		return false, nil
+	}
+
+	lockedUntil := timestamp(until)
+	throttle.LockedUntil = &lockedUntil
+	t.store.throttles[key] = throttle
+
+	return true, nil
+}
+
+func (t *ThrottleRepository) DeleteThrottle(ctx context.Context, key string) error {
+	unlock, err := t.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delete(t.store.throttles, key)
+
+	return nil
+}
+
+// DeleteStaleThrottles removes entries without failures since before and
+// without an active lock.
+func (t *ThrottleRepository) DeleteStaleThrottles(ctx context.Context, before time.Time) error {
+	unlock, err := t.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	maps.DeleteFunc(t.store.throttles, func(_ string, throttle model.LoginThrottle) bool {
+		return throttle.LastFailureAt.Before(before) &&
+			(throttle.LockedUntil == nil || throttle.LockedUntil.Before(before))
+	})
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"simple-forum/internal/model"
+	"slices"
+	"time"
+)
+
+type TokenRepository struct {
+	store *Store
+}
+
+func NewTokenRepository(store *Store) *TokenRepository {
+	return &TokenRepository{store: store}
+}
+
+// copyToken returns a copy that shares nothing with token.
+func copyToken(token model.AccessToken) *model.AccessToken {
+	token.Scopes = slices.Clone(token.Scopes)
+	token.ExpiresAt = nullableTimestamp(token.ExpiresAt)
+	token.LastUsedAt = nullableTimestamp(token.LastUsedAt)
+	return &token
+}
+
+func (t *TokenRepository) GetTokensByUserID(ctx context.Context, userID int) ([]*model.AccessToken, error) {
+	unlock, err := t.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var tokens []*model.AccessToken
+	for _, token := range t.store.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, copyToken(token))
+		}
+	}
+
+	slices.SortFunc(tokens, func(a, b *model.AccessToken) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+func (t *TokenRepository) GetTokenByHash(ctx context.Context, hash string) (*model.AccessToken, error) {
+	unlock, err := t.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	for _, token := range t.store.tokens {
+		if token.Hash == hash {
+			return copyToken(token), nil
+		}
+	}
+	return nil, nil
+}
+
+func (t *TokenRepository) InsertToken(ctx context.Context, token *model.AccessToken) (int, error) {
+	unlock, err := t.store.write(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err = t.store.userExists(token.UserID); err != nil {
+		return 0, err
+	}
+	for _, existing := range t.store.tokens {
+		if existing.Hash == token.Hash {
+			return 0, fmt.Errorf("%w \"personal_access_tokens_token_hash_key\"", ErrUniqueViolation)
+		}
+	}
+
+	t.store.seq.tokens++
+	token.ID = t.store.seq.tokens
+
+	stored := copyToken(*token)
+	stored.LastUsedAt = nil
+	stored.CreatedAt = timestamp(token.CreatedAt)
+	t.store.tokens[token.ID] = *stored
+
+	return token.ID, nil
+}
+
+func (t *TokenRepository) TouchToken(ctx context.Context, id int, usedAt time.Time) error {
+	unlock, err := t.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	token, ok := t.store.tokens[id]
+	if !ok {
+		return nil
+	}
+	token.LastUsedAt = nullableTimestamp(&usedAt)
+	t.store.tokens[id] = token
+
+	return nil
+}
+
+// DeleteToken removes the token only if it belongs to userID and returns
+// sql.ErrNoRows otherwise.
+func (t *TokenRepository) DeleteToken(ctx context.Context, id, userID int) error {
+	unlock, err := t.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	token, ok := t.store.tokens[id]
+	if !ok || token.UserID != userID {
+		return sql.ErrNoRows
+	}
+	delete(t.store.tokens, id)
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"simple-forum/internal/model"
+	"slices"
+)
+
+type TopicRepository struct {
+	store *Store
+}
+
+func NewTopicRepository(store *Store) *TopicRepository {
+	return &TopicRepository{store: store}
+}
+
+func (t *TopicRepository) GetAllTopics(ctx context.Context) ([]*model.Topic, error) {
+	unlock, err := t.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var topics []*model.Topic
+	for _, id := range slices.Sorted(maps.Keys(t.store.topics)) {
+		topic := t.store.topics[id]
+		topics = append(topics, &topic)
+	}
+	return topics, nil
+}
+
+// GetPublicTopicsByAuthorID returns up to limit topics the author started
+// that are not private, newest first.
+func (t *TopicRepository) GetPublicTopicsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Topic, error) {
+	unlock, err := t.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var topics []*model.Topic
+	for _, topic := range t.store.topics {
+		if topic.AuthorId == authorID && !topic.Private {
+			topics = append(topics, &topic)
+		}
+	}
+
+	slices.SortFunc(topics, func(a, b *model.Topic) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return b.ID - a.ID
+	})
+	return topics[:min(limit, len(topics))], nil
+}
+
+func (t *TopicRepository) GetTopicByID(ctx context.Context, topicID int) (*model.Topic, error) {
+	unlock, err := t.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	topic, ok := t.store.topics[topicID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &topic, nil
+}
+
+func (t *TopicRepository) GetTopicByPostID(ctx context.Context, postID int) (*model.Topic, error) {
+	unlock, err := t.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	post, ok := t.store.posts[postID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	topic, ok := t.store.topics[post.TopicId]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &topic, nil
+}
+
+func (t *TopicRepository) GetTopicWithPosts(ctx context.Context, topicID int) (*model.Topic, []*model.Post, error) {
+	unlock, err := t.store.read(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	topic, ok := t.store.topics[topicID]
+	if !ok {
+		return nil, nil, sql.ErrNoRows
+	}
+
+	var posts []*model.Post
+	for _, id := range slices.Sorted(maps.Keys(t.store.posts)) {
+		post := t.store.posts[id]
+		if post.TopicId == topicID {
+			posts = append(posts, &post)
+		}
+	}
+	return &topic, posts, nil
+}
+
+func (t *TopicRepository) InsertTopic(ctx context.Context, topic *model.Topic) (int, error) {
+	unlock, err := t.store.write(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err = t.store.userExists(topic.AuthorId); err != nil {
+		return 0, err
+	}
+
+	t.store.seq.topics++
+	topic.ID = t.store.seq.topics
+
+	stored := *topic
+	stored.CreatedAt = timestamp(topic.CreatedAt)
+	t.store.topics[topic.ID] = stored
+
+	return topic.ID, nil
+}
+
+func (t *TopicRepository) UpdateTopic(ctx context.Context, topic *model.Topic) error {
+	unlock, err := t.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	stored, ok := t.store.topics[topic.ID]
+	if !ok {
+		return nil
+	}
+	stored.Name = topic.Name
+	stored.Description = topic.Description
+	stored.Private = topic.Private
+	t.store.topics[topic.ID] = stored
+
+	return nil
+}
+
+// DeleteTopic removes the topic along with its posts and their mentions,
+// detaching their attachments.
+func (t *TopicRepository) DeleteTopic(ctx context.Context, topic *model.Topic) error {
+	unlock, err := t.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delete(t.store.topics, topic.ID)
+	maps.DeleteFunc(t.store.posts, func(_ int, post model.Post) bool {
+		if post.TopicId != topic.ID {
+			return false
+		}
+		t.store.detachAttachments(post.ID)
+		t.store.deleteMentions(post.ID)
+		return true
+	})
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package memory
+
+import "context"
+
+type txKey struct{}
+
+func (s *Store) inTx(ctx context.Context) bool {
+	tx, ok := ctx.Value(txKey{}).(*Store)
+	return ok && tx == s
+}
+
+// Transactor runs units of work against a Store with the isolation of a
+// serializable transaction: it holds the store's lock for the whole unit
+// and restores the tables if the unit fails.
+type Transactor struct {
+	store *Store
+}
+
+func NewTransactor(store *Store) *Transactor {
+	return &Transactor{store: store}
+}
+
+// WithinTx runs fn and keeps its writes only if it returns nil. A call from
+// within fn joins the outer transaction. Repositories called with the ctx
+// passed to fn must be of the same store, and fn must not wait for other
+// goroutines that use it.
+func (t *Transactor) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if t.store.inTx(ctx) {
+		return fn(ctx)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	saved := t.store.snapshot()
+
+	err := fn(context.WithValue(ctx, txKey{}, t.store))
+	if err != nil {
+		t.store.tables = saved
+		return err
+	}
+
+	return nil
+}
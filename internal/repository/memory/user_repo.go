@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"simple-forum/internal/model"
+	"slices"
+	"strings"
+)
+
+type UserRepository struct {
+	store *Store
+}
+
+func NewUserRepository(store *Store) *UserRepository {
+	return &UserRepository{store: store}
+}
+
+func (u *UserRepository) find(match func(user *model.User) bool) *model.User {
+	for _, user := range u.store.users {
+		if match(&user) {
+			return &user
+		}
+	}
+	return nil
+}
+
+func (u *UserRepository) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	unlock, err := u.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return u.find(func(user *model.User) bool { return user.Email == email }), nil
+}
+
+func (u *UserRepository) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
+	unlock, err := u.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return u.find(func(user *model.User) bool { return user.Name == username }), nil
+}
+
+func (u *UserRepository) GetUsernamesByPrefix(ctx context.Context, prefix string, limit int) ([]string, error) {
+	unlock, err := u.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var names []string
+	for _, user := range u.store.users {
+		if strings.HasPrefix(strings.ToLower(user.Name), strings.ToLower(prefix)) {
+			names = append(names, user.Name)
+		}
+	}
+	slices.Sort(names)
+	if len(names) > limit {
+		names = names[:limit]
+	}
+
+	return names, nil
+}
+
+func (u *UserRepository) GetUserByID(ctx context.Context, id int) (*model.User, error) {
+	unlock, err := u.store.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	user, ok := u.store.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (u *UserRepository) InsertUser(ctx context.Context, user *model.User) (int, error) {
+	unlock, err := u.store.write(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err = checkRole(user.Role); err != nil {
+		return 0, err
+	}
+	if u.find(func(existing *model.User) bool { return existing.Name == user.Name }) != nil {
+		return 0, fmt.Errorf("%w \"users_username_key\"", ErrUniqueViolation)
+	}
+	if u.find(func(existing *model.User) bool { return existing.Email == user.Email }) != nil {
+		return 0, fmt.Errorf("%w \"users_email_key\"", ErrUniqueViolation)
+	}
+
+	u.store.seq.users++
+	user.ID = u.store.seq.users
+
+	stored := *user
+	stored.CreatedAt = timestamp(user.CreatedAt)
+	u.store.users[user.ID] = stored
+
+	return user.ID, nil
+}
+
+func (u *UserRepository) UpdateUserRole(ctx context.Context, id int, role string) error {
+	unlock, err := u.store.write(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = checkRole(role); err != nil {
+		return err
+	}
+
+	user, ok := u.store.users[id]
+	if !ok {
+		return nil
+	}
+	user.Role = role
+	u.store.users[id] = user
+
+	return nil
+}
+
+func checkRole(role string) error {
+	if role != "user" && role != "admin" {
+		return fmt.Errorf("%w \"users_role_check\"", ErrCheckViolation)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"simple-forum/internal/model"
+	"time"
+)
+
+type MentionRepository struct {
+	conn    *sql.DB
+	timeout time.Duration
+}
+
+func NewMentionRepository(conn *sql.DB, timeout time.Duration) *MentionRepository {
+	return &MentionRepository{conn: conn, timeout: timeout}
+}
+
+// InsertMention records the mention unless the post mentioned the user
+// before, and reports whether it did.
+func (m *MentionRepository) InsertMention(ctx context.Context, mention *model.Mention) (bool, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `INSERT INTO post_mentions (post_id, user_id, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (post_id, user_id) DO NOTHING`
+
+	result, err := executor(ctx, m.conn).ExecContext(ctx, query, mention.PostID, mention.UserID, mention.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// GetMentionsByPostID returns the users the post ever mentioned, by name.
+func (m *MentionRepository) GetMentionsByPostID(ctx context.Context, postID int) ([]*model.Mention, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `SELECT m.post_id, m.user_id, u.username, m.created_at
+		FROM post_mentions m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.post_id = $1
+		ORDER BY u.username`
+
+	rows, err := executor(ctx, m.conn).QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentions []*model.Mention
+	for rows.Next() {
+		mention := new(model.Mention)
+		err = rows.Scan(&mention.PostID, &mention.UserID, &mention.UserName, &mention.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, mention)
+	}
+
+	return mentions, rows.Err()
+}
@@ -0,0 +1,318 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"simple-forum/internal/model"
+	"time"
+)
+
+type MessageRepository struct {
+	conn    *sql.DB
+	timeout time.Duration
+}
+
+func NewMessageRepository(conn *sql.DB, timeout time.Duration) *MessageRepository {
+	return &MessageRepository{conn: conn, timeout: timeout}
+}
+
+func (m *MessageRepository) InsertConversation(ctx context.Context, conversation *model.Conversation) (int, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `INSERT INTO conversations (subject, created_at, last_message_at) VALUES ($1, $2, $3) RETURNING id`
+
+	err := executor(ctx, m.conn).QueryRowContext(ctx, query,
+		conversation.Subject,
+		conversation.CreatedAt,
+		conversation.LastMessageAt).Scan(&conversation.ID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return conversation.ID, nil
+}
+
+// GetConversationByID returns the conversation without the fields of a
+// participant, or sql.ErrNoRows.
+func (m *MessageRepository) GetConversationByID(ctx context.Context, id int) (*model.Conversation, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `SELECT id, subject, created_at, last_message_at FROM conversations WHERE id = $1`
+
+	conversation := new(model.Conversation)
+
+	err := executor(ctx, m.conn).QueryRowContext(ctx, query, id).Scan(
+		&conversation.ID,
+		&conversation.Subject,
+		&conversation.CreatedAt,
+		&conversation.LastMessageAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return conversation, nil
+}
+
+// GetConversationsByUserID returns up to limit conversations the user has
+// not left, with the last message first, along with how many messages of
+// the others they have not read.
+func (m *MessageRepository) GetConversationsByUserID(ctx context.Context, userID, limit int) ([]*model.Conversation, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `SELECT c.id, c.subject, c.created_at, c.last_message_at, p.last_read_id, p.muted,
+			(SELECT COUNT(*) FROM messages m
+				WHERE m.conversation_id = c.id AND m.id > p.last_read_id AND m.user_id <> p.user_id)
+		FROM conversations c
+		JOIN conversation_participants p ON p.conversation_id = c.id
+		WHERE p.user_id = $1 AND p.left_at IS NULL
+		ORDER BY c.last_message_at DESC, c.id DESC
+		LIMIT $2`
+
+	rows, err := executor(ctx, m.conn).QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []*model.Conversation
+	for rows.Next() {
+		conversation := new(model.Conversation)
+		err = rows.Scan(
+			&conversation.ID,
+			&conversation.Subject,
+			&conversation.CreatedAt,
+			&conversation.LastMessageAt,
+			&conversation.LastReadID,
+			&conversation.Muted,
+			&conversation.Unread,
+		)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, rows.Err()
+}
+
+// SetLastMessageAt moves the conversation to when its last message was
+// sent.
+func (m *MessageRepository) SetLastMessageAt(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `UPDATE conversations SET last_message_at = $2 WHERE id = $1`
+
+	_, err := executor(ctx, m.conn).ExecContext(ctx, query, id, at)
+
+	return err
+}
+
+// DeleteConversation removes the conversation with its participants and
+// messages.
+func (m *MessageRepository) DeleteConversation(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `DELETE FROM conversations WHERE id = $1`
+
+	_, err := executor(ctx, m.conn).ExecContext(ctx, query, id)
+
+	return err
+}
+
+func (m *MessageRepository) InsertParticipant(ctx context.Context, participant *model.Participant) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `INSERT INTO conversation_participants (conversation_id, user_id, joined_at) VALUES ($1, $2, $3)`
+
+	_, err := executor(ctx, m.conn).ExecContext(ctx, query,
+		participant.ConversationID,
+		participant.UserID,
+		participant.JoinedAt)
+
+	return err
+}
+
+const participantColumns = `p.conversation_id, p.user_id, u.username, p.last_read_id, p.muted, p.left_at, p.joined_at`
+
+func (m *MessageRepository) queryParticipants(ctx context.Context, query string, args ...any) ([]*model.Participant, error) {
+	rows, err := executor(ctx, m.conn).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var participants []*model.Participant
+	for rows.Next() {
+		participant := new(model.Participant)
+
+		var leftAt sql.NullTime
+
+		err = rows.Scan(
+			&participant.ConversationID,
+			&participant.UserID,
+			&participant.UserName,
+			&participant.LastReadID,
+			&participant.Muted,
+			&leftAt,
+			&participant.JoinedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if leftAt.Valid {
+			participant.LeftAt = &leftAt.Time
+		}
+
+		participants = append(participants, participant)
+	}
+	return participants, rows.Err()
+}
+
+// GetParticipants returns everyone who took part in the conversation,
+// including those who left, in the order they joined.
+func (m *MessageRepository) GetParticipants(ctx context.Context, conversationID int) ([]*model.Participant, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `SELECT ` + participantColumns + ` FROM conversation_participants p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.conversation_id = $1
+		ORDER BY p.joined_at, p.user_id`
+
+	return m.queryParticipants(ctx, query, conversationID)
+}
+
+// GetParticipantsByUserID returns the participants of every conversation
+// the user has not left, by conversation and in the order they joined.
+func (m *MessageRepository) GetParticipantsByUserID(ctx context.Context, userID int) ([]*model.Participant, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `SELECT ` + participantColumns + ` FROM conversation_participants p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.conversation_id IN
+			(SELECT conversation_id FROM conversation_participants WHERE user_id = $1 AND left_at IS NULL)
+		ORDER BY p.conversation_id, p.joined_at, p.user_id`
+
+	return m.queryParticipants(ctx, query, userID)
+}
+
+// MarkRead moves the read marker of the participant forward to messageID;
+// it never moves back.
+func (m *MessageRepository) MarkRead(ctx context.Context, conversationID, userID, messageID int) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `UPDATE conversation_participants SET last_read_id = $3
+		WHERE conversation_id = $1 AND user_id = $2 AND last_read_id < $3`
+
+	_, err := executor(ctx, m.conn).ExecContext(ctx, query, conversationID, userID, messageID)
+
+	return err
+}
+
+func (m *MessageRepository) SetMuted(ctx context.Context, conversationID, userID int, muted bool) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `UPDATE conversation_participants SET muted = $3 WHERE conversation_id = $1 AND user_id = $2`
+
+	_, err := executor(ctx, m.conn).ExecContext(ctx, query, conversationID, userID, muted)
+
+	return err
+}
+
+func (m *MessageRepository) LeaveConversation(ctx context.Context, conversationID, userID int, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `UPDATE conversation_participants SET left_at = $3
+		WHERE conversation_id = $1 AND user_id = $2 AND left_at IS NULL`
+
+	_, err := executor(ctx, m.conn).ExecContext(ctx, query, conversationID, userID, at)
+
+	return err
+}
+
+// CountUnreadMessages returns how many messages of others the user has not
+// read in the conversations they neither left nor muted.
+func (m *MessageRepository) CountUnreadMessages(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FROM messages m
+		JOIN conversation_participants p ON p.conversation_id = m.conversation_id
+		WHERE p.user_id = $1 AND p.left_at IS NULL AND NOT p.muted
+			AND m.id > p.last_read_id AND m.user_id <> $1`
+
+	var count int
+	err := executor(ctx, m.conn).QueryRowContext(ctx, query, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (m *MessageRepository) InsertMessage(ctx context.Context, message *model.Message) (int, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `INSERT INTO messages (conversation_id, user_id, body, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
+
+	err := executor(ctx, m.conn).QueryRowContext(ctx, query,
+		message.ConversationID,
+		message.UserID,
+		message.Body,
+		message.CreatedAt).Scan(&message.ID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return message.ID, nil
+}
+
+// GetMessagesByConversationID returns the messages of the conversation,
+// oldest first.
+func (m *MessageRepository) GetMessagesByConversationID(ctx context.Context, conversationID int) ([]*model.Message, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `SELECT m.id, m.conversation_id, m.user_id, u.username, m.body, m.created_at
+		FROM messages m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.conversation_id = $1
+		ORDER BY m.id`
+
+	rows, err := executor(ctx, m.conn).QueryContext(ctx, query, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*model.Message
+	for rows.Next() {
+		message := new(model.Message)
+		err = rows.Scan(
+			&message.ID,
+			&message.ConversationID,
+			&message.UserID,
+			&message.UserName,
+			&message.Body,
+			&message.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
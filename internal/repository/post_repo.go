@@ -1,54 +1,34 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"simple-forum/internal/database"
 	"simple-forum/internal/model"
+	"time"
 )
 
 type PostRepository struct {
-	conn *sql.DB
+	conn     *sql.DB
+	replicas *database.ReplicaSet
+	timeout  time.Duration
 }
 
-func NewPostRepository(conn *sql.DB) *PostRepository {
-	return &PostRepository{conn: conn}
+func NewPostRepository(conn *sql.DB, timeout time.Duration) *PostRepository {
+	return &PostRepository{conn: conn, timeout: timeout}
 }
 
-func (p *PostRepository) GetPostsByTopicID(topicID int) ([]*model.Post, error) {
-	query := `SELECT * FROM posts WHERE topic_id = $1`
-
-	rows, err := p.conn.Query(query, topicID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var posts []*model.Post
-	for rows.Next() {
-		post := new(model.Post)
-		err := rows.Scan(
-			&post.ID,
-			&post.Title,
-			&post.Content,
-			&post.AuthorId,
-			&post.AuthorName,
-			&post.TopicId,
-			&post.CreatedAt,
-			&post.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		posts = append(posts, post)
-	}
-	return posts, nil
+// WithReplicas sends the repository's read-only queries to replicas.
+func (p *PostRepository) WithReplicas(replicas *database.ReplicaSet) *PostRepository {
+	p.replicas = replicas
+	return p
 }
 
-func (p *PostRepository) GetPostByID(postID int) (*model.Post, error) {
-	query := `SELECT * FROM posts WHERE id = $1`
-
+// scanPost reads a row of SELECT * FROM posts, from database/sql or pgx.
+func scanPost(row interface{ Scan(dest ...any) error }) (*model.Post, error) {
 	post := new(model.Post)
 
-	err := p.conn.QueryRow(query, postID).Scan(
+	err := row.Scan(
 		&post.ID,
 		&post.Title,
 		&post.Content,
@@ -58,17 +38,132 @@ func (p *PostRepository) GetPostByID(postID int) (*model.Post, error) {
 		&post.CreatedAt,
 		&post.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+func (p *PostRepository) GetPostsByTopicID(ctx context.Context, topicID int) ([]*model.Post, error) {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
 
+	query := `SELECT * FROM posts WHERE topic_id = $1`
+
+	rows, err := reader(ctx, p.conn, p.replicas).QueryContext(ctx, query, topicID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return collectPosts(rows)
+}
+
+func (p *PostRepository) GetPostByID(ctx context.Context, postID int) (*model.Post, error) {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
+	query := `SELECT * FROM posts WHERE id = $1`
+
+	post, err := scanPost(reader(ctx, p.conn, p.replicas).QueryRowContext(ctx, query, postID))
 	if err != nil {
 		return nil, err
 	}
 	return post, nil
 }
 
-func (p *PostRepository) InsertPost(post *model.Post) (int, error) {
+// GetLatestPublicPosts returns up to limit posts outside private topics,
+// most recently updated first.
+func (p *PostRepository) GetLatestPublicPosts(ctx context.Context, limit int) ([]*model.Post, error) {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
+	query := `SELECT p.* FROM posts p JOIN topics t ON t.id = p.topic_id
+		WHERE NOT t.private ORDER BY p.updated_at DESC, p.id DESC LIMIT $1`
+
+	rows, err := reader(ctx, p.conn, p.replicas).QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return collectPosts(rows)
+}
+
+// GetPublicPosts returns up to limit posts outside private topics in the
+// order they were created, skipping the first offset of them.
+func (p *PostRepository) GetPublicPosts(ctx context.Context, offset, limit int) ([]*model.Post, error) {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
+	query := `SELECT p.* FROM posts p JOIN topics t ON t.id = p.topic_id
+		WHERE NOT t.private ORDER BY p.id LIMIT $1 OFFSET $2`
+
+	rows, err := reader(ctx, p.conn, p.replicas).QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return collectPosts(rows)
+}
+
+// CountPublicPosts returns the number of posts outside private topics.
+func (p *PostRepository) CountPublicPosts(ctx context.Context) (int, error) {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FROM posts p JOIN topics t ON t.id = p.topic_id WHERE NOT t.private`
+
+	var count int
+	err := reader(ctx, p.conn, p.replicas).QueryRowContext(ctx, query).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetPublicPostsByAuthorID returns up to limit posts of the author outside
+// private topics, newest first.
+func (p *PostRepository) GetPublicPostsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Post, error) {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
+	query := `SELECT p.* FROM posts p JOIN topics t ON t.id = p.topic_id
+		WHERE p.author_id = $1 AND NOT t.private ORDER BY p.created_at DESC, p.id DESC LIMIT $2`
+
+	rows, err := reader(ctx, p.conn, p.replicas).QueryContext(ctx, query, authorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return collectPosts(rows)
+}
+
+// CountPublicPostsByAuthorID returns the number of posts of the author
+// outside private topics.
+func (p *PostRepository) CountPublicPostsByAuthorID(ctx context.Context, authorID int) (int, error) {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FROM posts p JOIN topics t ON t.id = p.topic_id WHERE p.author_id = $1 AND NOT t.private`
+
+	var count int
+	err := reader(ctx, p.conn, p.replicas).QueryRowContext(ctx, query, authorID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (p *PostRepository) InsertPost(ctx context.Context, post *model.Post) (int, error) {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
 	query := `INSERT INTO posts (title, content, topic_id, author_id, author_name, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
 
-	err := p.conn.QueryRow(query,
+	err := executor(ctx, p.conn).QueryRowContext(ctx, query,
 		post.Title,
 		post.Content,
 		post.TopicId,
@@ -85,10 +180,13 @@ func (p *PostRepository) InsertPost(post *model.Post) (int, error) {
 	return post.ID, nil
 }
 
-func (p *PostRepository) UpdatePost(post *model.Post) error {
+func (p *PostRepository) UpdatePost(ctx context.Context, post *model.Post) error {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
 	query := `UPDATE posts SET title = $1, content = $2, topic_id = $3, author_id = $4, author_name = $5, updated_at = $6 WHERE id = $7`
 
-	_, err := p.conn.Exec(query,
+	_, err := executor(ctx, p.conn).ExecContext(ctx, query,
 		post.Title,
 		post.Content,
 		post.TopicId,
@@ -104,13 +202,33 @@ func (p *PostRepository) UpdatePost(post *model.Post) error {
 	return nil
 }
 
-func (p *PostRepository) DeletePost(post *model.Post) error {
+func (p *PostRepository) DeletePost(ctx context.Context, post *model.Post) error {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
 	query := `DELETE FROM posts WHERE id = $1`
 
-	_, err := p.conn.Exec(query, post.ID)
+	_, err := executor(ctx, p.conn).ExecContext(ctx, query, post.ID)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// collectPosts reads all posts from rows, of database/sql or pgx.
+func collectPosts(rows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}) ([]*model.Post, error) {
+	var posts []*model.Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"simple-forum/internal/model"
+	"time"
+)
+
+type ProfileRepository struct {
+	conn    *sql.DB
+	timeout time.Duration
+}
+
+func NewProfileRepository(conn *sql.DB, timeout time.Duration) *ProfileRepository {
+	return &ProfileRepository{conn: conn, timeout: timeout}
+}
+
+// GetProfile returns the profile of the user, or nil if they have not
+// saved one.
+func (p *ProfileRepository) GetProfile(ctx context.Context, userID int) (*model.Profile, error) {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
+	query := `SELECT user_id, display_name, bio, location, website, signature, show_email, avatar, updated_at
+		FROM user_profiles WHERE user_id = $1`
+
+	profile := new(model.Profile)
+
+	err := executor(ctx, p.conn).QueryRowContext(ctx, query, userID).Scan(
+		&profile.UserID,
+		&profile.DisplayName,
+		&profile.Bio,
+		&profile.Location,
+		&profile.Website,
+		&profile.Signature,
+		&profile.ShowEmail,
+		&profile.Avatar,
+		&profile.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// SaveProfile creates the profile of profile.UserID or replaces it, except
+// for the avatar, which only SaveAvatar changes.
+func (p *ProfileRepository) SaveProfile(ctx context.Context, profile *model.Profile) error {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
+	query := `INSERT INTO user_profiles (user_id, display_name, bio, location, website, signature, show_email, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id) DO UPDATE SET
+			display_name = excluded.display_name,
+			bio = excluded.bio,
+			location = excluded.location,
+			website = excluded.website,
+			signature = excluded.signature,
+			show_email = excluded.show_email,
+			updated_at = excluded.updated_at`
+
+	_, err := executor(ctx, p.conn).ExecContext(ctx, query,
+		profile.UserID,
+		profile.DisplayName,
+		profile.Bio,
+		profile.Location,
+		profile.Website,
+		profile.Signature,
+		profile.ShowEmail,
+		profile.UpdatedAt)
+
+	return err
+}
+
+// SaveAvatar sets the avatar of the user, creating an otherwise empty
+// profile if they have none.
+func (p *ProfileRepository) SaveAvatar(ctx context.Context, userID int, avatar string, updatedAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, p.timeout)
+	defer cancel()
+
+	query := `INSERT INTO user_profiles (user_id, avatar, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET avatar = excluded.avatar, updated_at = excluded.updated_at`
+
+	_, err := executor(ctx, p.conn).ExecContext(ctx, query, userID, avatar, updatedAt)
+
+	return err
+}
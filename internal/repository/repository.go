@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// withTimeout bounds a single query. A zero timeout leaves ctx as it is, so
+// only the request's own deadline or cancellation applies.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,1242 @@
+// Package storagetest checks that an implementation of the storage
+// interfaces behaves like the PostgreSQL repositories. The checks create
+// their own rows with unique names, so they can run against a database that
+// already holds data.
+package storagetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"simple-forum/internal/model"
+	"simple-forum/internal/service"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Storages is one backend under test.
+type Storages struct {
+	Users       service.UserStorage
+	Identities  service.IdentityStorage
+	Topics      service.TopicStorage
+	Posts       service.PostStorage
+	Tokens      service.TokenStorage
+	Profiles    service.ProfileStorage
+	Attachments service.AttachmentStorage
+	Mentions    service.MentionStorage
+	Messages    service.MessageStorage
+	Blocks      service.BlockStorage
+	Throttles   service.ThrottleStorage
+	Audit       service.AuditStorage
+	Tx          service.Transactor
+
+	// Precision is the finest time the backend stores, a microsecond like
+	// TIMESTAMPTZ if zero.
+	Precision time.Duration
+}
+
+func (s Storages) precision() time.Duration {
+	if s.Precision == 0 {
+		return time.Microsecond
+	}
+	return s.Precision
+}
+
+// sameTime reports whether got is want as the backend stores it.
+func (s Storages) sameTime(got, want time.Time) bool {
+	return got.Sub(want).Abs() < s.precision()
+}
+
+var unique atomic.Int64
+
+// name returns a name no other check uses, short enough for every column.
+func name(prefix string) string {
+	return fmt.Sprintf("%s%d-%d", prefix, time.Now().UnixNano()%1e9, unique.Add(1))
+}
+
+// Run runs every check against the storages returned by open.
+func Run(t *testing.T, open func(t *testing.T) Storages) {
+	checks := []struct {
+		name  string
+		check func(t *testing.T, s Storages)
+	}{
+		{"Users", testUsers},
+		{"Topics", testTopics},
+		{"Posts", testPosts},
+		{"Identities", testIdentities},
+		{"Tokens", testTokens},
+		{"Profiles", testProfiles},
+		{"Attachments", testAttachments},
+		{"Mentions", testMentions},
+		{"Messages", testMessages},
+		{"Blocks", testBlocks},
+		{"Throttles", testThrottles},
+		{"Audit", testAudit},
+		{"Transactions", testTransactions},
+		{"Cancelled Context", testCancelled},
+	}
+
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			c.check(t, open(t))
+		})
+	}
+}
+
+func insertUser(ctx context.Context, t *testing.T, s Storages) *model.User {
+	t.Helper()
+
+	user := &model.User{
+		Name:         name("u"),
+		PasswordHash: "hash",
+		CreatedAt:    time.Now(),
+		Role:         "user",
+	}
+	user.Email = user.Name + "@example.com"
+
+	_, err := s.Users.InsertUser(ctx, user)
+	if err != nil {
+		t.Fatalf("unable to insert user: %v", err)
+	}
+	return user
+}
+
+func insertTopic(ctx context.Context, t *testing.T, s Storages, authorID int) *model.Topic {
+	t.Helper()
+
+	topic := &model.Topic{Name: name("topic"), Description: "description", CreatedAt: time.Now(), AuthorId: authorID}
+
+	_, err := s.Topics.InsertTopic(ctx, topic)
+	if err != nil {
+		t.Fatalf("unable to insert topic: %v", err)
+	}
+	return topic
+}
+
+func insertPost(ctx context.Context, t *testing.T, s Storages, topicID int, author *model.User) *model.Post {
+	t.Helper()
+
+	now := time.Now()
+	post := &model.Post{
+		Title:      name("post"),
+		Content:    "content",
+		AuthorId:   author.ID,
+		AuthorName: author.Name,
+		TopicId:    topicID,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	_, err := s.Posts.InsertPost(ctx, post)
+	if err != nil {
+		t.Fatalf("unable to insert post: %v", err)
+	}
+	return post
+}
+
+func testUsers(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	first := insertUser(ctx, t, s)
+	second := insertUser(ctx, t, s)
+	if first.ID == 0 || second.ID <= first.ID {
+		t.Errorf("expected increasing IDs, got %d and %d", first.ID, second.ID)
+	}
+
+	byEmail, err := s.Users.GetUserByEmail(ctx, first.Email)
+	if err != nil || byEmail == nil || byEmail.ID != first.ID {
+		t.Errorf("expected user %d by email, got %+v, %v", first.ID, byEmail, err)
+	}
+
+	byName, err := s.Users.GetUserByUsername(ctx, first.Name)
+	if err != nil || byName == nil || byName.ID != first.ID {
+		t.Errorf("expected user %d by username, got %+v, %v", first.ID, byName, err)
+	}
+
+	byID, err := s.Users.GetUserByID(ctx, first.ID)
+	if err != nil || byID == nil || byID.Email != first.Email {
+		t.Fatalf("expected user %d by ID, got %+v, %v", first.ID, byID, err)
+	}
+	if !s.sameTime(byID.CreatedAt, first.CreatedAt) {
+		t.Errorf("expected created at %v, got %v", first.CreatedAt, byID.CreatedAt)
+	}
+
+	// missing users are not an error, see UserService
+	for _, lookup := range []func() (*model.User, error){
+		func() (*model.User, error) { return s.Users.GetUserByEmail(ctx, name("missing")) },
+		func() (*model.User, error) { return s.Users.GetUserByUsername(ctx, name("missing")) },
+		func() (*model.User, error) { return s.Users.GetUserByID(ctx, -1) },
+	} {
+		user, err := lookup()
+		if user != nil || err != nil {
+			t.Errorf("expected no user and no error, got %+v, %v", user, err)
+		}
+	}
+
+	duplicates := []*model.User{
+		{Name: first.Name, Email: name("other") + "@example.com", Role: "user"},
+		{Name: name("other"), Email: first.Email, Role: "user"},
+	}
+	for _, duplicate := range duplicates {
+		_, err = s.Users.InsertUser(ctx, duplicate)
+		if err == nil {
+			t.Errorf("expected duplicate %q <%s> to be rejected", duplicate.Name, duplicate.Email)
+		}
+	}
+
+	err = s.Users.UpdateUserRole(ctx, first.ID, "admin")
+	if err != nil {
+		t.Fatalf("unable to update role: %v", err)
+	}
+	byID, _ = s.Users.GetUserByID(ctx, first.ID)
+	if byID.Role != "admin" {
+		t.Errorf("expected role admin, got %q", byID.Role)
+	}
+
+	err = s.Users.UpdateUserRole(ctx, first.ID, "owner")
+	if err == nil {
+		t.Error("expected unknown role to be rejected")
+	}
+
+	// what callers do with a returned user must not reach the storage
+	byID.Role = "user"
+	byID, _ = s.Users.GetUserByID(ctx, first.ID)
+	if byID.Role != "admin" {
+		t.Errorf("expected stored role to stay admin, got %q", byID.Role)
+	}
+
+	// prefixes ignore case and take an underscore literally
+	stem := name("pre")
+	for _, username := range []string{stem + "_b", stem + "xa", stem + "_a"} {
+		_, err = s.Users.InsertUser(ctx, &model.User{Name: username, Email: username + "@example.com", PasswordHash: "hash", CreatedAt: time.Now(), Role: "user"})
+		if err != nil {
+			t.Fatalf("unable to insert user: %v", err)
+		}
+	}
+	names, err := s.Users.GetUsernamesByPrefix(ctx, strings.ToUpper(stem)+"_", 10)
+	if err != nil || !slices.Equal(names, []string{stem + "_a", stem + "_b"}) {
+		t.Errorf("expected the 2 names with an underscore in order, got %q, %v", names, err)
+	}
+	names, err = s.Users.GetUsernamesByPrefix(ctx, stem, 1)
+	if err != nil || !slices.Equal(names, []string{stem + "_a"}) {
+		t.Errorf("expected the first name only, got %q, %v", names, err)
+	}
+}
+
+func testTopics(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	author := insertUser(ctx, t, s)
+	topic := insertTopic(ctx, t, s, author.ID)
+
+	_, err := s.Topics.InsertTopic(ctx, &model.Topic{Name: name("topic"), CreatedAt: time.Now(), AuthorId: -1})
+	if err == nil {
+		t.Error("expected topic of a missing author to be rejected")
+	}
+
+	got, err := s.Topics.GetTopicByID(ctx, topic.ID)
+	if err != nil || got.Name != topic.Name {
+		t.Fatalf("expected topic %q, got %+v, %v", topic.Name, got, err)
+	}
+
+	_, err = s.Topics.GetTopicByID(ctx, -1)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing topic, got %v", err)
+	}
+
+	all, err := s.Topics.GetAllTopics(ctx)
+	if err != nil {
+		t.Fatalf("unable to get topics: %v", err)
+	}
+	if !slices.ContainsFunc(all, func(tp *model.Topic) bool { return tp.ID == topic.ID }) {
+		t.Errorf("expected topic %d among all topics", topic.ID)
+	}
+
+	topic.Name = name("renamed")
+	topic.Description = "changed"
+	topic.Private = true
+	topic.AuthorId = -1
+	err = s.Topics.UpdateTopic(ctx, topic)
+	if err != nil {
+		t.Fatalf("unable to update topic: %v", err)
+	}
+	got, _ = s.Topics.GetTopicByID(ctx, topic.ID)
+	if got.Name != topic.Name || got.Description != "changed" || !got.Private || got.AuthorId != author.ID {
+		t.Errorf("expected name, description and privacy to change and the author to stay, got %+v", got)
+	}
+
+	post := insertPost(ctx, t, s, topic.ID, author)
+
+	byPost, err := s.Topics.GetTopicByPostID(ctx, post.ID)
+	if err != nil || byPost.ID != topic.ID {
+		t.Errorf("expected topic %d by post, got %+v, %v", topic.ID, byPost, err)
+	}
+
+	withPosts, posts, err := s.Topics.GetTopicWithPosts(ctx, topic.ID)
+	if err != nil || withPosts.ID != topic.ID || len(posts) != 1 || posts[0].ID != post.ID {
+		t.Errorf("expected topic %d with post %d, got %+v, %v, %v", topic.ID, post.ID, withPosts, posts, err)
+	}
+
+	// the same inside a transaction, where a batch cannot be used
+	err = s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		_, posts, err = s.Topics.GetTopicWithPosts(ctx, topic.ID)
+		return err
+	})
+	if err != nil || len(posts) != 1 {
+		t.Errorf("expected 1 post within a transaction, got %v, %v", posts, err)
+	}
+
+	_, _, err = s.Topics.GetTopicWithPosts(ctx, -1)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for the posts of a missing topic, got %v", err)
+	}
+
+	err = s.Topics.DeleteTopic(ctx, topic)
+	if err != nil {
+		t.Fatalf("unable to delete topic: %v", err)
+	}
+
+	_, err = s.Topics.GetTopicByID(ctx, topic.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected deleted topic to be gone, got %v", err)
+	}
+
+	_, err = s.Posts.GetPostByID(ctx, post.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected posts to be deleted with their topic, got %v", err)
+	}
+}
+
+func testPosts(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	author := insertUser(ctx, t, s)
+	topic := insertTopic(ctx, t, s, author.ID)
+	other := insertTopic(ctx, t, s, author.ID)
+
+	other.Private = true
+	err := s.Topics.UpdateTopic(ctx, other)
+	if err != nil {
+		t.Fatalf("unable to make topic private: %v", err)
+	}
+
+	first := insertPost(ctx, t, s, topic.ID, author)
+	second := insertPost(ctx, t, s, topic.ID, author)
+	hidden := insertPost(ctx, t, s, other.ID, author)
+
+	_, err = s.Posts.InsertPost(ctx, &model.Post{Title: "t", Content: "c", AuthorName: author.Name, TopicId: -1})
+	if err == nil {
+		t.Error("expected post in a missing topic to be rejected")
+	}
+
+	posts, err := s.Posts.GetPostsByTopicID(ctx, topic.ID)
+	if err != nil {
+		t.Fatalf("unable to get posts: %v", err)
+	}
+	ids := make([]int, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []int{first.ID, second.ID}) {
+		t.Errorf("expected posts %d and %d, got %v", first.ID, second.ID, ids)
+	}
+
+	_, err = s.Posts.GetPostByID(ctx, -1)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing post, got %v", err)
+	}
+
+	first.Title = name("edited")
+	first.UpdatedAt = first.UpdatedAt.Add(time.Minute)
+	err = s.Posts.UpdatePost(ctx, first)
+	if err != nil {
+		t.Fatalf("unable to update post: %v", err)
+	}
+	got, err := s.Posts.GetPostByID(ctx, first.ID)
+	if err != nil || got.Title != first.Title || !s.sameTime(got.UpdatedAt, first.UpdatedAt) {
+		t.Errorf("expected edited post, got %+v, %v", got, err)
+	}
+
+	latest, err := s.Posts.GetLatestPublicPosts(ctx, 1000)
+	if err != nil {
+		t.Fatalf("unable to get latest posts: %v", err)
+	}
+	ids = ids[:0]
+	for _, post := range latest {
+		ids = append(ids, post.ID)
+	}
+	firstAt, secondAt := slices.Index(ids, first.ID), slices.Index(ids, second.ID)
+	if firstAt < 0 || secondAt < firstAt || slices.Contains(ids, hidden.ID) {
+		t.Errorf("expected edited post %d before %d and not %d of the private topic, got %v", first.ID, second.ID, hidden.ID, ids)
+	}
+	latest, _ = s.Posts.GetLatestPublicPosts(ctx, 1)
+	if len(latest) != 1 {
+		t.Errorf("expected the limit to apply, got %d posts", len(latest))
+	}
+
+	public, err := s.Posts.GetPublicPosts(ctx, 0, 100000)
+	if err != nil {
+		t.Fatalf("unable to get public posts: %v", err)
+	}
+	ids = ids[:0]
+	for _, post := range public {
+		ids = append(ids, post.ID)
+	}
+	if !slices.IsSorted(ids) || !slices.Contains(ids, first.ID) || !slices.Contains(ids, second.ID) || slices.Contains(ids, hidden.ID) {
+		t.Errorf("expected public posts by id with %d and %d and without %d, got %v", first.ID, second.ID, hidden.ID, ids)
+	}
+	count, err := s.Posts.CountPublicPosts(ctx)
+	if err != nil || count != len(public) {
+		t.Errorf("expected %d public posts, got %d, %v", len(public), count, err)
+	}
+	page, _ := s.Posts.GetPublicPosts(ctx, len(public)-1, 10)
+	if len(page) != 1 || page[0].ID != ids[len(ids)-1] {
+		t.Errorf("expected the last public post after the offset, got %v", page)
+	}
+
+	byAuthor, err := s.Posts.GetPublicPostsByAuthorID(ctx, author.ID, 10)
+	if err != nil || len(byAuthor) != 2 || byAuthor[0].ID != second.ID || byAuthor[1].ID != first.ID {
+		t.Errorf("expected public posts %d and %d of the author newest first, got %+v, %v", second.ID, first.ID, byAuthor, err)
+	}
+	byAuthor, _ = s.Posts.GetPublicPostsByAuthorID(ctx, author.ID, 1)
+	if len(byAuthor) != 1 {
+		t.Errorf("expected the limit to apply, got %d posts", len(byAuthor))
+	}
+	count, err = s.Posts.CountPublicPostsByAuthorID(ctx, author.ID)
+	if err != nil || count != 2 {
+		t.Errorf("expected 2 public posts of the author, got %d, %v", count, err)
+	}
+	started, err := s.Topics.GetPublicTopicsByAuthorID(ctx, author.ID, 10)
+	if err != nil || len(started) != 1 || started[0].ID != topic.ID {
+		t.Errorf("expected public topic %d of the author, got %+v, %v", topic.ID, started, err)
+	}
+
+	err = s.Posts.DeletePost(ctx, second)
+	if err != nil {
+		t.Fatalf("unable to delete post: %v", err)
+	}
+	posts, _ = s.Posts.GetPostsByTopicID(ctx, topic.ID)
+	if len(posts) != 1 {
+		t.Errorf("expected 1 post after delete, got %d", len(posts))
+	}
+
+	// deleting again is not an error, like DELETE of a missing row
+	err = s.Posts.DeletePost(ctx, second)
+	if err != nil {
+		t.Errorf("expected deleting a missing post to succeed, got %v", err)
+	}
+}
+
+func testIdentities(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	user := insertUser(ctx, t, s)
+	identity := &model.Identity{UserID: user.ID, Provider: "sso", Subject: name("sub"), Email: user.Email, CreatedAt: time.Now()}
+
+	_, err := s.Identities.InsertIdentity(ctx, identity)
+	if err != nil || identity.ID == 0 {
+		t.Fatalf("unable to insert identity: %v", err)
+	}
+
+	got, err := s.Identities.GetIdentity(ctx, "sso", identity.Subject)
+	if err != nil || got == nil || got.UserID != user.ID {
+		t.Errorf("expected identity of user %d, got %+v, %v", user.ID, got, err)
+	}
+
+	got, err = s.Identities.GetIdentity(ctx, "other", identity.Subject)
+	if got != nil || err != nil {
+		t.Errorf("expected no identity for another provider, got %+v, %v", got, err)
+	}
+
+	_, err = s.Identities.InsertIdentity(ctx, &model.Identity{UserID: user.ID, Provider: "sso", Subject: identity.Subject, Email: user.Email})
+	if err == nil {
+		t.Error("expected duplicate identity to be rejected")
+	}
+
+	_, err = s.Identities.InsertIdentity(ctx, &model.Identity{UserID: -1, Provider: "sso", Subject: name("sub"), Email: user.Email})
+	if err == nil {
+		t.Error("expected identity of a missing user to be rejected")
+	}
+}
+
+func testTokens(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	user := insertUser(ctx, t, s)
+	other := insertUser(ctx, t, s)
+
+	now := time.Now()
+	older := &model.AccessToken{UserID: user.ID, Name: "older", Hash: name("hash"), Scopes: []string{"read"}, CreatedAt: now.Add(-time.Hour)}
+	expires := now.Add(time.Hour)
+	newer := &model.AccessToken{UserID: user.ID, Name: "newer", Hash: name("hash"), Scopes: []string{"read", "post"}, ExpiresAt: &expires, CreatedAt: now}
+
+	for _, token := range []*model.AccessToken{older, newer} {
+		_, err := s.Tokens.InsertToken(ctx, token)
+		if err != nil {
+			t.Fatalf("unable to insert token: %v", err)
+		}
+	}
+
+	_, err := s.Tokens.InsertToken(ctx, &model.AccessToken{UserID: user.ID, Name: "copy", Hash: older.Hash, Scopes: []string{"read"}, CreatedAt: now})
+	if err == nil {
+		t.Error("expected duplicate token hash to be rejected")
+	}
+
+	tokens, err := s.Tokens.GetTokensByUserID(ctx, user.ID)
+	if err != nil || len(tokens) != 2 || tokens[0].ID != newer.ID {
+		t.Fatalf("expected 2 tokens newest first, got %+v, %v", tokens, err)
+	}
+	if !slices.Equal(tokens[0].Scopes, newer.Scopes) || tokens[0].ExpiresAt == nil || tokens[0].LastUsedAt != nil {
+		t.Errorf("expected scopes, expiry and no last use to round-trip, got %+v", tokens[0])
+	}
+
+	got, err := s.Tokens.GetTokenByHash(ctx, name("missing"))
+	if got != nil || err != nil {
+		t.Errorf("expected no token for an unknown hash, got %+v, %v", got, err)
+	}
+
+	err = s.Tokens.TouchToken(ctx, older.ID, now)
+	if err != nil {
+		t.Fatalf("unable to touch token: %v", err)
+	}
+	got, _ = s.Tokens.GetTokenByHash(ctx, older.Hash)
+	if got == nil || got.LastUsedAt == nil || !s.sameTime(*got.LastUsedAt, now) {
+		t.Errorf("expected last use %v, got %+v", now, got)
+	}
+
+	err = s.Tokens.DeleteToken(ctx, older.ID, other.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows deleting another user's token, got %v", err)
+	}
+
+	err = s.Tokens.DeleteToken(ctx, older.ID, user.ID)
+	if err != nil {
+		t.Errorf("unable to delete token: %v", err)
+	}
+	tokens, _ = s.Tokens.GetTokensByUserID(ctx, user.ID)
+	if len(tokens) != 1 {
+		t.Errorf("expected 1 token after delete, got %d", len(tokens))
+	}
+}
+
+func testProfiles(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	user := insertUser(ctx, t, s)
+
+	got, err := s.Profiles.GetProfile(ctx, user.ID)
+	if got != nil || err != nil {
+		t.Errorf("expected no profile before one is saved, got %+v, %v", got, err)
+	}
+
+	profile := &model.Profile{
+		UserID:      user.ID,
+		DisplayName: "Ada",
+		Bio:         "bio",
+		Location:    "London",
+		Website:     "https://example.com",
+		Signature:   "signature",
+		UpdatedAt:   time.Now(),
+	}
+	err = s.Profiles.SaveProfile(ctx, profile)
+	if err != nil {
+		t.Fatalf("unable to save profile: %v", err)
+	}
+	got, err = s.Profiles.GetProfile(ctx, user.ID)
+	if err != nil || got == nil || got.DisplayName != "Ada" || got.Website != profile.Website || got.ShowEmail || !s.sameTime(got.UpdatedAt, profile.UpdatedAt) {
+		t.Errorf("expected profile to round-trip, got %+v, %v", got, err)
+	}
+
+	profile.Bio = ""
+	profile.ShowEmail = true
+	err = s.Profiles.SaveProfile(ctx, profile)
+	if err != nil {
+		t.Fatalf("unable to update profile: %v", err)
+	}
+	got, _ = s.Profiles.GetProfile(ctx, user.ID)
+	if got == nil || got.Bio != "" || !got.ShowEmail || got.Location != "London" {
+		t.Errorf("expected updated profile, got %+v", got)
+	}
+
+	err = s.Profiles.SaveProfile(ctx, &model.Profile{UserID: -1, UpdatedAt: time.Now()})
+	if err == nil {
+		t.Error("expected profile of a missing user to be rejected")
+	}
+
+	err = s.Profiles.SaveAvatar(ctx, user.ID, "0123abcd", time.Now())
+	if err != nil {
+		t.Fatalf("unable to save avatar: %v", err)
+	}
+	profile.Avatar = ""
+	err = s.Profiles.SaveProfile(ctx, profile)
+	if err != nil {
+		t.Fatalf("unable to update profile: %v", err)
+	}
+	got, _ = s.Profiles.GetProfile(ctx, user.ID)
+	if got == nil || got.Avatar != "0123abcd" || got.DisplayName != "Ada" {
+		t.Errorf("expected saving the profile to keep the avatar, got %+v", got)
+	}
+
+	// a user without a profile gets one with the avatar
+	other := insertUser(ctx, t, s)
+	err = s.Profiles.SaveAvatar(ctx, other.ID, "4567cdef", time.Now())
+	if err != nil {
+		t.Fatalf("unable to save avatar: %v", err)
+	}
+	got, _ = s.Profiles.GetProfile(ctx, other.ID)
+	if got == nil || got.Avatar != "4567cdef" || got.DisplayName != "" {
+		t.Errorf("expected an empty profile with the avatar, got %+v", got)
+	}
+
+	err = s.Profiles.SaveAvatar(ctx, -1, "89ab", time.Now())
+	if err == nil {
+		t.Error("expected avatar of a missing user to be rejected")
+	}
+}
+
+func testAttachments(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	user := insertUser(ctx, t, s)
+	topic := insertTopic(ctx, t, s, user.ID)
+	post := insertPost(ctx, t, s, topic.ID, user)
+	other := insertPost(ctx, t, s, topic.ID, user)
+
+	sum := fmt.Sprintf("%064d", unique.Add(1))
+	first := &model.Attachment{PostID: post.ID, UserID: user.ID, Filename: "app.log", ContentType: "text/plain; charset=utf-8", Size: 100, SHA256: sum, CreatedAt: time.Now()}
+	second := &model.Attachment{PostID: post.ID, UserID: user.ID, Filename: "shot.png", ContentType: "image/png", Size: 2000, SHA256: name("sha"), CreatedAt: time.Now()}
+	copied := &model.Attachment{PostID: other.ID, UserID: user.ID, Filename: "copy.log", ContentType: "text/plain; charset=utf-8", Size: 100, SHA256: sum, CreatedAt: time.Now()}
+
+	for _, attachment := range []*model.Attachment{first, second, copied} {
+		_, err := s.Attachments.InsertAttachment(ctx, attachment)
+		if err != nil {
+			t.Fatalf("unable to insert attachment: %v", err)
+		}
+		// saving the blob again only touches its row
+		err = s.Attachments.SaveAttachmentBlob(ctx, attachment.SHA256)
+		if err != nil {
+			t.Fatalf("unable to save attachment blob: %v", err)
+		}
+	}
+
+	_, err := s.Attachments.InsertAttachment(ctx, &model.Attachment{PostID: -1, UserID: user.ID, Filename: "x", ContentType: "text/plain", SHA256: sum, CreatedAt: time.Now()})
+	if err == nil {
+		t.Error("expected attachment of a missing post to be rejected")
+	}
+
+	got, err := s.Attachments.GetAttachmentByID(ctx, first.ID)
+	if err != nil || got.PostID != post.ID || got.Filename != "app.log" || got.Size != 100 || got.SHA256 != sum || !s.sameTime(got.CreatedAt, first.CreatedAt) {
+		t.Errorf("expected attachment to round-trip, got %+v, %v", got, err)
+	}
+	_, err = s.Attachments.GetAttachmentByID(ctx, -1)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing attachment, got %v", err)
+	}
+
+	attachments, err := s.Attachments.GetAttachmentsByPostID(ctx, post.ID)
+	if err != nil || len(attachments) != 2 || attachments[0].ID != first.ID || attachments[1].ID != second.ID {
+		t.Errorf("expected 2 attachments in the order added, got %+v, %v", attachments, err)
+	}
+
+	total, err := s.Attachments.SumAttachmentSizesByUserID(ctx, user.ID)
+	if err != nil || total != 2200 {
+		t.Errorf("expected 2200 bytes uploaded, counting the copy, got %d, %v", total, err)
+	}
+	total, _ = s.Attachments.SumAttachmentSizesByUserID(ctx, insertUser(ctx, t, s).ID)
+	if total != 0 {
+		t.Errorf("expected nothing uploaded by a new user, got %d", total)
+	}
+
+	unused, err := s.Attachments.DeleteUnusedAttachmentBlob(ctx, sum)
+	if err != nil || unused {
+		t.Errorf("expected the blob of 2 attachments kept, got %v, %v", unused, err)
+	}
+
+	// a pending attachment has no post until it is attached
+	pending := &model.Attachment{UserID: user.ID, Filename: "draft.log", ContentType: "text/plain; charset=utf-8", Size: 10, SHA256: name("sha"), CreatedAt: time.Now(), Pending: true}
+	_, err = s.Attachments.InsertAttachment(ctx, pending)
+	if err != nil {
+		t.Fatalf("unable to insert pending attachment: %v", err)
+	}
+	got, err = s.Attachments.GetAttachmentByID(ctx, pending.ID)
+	if err != nil || got.PostID != 0 || !got.Pending {
+		t.Errorf("expected the attachment pending without a post, got %+v, %v", got, err)
+	}
+
+	detached, err := s.Attachments.GetDetachedAttachments(ctx, time.Now().Add(-time.Hour), 1000)
+	if err != nil || slices.ContainsFunc(detached, func(a *model.Attachment) bool { return a.ID == pending.ID }) {
+		t.Errorf("expected a recent pending attachment not to be detached, got %+v, %v", detached, err)
+	}
+	detached, err = s.Attachments.GetDetachedAttachments(ctx, time.Now().Add(time.Hour), 1000)
+	if err != nil || !slices.ContainsFunc(detached, func(a *model.Attachment) bool { return a.ID == pending.ID }) {
+		t.Errorf("expected an old pending attachment among the detached, got %+v, %v", detached, err)
+	}
+
+	err = s.Attachments.AttachAttachment(ctx, pending.ID, other.ID)
+	if err != nil {
+		t.Fatalf("unable to attach attachment: %v", err)
+	}
+	got, err = s.Attachments.GetAttachmentByID(ctx, pending.ID)
+	if err != nil || got.PostID != other.ID || got.Pending {
+		t.Errorf("expected the attachment attached to the post, got %+v, %v", got, err)
+	}
+	err = s.Attachments.AttachAttachment(ctx, pending.ID, post.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows attaching an attachment twice, got %v", err)
+	}
+
+	// deleting the post leaves its attachments to be collected
+	err = s.Posts.DeletePost(ctx, post)
+	if err != nil {
+		t.Fatalf("unable to delete post: %v", err)
+	}
+	got, err = s.Attachments.GetAttachmentByID(ctx, first.ID)
+	if err != nil || got.PostID != 0 {
+		t.Errorf("expected the attachment detached from the deleted post, got %+v, %v", got, err)
+	}
+
+	detached, err = s.Attachments.GetDetachedAttachments(ctx, time.Now(), 1000)
+	if err != nil || !slices.ContainsFunc(detached, func(a *model.Attachment) bool { return a.ID == second.ID }) {
+		t.Errorf("expected the attachments of the deleted post among the detached, got %+v, %v", detached, err)
+	}
+	if slices.ContainsFunc(detached, func(a *model.Attachment) bool { return a.ID == copied.ID }) {
+		t.Error("expected the attachment of another post not to be detached")
+	}
+
+	for _, attachment := range []*model.Attachment{first, second} {
+		err = s.Attachments.DeleteAttachment(ctx, attachment.ID)
+		if err != nil {
+			t.Fatalf("unable to delete attachment: %v", err)
+		}
+	}
+	unused, err = s.Attachments.DeleteUnusedAttachmentBlob(ctx, sum)
+	if err != nil || unused {
+		t.Errorf("expected the blob of the copy kept, got %v, %v", unused, err)
+	}
+	unused, err = s.Attachments.DeleteUnusedAttachmentBlob(ctx, second.SHA256)
+	if err != nil || !unused {
+		t.Errorf("expected the blob of no attachment deleted, got %v, %v", unused, err)
+	}
+	unused, _ = s.Attachments.DeleteUnusedAttachmentBlob(ctx, second.SHA256)
+	if unused {
+		t.Error("expected a deleted blob not to be deleted again")
+	}
+}
+
+func testMentions(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	author := insertUser(ctx, t, s)
+	first := insertUser(ctx, t, s)
+	second := insertUser(ctx, t, s)
+	topic := insertTopic(ctx, t, s, author.ID)
+	post := insertPost(ctx, t, s, topic.ID, author)
+
+	for _, user := range []*model.User{second, first} {
+		created, err := s.Mentions.InsertMention(ctx, &model.Mention{PostID: post.ID, UserID: user.ID, CreatedAt: time.Now()})
+		if err != nil || !created {
+			t.Fatalf("unable to insert mention: %v, %v", created, err)
+		}
+	}
+
+	created, err := s.Mentions.InsertMention(ctx, &model.Mention{PostID: post.ID, UserID: first.ID, CreatedAt: time.Now()})
+	if err != nil || created {
+		t.Errorf("expected a second mention of the same user to be skipped, got %v, %v", created, err)
+	}
+	_, err = s.Mentions.InsertMention(ctx, &model.Mention{PostID: -1, UserID: first.ID, CreatedAt: time.Now()})
+	if err == nil {
+		t.Error("expected mention in a missing post to be rejected")
+	}
+	_, err = s.Mentions.InsertMention(ctx, &model.Mention{PostID: post.ID, UserID: -1, CreatedAt: time.Now()})
+	if err == nil {
+		t.Error("expected mention of a missing user to be rejected")
+	}
+
+	mentions, err := s.Mentions.GetMentionsByPostID(ctx, post.ID)
+	if err != nil || len(mentions) != 2 || mentions[0].UserID != first.ID || mentions[0].UserName != first.Name || mentions[1].UserID != second.ID {
+		t.Errorf("expected 2 mentions by name, got %+v, %v", mentions, err)
+	}
+
+	err = s.Posts.DeletePost(ctx, post)
+	if err != nil {
+		t.Fatalf("unable to delete post: %v", err)
+	}
+	mentions, err = s.Mentions.GetMentionsByPostID(ctx, post.ID)
+	if err != nil || len(mentions) != 0 {
+		t.Errorf("expected the mentions deleted with the post, got %+v, %v", mentions, err)
+	}
+}
+
+func testMessages(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	ada, bob, cy := insertUser(ctx, t, s), insertUser(ctx, t, s), insertUser(ctx, t, s)
+
+	now := time.Now()
+	insertConversation := func(subject string, at time.Time, users ...*model.User) *model.Conversation {
+		t.Helper()
+
+		conversation := &model.Conversation{Subject: subject, CreatedAt: at, LastMessageAt: at}
+		_, err := s.Messages.InsertConversation(ctx, conversation)
+		if err != nil {
+			t.Fatalf("unable to insert conversation: %v", err)
+		}
+		for _, user := range users {
+			err = s.Messages.InsertParticipant(ctx, &model.Participant{ConversationID: conversation.ID, UserID: user.ID, JoinedAt: at})
+			if err != nil {
+				t.Fatalf("unable to insert participant: %v", err)
+			}
+		}
+		return conversation
+	}
+	insertMessage := func(conversation *model.Conversation, user *model.User, body string) *model.Message {
+		t.Helper()
+
+		message := &model.Message{ConversationID: conversation.ID, UserID: user.ID, Body: body, CreatedAt: time.Now()}
+		_, err := s.Messages.InsertMessage(ctx, message)
+		if err != nil {
+			t.Fatalf("unable to insert message: %v", err)
+		}
+		return message
+	}
+
+	group := insertConversation("group", now.Add(-time.Hour), ada, bob, cy)
+	pair := insertConversation("pair", now, ada, bob)
+
+	err := s.Messages.InsertParticipant(ctx, &model.Participant{ConversationID: group.ID, UserID: ada.ID, JoinedAt: now})
+	if err == nil {
+		t.Error("expected a participant added twice to be rejected")
+	}
+	err = s.Messages.InsertParticipant(ctx, &model.Participant{ConversationID: -1, UserID: ada.ID, JoinedAt: now})
+	if err == nil {
+		t.Error("expected a participant of a missing conversation to be rejected")
+	}
+	_, err = s.Messages.InsertMessage(ctx, &model.Message{ConversationID: -1, UserID: ada.ID, Body: "lost", CreatedAt: now})
+	if err == nil {
+		t.Error("expected a message to a missing conversation to be rejected")
+	}
+
+	got, err := s.Messages.GetConversationByID(ctx, group.ID)
+	if err != nil || got.Subject != "group" || !s.sameTime(got.CreatedAt, group.CreatedAt) || !s.sameTime(got.LastMessageAt, group.LastMessageAt) {
+		t.Errorf("expected conversation to round-trip, got %+v, %v", got, err)
+	}
+	_, err = s.Messages.GetConversationByID(ctx, -1)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing conversation, got %v", err)
+	}
+
+	first := insertMessage(group, ada, "hello")
+	second := insertMessage(group, bob, "hi")
+	third := insertMessage(group, bob, "how are you?")
+
+	messages, err := s.Messages.GetMessagesByConversationID(ctx, group.ID)
+	if err != nil || len(messages) != 3 || messages[0].ID != first.ID || messages[2].ID != third.ID {
+		t.Fatalf("expected 3 messages in the order sent, got %+v, %v", messages, err)
+	}
+	if messages[1].UserName != bob.Name || messages[1].Body != "hi" || !s.sameTime(messages[1].CreatedAt, second.CreatedAt) {
+		t.Errorf("expected message to round-trip with the name of its author, got %+v", messages[1])
+	}
+
+	// read markers only move forward
+	for _, id := range []int{first.ID, third.ID, second.ID} {
+		err = s.Messages.MarkRead(ctx, group.ID, ada.ID, id)
+		if err != nil {
+			t.Fatalf("unable to mark read: %v", err)
+		}
+	}
+	err = s.Messages.MarkRead(ctx, group.ID, bob.ID, first.ID)
+	if err != nil {
+		t.Fatalf("unable to mark read: %v", err)
+	}
+
+	// messages of others after the marker are unread
+	for _, tt := range []struct {
+		user *model.User
+		want int
+	}{{ada, 0}, {bob, 0}, {cy, 3}} {
+		count, err := s.Messages.CountUnreadMessages(ctx, tt.user.ID)
+		if err != nil || count != tt.want {
+			t.Errorf("expected %d unread for %s, got %d, %v", tt.want, tt.user.Name, count, err)
+		}
+	}
+
+	participants, err := s.Messages.GetParticipants(ctx, group.ID)
+	if err != nil || len(participants) != 3 || participants[0].UserID != ada.ID || participants[0].UserName != ada.Name {
+		t.Fatalf("expected 3 participants in the order they joined, got %+v, %v", participants, err)
+	}
+	if participants[0].LastReadID != third.ID || !participants[0].Active() || !s.sameTime(participants[0].JoinedAt, group.CreatedAt) {
+		t.Errorf("expected the marker at the newest message, got %+v", participants[0])
+	}
+
+	conversations, err := s.Messages.GetConversationsByUserID(ctx, ada.ID, 10)
+	if err != nil || len(conversations) != 2 || conversations[0].ID != pair.ID || conversations[1].ID != group.ID {
+		t.Fatalf("expected both conversations, the newest first, got %+v, %v", conversations, err)
+	}
+	conversations, _ = s.Messages.GetConversationsByUserID(ctx, ada.ID, 1)
+	if len(conversations) != 1 {
+		t.Errorf("expected the limit kept, got %d", len(conversations))
+	}
+
+	// a muted conversation is still listed as unread but not counted
+	err = s.Messages.SetMuted(ctx, group.ID, cy.ID, true)
+	if err != nil {
+		t.Fatalf("unable to mute: %v", err)
+	}
+	count, _ := s.Messages.CountUnreadMessages(ctx, cy.ID)
+	if count != 0 {
+		t.Errorf("expected a muted conversation not counted, got %d", count)
+	}
+	conversations, err = s.Messages.GetConversationsByUserID(ctx, cy.ID, 10)
+	if err != nil || len(conversations) != 1 || !conversations[0].Muted || conversations[0].Unread != 3 || conversations[0].LastReadID != 0 {
+		t.Errorf("expected the muted conversation with 3 unread, got %+v, %v", conversations, err)
+	}
+
+	err = s.Messages.SetLastMessageAt(ctx, group.ID, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("unable to set last message time: %v", err)
+	}
+	conversations, _ = s.Messages.GetConversationsByUserID(ctx, ada.ID, 10)
+	if len(conversations) != 2 || conversations[0].ID != group.ID {
+		t.Errorf("expected the conversation with the newest message first, got %+v", conversations)
+	}
+
+	// leaving hides the conversation but keeps the participant
+	left := now.Add(2 * time.Minute)
+	err = s.Messages.LeaveConversation(ctx, group.ID, bob.ID, left)
+	if err != nil {
+		t.Fatalf("unable to leave: %v", err)
+	}
+	conversations, _ = s.Messages.GetConversationsByUserID(ctx, bob.ID, 10)
+	if len(conversations) != 1 || conversations[0].ID != pair.ID {
+		t.Errorf("expected only the conversation not left, got %+v", conversations)
+	}
+	participants, _ = s.Messages.GetParticipants(ctx, group.ID)
+	if len(participants) != 3 || participants[1].Active() || !s.sameTime(*participants[1].LeftAt, left) {
+		t.Errorf("expected the participant marked as left, got %+v", participants)
+	}
+
+	participants, err = s.Messages.GetParticipantsByUserID(ctx, ada.ID)
+	if err != nil || len(participants) != 5 {
+		t.Errorf("expected the participants of both conversations, got %d, %v", len(participants), err)
+	}
+	participants, _ = s.Messages.GetParticipantsByUserID(ctx, bob.ID)
+	if len(participants) != 2 || participants[0].ConversationID != pair.ID {
+		t.Errorf("expected the participants of the conversation not left, got %+v", participants)
+	}
+
+	err = s.Messages.DeleteConversation(ctx, group.ID)
+	if err != nil {
+		t.Fatalf("unable to delete conversation: %v", err)
+	}
+	_, err = s.Messages.GetConversationByID(ctx, group.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the conversation deleted, got %v", err)
+	}
+	messages, _ = s.Messages.GetMessagesByConversationID(ctx, group.ID)
+	participants, _ = s.Messages.GetParticipants(ctx, group.ID)
+	if len(messages) != 0 || len(participants) != 0 {
+		t.Errorf("expected messages and participants deleted with the conversation, got %d and %d", len(messages), len(participants))
+	}
+}
+
+func testBlocks(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	ada, bob, cy := insertUser(ctx, t, s), insertUser(ctx, t, s), insertUser(ctx, t, s)
+
+	for _, block := range []*model.UserBlock{
+		{UserID: ada.ID, BlockedUserID: bob.ID, CreatedAt: time.Now()},
+		{UserID: cy.ID, BlockedUserID: bob.ID, CreatedAt: time.Now()},
+		// blocking twice keeps the block
+		{UserID: ada.ID, BlockedUserID: bob.ID, CreatedAt: time.Now()},
+	} {
+		err := s.Blocks.InsertBlock(ctx, block)
+		if err != nil {
+			t.Fatalf("unable to insert block: %v", err)
+		}
+	}
+
+	err := s.Blocks.InsertBlock(ctx, &model.UserBlock{UserID: ada.ID, BlockedUserID: ada.ID, CreatedAt: time.Now()})
+	if err == nil {
+		t.Error("expected a user blocking themselves to be rejected")
+	}
+
+	blockers, err := s.Blocks.GetBlockerIDs(ctx, bob.ID)
+	if err != nil || !slices.Equal(blockers, []int{ada.ID, cy.ID}) {
+		t.Errorf("expected bob blocked by ada and cy, got %v, %v", blockers, err)
+	}
+
+	blocks, err := s.Blocks.GetBlocksByUserID(ctx, ada.ID)
+	if err != nil || len(blocks) != 1 || blocks[0].BlockedUserID != bob.ID || blocks[0].BlockedUserName != bob.Name {
+		t.Errorf("expected ada to block bob, got %+v, %v", blocks, err)
+	}
+
+	err = s.Blocks.DeleteBlock(ctx, ada.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("unable to delete block: %v", err)
+	}
+	blockers, _ = s.Blocks.GetBlockerIDs(ctx, bob.ID)
+	if !slices.Equal(blockers, []int{cy.ID}) {
+		t.Errorf("expected bob blocked by cy only, got %v", blockers)
+	}
+
+	ban, err := s.Blocks.GetMessagingBan(ctx, bob.ID)
+	if err != nil || ban != nil {
+		t.Errorf("expected no ban, got %+v, %v", ban, err)
+	}
+
+	saved := &model.MessagingBan{UserID: bob.ID, BannedBy: ada.ID, Reason: "spam", CreatedAt: time.Now()}
+	for _, reason := range []string{"ads", "spam"} {
+		saved.Reason = reason
+		err = s.Blocks.SaveMessagingBan(ctx, saved)
+		if err != nil {
+			t.Fatalf("unable to save ban: %v", err)
+		}
+	}
+	err = s.Blocks.SaveMessagingBan(ctx, &model.MessagingBan{UserID: cy.ID, CreatedAt: time.Now().Add(time.Second)})
+	if err != nil {
+		t.Fatalf("unable to save ban without an admin: %v", err)
+	}
+
+	ban, err = s.Blocks.GetMessagingBan(ctx, bob.ID)
+	if err != nil || ban == nil || ban.UserName != bob.Name || ban.BannedBy != ada.ID || ban.Reason != "spam" || !s.sameTime(ban.CreatedAt, saved.CreatedAt) {
+		t.Errorf("expected the ban to round-trip with the last reason, got %+v, %v", ban, err)
+	}
+
+	bans, err := s.Blocks.GetMessagingBans(ctx)
+	if err != nil {
+		t.Fatalf("unable to get bans: %v", err)
+	}
+	i := slices.IndexFunc(bans, func(b *model.MessagingBan) bool { return b.UserID == cy.ID })
+	j := slices.IndexFunc(bans, func(b *model.MessagingBan) bool { return b.UserID == bob.ID })
+	if i < 0 || j < 0 || i > j || bans[i].BannedBy != 0 {
+		t.Errorf("expected both bans, the newest first, got %+v", bans)
+	}
+
+	err = s.Blocks.DeleteMessagingBan(ctx, bob.ID)
+	if err != nil {
+		t.Fatalf("unable to delete ban: %v", err)
+	}
+	ban, _ = s.Blocks.GetMessagingBan(ctx, bob.ID)
+	if ban != nil {
+		t.Errorf("expected the ban lifted, got %+v", ban)
+	}
+}
+
+func testThrottles(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	now := time.Now()
+	window := now.Add(-15 * time.Minute)
+
+	lockedKey, staleKey, recentKey := name("ip:"), name("ip:"), name("ip:")
+
+	// failures long ago, one of them locked for another hour
+	for _, key := range []string{lockedKey, staleKey} {
+		for i := 0; i < 3; i++ {
+			_, err := s.Throttles.CountFailure(ctx, key, now.Add(-2*time.Hour), now.Add(-3*time.Hour))
+			if err != nil {
+				t.Fatalf("unable to count failure: %v", err)
+			}
+		}
+	}
+	locked, err := s.Throttles.LockThrottle(ctx, lockedKey, 3, now.Add(-2*time.Hour), now.Add(time.Hour))
+	if err != nil || !locked {
+		t.Fatalf("expected the throttle to be locked, got %v, %v", locked, err)
+	}
+	locked, err = s.Throttles.LockThrottle(ctx, staleKey, 4, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if err != nil || locked {
+		t.Errorf("expected too few failures not to lock, got %v, %v", locked, err)
+	}
+
+	// a failure outside the window starts over unless the key is locked
+	got, err := s.Throttles.CountFailure(ctx, staleKey, now, window)
+	if err != nil || got.Failures != 1 {
+		t.Errorf("expected the stale count to start over, got %+v, %v", got, err)
+	}
+	got, err = s.Throttles.CountFailure(ctx, lockedKey, now, window)
+	if err != nil || got.Failures != 4 || got.LockedUntil == nil {
+		t.Errorf("expected the locked count to go on, got %+v, %v", got, err)
+	}
+
+	// concurrent failures are all counted and only one of them locks
+	var wg sync.WaitGroup
+	var locks atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Throttles.CountFailure(ctx, recentKey, now, window)
+			if err != nil {
+				t.Errorf("unable to count failure: %v", err)
+				return
+			}
+			locked, err := s.Throttles.LockThrottle(ctx, recentKey, 5, now, now.Add(time.Minute))
+			if err != nil {
+				t.Errorf("unable to lock throttle: %v", err)
+			}
+			if locked {
+				locks.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err = s.Throttles.GetThrottle(ctx, recentKey)
+	if err != nil || got == nil || got.Failures != 10 || got.LockedUntil == nil {
+		t.Errorf("expected 10 failures and a lock, got %+v, %v", got, err)
+	}
+	if n := locks.Load(); n != 1 {
+		t.Errorf("expected exactly one lock, got %d", n)
+	}
+
+	// the lock on recentKey has expired by then
+	later := now.Add(2 * time.Minute)
+	lockedThrottles, err := s.Throttles.GetLockedThrottles(ctx, later)
+	if err != nil {
+		t.Fatalf("unable to get locked throttles: %v", err)
+	}
+	keys := make([]string, 0, len(lockedThrottles))
+	for _, throttle := range lockedThrottles {
+		keys = append(keys, throttle.Key)
+	}
+	if !slices.Contains(keys, lockedKey) || slices.Contains(keys, staleKey) || slices.Contains(keys, recentKey) {
+		t.Errorf("expected only %q to be locked, got %v", lockedKey, keys)
+	}
+
+	err = s.Throttles.DeleteStaleThrottles(ctx, later.Add(-30*time.Second))
+	if err != nil {
+		t.Fatalf("unable to delete stale throttles: %v", err)
+	}
+	for key, kept := range map[string]bool{lockedKey: true, staleKey: false, recentKey: false} {
+		got, err = s.Throttles.GetThrottle(ctx, key)
+		if err != nil || (got != nil) != kept {
+			t.Errorf("%s: expected kept %t, got %+v, %v", key, kept, got, err)
+		}
+	}
+
+	err = s.Throttles.DeleteThrottle(ctx, lockedKey)
+	if err != nil {
+		t.Fatalf("unable to delete throttle: %v", err)
+	}
+	got, err = s.Throttles.GetThrottle(ctx, lockedKey)
+	if got != nil || err != nil {
+		t.Errorf("expected deleted throttle to be gone, got %+v, %v", got, err)
+	}
+}
+
+func testAudit(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	actor := name("actor")
+	// stored exactly, so the range below has exact bounds
+	now := time.Now().Truncate(s.precision())
+
+	var ids []int64
+	for i, action := range []string{"post.create", "post.delete", "post.create"} {
+		entry := &model.AuditEntry{
+			CreatedAt:  now.Add(time.Duration(i) * time.Minute),
+			ActorName:  actor,
+			Action:     action,
+			TargetType: "post",
+			TargetID:   i + 1,
+			After:      `{"id":1}`,
+		}
+		id, err := s.Audit.InsertEntry(ctx, entry)
+		if err != nil {
+			t.Fatalf("unable to insert entry: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	tests := []struct {
+		name   string
+		filter model.AuditFilter
+		want   []int64
+	}{
+		{name: "Newest First", filter: model.AuditFilter{Actor: actor}, want: []int64{ids[2], ids[1], ids[0]}},
+		{name: "By Action", filter: model.AuditFilter{Actor: actor, Action: "post.create"}, want: []int64{ids[2], ids[0]}},
+		{name: "By Target", filter: model.AuditFilter{Actor: actor, TargetType: "post", TargetID: 2}, want: []int64{ids[1]}},
+		{name: "Time Range", filter: model.AuditFilter{Actor: actor, From: now.Add(time.Minute), To: now.Add(2 * time.Minute)}, want: []int64{ids[1]}},
+		{name: "Paged", filter: model.AuditFilter{Actor: actor, Limit: 1, Offset: 1}, want: []int64{ids[1]}},
+	}
+
+	for _, tt := range tests {
+		entries, err := s.Audit.GetEntries(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: unable to get entries: %v", tt.name, err)
+		}
+
+		got := make([]int64, 0, len(entries))
+		for _, entry := range entries {
+			got = append(got, entry.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected entries %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func testTransactions(t *testing.T, s Storages) {
+	ctx := context.Background()
+
+	author := insertUser(ctx, t, s)
+	topic := insertTopic(ctx, t, s, author.ID)
+
+	failed := errors.New("failed")
+	var inserted int
+	err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		inserted = insertPost(ctx, t, s, topic.ID, author).ID
+
+		topic.Name = name("renamed")
+		err := s.Topics.UpdateTopic(ctx, topic)
+		if err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected the error of the unit of work, got %v", err)
+	}
+
+	_, err = s.Posts.GetPostByID(ctx, inserted)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the insert to be rolled back, got %v", err)
+	}
+	got, _ := s.Topics.GetTopicByID(ctx, topic.ID)
+	if got.Name == topic.Name {
+		t.Error("expected the update to be rolled back")
+	}
+
+	// a nested call joins the outer transaction
+	err = s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		return s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+			inserted = insertPost(ctx, t, s, topic.ID, author).ID
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("unable to commit: %v", err)
+	}
+
+	_, err = s.Posts.GetPostByID(ctx, inserted)
+	if err != nil {
+		t.Errorf("expected the committed post, got %v", err)
+	}
+}
+
+func testCancelled(t *testing.T, s Storages) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.Topics.GetAllTopics(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
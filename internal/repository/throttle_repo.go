@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"simple-forum/internal/model"
+	"time"
+)
+
+type ThrottleRepository struct {
+	conn    *sql.DB
+	timeout time.Duration
+}
+
+func NewThrottleRepository(conn *sql.DB, timeout time.Duration) *ThrottleRepository {
+	return &ThrottleRepository{conn: conn, timeout: timeout}
+}
+
+func scanThrottle(row interface{ Scan(dest ...any) error }) (*model.LoginThrottle, error) {
+	throttle := new(model.LoginThrottle)
+
+	var lockedUntil sql.NullTime
+
+	err := row.Scan(
+		&throttle.Key,
+		&throttle.Failures,
+		&throttle.LastFailureAt,
+		&lockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lockedUntil.Valid {
+		throttle.LockedUntil = &lockedUntil.Time
+	}
+
+	return throttle, nil
+}
+
+func (t *ThrottleRepository) GetThrottle(ctx context.Context, key string) (*model.LoginThrottle, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `SELECT key, failures, last_failure_at, locked_until FROM login_throttles WHERE key = $1`
+
+	throttle, err := scanThrottle(executor(ctx, t.conn).QueryRowContext(ctx, query, key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return throttle, nil
+}
+
+func (t *ThrottleRepository) GetLockedThrottles(ctx context.Context, now time.Time) ([]*model.LoginThrottle, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `SELECT key, failures, last_failure_at, locked_until FROM login_throttles WHERE locked_until > $1 ORDER BY locked_until DESC`
+
+	rows, err := executor(ctx, t.conn).QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var throttles []*model.LoginThrottle
+	for rows.Next() {
+		throttle, err := scanThrottle(rows)
+		if err != nil {
+			return nil, err
+		}
+		throttles = append(throttles, throttle)
+	}
+	return throttles, rows.Err()
+}
+
+// CountFailure records a failure of key at now in a single statement, so
+// concurrent failures are all counted. The count starts over when the last
+// failure was before since and key is not locked.
+func (t *ThrottleRepository) CountFailure(ctx context.Context, key string, now, since time.Time) (*model.LoginThrottle, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `INSERT INTO login_throttles (key, failures, last_failure_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttles.last_failure_at < $3
+					AND (login_throttles.locked_until IS NULL OR login_throttles.locked_until <= $2) THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING key, failures, last_failure_at, locked_until`
+
+	return scanThrottle(executor(ctx, t.conn).QueryRowContext(ctx, query, key, now, since))
+}
+
+// LockThrottle locks key until until if it has at least failures failures
+// and is not locked at now. It reports whether it did, which is true for
+// only one of concurrent callers.
+func (t *ThrottleRepository) LockThrottle(ctx context.Context, key string, failures int, now, until time.Time) (bool, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `UPDATE login_throttles SET locked_until = $1
+		WHERE key = $2 AND failures >= $3 AND (locked_until IS NULL OR locked_until <= $4)`
+
+	result, err := executor(ctx, t.conn).ExecContext(ctx, query, until, key, failures, now)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (t *ThrottleRepository) DeleteThrottle(ctx context.Context, key string) error {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `DELETE FROM login_throttles WHERE key = $1`
+
+	_, err := executor(ctx, t.conn).ExecContext(ctx, query, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteStaleThrottles removes entries without failures since before and
+// without an active lock.
+func (t *ThrottleRepository) DeleteStaleThrottles(ctx context.Context, before time.Time) error {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `DELETE FROM login_throttles WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $1)`
+
+	_, err := executor(ctx, t.conn).ExecContext(ctx, query, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
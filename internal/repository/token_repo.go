@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"simple-forum/internal/model"
+	"strings"
+	"time"
+)
+
+type TokenRepository struct {
+	conn    *sql.DB
+	timeout time.Duration
+}
+
+func NewTokenRepository(conn *sql.DB, timeout time.Duration) *TokenRepository {
+	return &TokenRepository{conn: conn, timeout: timeout}
+}
+
+func scanToken(row interface{ Scan(dest ...any) error }) (*model.AccessToken, error) {
+	token := new(model.AccessToken)
+
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.Hash,
+		&scopes,
+		&expiresAt,
+		&lastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Split(scopes, ",")
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return token, nil
+}
+
+func (t *TokenRepository) GetTokensByUserID(ctx context.Context, userID int) ([]*model.AccessToken, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `SELECT id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at FROM personal_access_tokens WHERE user_id = $1 ORDER BY created_at DESC`
+
+	rows, err := executor(ctx, t.conn).QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*model.AccessToken
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (t *TokenRepository) GetTokenByHash(ctx context.Context, hash string) (*model.AccessToken, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `SELECT id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at FROM personal_access_tokens WHERE token_hash = $1`
+
+	token, err := scanToken(executor(ctx, t.conn).QueryRowContext(ctx, query, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (t *TokenRepository) InsertToken(ctx context.Context, token *model.AccessToken) (int, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+
+	var expiresAt sql.NullTime
+	if token.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *token.ExpiresAt, Valid: true}
+	}
+
+	err := executor(ctx, t.conn).QueryRowContext(ctx, query,
+		token.UserID,
+		token.Name,
+		token.Hash,
+		strings.Join(token.Scopes, ","),
+		expiresAt,
+		token.CreatedAt).Scan(&token.ID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return token.ID, nil
+}
+
+func (t *TokenRepository) TouchToken(ctx context.Context, id int, usedAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `UPDATE personal_access_tokens SET last_used_at = $1 WHERE id = $2`
+
+	_, err := executor(ctx, t.conn).ExecContext(ctx, query, usedAt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteToken removes the token only if it belongs to userID and returns
+// sql.ErrNoRows otherwise.
+func (t *TokenRepository) DeleteToken(ctx context.Context, id, userID int) error {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2`
+
+	result, err := executor(ctx, t.conn).ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -1,22 +1,69 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"simple-forum/internal/database"
 	"simple-forum/internal/model"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TopicRepository struct {
-	conn *sql.DB
+	conn     *sql.DB
+	pool     *pgxpool.Pool
+	replicas *database.ReplicaSet
+	timeout  time.Duration
+}
+
+func NewTopicRepository(conn *sql.DB, timeout time.Duration) *TopicRepository {
+	return &TopicRepository{conn: conn, timeout: timeout}
 }
 
-func NewTopicRepository(conn *sql.DB) *TopicRepository {
-	return &TopicRepository{conn: conn}
+// WithPool lets the repository send related queries to PostgreSQL in one
+// batch over pool, the pool conn draws its connections from.
+func (t *TopicRepository) WithPool(pool *pgxpool.Pool) *TopicRepository {
+	t.pool = pool
+	return t
 }
 
-func (t *TopicRepository) GetAllTopics() ([]*model.Topic, error) {
+// WithReplicas sends the repository's read-only queries to replicas.
+func (t *TopicRepository) WithReplicas(replicas *database.ReplicaSet) *TopicRepository {
+	t.replicas = replicas
+	return t
+}
+
+// scanTopic reads a row of SELECT * FROM topics, from database/sql or pgx.
+func scanTopic(row interface{ Scan(dest ...any) error }) (*model.Topic, error) {
+	topic := new(model.Topic)
+
+	err := row.Scan(
+		&topic.ID,
+		&topic.Name,
+		&topic.Description,
+		&topic.CreatedAt,
+		&topic.AuthorId,
+		&topic.Private,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return topic, nil
+}
+
+func (t *TopicRepository) GetAllTopics(ctx context.Context) ([]*model.Topic, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
 	query := `SELECT * FROM topics`
 
-	rows, err := t.conn.Query(query)
+	rows, err := reader(ctx, t.conn, t.replicas).QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -26,53 +73,60 @@ func (t *TopicRepository) GetAllTopics() ([]*model.Topic, error) {
 	var topics []*model.Topic
 
 	for rows.Next() {
-		topic := new(model.Topic)
-		err = rows.Scan(
-			&topic.ID,
-			&topic.Name,
-			&topic.Description,
-			&topic.CreatedAt,
-			&topic.AuthorId,
-		)
+		topic, err := scanTopic(rows)
 		if err != nil {
 			return nil, err
 		}
 		topics = append(topics, topic)
 	}
-	return topics, nil
+	return topics, rows.Err()
 }
 
-func (t *TopicRepository) GetTopicByID(topicID int) (*model.Topic, error) {
-	query := `SELECT * FROM topics WHERE id = $1`
+// GetPublicTopicsByAuthorID returns up to limit topics the author started
+// that are not private, newest first.
+func (t *TopicRepository) GetPublicTopicsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Topic, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
 
-	topic := new(model.Topic)
+	query := `SELECT * FROM topics WHERE author_id = $1 AND NOT private ORDER BY created_at DESC, id DESC LIMIT $2`
 
-	err := t.conn.QueryRow(query, topicID).Scan(
-		&topic.ID,
-		&topic.Name,
-		&topic.Description,
-		&topic.CreatedAt,
-		&topic.AuthorId,
-	)
+	rows, err := reader(ctx, t.conn, t.replicas).QueryContext(ctx, query, authorID, limit)
 	if err != nil {
 		return nil, err
 	}
-	return topic, nil
+	defer rows.Close()
+
+	var topics []*model.Topic
+	for rows.Next() {
+		topic, err := scanTopic(rows)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, topic)
+	}
+	return topics, rows.Err()
 }
 
-func (t *TopicRepository) GetTopicByPostID(postID int) (*model.Topic, error) {
-	query := `SELECT t.* FROM topics t JOIN posts p ON t.id = p.topic_id WHERE p.id = $1`
+func (t *TopicRepository) GetTopicByID(ctx context.Context, topicID int) (*model.Topic, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
 
-	topic := new(model.Topic)
+	query := `SELECT * FROM topics WHERE id = $1`
 
-	err := t.conn.QueryRow(query, postID).Scan(
-		&topic.ID,
-		&topic.Name,
-		&topic.Description,
-		&topic.CreatedAt,
-		&topic.AuthorId,
-	)
+	topic, err := scanTopic(reader(ctx, t.conn, t.replicas).QueryRowContext(ctx, query, topicID))
+	if err != nil {
+		return nil, err
+	}
+	return topic, nil
+}
+
+func (t *TopicRepository) GetTopicByPostID(ctx context.Context, postID int) (*model.Topic, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
 
+	query := `SELECT t.* FROM topics t JOIN posts p ON t.id = p.topic_id WHERE p.id = $1`
+
+	topic, err := scanTopic(reader(ctx, t.conn, t.replicas).QueryRowContext(ctx, query, postID))
 	if err != nil {
 		return nil, err
 	}
@@ -80,14 +134,84 @@ func (t *TopicRepository) GetTopicByPostID(postID int) (*model.Topic, error) {
 	return topic, nil
 }
 
-func (t *TopicRepository) InsertTopic(topic *model.Topic) (int, error) {
-	query := `INSERT INTO topics (name, description, created_at, author_id) VALUES ($1, $2, $3, $4) RETURNING id`
+// GetTopicWithPosts returns a topic along with its posts. With a pool the
+// two queries take a single round trip, otherwise and inside a transaction
+// they run one after the other. Like the other reads they go to a replica
+// when there is one.
+func (t *TopicRepository) GetTopicWithPosts(ctx context.Context, topicID int) (*model.Topic, []*model.Post, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	topicQuery := `SELECT * FROM topics WHERE id = $1`
+	postsQuery := `SELECT * FROM posts WHERE topic_id = $1`
+
+	if _, inTx := ctx.Value(txKey{}).(*sql.Tx); t.pool == nil || inTx {
+		db := reader(ctx, t.conn, t.replicas)
+
+		topic, err := scanTopic(db.QueryRowContext(ctx, topicQuery, topicID))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rows, err := db.QueryContext(ctx, postsQuery, topicID)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rows.Close()
+
+		posts, err := collectPosts(rows)
+		return topic, posts, err
+	}
+
+	pool := t.pool
+	if replica := t.replicas.Pick(ctx); replica != nil {
+		pool = replica.Pool
+	}
+
+	ctx, span := tracer.Start(ctx, "pgx.batch", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, attribute.Int("db.batch.size", 2)))
+	defer span.End()
+
+	batch := &pgx.Batch{}
+	batch.Queue(topicQuery, topicID)
+	batch.Queue(postsQuery, topicID)
+
+	results := pool.SendBatch(ctx, batch)
+	defer results.Close()
+
+	topic, err := scanTopic(results.QueryRow())
+	if errors.Is(err, pgx.ErrNoRows) {
+		// like database/sql, so callers need not know which path ran
+		return nil, nil, sql.ErrNoRows
+	}
+	if err != nil {
+		span.RecordError(err)
+		return nil, nil, err
+	}
+
+	rows, err := results.Query()
+	if err != nil {
+		span.RecordError(err)
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	posts, err := collectPosts(rows)
+	return topic, posts, err
+}
+
+func (t *TopicRepository) InsertTopic(ctx context.Context, topic *model.Topic) (int, error) {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `INSERT INTO topics (name, description, created_at, author_id, private) VALUES ($1, $2, $3, $4, $5) RETURNING id`
 
-	err := t.conn.QueryRow(query,
+	err := executor(ctx, t.conn).QueryRowContext(ctx, query,
 		topic.Name,
 		topic.Description,
 		topic.CreatedAt,
-		topic.AuthorId).Scan(&topic.ID)
+		topic.AuthorId,
+		topic.Private).Scan(&topic.ID)
 
 	if err != nil {
 		return 0, err
@@ -96,12 +220,16 @@ func (t *TopicRepository) InsertTopic(topic *model.Topic) (int, error) {
 	return topic.ID, nil
 }
 
-func (t *TopicRepository) UpdateTopic(topic *model.Topic) error {
-	query := `UPDATE topics SET name = $1, description = $2 WHERE id = $3`
+func (t *TopicRepository) UpdateTopic(ctx context.Context, topic *model.Topic) error {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
 
-	_, err := t.conn.Exec(query,
+	query := `UPDATE topics SET name = $1, description = $2, private = $3 WHERE id = $4`
+
+	_, err := executor(ctx, t.conn).ExecContext(ctx, query,
 		topic.Name,
 		topic.Description,
+		topic.Private,
 		topic.ID)
 
 	if err != nil {
@@ -110,10 +238,13 @@ func (t *TopicRepository) UpdateTopic(topic *model.Topic) error {
 	return nil
 }
 
-func (t *TopicRepository) DeleteTopic(topic *model.Topic) error {
+func (t *TopicRepository) DeleteTopic(ctx context.Context, topic *model.Topic) error {
+	ctx, cancel := withTimeout(ctx, t.timeout)
+	defer cancel()
+
 	query := `DELETE FROM topics WHERE id = $1`
 
-	_, err := t.conn.Exec(query, topic.ID)
+	_, err := executor(ctx, t.conn).ExecContext(ctx, query, topic.ID)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,6 @@
+package repository
+
+import "go.opentelemetry.io/otel"
+
+// tracer covers what otelsql does not see: batches sent over the pgx pool.
+var tracer = otel.Tracer("simple-forum/internal/repository")
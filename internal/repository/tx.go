@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"simple-forum/internal/database"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// DBTX is what repositories need to run queries, satisfied by both the pool
+// and a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type txKey struct{}
+
+// executor returns the transaction WithinTx stored in ctx, or conn outside
+// of one.
+func executor(ctx context.Context, conn *sql.DB) DBTX {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return conn
+}
+
+// reader is executor for read-only queries, which outside of a transaction
+// go to a replica when replicas has a healthy one.
+func reader(ctx context.Context, conn *sql.DB, replicas *database.ReplicaSet) DBTX {
+	if _, inTx := ctx.Value(txKey{}).(*sql.Tx); !inTx {
+		if replica := replicas.Pick(ctx); replica != nil {
+			return replica.DB
+		}
+	}
+	return executor(ctx, conn)
+}
+
+// Transactor runs units of work in serializable transactions.
+type Transactor struct {
+	conn    *sql.DB
+	retries int
+}
+
+// NewTransactor creates a Transactor that runs a unit of work up to retries
+// more times when the database aborts it over a conflict with another.
+func NewTransactor(conn *sql.DB, retries int) *Transactor {
+	return &Transactor{conn: conn, retries: retries}
+}
+
+// WithinTx runs fn in a transaction and commits if fn returns nil. Every
+// repository called with the ctx passed to fn takes part in it. A call from
+// within fn joins the outer transaction. fn may run several times, so it
+// must not have effects outside the database.
+func (t *Transactor) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := t.run(ctx, fn)
+		if err == nil || !retryable(err) || attempt >= t.retries {
+			return err
+		}
+
+		// back off with jitter so the conflicting transactions do not collide again
+		backoff := time.Duration(1<<attempt)*10*time.Millisecond + rand.N(10*time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (t *Transactor) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := t.conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+
+	err = fn(context.WithValue(ctx, txKey{}, tx))
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// retryable reports serialization failures and deadlocks, and SQLite
+// giving up waiting for the write lock, after which the whole transaction
+// can be run again.
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// the low byte is the primary code of extended ones like SQLITE_BUSY_SNAPSHOT
+		return sqliteErr.Code()&0xff == sqlite3.SQLITE_BUSY
+	}
+
+	return false
+}
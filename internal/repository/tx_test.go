@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// stubDriver counts transactions and fails the first failures statements
+// with err.
+type stubDriver struct {
+	mu        sync.Mutex
+	commits   int
+	rollbacks int
+	execs     int
+	failures  int
+	err       error
+}
+
+func (d *stubDriver) Open(string) (driver.Conn, error) { return &stubConn{d: d}, nil }
+
+type stubConn struct{ d *stubDriver }
+
+func (c *stubConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *stubConn) Close() error                        { return nil }
+func (c *stubConn) Begin() (driver.Tx, error)           { return c, nil }
+
+func (c *stubConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return c, nil }
+
+func (c *stubConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+
+	c.d.execs++
+	if c.d.failures > 0 {
+		c.d.failures--
+		return nil, c.d.err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *stubConn) Commit() error {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.commits++
+	return nil
+}
+
+func (c *stubConn) Rollback() error {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.rollbacks++
+	return nil
+}
+
+func newStubDB(t *testing.T, failures int, err error) (*sql.DB, *stubDriver) {
+	t.Helper()
+
+	d := &stubDriver{failures: failures, err: err}
+	db := sql.OpenDB(connector{d})
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db, d
+}
+
+type connector struct{ d *stubDriver }
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return c.d.Open("") }
+func (c connector) Driver() driver.Driver                        { return c.d }
+
+func TestTransactor_WithinTx(t *testing.T) {
+	t.Parallel()
+
+	conflict := &pgconn.PgError{Code: "40001"}
+	violation := &pgconn.PgError{Code: "23505"}
+
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		valid     bool
+		execs     int
+		commits   int
+		rollbacks int
+	}{
+		{name: "Committed", valid: true, execs: 1, commits: 1},
+		{name: "Retried After Conflict", failures: 2, err: conflict, valid: true, execs: 3, commits: 1, rollbacks: 2},
+		{name: "Retries Exhausted", failures: 5, err: conflict, valid: false, execs: 4, rollbacks: 4},
+		{name: "Not Retried", failures: 1, err: violation, valid: false, execs: 1, rollbacks: 1},
+	}
+
+	for _, tt := range tests {
+		db, d := newStubDB(t, tt.failures, tt.err)
+		transactor := NewTransactor(db, 3)
+
+		err := transactor.WithinTx(context.Background(), func(ctx context.Context) error {
+			_, err := executor(ctx, db).ExecContext(ctx, "UPDATE posts SET title = $1", "title")
+			return err
+		})
+
+		if tt.valid && err != nil {
+			t.Errorf("%s: no error expected, but got %s", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error, got nil", tt.name)
+		}
+		if d.execs != tt.execs || d.commits != tt.commits || d.rollbacks != tt.rollbacks {
+			t.Errorf("%s: expected %d execs, %d commits and %d rollbacks, got %d, %d and %d",
+				tt.name, tt.execs, tt.commits, tt.rollbacks, d.execs, d.commits, d.rollbacks)
+		}
+	}
+}
+
+func TestTransactor_Nested(t *testing.T) {
+	t.Parallel()
+
+	db, d := newStubDB(t, 0, nil)
+	transactor := NewTransactor(db, 3)
+
+	err := transactor.WithinTx(context.Background(), func(ctx context.Context) error {
+		outer := executor(ctx, db)
+		return transactor.WithinTx(ctx, func(ctx context.Context) error {
+			if executor(ctx, db) != outer {
+				t.Error("expected the inner unit of work to join the outer transaction")
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+
+	if d.commits != 1 {
+		t.Errorf("expected a single commit, got %d", d.commits)
+	}
+	if _, ok := executor(context.Background(), db).(*sql.DB); !ok {
+		t.Error("expected the pool outside of a transaction")
+	}
+}
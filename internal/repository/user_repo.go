@@ -1,25 +1,32 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"simple-forum/internal/model"
+	"strings"
+	"time"
 )
 
 type UserRepository struct {
-	conn *sql.DB
+	conn    *sql.DB
+	timeout time.Duration
 }
 
-func NewUserRepository(conn *sql.DB) *UserRepository {
-	return &UserRepository{conn: conn}
+func NewUserRepository(conn *sql.DB, timeout time.Duration) *UserRepository {
+	return &UserRepository{conn: conn, timeout: timeout}
 }
 
-func (u *UserRepository) GetUserByEmail(email string) (*model.User, error) {
+func (u *UserRepository) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	ctx, cancel := withTimeout(ctx, u.timeout)
+	defer cancel()
+
 	query := `SELECT * FROM users WHERE email = $1`
 
 	user := new(model.User)
 
-	err := u.conn.QueryRow(query, email).Scan(
+	err := executor(ctx, u.conn).QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.Name,
 		&user.Email,
@@ -37,12 +44,15 @@ func (u *UserRepository) GetUserByEmail(email string) (*model.User, error) {
 	return user, nil
 }
 
-func (u *UserRepository) GetUserByUsername(username string) (*model.User, error) {
+func (u *UserRepository) GetUserByUsername(ctx context.Context, username string) (*model.User, error) {
+	ctx, cancel := withTimeout(ctx, u.timeout)
+	defer cancel()
+
 	query := `SELECT id, username, email, password_hash, created_at, role FROM users WHERE username = $1`
 
 	user := new(model.User)
 
-	err := u.conn.QueryRow(query, username).Scan(
+	err := executor(ctx, u.conn).QueryRowContext(ctx, query, username).Scan(
 		&user.ID,
 		&user.Name,
 		&user.Email,
@@ -59,10 +69,43 @@ func (u *UserRepository) GetUserByUsername(username string) (*model.User, error)
 	return user, nil
 }
 
-func (u *UserRepository) InsertUser(user *model.User) (int, error) {
+// GetUsernamesByPrefix returns up to limit usernames starting with prefix,
+// ignoring case, in order.
+func (u *UserRepository) GetUsernamesByPrefix(ctx context.Context, prefix string, limit int) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, u.timeout)
+	defer cancel()
+
+	query := `SELECT username FROM users WHERE lower(username) LIKE lower($1) ESCAPE '\' ORDER BY username LIMIT $2`
+
+	rows, err := executor(ctx, u.conn).QueryContext(ctx, query, likePrefix(prefix), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// likePrefix returns the LIKE pattern matching what starts with prefix.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+}
+
+func (u *UserRepository) InsertUser(ctx context.Context, user *model.User) (int, error) {
+	ctx, cancel := withTimeout(ctx, u.timeout)
+	defer cancel()
+
 	query := `INSERT INTO users (username, email, password_hash, created_at, role) VALUES ($1, $2, $3, $4, $5) RETURNING id`
 
-	err := u.conn.QueryRow(query,
+	err := executor(ctx, u.conn).QueryRowContext(ctx, query,
 		user.Name,
 		user.Email,
 		user.PasswordHash,
@@ -76,12 +119,15 @@ func (u *UserRepository) InsertUser(user *model.User) (int, error) {
 	return user.ID, nil
 }
 
-func (u *UserRepository) GetUserByID(id int) (*model.User, error) {
+func (u *UserRepository) GetUserByID(ctx context.Context, id int) (*model.User, error) {
+	ctx, cancel := withTimeout(ctx, u.timeout)
+	defer cancel()
+
 	query := `SELECT * FROM users WHERE id = $1`
 
 	user := new(model.User)
 
-	err := u.conn.QueryRow(query, id).Scan(
+	err := executor(ctx, u.conn).QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.Name,
 		&user.Email,
@@ -98,3 +144,17 @@ func (u *UserRepository) GetUserByID(id int) (*model.User, error) {
 
 	return user, nil
 }
+
+func (u *UserRepository) UpdateUserRole(ctx context.Context, id int, role string) error {
+	ctx, cancel := withTimeout(ctx, u.timeout)
+	defer cancel()
+
+	query := `UPDATE users SET role = $1 WHERE id = $2`
+
+	_, err := executor(ctx, u.conn).ExecContext(ctx, query, role, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
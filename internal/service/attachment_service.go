@@ -0,0 +1,471 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"simple-forum/internal/blob"
+	"simple-forum/internal/model"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+	ErrAttachmentType     = errors.New("attachment type is not allowed")
+	ErrAttachmentQuota    = errors.New("attachment quota exceeded")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+)
+
+const (
+	// DefaultMaxAttachmentBytes is the largest file accepted unless
+	// WithMaxBytes says otherwise.
+	DefaultMaxAttachmentBytes = 10 << 20
+	// DefaultAttachmentQuota is how much a user may upload in all unless
+	// WithQuota says otherwise.
+	DefaultAttachmentQuota = 100 << 20
+)
+
+// DefaultAttachmentTypes are the types of files accepted unless WithTypes
+// says otherwise: pictures, text such as logs and configuration, PDFs and
+// archives.
+var DefaultAttachmentTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp",
+	"text/plain", "application/pdf", "application/zip", "application/x-gzip",
+}
+
+// collectBatch is how many detached attachments Collect removes at a time.
+const collectBatch = 100
+
+// pendingTimeout is how long an attachment may wait for its post before
+// Collect takes it for abandoned, as when the server stopped in between.
+const pendingTimeout = time.Hour
+
+type AttachmentStorage interface {
+	GetAttachmentByID(ctx context.Context, id int) (*model.Attachment, error)
+	GetAttachmentsByPostID(ctx context.Context, postID int) ([]*model.Attachment, error)
+	GetDetachedAttachments(ctx context.Context, pendingBefore time.Time, limit int) ([]*model.Attachment, error)
+	SumAttachmentSizesByUserID(ctx context.Context, userID int) (int64, error)
+	InsertAttachment(ctx context.Context, attachment *model.Attachment) (int, error)
+	AttachAttachment(ctx context.Context, id, postID int) error
+	DeleteAttachment(ctx context.Context, id int) error
+	SaveAttachmentBlob(ctx context.Context, sum string) error
+	DeleteUnusedAttachmentBlob(ctx context.Context, sum string) (bool, error)
+}
+
+// Upload is a file to attach, as the browser sent it.
+type Upload struct {
+	Filename string
+	Content  io.ReadSeeker
+}
+
+// AttachmentService keeps the files attached to posts. Their content is
+// stored once however many posts it is attached to, as a blob named after
+// its SHA-256, and removed with the last attachment of it.
+type AttachmentService struct {
+	l          *slog.Logger
+	repository AttachmentStorage
+	blobs      BlobStore
+	tx         Transactor
+	maxBytes   int64
+	quota      int64
+	types      []string
+	collect    chan struct{}
+}
+
+func NewAttachmentService(l *slog.Logger, repository AttachmentStorage, blobs BlobStore, tx Transactor) *AttachmentService {
+	return &AttachmentService{
+		l:          l,
+		repository: repository,
+		blobs:      blobs,
+		tx:         tx,
+		maxBytes:   DefaultMaxAttachmentBytes,
+		quota:      DefaultAttachmentQuota,
+		types:      DefaultAttachmentTypes,
+		collect:    make(chan struct{}, 1),
+	}
+}
+
+// WithMaxBytes sets the largest file accepted.
+func (a *AttachmentService) WithMaxBytes(n int64) *AttachmentService {
+	a.maxBytes = n
+	return a
+}
+
+// WithQuota sets how much a user may upload in all, counting every upload,
+// even of content already stored.
+func (a *AttachmentService) WithQuota(n int64) *AttachmentService {
+	a.quota = n
+	return a
+}
+
+// WithTypes sets the media types of the files accepted, such as
+// text/plain, without parameters.
+func (a *AttachmentService) WithTypes(types []string) *AttachmentService {
+	a.types = types
+	return a
+}
+
+// Store checks the uploads of the user against the limits and stores their
+// content, returning pending attachments for AttachToPost or Discard. Files
+// that are too large or of a type not allowed give ErrAttachmentTooLarge or
+// ErrAttachmentType, and uploads beyond the quota of the user
+// ErrAttachmentQuota; nothing is stored then.
+func (a *AttachmentService) Store(ctx context.Context, userID int, uploads []Upload) ([]*model.Attachment, error) {
+	ctx, span := tracer.Start(ctx, "AttachmentService.Store")
+	defer span.End()
+
+	if len(uploads) == 0 {
+		return nil, nil
+	}
+
+	attachments := make([]*model.Attachment, 0, len(uploads))
+	var total int64
+	for _, upload := range uploads {
+		attachment, err := a.check(userID, upload)
+		if err != nil {
+			return nil, err
+		}
+		total += attachment.Size
+		attachments = append(attachments, attachment)
+	}
+
+	// the rows are saved with the quota check, so that concurrent uploads
+	// cannot both fit, and before the blobs are, so that release keeps them
+	err := a.tx.WithinTx(ctx, func(ctx context.Context) error {
+		used, err := a.repository.SumAttachmentSizesByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if used+total > a.quota {
+			return fmt.Errorf("%w: %s of %s left", ErrAttachmentQuota,
+				humanBytes(max(a.quota-used, 0)), humanBytes(a.quota))
+		}
+
+		for _, attachment := range attachments {
+			_, err = a.repository.InsertAttachment(ctx, attachment)
+			if err != nil {
+				return err
+			}
+			err = a.repository.SaveAttachmentBlob(ctx, attachment.SHA256)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, attachment := range attachments {
+		key := attachmentKey(attachment.SHA256)
+
+		// the content may be stored already, for this or another post
+		_, err = a.blobs.Stat(ctx, key)
+		if errors.Is(err, blob.ErrNotFound) {
+			content := uploads[i].Content
+			if _, err = content.Seek(0, io.SeekStart); err == nil {
+				err = a.blobs.Put(ctx, key, content, attachment.ContentType)
+			}
+		}
+		if err != nil {
+			a.discard(ctx, attachments)
+			return nil, err
+		}
+	}
+
+	return attachments, nil
+}
+
+// check reads upload to tell its type, size and hash and returns it as an
+// attachment, unless it breaks the limits of a single file.
+func (a *AttachmentService) check(userID int, upload Upload) (*model.Attachment, error) {
+	name := cleanFilename(upload.Filename)
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(upload.Content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	contentType := http.DetectContentType(head[:n])
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !slices.Contains(a.types, mediaType) {
+		return nil, fmt.Errorf("%w: %s is %s", ErrAttachmentType, name, mediaType)
+	}
+
+	_, err = upload.Content.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, io.LimitReader(upload.Content, a.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > a.maxBytes {
+		return nil, fmt.Errorf("%w: %s is larger than %s", ErrAttachmentTooLarge, name, humanBytes(a.maxBytes))
+	}
+
+	return &model.Attachment{
+		UserID:      userID,
+		Filename:    name,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt:   time.Now(),
+		Pending:     true,
+	}, nil
+}
+
+// AttachToPost gives attachments returned by Store to the post. Those not
+// attached are discarded.
+func (a *AttachmentService) AttachToPost(ctx context.Context, postID int, attachments []*model.Attachment) error {
+	ctx, span := tracer.Start(ctx, "AttachmentService.AttachToPost")
+	defer span.End()
+
+	for i, attachment := range attachments {
+		err := a.repository.AttachAttachment(ctx, attachment.ID, postID)
+		if err != nil {
+			a.discard(ctx, attachments[i:])
+			return err
+		}
+		attachment.PostID = postID
+		attachment.Pending = false
+	}
+	return nil
+}
+
+// Discard removes attachments returned by Store that are not to be saved
+// after all, and their content unless other attachments share it.
+func (a *AttachmentService) Discard(ctx context.Context, attachments []*model.Attachment) {
+	ctx, span := tracer.Start(ctx, "AttachmentService.Discard")
+	defer span.End()
+
+	a.discard(ctx, attachments)
+}
+
+// discard deletes pending attachments and releases their blobs. Those it
+// cannot delete are left for Collect once they are old enough.
+func (a *AttachmentService) discard(ctx context.Context, attachments []*model.Attachment) {
+	ctx = context.WithoutCancel(ctx)
+
+	for _, attachment := range attachments {
+		err := a.repository.DeleteAttachment(ctx, attachment.ID)
+		if err != nil {
+			a.l.WarnContext(ctx, "Unable to delete pending attachment", "id", attachment.ID, "error", err.Error())
+		}
+	}
+	a.release(ctx, attachments)
+}
+
+func (a *AttachmentService) GetAttachments(ctx context.Context, postID int) ([]*model.Attachment, error) {
+	ctx, span := tracer.Start(ctx, "AttachmentService.GetAttachments")
+	defer span.End()
+
+	return a.repository.GetAttachmentsByPostID(ctx, postID)
+}
+
+// GetAttachment returns the attachment of a post that still exists, or
+// ErrAttachmentNotFound.
+func (a *AttachmentService) GetAttachment(ctx context.Context, id int) (*model.Attachment, error) {
+	ctx, span := tracer.Start(ctx, "AttachmentService.GetAttachment")
+	defer span.End()
+
+	attachment, err := a.repository.GetAttachmentByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && attachment.PostID == 0) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// OpenAttachment returns the content of the attachment, which the caller
+// closes, or ErrAttachmentNotFound if it has gone missing.
+func (a *AttachmentService) OpenAttachment(ctx context.Context, attachment *model.Attachment) (io.ReadCloser, *blob.Info, error) {
+	ctx, span := tracer.Start(ctx, "AttachmentService.OpenAttachment")
+	defer span.End()
+
+	r, info, err := a.blobs.Open(ctx, attachmentKey(attachment.SHA256))
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	return r, info, err
+}
+
+// RemoveAttachments removes the attachments with the given ids from the
+// post and returns those removed; ids of other posts are passed over.
+func (a *AttachmentService) RemoveAttachments(ctx context.Context, postID int, ids []int) ([]*model.Attachment, error) {
+	ctx, span := tracer.Start(ctx, "AttachmentService.RemoveAttachments")
+	defer span.End()
+
+	var removed []*model.Attachment
+	defer func() { a.release(ctx, removed) }()
+
+	for _, id := range ids {
+		attachment, err := a.repository.GetAttachmentByID(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if attachment.PostID != postID {
+			continue
+		}
+
+		err = a.repository.DeleteAttachment(ctx, id)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, attachment)
+	}
+	return removed, nil
+}
+
+// Collect removes the attachments the database detached from deleted posts,
+// and those abandoned before their post was created, and returns how many
+// there were.
+func (a *AttachmentService) Collect(ctx context.Context) (int, error) {
+	ctx, span := tracer.Start(ctx, "AttachmentService.Collect")
+	defer span.End()
+
+	collected := 0
+	for {
+		detached, err := a.repository.GetDetachedAttachments(ctx, time.Now().Add(-pendingTimeout), collectBatch)
+		if err != nil {
+			return collected, err
+		}
+
+		for i, attachment := range detached {
+			err = a.repository.DeleteAttachment(ctx, attachment.ID)
+			if err != nil {
+				a.release(ctx, detached[:i])
+				return collected, err
+			}
+			collected++
+		}
+		a.release(ctx, detached)
+
+		if len(detached) < collectBatch {
+			return collected, nil
+		}
+	}
+}
+
+// Wake has Run collect detached attachments now, as after a post was
+// deleted. It does not wait.
+func (a *AttachmentService) Wake() {
+	select {
+	case a.collect <- struct{}{}:
+	default:
+		// a collection is due already
+	}
+}
+
+// Run collects detached attachments when woken and every interval, in
+// case a wake-up was lost, until ctx is cancelled.
+func (a *AttachmentService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.collect:
+		case <-ticker.C:
+		}
+
+		n, err := a.Collect(ctx)
+		if err != nil {
+			a.l.ErrorContext(ctx, "Unable to collect detached attachments", "error", err.Error())
+		}
+		if n > 0 {
+			a.l.InfoContext(ctx, "Collected detached attachments", "count", n)
+		}
+	}
+}
+
+// release deletes the blobs of attachments no other attachment shares. It
+// carries on past errors; a blob left behind only takes up space.
+func (a *AttachmentService) release(ctx context.Context, attachments []*model.Attachment) {
+	ctx = context.WithoutCancel(ctx)
+
+	seen := make(map[string]bool)
+	for _, attachment := range attachments {
+		if seen[attachment.SHA256] {
+			continue
+		}
+		seen[attachment.SHA256] = true
+
+		// the blob goes before its row is gone for good, while a Store of the
+		// same content waits for the row; deleting it again on a retry is
+		// harmless
+		err := a.tx.WithinTx(ctx, func(ctx context.Context) error {
+			unused, err := a.repository.DeleteUnusedAttachmentBlob(ctx, attachment.SHA256)
+			if err != nil || !unused {
+				return err
+			}
+			return a.blobs.Delete(ctx, attachmentKey(attachment.SHA256))
+		})
+		if err != nil {
+			a.l.WarnContext(ctx, "Unable to delete attachment blob", "sha256", attachment.SHA256, "error", err.Error())
+		}
+	}
+}
+
+// attachmentKey spreads blobs over directories by the first byte of their
+// hash, to keep directories of the file store small.
+func attachmentKey(sum string) string {
+	return "attachments/" + sum[:2] + "/" + sum
+}
+
+// cleanFilename keeps the last element of name, which some browsers send
+// as a full path, without control characters and at most 255 bytes long,
+// cutting the stem rather than the extension.
+func cleanFilename(name string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	name = path.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" || name == ".." {
+		return "attachment"
+	}
+
+	if len(name) > 255 {
+		ext := path.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		stem := name[:255-len(ext)]
+		for !utf8.ValidString(stem) {
+			stem = stem[:len(stem)-1]
+		}
+		name = stem + ext
+	}
+	return name
+}
+
+// humanBytes formats n in the largest unit that keeps it at least 1.
+func humanBytes(n int64) string {
+	return (&model.Attachment{Size: n}).HumanSize()
+}
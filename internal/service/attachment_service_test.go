@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"simple-forum/internal/blob"
+	"simple-forum/internal/model"
+	"simple-forum/internal/repository/memory"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAttachmentService(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := memory.NewStore()
+	posts := memory.NewPostRepository(store)
+	topicID, _ := memory.NewTopicRepository(store).InsertTopic(ctx, &model.Topic{Name: "logs", AuthorId: 1, CreatedAt: time.Now()})
+	newPost := func() *model.Post {
+		post := &model.Post{Title: "post", TopicId: topicID, AuthorId: 1, AuthorName: "admin", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+		_, err := posts.InsertPost(ctx, post)
+		if err != nil {
+			t.Fatalf("unable to insert post: %v", err)
+		}
+		return post
+	}
+
+	dir := t.TempDir()
+	blobs, err := blob.NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("unable to create blob store: %v", err)
+	}
+	blobCount := func() int {
+		n := 0
+		_ = filepath.WalkDir(dir, func(_ string, d os.DirEntry, _ error) error {
+			if !d.IsDir() {
+				n++
+			}
+			return nil
+		})
+		return n
+	}
+
+	s := NewAttachmentService(slog.New(slog.NewTextHandler(io.Discard, nil)), memory.NewAttachmentRepository(store), blobs, memory.NewTransactor(store)).
+		WithMaxBytes(100).
+		WithQuota(150)
+
+	upload := func(name, content string) Upload {
+		return Upload{Filename: name, Content: strings.NewReader(content)}
+	}
+	log := strings.Repeat("error: disk full\n", 4)
+
+	for _, tt := range []struct {
+		name    string
+		uploads []Upload
+		err     error
+	}{
+		{"html", []Upload{upload("page.txt", "<!DOCTYPE html><script>alert(1)</script>")}, ErrAttachmentType},
+		{"too large", []Upload{upload("big.log", strings.Repeat("x", 101))}, ErrAttachmentTooLarge},
+		{"over quota", []Upload{upload("a.log", strings.Repeat("a", 80)), upload("b.log", strings.Repeat("b", 80))}, ErrAttachmentQuota},
+	} {
+		_, err := s.Store(ctx, 1, tt.uploads)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+	if blobCount() != 0 {
+		t.Fatalf("expected nothing stored for rejected uploads, got %d blobs", blobCount())
+	}
+
+	// the same log attached to two posts is stored once
+	first, second := newPost(), newPost()
+	for _, post := range []*model.Post{first, second} {
+		attachments, err := s.Store(ctx, 1, []Upload{upload(`C:\logs\app.log`, log)})
+		if err != nil {
+			t.Fatalf("unable to store attachment: %v", err)
+		}
+		err = s.AttachToPost(ctx, post.ID, attachments)
+		if err != nil {
+			t.Fatalf("unable to attach: %v", err)
+		}
+	}
+	if blobCount() != 1 {
+		t.Errorf("expected the content to be stored once, got %d blobs", blobCount())
+	}
+
+	attachments, err := s.GetAttachments(ctx, first.ID)
+	if err != nil || len(attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %+v, %v", attachments, err)
+	}
+	got := attachments[0]
+	if got.Filename != "app.log" || got.ContentType != "text/plain; charset=utf-8" || got.Size != int64(len(log)) {
+		t.Errorf("expected app.log as text of %d bytes, got %+v", len(log), got)
+	}
+
+	r, _, err := s.OpenAttachment(ctx, got)
+	if err != nil {
+		t.Fatalf("unable to open attachment: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != log {
+		t.Errorf("expected the log back, got %q", content)
+	}
+
+	// another 100 bytes would exceed the quota of 150
+	_, err = s.Store(ctx, 1, []Upload{upload("more.log", strings.Repeat("m", 100))})
+	if !errors.Is(err, ErrAttachmentQuota) {
+		t.Errorf("expected the quota to count both uploads, got %v", err)
+	}
+
+	// the blob stays while another post has it
+	removed, err := s.RemoveAttachments(ctx, second.ID, []int{got.ID})
+	if err != nil || len(removed) != 0 {
+		t.Errorf("expected no attachment of another post removed, got %+v, %v", removed, err)
+	}
+	others, _ := s.GetAttachments(ctx, second.ID)
+	removed, err = s.RemoveAttachments(ctx, second.ID, []int{others[0].ID})
+	if err != nil || len(removed) != 1 || blobCount() != 1 {
+		t.Errorf("expected the attachment removed and the blob kept, got %+v, %v and %d blobs", removed, err, blobCount())
+	}
+
+	err = posts.DeletePost(ctx, first)
+	if err != nil {
+		t.Fatalf("unable to delete post: %v", err)
+	}
+	_, err = s.GetAttachment(ctx, got.ID)
+	if !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("expected the attachment of a deleted post to be gone, got %v", err)
+	}
+
+	n, err := s.Collect(ctx)
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 attachment collected, got %d, %v", n, err)
+	}
+	if blobCount() != 0 {
+		t.Errorf("expected the last blob deleted, got %d blobs", blobCount())
+	}
+}
+
+func TestAttachmentService_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := memory.NewStore()
+	posts := memory.NewPostRepository(store)
+	topicID, _ := memory.NewTopicRepository(store).InsertTopic(ctx, &model.Topic{Name: "logs", AuthorId: 1, CreatedAt: time.Now()})
+
+	blobs, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create blob store: %v", err)
+	}
+	s := NewAttachmentService(slog.New(slog.NewTextHandler(io.Discard, nil)), memory.NewAttachmentRepository(store), blobs, memory.NewTransactor(store)).
+		WithQuota(1000)
+
+	const n = 20
+	log := strings.Repeat("error: disk full\n", 10)
+
+	// uploads of the same content race the removal of the last copy of it
+	for range n {
+		var wg sync.WaitGroup
+		var stored []*model.Attachment
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			attachments, err := s.Store(ctx, 1, []Upload{{Filename: "app.log", Content: strings.NewReader(log)}})
+			if err != nil {
+				t.Errorf("unable to store attachment: %v", err)
+				return
+			}
+			stored = attachments
+		}()
+		go func() {
+			defer wg.Done()
+			s.Collect(ctx)
+		}()
+		wg.Wait()
+		if stored == nil {
+			t.FailNow()
+		}
+
+		post := &model.Post{Title: "post", TopicId: topicID, AuthorId: 1, AuthorName: "admin", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+		_, err := posts.InsertPost(ctx, post)
+		if err != nil {
+			t.Fatalf("unable to insert post: %v", err)
+		}
+		err = s.AttachToPost(ctx, post.ID, stored)
+		if err != nil {
+			t.Fatalf("unable to attach: %v", err)
+		}
+
+		r, _, err := s.OpenAttachment(ctx, stored[0])
+		if err != nil {
+			t.Fatalf("expected the content of an attached file kept, got %v", err)
+		}
+		r.Close()
+
+		// leave the attachment to the next round's Collect
+		err = posts.DeletePost(ctx, post)
+		if err != nil {
+			t.Fatalf("unable to delete post: %v", err)
+		}
+	}
+
+	// uploads that only fit the quota one at a time, once the last round is
+	// collected
+	var wg sync.WaitGroup
+	var ok atomic.Int32
+	big := strings.Repeat("b", 600)
+	s.Collect(ctx)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Store(ctx, 1, []Upload{{Filename: "big.log", Content: strings.NewReader(big)}})
+			switch {
+			case err == nil:
+				ok.Add(1)
+			case !errors.Is(err, ErrAttachmentQuota):
+				t.Errorf("expected ErrAttachmentQuota, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if ok.Load() != 1 {
+		t.Errorf("expected 1 upload within the quota, got %d", ok.Load())
+	}
+}
+
+func TestCleanFilename(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("é", 200) + ".log"
+
+	tests := []struct {
+		name, want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{`C:\Users\ada\report.pdf`, "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{"bad\x00na\nme.txt", "badname.txt"},
+		{"  ", "attachment"},
+		{"..", "attachment"},
+		{long, strings.Repeat("é", 125) + ".log"},
+	}
+
+	for _, tt := range tests {
+		if got := cleanFilename(tt.name); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"simple-forum/internal/model"
+	"time"
+)
+
+const (
+	AuditTopicCreate    = "topic.create"
+	AuditTopicEdit      = "topic.edit"
+	AuditTopicDelete    = "topic.delete"
+	AuditPostCreate     = "post.create"
+	AuditPostEdit       = "post.edit"
+	AuditPostDelete     = "post.delete"
+	AuditLogin          = "user.login"
+	AuditLoginFailed    = "user.login_failed"
+	AuditRoleChange     = "user.role_change"
+	AuditProfileEdit    = "user.profile_edit"
+	AuditTokenCreate    = "token.create"
+	AuditTokenRevoke    = "token.revoke"
+	AuditLockoutClear   = "lockout.clear"
+	AuditMessagingBan   = "user.messaging_ban"
+	AuditMessagingUnban = "user.messaging_unban"
+)
+
+const (
+	AuditTargetTopic   = "topic"
+	AuditTargetPost    = "post"
+	AuditTargetUser    = "user"
+	AuditTargetToken   = "token"
+	AuditTargetLockout = "lockout"
+)
+
+// maxAuditEntries caps a single query, including CSV exports.
+const maxAuditEntries = 50000
+
+var AuditActions = []string{
+	AuditTopicCreate, AuditTopicEdit, AuditTopicDelete,
+	AuditPostCreate, AuditPostEdit, AuditPostDelete,
+	AuditLogin, AuditLoginFailed, AuditRoleChange, AuditProfileEdit,
+	AuditTokenCreate, AuditTokenRevoke, AuditLockoutClear,
+	AuditMessagingBan, AuditMessagingUnban,
+}
+
+type AuditStorage interface {
+	InsertEntry(ctx context.Context, entry *model.AuditEntry) (int64, error)
+	GetEntries(ctx context.Context, filter model.AuditFilter) ([]*model.AuditEntry, error)
+}
+
+type AuditService struct {
+	repository AuditStorage
+}
+
+func NewAuditService(repository AuditStorage) *AuditService {
+	return &AuditService{repository: repository}
+}
+
+// Record stores entry. before and after are snapshots of the target and are
+// encoded as JSON; pass nil where there is none.
+func (a *AuditService) Record(ctx context.Context, entry *model.AuditEntry, before, after any) error {
+	ctx, span := tracer.Start(ctx, "AuditService.Record")
+	defer span.End()
+
+	if before != nil {
+		b, err := json.Marshal(before)
+		if err != nil {
+			return err
+		}
+		entry.Before = string(b)
+	}
+
+	if after != nil {
+		b, err := json.Marshal(after)
+		if err != nil {
+			return err
+		}
+		entry.After = string(b)
+	}
+
+	entry.CreatedAt = time.Now()
+
+	_, err := a.repository.InsertEntry(ctx, entry)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *AuditService) GetEntries(ctx context.Context, filter model.AuditFilter) ([]*model.AuditEntry, error) {
+	ctx, span := tracer.Start(ctx, "AuditService.GetEntries")
+	defer span.End()
+
+	if filter.Limit <= 0 || filter.Limit > maxAuditEntries {
+		filter.Limit = maxAuditEntries
+	}
+
+	entries, err := a.repository.GetEntries(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"simple-forum/internal/model"
+	"simple-forum/internal/repository/memory"
+	"testing"
+	"time"
+)
+
+// auditLimitStub keeps the filter it was asked with.
+type auditLimitStub struct {
+	AuditStorage
+	filter model.AuditFilter
+}
+
+func (s *auditLimitStub) GetEntries(ctx context.Context, filter model.AuditFilter) ([]*model.AuditEntry, error) {
+	s.filter = filter
+	return nil, nil
+}
+
+func TestAuditService_Record(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := NewAuditService(memory.NewAuditRepository(memory.NewStore()))
+
+	tests := []struct {
+		name   string
+		before any
+		after  any
+		want   model.AuditEntry
+	}{
+		{
+			name:   "Snapshots",
+			before: map[string]string{"role": "user"},
+			after:  map[string]string{"role": "admin"},
+			want:   model.AuditEntry{Before: `{"role":"user"}`, After: `{"role":"admin"}`},
+		},
+		{
+			name:  "Created",
+			after: struct{ ID int }{ID: 1},
+			want:  model.AuditEntry{After: `{"ID":1}`},
+		},
+		{
+			name: "No Snapshots",
+		},
+	}
+
+	for i, tt := range tests {
+		entry := &model.AuditEntry{ActorID: 1, ActorName: "ada", Action: AuditRoleChange, TargetType: AuditTargetUser, TargetID: i + 1}
+
+		before := time.Now()
+		if err := s.Record(ctx, entry, tt.before, tt.after); err != nil {
+			t.Fatalf("%s: no error expected, but got %s", tt.name, err)
+		}
+
+		entries, err := s.GetEntries(ctx, model.AuditFilter{TargetType: AuditTargetUser, TargetID: i + 1})
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("%s: expected the recorded entry, got %v, %v", tt.name, entries, err)
+		}
+		got := entries[0]
+		if got.Before != tt.want.Before || got.After != tt.want.After {
+			t.Errorf("%s: expected %q and %q, got %q and %q", tt.name, tt.want.Before, tt.want.After, got.Before, got.After)
+		}
+		if got.CreatedAt.Before(before.Add(-time.Millisecond)) || got.ActorName != "ada" {
+			t.Errorf("%s: expected the entry of ada created now, got %+v", tt.name, got)
+		}
+	}
+
+	if err := s.Record(ctx, &model.AuditEntry{Action: AuditTopicEdit}, nil, make(chan int)); err == nil {
+		t.Error("expected a snapshot that cannot be encoded to fail")
+	}
+}
+
+func TestAuditService_GetEntriesLimit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		limit int
+		want  int
+	}{
+		{name: "Page", limit: 101, want: 101},
+		{name: "Unlimited", limit: 0, want: maxAuditEntries},
+		{name: "Negative", limit: -1, want: maxAuditEntries},
+		{name: "Too Many", limit: maxAuditEntries + 1, want: maxAuditEntries},
+	}
+
+	for _, tt := range tests {
+		stub := new(auditLimitStub)
+		_, err := NewAuditService(stub).GetEntries(context.Background(), model.AuditFilter{Limit: tt.limit})
+		if err != nil {
+			t.Fatalf("%s: no error expected, but got %s", tt.name, err)
+		}
+		if stub.filter.Limit != tt.want {
+			t.Errorf("%s: expected limit %d, got %d", tt.name, tt.want, stub.filter.Limit)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package service
+
+import (
+	"context"
+	"io"
+	"simple-forum/internal/blob"
+)
+
+// BlobStore keeps files outside the database, such as avatars and
+// attachments.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, *blob.Info, error)
+	Stat(ctx context.Context, key string) (*blob.Info, error)
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"simple-forum/internal/model"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrLoginLocked        = errors.New("login temporarily locked")
+	ErrLoginThrottled     = errors.New("login attempted too soon after a failure")
+)
+
+// LoginRetryError is returned by Check for a login that is locked or
+// throttled. It matches ErrLoginLocked or ErrLoginThrottled and tells how
+// long the client has to wait before trying again.
+type LoginRetryError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *LoginRetryError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Err, e.After.Round(time.Second))
+}
+
+func (e *LoginRetryError) Unwrap() error {
+	return e.Err
+}
+
+type ThrottleStorage interface {
+	GetThrottle(ctx context.Context, key string) (*model.LoginThrottle, error)
+	GetLockedThrottles(ctx context.Context, now time.Time) ([]*model.LoginThrottle, error)
+	CountFailure(ctx context.Context, key string, now, since time.Time) (*model.LoginThrottle, error)
+	LockThrottle(ctx context.Context, key string, failures int, now, until time.Time) (bool, error)
+	DeleteThrottle(ctx context.Context, key string) error
+	DeleteStaleThrottles(ctx context.Context, before time.Time) error
+}
+
+type Notifier interface {
+	Notify(user *model.User, subject, body string) error
+}
+
+type LoginPolicy struct {
+	// Window is how long a failure counts towards delays and locks.
+	Window time.Duration
+	// DelayAfter failures, each further attempt is held back until BaseDelay
+	// has passed since the last failure, doubling with every failure up to
+	// MaxDelay.
+	DelayAfter int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// AccountLockAfter and IPLockAfter failures lock the account or
+	// address for LockDuration.
+	AccountLockAfter int
+	IPLockAfter      int
+	LockDuration     time.Duration
+}
+
+// LoginGuard tracks failed logins per account and per client address and
+// decides when further attempts have to wait or are locked out.
+type LoginGuard struct {
+	l          *slog.Logger
+	repository ThrottleStorage
+	notifier   Notifier
+	policy     LoginPolicy
+	now        func() time.Time
+}
+
+func NewLoginGuard(l *slog.Logger, repository ThrottleStorage, notifier Notifier, policy LoginPolicy) *LoginGuard {
+	return &LoginGuard{
+		l:          l,
+		repository: repository,
+		notifier:   notifier,
+		policy:     policy,
+		now:        time.Now,
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a LoginRetryError when a login for email from ip must not
+// be attempted now: ErrLoginLocked while either is locked, and
+// ErrLoginThrottled until the delay since the last failure has passed.
+// Attempts are turned away rather than held, so that waiting clients do
+// not hold requests open.
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) error {
+	ctx, span := tracer.Start(ctx, "LoginGuard.Check")
+	defer span.End()
+
+	now := g.now()
+
+	var wait time.Duration
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		throttle, err := g.repository.GetThrottle(ctx, key)
+		if err != nil {
+			return err
+		}
+		if throttle == nil {
+			continue
+		}
+
+		if throttle.Locked(now) {
+			return &LoginRetryError{Err: ErrLoginLocked, After: throttle.LockedUntil.Sub(now)}
+		}
+
+		if throttle.LastFailureAt.Before(now.Add(-g.policy.Window)) {
+			continue
+		}
+
+		delay := g.delay(throttle.Failures)
+		if delay > 0 {
+			wait = max(wait, throttle.LastFailureAt.Add(delay).Sub(now))
+		}
+	}
+
+	if wait > 0 {
+		return &LoginRetryError{Err: ErrLoginThrottled, After: wait}
+	}
+	return nil
+}
+
+func (g *LoginGuard) delay(failures int) time.Duration {
+	if failures < g.policy.DelayAfter {
+		return 0
+	}
+
+	delay := g.policy.BaseDelay
+	for i := g.policy.DelayAfter; i < failures && delay < g.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, g.policy.MaxDelay)
+}
+
+// Fail records a failed login. user is the account owner if the email
+// belongs to one, and is notified when the account gets locked.
+func (g *LoginGuard) Fail(ctx context.Context, email, ip string, user *model.User) error {
+	ctx, span := tracer.Start(ctx, "LoginGuard.Fail")
+	defer span.End()
+
+	now := g.now()
+
+	keys := []struct {
+		key       string
+		lockAfter int
+	}{
+		{accountKey(email), g.policy.AccountLockAfter},
+		{ipKey(ip), g.policy.IPLockAfter},
+	}
+
+	for _, k := range keys {
+		throttle, err := g.repository.CountFailure(ctx, k.key, now, now.Add(-g.policy.Window))
+		if err != nil {
+			return err
+		}
+
+		if throttle.Failures < k.lockAfter {
+			continue
+		}
+
+		lockedUntil := now.Add(g.policy.LockDuration)
+		locked, err := g.repository.LockThrottle(ctx, k.key, k.lockAfter, now, lockedUntil)
+		if err != nil {
+			return err
+		}
+
+		if locked && throttle.Kind() == "account" && user != nil {
+			g.notifyLocked(user, ip, lockedUntil)
+		}
+	}
+
+	return nil
+}
+
+func (g *LoginGuard) notifyLocked(user *model.User, ip string, until time.Time) {
+	subject := "Your forum account was temporarily locked"
+	body := fmt.Sprintf("Hello %s,\n\nthere were too many failed attempts to sign in to your account, the last one from %s. "+
+		"Signing in is blocked until %s.\n\nIf this was not you, consider changing your password.",
+		user.Name, ip, until.Format(time.RFC1123))
+
+	// mail delivery must not slow down or fail the login request
+	go func() {
+		err := g.notifier.Notify(user, subject, body)
+		if err != nil {
+			g.l.Error("Failed to notify about account lock", "user_id", user.ID, "error", err.Error())
+		}
+	}()
+}
+
+// Succeed forgets the account's failures. Failures of the address remain,
+// so logging into one account does not reset guessing at others.
+func (g *LoginGuard) Succeed(ctx context.Context, email string) error {
+	ctx, span := tracer.Start(ctx, "LoginGuard.Succeed")
+	defer span.End()
+
+	return g.repository.DeleteThrottle(ctx, accountKey(email))
+}
+
+// GetLockouts returns all active locks.
+func (g *LoginGuard) GetLockouts(ctx context.Context) ([]*model.LoginThrottle, error) {
+	ctx, span := tracer.Start(ctx, "LoginGuard.GetLockouts")
+	defer span.End()
+
+	throttles, err := g.repository.GetLockedThrottles(ctx, g.now())
+	if err != nil {
+		return nil, err
+	}
+	return throttles, nil
+}
+
+func (g *LoginGuard) ClearLockout(ctx context.Context, key string) error {
+	ctx, span := tracer.Start(ctx, "LoginGuard.ClearLockout")
+	defer span.End()
+
+	return g.repository.DeleteThrottle(ctx, key)
+}
+
+// Purge drops entries whose failures no longer count and that are not
+// locked.
+func (g *LoginGuard) Purge(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "LoginGuard.Purge")
+	defer span.End()
+
+	return g.repository.DeleteStaleThrottles(ctx, g.now().Add(-g.policy.Window))
+}
+
+// Run purges every interval until ctx is done.
+func (g *LoginGuard) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := g.Purge(ctx)
+		if err != nil {
+			g.l.ErrorContext(ctx, "Unable to purge login throttles", "error", err.Error())
+		}
+	}
+}
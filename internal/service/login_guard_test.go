@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"simple-forum/internal/model"
+	"simple-forum/internal/repository/memory"
+	"sync"
+	"testing"
+	"time"
+)
+
+type notifierStub struct {
+	wg      sync.WaitGroup
+	subject string
+}
+
+func (n *notifierStub) Notify(user *model.User, subject, body string) error {
+	n.subject = subject
+	n.wg.Done()
+	return nil
+}
+
+func TestLoginGuard(t *testing.T) {
+	t.Parallel()
+
+	// as precise as stored timestamps, so the waits come out exact
+	now := time.Now().Round(time.Microsecond)
+	notifier := new(notifierStub)
+	guard := NewLoginGuard(slog.New(slog.NewTextHandler(io.Discard, nil)), memory.NewThrottleRepository(memory.NewStore()), notifier, LoginPolicy{
+		Window:           15 * time.Minute,
+		DelayAfter:       2,
+		BaseDelay:        time.Second,
+		MaxDelay:         4 * time.Second,
+		AccountLockAfter: 5,
+		IPLockAfter:      100,
+		LockDuration:     10 * time.Minute,
+	})
+	guard.now = func() time.Time { return now }
+
+	user := &model.User{ID: 1, Name: "jane", Email: "jane@example.com"}
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		email   string
+		ip      string
+		fail    bool
+		wait    time.Duration
+		err     error
+	}{
+		{name: "First Failure", email: "Jane@example.com", fail: true},
+		{name: "No Delay Yet", email: "jane@example.com", fail: true},
+		{name: "Throttled", email: "jane@example.com", wait: time.Second, err: ErrLoginThrottled},
+		{name: "Delay Passed", advance: time.Second, email: "jane@example.com", fail: true},
+		{name: "Delay Doubled", email: "jane@example.com", wait: 2 * time.Second, err: ErrLoginThrottled},
+		{name: "Same Address", advance: time.Second, email: "bob@example.com", wait: time.Second, err: ErrLoginThrottled},
+		{name: "Other Account", email: "bob@example.com", ip: "10.0.0.2"},
+		{name: "Doubled Delay Passed", advance: time.Second, email: "jane@example.com", fail: true},
+		{name: "Delay Doubled Again", email: "jane@example.com", wait: 4 * time.Second, err: ErrLoginThrottled},
+		{name: "Locking Failure", advance: 4 * time.Second, email: "jane@example.com", fail: true},
+		{name: "Locked", advance: 5 * time.Minute, email: "jane@example.com", wait: 5 * time.Minute, err: ErrLoginLocked},
+		{name: "Lock Expired", advance: 6 * time.Minute, email: "jane@example.com"},
+	}
+
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+
+		ip := tt.ip
+		if ip == "" {
+			ip = "10.0.0.1"
+		}
+
+		err := guard.Check(context.Background(), tt.email, ip)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+		var retry *LoginRetryError
+		if errors.As(err, &retry) && retry.After != tt.wait {
+			t.Errorf("%s: expected to be told to wait %s, got %s", tt.name, tt.wait, retry.After)
+		}
+
+		if tt.fail {
+			if tt.name == "Locking Failure" {
+				notifier.wg.Add(1)
+			}
+			if err = guard.Fail(context.Background(), tt.email, ip, user); err != nil {
+				t.Fatalf("%s: no error expected, but got %s", tt.name, err)
+			}
+		}
+	}
+
+	notifier.wg.Wait()
+	if notifier.subject == "" {
+		t.Error("expected the account owner to be notified about the lock")
+	}
+
+	if err := guard.Succeed(context.Background(), "jane@example.com"); err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+	if err := guard.Check(context.Background(), "jane@example.com", "10.0.0.3"); err != nil {
+		t.Errorf("expected successful login to reset the account, got %s", err)
+	}
+}
+
+func TestLoginGuard_ConcurrentFailures(t *testing.T) {
+	t.Parallel()
+
+	notifier := new(notifierStub)
+	guard := NewLoginGuard(slog.New(slog.NewTextHandler(io.Discard, nil)), memory.NewThrottleRepository(memory.NewStore()), notifier, LoginPolicy{
+		Window:           15 * time.Minute,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Second,
+		AccountLockAfter: 5,
+		IPLockAfter:      100,
+		LockDuration:     10 * time.Minute,
+	})
+
+	user := &model.User{ID: 1, Name: "jane", Email: "jane@example.com"}
+
+	// only the failure that locks the account notifies
+	notifier.wg.Add(1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := guard.Fail(context.Background(), "jane@example.com", "10.0.0.1", user); err != nil {
+				t.Errorf("no error expected, but got %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	notifier.wg.Wait()
+
+	lockouts, err := guard.GetLockouts(context.Background())
+	if err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Failures != 20 {
+		t.Errorf("expected the account locked after 20 failures, got %+v", lockouts)
+	}
+}
@@ -0,0 +1,508 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"simple-forum/internal/model"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrInvalidMessage       = errors.New("invalid message")
+	ErrNoRecipients         = errors.New("conversation has no one to message")
+	ErrTooManyParticipants  = errors.New("conversation has too many participants")
+	ErrConversationClosed   = errors.New("everyone else has left the conversation")
+	ErrMessagingBanned      = errors.New("user is banned from messaging")
+	ErrRecipientBlocked     = errors.New("recipient has blocked the user")
+	ErrBlockSelf            = errors.New("users cannot block themselves")
+	ErrBanReasonTooLong     = errors.New("ban reason is too long")
+)
+
+// DefaultMaxParticipants is how many users a conversation may have, the one
+// starting it included, unless WithMaxParticipants says otherwise.
+const DefaultMaxParticipants = 10
+
+// The most characters a subject, a message and the reason of a ban hold.
+const (
+	maxSubjectLength   = 150
+	maxMessageLength   = 10000
+	maxBanReasonLength = 200
+)
+
+// conversationLimit is how many conversations GetConversations returns.
+const conversationLimit = 100
+
+type MessageStorage interface {
+	InsertConversation(ctx context.Context, conversation *model.Conversation) (int, error)
+	GetConversationByID(ctx context.Context, id int) (*model.Conversation, error)
+	GetConversationsByUserID(ctx context.Context, userID, limit int) ([]*model.Conversation, error)
+	SetLastMessageAt(ctx context.Context, id int, at time.Time) error
+	DeleteConversation(ctx context.Context, id int) error
+	InsertParticipant(ctx context.Context, participant *model.Participant) error
+	GetParticipants(ctx context.Context, conversationID int) ([]*model.Participant, error)
+	GetParticipantsByUserID(ctx context.Context, userID int) ([]*model.Participant, error)
+	MarkRead(ctx context.Context, conversationID, userID, messageID int) error
+	SetMuted(ctx context.Context, conversationID, userID int, muted bool) error
+	LeaveConversation(ctx context.Context, conversationID, userID int, at time.Time) error
+	CountUnreadMessages(ctx context.Context, userID int) (int, error)
+	InsertMessage(ctx context.Context, message *model.Message) (int, error)
+	GetMessagesByConversationID(ctx context.Context, conversationID int) ([]*model.Message, error)
+}
+
+type BlockStorage interface {
+	InsertBlock(ctx context.Context, block *model.UserBlock) error
+	DeleteBlock(ctx context.Context, userID, blockedUserID int) error
+	GetBlocksByUserID(ctx context.Context, userID int) ([]*model.UserBlock, error)
+	GetBlockerIDs(ctx context.Context, blockedUserID int) ([]int, error)
+	SaveMessagingBan(ctx context.Context, ban *model.MessagingBan) error
+	DeleteMessagingBan(ctx context.Context, userID int) error
+	GetMessagingBan(ctx context.Context, userID int) (*model.MessagingBan, error)
+	GetMessagingBans(ctx context.Context) ([]*model.MessagingBan, error)
+}
+
+// MessageService runs the private conversations between users. Users
+// banned from messaging by an admin cannot send messages, and nobody can
+// send messages to a conversation with someone who blocked them.
+type MessageService struct {
+	repository      MessageStorage
+	blocks          BlockStorage
+	users           UserStorage
+	tx              Transactor
+	maxParticipants int
+}
+
+func NewMessageService(repository MessageStorage, blocks BlockStorage, users UserStorage, tx Transactor) *MessageService {
+	return &MessageService{repository: repository, blocks: blocks, users: users, tx: tx, maxParticipants: DefaultMaxParticipants}
+}
+
+// WithMaxParticipants sets how many users a conversation may have.
+func (m *MessageService) WithMaxParticipants(n int) *MessageService {
+	m.maxParticipants = n
+	return m
+}
+
+// StartConversation sends the first message of a new conversation between
+// the sender and the users named in recipients, which may start with @.
+// Unknown names give ErrUserNotFound, and the sender among the recipients
+// is left out.
+func (m *MessageService) StartConversation(ctx context.Context, senderID int, recipients []string, subject, body string) (*model.Conversation, error) {
+	ctx, span := tracer.Start(ctx, "MessageService.StartConversation")
+	defer span.End()
+
+	subject = strings.TrimSpace(subject)
+	body = strings.TrimSpace(body)
+
+	switch {
+	case subject == "":
+		return nil, fmt.Errorf("%w: a subject is needed", ErrInvalidMessage)
+	case utf8.RuneCountInString(subject) > maxSubjectLength:
+		return nil, fmt.Errorf("%w: the subject is limited to %d characters", ErrInvalidMessage, maxSubjectLength)
+	}
+	if err := checkBody(body); err != nil {
+		return nil, err
+	}
+
+	if err := m.checkBan(ctx, senderID); err != nil {
+		return nil, err
+	}
+
+	var others []*model.Participant
+	for _, name := range recipients {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+		if name == "" {
+			continue
+		}
+
+		user, err := m.users.GetUserByUsername(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, name)
+		}
+
+		if user.ID == senderID || slices.ContainsFunc(others, func(p *model.Participant) bool { return p.UserID == user.ID }) {
+			continue
+		}
+		others = append(others, &model.Participant{UserID: user.ID, UserName: user.Name})
+	}
+
+	if len(others) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if len(others)+1 > m.maxParticipants {
+		return nil, fmt.Errorf("%w: a conversation has at most %d participants", ErrTooManyParticipants, m.maxParticipants)
+	}
+
+	if err := m.checkBlocks(ctx, senderID, others); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	conversation := &model.Conversation{Subject: subject, CreatedAt: now, LastMessageAt: now}
+
+	err := m.tx.WithinTx(ctx, func(ctx context.Context) error {
+		_, err := m.repository.InsertConversation(ctx, conversation)
+		if err != nil {
+			return err
+		}
+
+		participants := append([]*model.Participant{{UserID: senderID}}, others...)
+		for _, participant := range participants {
+			participant.ConversationID = conversation.ID
+			participant.JoinedAt = now
+
+			err = m.repository.InsertParticipant(ctx, participant)
+			if err != nil {
+				return err
+			}
+		}
+
+		message := &model.Message{ConversationID: conversation.ID, UserID: senderID, Body: body, CreatedAt: now}
+		_, err = m.repository.InsertMessage(ctx, message)
+		if err != nil {
+			return err
+		}
+
+		return m.repository.MarkRead(ctx, conversation.ID, senderID, message.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return conversation, nil
+}
+
+// Reply sends a message to a conversation the sender takes part in. It
+// gives ErrConversationClosed once everyone else has left.
+func (m *MessageService) Reply(ctx context.Context, senderID, conversationID int, body string) (*model.Message, error) {
+	ctx, span := tracer.Start(ctx, "MessageService.Reply")
+	defer span.End()
+
+	body = strings.TrimSpace(body)
+	if err := checkBody(body); err != nil {
+		return nil, err
+	}
+
+	_, participants, err := m.participant(ctx, conversationID, senderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.checkBan(ctx, senderID); err != nil {
+		return nil, err
+	}
+
+	var others []*model.Participant
+	for _, participant := range participants {
+		if participant.UserID != senderID && participant.Active() {
+			others = append(others, participant)
+		}
+	}
+	if len(others) == 0 {
+		return nil, ErrConversationClosed
+	}
+
+	if err = m.checkBlocks(ctx, senderID, others); err != nil {
+		return nil, err
+	}
+
+	message := &model.Message{ConversationID: conversationID, UserID: senderID, Body: body, CreatedAt: time.Now()}
+
+	err = m.tx.WithinTx(ctx, func(ctx context.Context) error {
+		_, err := m.repository.InsertMessage(ctx, message)
+		if err != nil {
+			return err
+		}
+
+		err = m.repository.SetLastMessageAt(ctx, conversationID, message.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		return m.repository.MarkRead(ctx, conversationID, senderID, message.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func checkBody(body string) error {
+	switch {
+	case body == "":
+		return fmt.Errorf("%w: the message is empty", ErrInvalidMessage)
+	case utf8.RuneCountInString(body) > maxMessageLength:
+		return fmt.Errorf("%w: a message is limited to %d characters", ErrInvalidMessage, maxMessageLength)
+	}
+	return nil
+}
+
+func (m *MessageService) checkBan(ctx context.Context, userID int) error {
+	ban, err := m.blocks.GetMessagingBan(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if ban != nil {
+		return ErrMessagingBanned
+	}
+	return nil
+}
+
+// checkBlocks gives ErrRecipientBlocked, naming the recipient, if any of
+// recipients has blocked the sender.
+func (m *MessageService) checkBlocks(ctx context.Context, senderID int, recipients []*model.Participant) error {
+	blockers, err := m.blocks.GetBlockerIDs(ctx, senderID)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if slices.Contains(blockers, recipient.UserID) {
+			return fmt.Errorf("%w: %s", ErrRecipientBlocked, recipient.UserName)
+		}
+	}
+	return nil
+}
+
+// participant returns the user as a participant of the conversation along
+// with everyone in it, or ErrConversationNotFound if they are not in it or
+// have left.
+func (m *MessageService) participant(ctx context.Context, conversationID, userID int) (*model.Participant, []*model.Participant, error) {
+	participants, err := m.repository.GetParticipants(ctx, conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	i := slices.IndexFunc(participants, func(p *model.Participant) bool {
+		return p.UserID == userID && p.Active()
+	})
+	if i < 0 {
+		return nil, nil, ErrConversationNotFound
+	}
+	return participants[i], participants, nil
+}
+
+// GetConversations returns the newest conversations the user takes part
+// in, with the last message first.
+func (m *MessageService) GetConversations(ctx context.Context, userID int) ([]*model.Conversation, error) {
+	ctx, span := tracer.Start(ctx, "MessageService.GetConversations")
+	defer span.End()
+
+	conversations, err := m.repository.GetConversationsByUserID(ctx, userID, conversationLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	participants, err := m.repository.GetParticipantsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byConversation := make(map[int][]*model.Participant, len(conversations))
+	for _, participant := range participants {
+		byConversation[participant.ConversationID] = append(byConversation[participant.ConversationID], participant)
+	}
+	for _, conversation := range conversations {
+		conversation.Participants = byConversation[conversation.ID]
+	}
+
+	return conversations, nil
+}
+
+// GetConversation returns the conversation with its messages, oldest
+// first, or ErrConversationNotFound unless the user takes part in it.
+func (m *MessageService) GetConversation(ctx context.Context, userID, conversationID int) (*model.Conversation, []*model.Message, error) {
+	ctx, span := tracer.Start(ctx, "MessageService.GetConversation")
+	defer span.End()
+
+	conversation, err := m.repository.GetConversationByID(ctx, conversationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	participant, participants, err := m.participant(ctx, conversationID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	conversation.Participants = participants
+	conversation.LastReadID = participant.LastReadID
+	conversation.Muted = participant.Muted
+
+	messages, err := m.repository.GetMessagesByConversationID(ctx, conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, message := range messages {
+		if message.ID > participant.LastReadID && message.UserID != userID {
+			conversation.Unread++
+		}
+	}
+
+	return conversation, messages, nil
+}
+
+// MarkRead records that the user has seen the conversation up to
+// messageID.
+func (m *MessageService) MarkRead(ctx context.Context, userID, conversationID, messageID int) error {
+	ctx, span := tracer.Start(ctx, "MessageService.MarkRead")
+	defer span.End()
+
+	return m.repository.MarkRead(ctx, conversationID, userID, messageID)
+}
+
+// CountUnread returns how many messages the user has not read, leaving out
+// the conversations they muted.
+func (m *MessageService) CountUnread(ctx context.Context, userID int) (int, error) {
+	ctx, span := tracer.Start(ctx, "MessageService.CountUnread")
+	defer span.End()
+
+	return m.repository.CountUnreadMessages(ctx, userID)
+}
+
+// SetMuted mutes or unmutes the conversation for the user.
+func (m *MessageService) SetMuted(ctx context.Context, userID, conversationID int, muted bool) error {
+	ctx, span := tracer.Start(ctx, "MessageService.SetMuted")
+	defer span.End()
+
+	_, _, err := m.participant(ctx, conversationID, userID)
+	if err != nil {
+		return err
+	}
+
+	return m.repository.SetMuted(ctx, conversationID, userID, muted)
+}
+
+// Leave takes the user out of the conversation. The others keep it, and
+// it is deleted when the last participant leaves.
+func (m *MessageService) Leave(ctx context.Context, userID, conversationID int) error {
+	ctx, span := tracer.Start(ctx, "MessageService.Leave")
+	defer span.End()
+
+	return m.tx.WithinTx(ctx, func(ctx context.Context) error {
+		_, participants, err := m.participant(ctx, conversationID, userID)
+		if err != nil {
+			return err
+		}
+
+		err = m.repository.LeaveConversation(ctx, conversationID, userID, time.Now())
+		if err != nil {
+			return err
+		}
+
+		remaining := slices.ContainsFunc(participants, func(p *model.Participant) bool {
+			return p.UserID != userID && p.Active()
+		})
+		if !remaining {
+			return m.repository.DeleteConversation(ctx, conversationID)
+		}
+		return nil
+	})
+}
+
+// Block keeps the user named username from messaging the user. It gives
+// ErrUserNotFound for an unknown name.
+func (m *MessageService) Block(ctx context.Context, userID int, username string) error {
+	ctx, span := tracer.Start(ctx, "MessageService.Block")
+	defer span.End()
+
+	user, err := m.users.GetUserByUsername(ctx, strings.TrimPrefix(strings.TrimSpace(username), "@"))
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if user.ID == userID {
+		return ErrBlockSelf
+	}
+
+	return m.blocks.InsertBlock(ctx, &model.UserBlock{UserID: userID, BlockedUserID: user.ID, CreatedAt: time.Now()})
+}
+
+func (m *MessageService) Unblock(ctx context.Context, userID, blockedUserID int) error {
+	ctx, span := tracer.Start(ctx, "MessageService.Unblock")
+	defer span.End()
+
+	return m.blocks.DeleteBlock(ctx, userID, blockedUserID)
+}
+
+// GetBlocks returns the users the user has blocked.
+func (m *MessageService) GetBlocks(ctx context.Context, userID int) ([]*model.UserBlock, error) {
+	ctx, span := tracer.Start(ctx, "MessageService.GetBlocks")
+	defer span.End()
+
+	return m.blocks.GetBlocksByUserID(ctx, userID)
+}
+
+// GetMessagingBan returns the ban of the user, or nil if they may send
+// messages.
+func (m *MessageService) GetMessagingBan(ctx context.Context, userID int) (*model.MessagingBan, error) {
+	ctx, span := tracer.Start(ctx, "MessageService.GetMessagingBan")
+	defer span.End()
+
+	return m.blocks.GetMessagingBan(ctx, userID)
+}
+
+func (m *MessageService) GetMessagingBans(ctx context.Context) ([]*model.MessagingBan, error) {
+	ctx, span := tracer.Start(ctx, "MessageService.GetMessagingBans")
+	defer span.End()
+
+	return m.blocks.GetMessagingBans(ctx)
+}
+
+// BanFromMessaging keeps the user named username from sending messages, on
+// behalf of the admin adminID. Banning a banned user again replaces the
+// reason.
+func (m *MessageService) BanFromMessaging(ctx context.Context, adminID int, username, reason string) (*model.MessagingBan, error) {
+	ctx, span := tracer.Start(ctx, "MessageService.BanFromMessaging")
+	defer span.End()
+
+	reason = strings.TrimSpace(reason)
+	if utf8.RuneCountInString(reason) > maxBanReasonLength {
+		return nil, fmt.Errorf("%w: the reason is limited to %d characters", ErrBanReasonTooLong, maxBanReasonLength)
+	}
+
+	user, err := m.users.GetUserByUsername(ctx, strings.TrimPrefix(strings.TrimSpace(username), "@"))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	ban := &model.MessagingBan{UserID: user.ID, UserName: user.Name, BannedBy: adminID, Reason: reason, CreatedAt: time.Now()}
+
+	err = m.blocks.SaveMessagingBan(ctx, ban)
+	if err != nil {
+		return nil, err
+	}
+	return ban, nil
+}
+
+// LiftMessagingBan lets the user send messages again and returns the ban
+// lifted, nil if there was none.
+func (m *MessageService) LiftMessagingBan(ctx context.Context, userID int) (*model.MessagingBan, error) {
+	ctx, span := tracer.Start(ctx, "MessageService.LiftMessagingBan")
+	defer span.End()
+
+	ban, err := m.blocks.GetMessagingBan(ctx, userID)
+	if err != nil || ban == nil {
+		return nil, err
+	}
+
+	err = m.blocks.DeleteMessagingBan(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return ban, nil
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"simple-forum/internal/model"
+	"simple-forum/internal/repository/memory"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageService(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := memory.NewStore()
+	users := memory.NewUserRepository(store)
+	messages := memory.NewMessageRepository(store)
+
+	newUser := func(name string) *model.User {
+		user := &model.User{Name: name, Email: name + "@example.com", PasswordHash: "hash", CreatedAt: time.Now(), Role: "user"}
+		_, err := users.InsertUser(ctx, user)
+		if err != nil {
+			t.Fatalf("unable to insert user: %v", err)
+		}
+		return user
+	}
+	ada, bob, cy := newUser("ada"), newUser("bob"), newUser("cy")
+	newUser("dee")
+
+	s := NewMessageService(messages, memory.NewBlockRepository(store), users, memory.NewTransactor(store)).
+		WithMaxParticipants(3)
+
+	for _, tt := range []struct {
+		name       string
+		recipients []string
+		subject    string
+		err        error
+	}{
+		{"unknown", []string{"bob", "nobody"}, "hi", ErrUserNotFound},
+		{"only self", []string{"ada", " "}, "hi", ErrNoRecipients},
+		{"too many", []string{"bob", "cy", "dee"}, "hi", ErrTooManyParticipants},
+		{"no subject", []string{"bob"}, " ", ErrInvalidMessage},
+		{"long subject", []string{"bob"}, strings.Repeat("s", 151), ErrInvalidMessage},
+	} {
+		_, err := s.StartConversation(ctx, ada.ID, tt.recipients, tt.subject, "hello")
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	conversation, err := s.StartConversation(ctx, ada.ID, []string{"@bob", "bob", "ada"}, " Lunch ", " hello ")
+	if err != nil {
+		t.Fatalf("unable to start conversation: %v", err)
+	}
+
+	got, thread, err := s.GetConversation(ctx, bob.ID, conversation.ID)
+	if err != nil || got.Subject != "Lunch" || len(got.Participants) != 2 || got.Unread != 1 {
+		t.Fatalf("expected the conversation of ada and bob with 1 unread, got %+v, %v", got, err)
+	}
+	if len(thread) != 1 || thread[0].Body != "hello" || thread[0].UserName != "ada" {
+		t.Errorf("expected the first message, got %+v", thread)
+	}
+	if count, _ := s.CountUnread(ctx, bob.ID); count != 1 {
+		t.Errorf("expected 1 unread for bob, got %d", count)
+	}
+	if count, _ := s.CountUnread(ctx, ada.ID); count != 0 {
+		t.Errorf("expected the own message read, got %d", count)
+	}
+
+	err = s.MarkRead(ctx, bob.ID, conversation.ID, thread[0].ID)
+	if err != nil {
+		t.Fatalf("unable to mark read: %v", err)
+	}
+	if count, _ := s.CountUnread(ctx, bob.ID); count != 0 {
+		t.Errorf("expected nothing unread after reading, got %d", count)
+	}
+
+	// others neither see nor write to the conversation
+	_, _, err = s.GetConversation(ctx, cy.ID, conversation.ID)
+	if !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound for someone else, got %v", err)
+	}
+	_, err = s.Reply(ctx, cy.ID, conversation.ID, "me too")
+	if !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound replying as someone else, got %v", err)
+	}
+
+	// blocked users cannot message
+	if err = s.Block(ctx, bob.ID, "bob"); !errors.Is(err, ErrBlockSelf) {
+		t.Errorf("expected ErrBlockSelf, got %v", err)
+	}
+	err = s.Block(ctx, bob.ID, "@ada")
+	if err != nil {
+		t.Fatalf("unable to block: %v", err)
+	}
+	_, err = s.Reply(ctx, ada.ID, conversation.ID, "still there?")
+	if !errors.Is(err, ErrRecipientBlocked) {
+		t.Errorf("expected ErrRecipientBlocked replying, got %v", err)
+	}
+	_, err = s.StartConversation(ctx, ada.ID, []string{"cy", "bob"}, "Party", "come over")
+	if !errors.Is(err, ErrRecipientBlocked) || !strings.Contains(err.Error(), "bob") {
+		t.Errorf("expected ErrRecipientBlocked naming bob, got %v", err)
+	}
+	_, err = s.Reply(ctx, bob.ID, conversation.ID, "blocking does not silence the blocker")
+	if err != nil {
+		t.Errorf("expected the blocker to reply, got %v", err)
+	}
+	err = s.Unblock(ctx, bob.ID, ada.ID)
+	if err != nil {
+		t.Fatalf("unable to unblock: %v", err)
+	}
+
+	// banned users cannot message anyone
+	ban, err := s.BanFromMessaging(ctx, 1, "ada", "spam")
+	if err != nil || ban.UserID != ada.ID {
+		t.Fatalf("unable to ban: %+v, %v", ban, err)
+	}
+	_, err = s.Reply(ctx, ada.ID, conversation.ID, "hello?")
+	if !errors.Is(err, ErrMessagingBanned) {
+		t.Errorf("expected ErrMessagingBanned, got %v", err)
+	}
+	lifted, err := s.LiftMessagingBan(ctx, ada.ID)
+	if err != nil || lifted == nil || lifted.Reason != "spam" {
+		t.Errorf("expected the ban lifted, got %+v, %v", lifted, err)
+	}
+
+	// muted conversations are not counted
+	err = s.SetMuted(ctx, bob.ID, conversation.ID, true)
+	if err != nil {
+		t.Fatalf("unable to mute: %v", err)
+	}
+	_, err = s.Reply(ctx, ada.ID, conversation.ID, "hello again")
+	if err != nil {
+		t.Fatalf("unable to reply: %v", err)
+	}
+	if count, _ := s.CountUnread(ctx, bob.ID); count != 0 {
+		t.Errorf("expected a muted conversation not counted, got %d", count)
+	}
+	conversations, err := s.GetConversations(ctx, bob.ID)
+	if err != nil || len(conversations) != 1 || conversations[0].Unread != 1 || !conversations[0].Muted || len(conversations[0].Participants) != 2 {
+		t.Errorf("expected the muted conversation listed with 1 unread, got %+v, %v", conversations, err)
+	}
+
+	// the last one to leave deletes the conversation
+	err = s.Leave(ctx, bob.ID, conversation.ID)
+	if err != nil {
+		t.Fatalf("unable to leave: %v", err)
+	}
+	if conversations, _ = s.GetConversations(ctx, bob.ID); len(conversations) != 0 {
+		t.Errorf("expected no conversations after leaving, got %+v", conversations)
+	}
+	_, err = s.Reply(ctx, ada.ID, conversation.ID, "anyone?")
+	if !errors.Is(err, ErrConversationClosed) {
+		t.Errorf("expected ErrConversationClosed, got %v", err)
+	}
+	err = s.Leave(ctx, ada.ID, conversation.ID)
+	if err != nil {
+		t.Fatalf("unable to leave: %v", err)
+	}
+	_, err = messages.GetConversationByID(ctx, conversation.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the conversation deleted, got %v", err)
+	}
+}
@@ -1,43 +1,155 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"simple-forum/internal/events"
+	"simple-forum/internal/mention"
+	"simple-forum/internal/metrics"
 	"simple-forum/internal/model"
+	"strings"
 	"time"
 )
 
+// maxMentions is the most users a post notifies; later mentions are left
+// as plain text.
+const maxMentions = 20
+
 type PostStorage interface {
-	GetPostsByTopicID(topicID int) ([]*model.Post, error)
-	GetPostByID(postID int) (*model.Post, error)
-	InsertPost(post *model.Post) (int, error)
-	UpdatePost(post *model.Post) error
-	DeletePost(post *model.Post) error
+	GetPostsByTopicID(ctx context.Context, topicID int) ([]*model.Post, error)
+	GetPostByID(ctx context.Context, postID int) (*model.Post, error)
+	GetLatestPublicPosts(ctx context.Context, limit int) ([]*model.Post, error)
+	GetPublicPosts(ctx context.Context, offset, limit int) ([]*model.Post, error)
+	CountPublicPosts(ctx context.Context) (int, error)
+	GetPublicPostsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Post, error)
+	CountPublicPostsByAuthorID(ctx context.Context, authorID int) (int, error)
+	InsertPost(ctx context.Context, post *model.Post) (int, error)
+	UpdatePost(ctx context.Context, post *model.Post) error
+	DeletePost(ctx context.Context, post *model.Post) error
+}
+
+type MentionStorage interface {
+	InsertMention(ctx context.Context, mention *model.Mention) (bool, error)
+	GetMentionsByPostID(ctx context.Context, postID int) ([]*model.Mention, error)
 }
 
 type PostService struct {
 	repository PostStorage
+	tx         Transactor
+	events     Publisher
+
+	mentions MentionStorage
+	users    UserStorage
+	l        *slog.Logger
+	notifier Notifier
+	// topics and baseURL make up the links in notifications
+	topics  TopicStorage
+	baseURL string
+}
+
+func NewPostService(repository PostStorage, tx Transactor, events Publisher) *PostService {
+	return &PostService{repository: repository, tx: tx, events: events}
+}
+
+// WithMentions records the users posts mention as @username, so that the
+// mentions are linked to their profiles.
+func (p *PostService) WithMentions(mentions MentionStorage, users UserStorage) *PostService {
+	p.mentions = mentions
+	p.users = users
+	return p
 }
 
-func NewPostService(repository PostStorage) *PostService {
-	return &PostService{repository: repository}
+// WithNotifier tells users by notifier when a post first mentions them.
+// Links to the post are its canonical path, with the topic looked up in
+// topics, after baseURL, the public address of the forum.
+func (p *PostService) WithNotifier(l *slog.Logger, notifier Notifier, topics TopicStorage, baseURL string) *PostService {
+	p.l = l
+	p.notifier = notifier
+	p.topics = topics
+	p.baseURL = strings.TrimSuffix(baseURL, "/")
+	return p
 }
 
-func (p *PostService) GetPostByID(userID int) (*model.Post, error) {
-	post, err := p.repository.GetPostByID(userID)
+func (p *PostService) GetPostByID(ctx context.Context, userID int) (*model.Post, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetPostByID")
+	defer span.End()
+
+	post, err := p.repository.GetPostByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 	return post, nil
 }
 
-func (p *PostService) GetPostsByTopicID(topicID int) ([]*model.Post, error) {
-	posts, err := p.repository.GetPostsByTopicID(topicID)
+func (p *PostService) GetPostsByTopicID(ctx context.Context, topicID int) ([]*model.Post, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetPostsByTopicID")
+	defer span.End()
+
+	posts, err := p.repository.GetPostsByTopicID(ctx, topicID)
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// GetLatestPublicPosts returns up to limit of the most recently updated
+// posts, leaving out those in private topics.
+func (p *PostService) GetLatestPublicPosts(ctx context.Context, limit int) ([]*model.Post, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetLatestPublicPosts")
+	defer span.End()
+
+	posts, err := p.repository.GetLatestPublicPosts(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// GetPublicPosts returns up to limit posts outside private topics in the
+// order they were created, skipping the first offset of them.
+func (p *PostService) GetPublicPosts(ctx context.Context, offset, limit int) ([]*model.Post, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetPublicPosts")
+	defer span.End()
+
+	posts, err := p.repository.GetPublicPosts(ctx, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (p *PostService) CountPublicPosts(ctx context.Context) (int, error) {
+	ctx, span := tracer.Start(ctx, "PostService.CountPublicPosts")
+	defer span.End()
+
+	return p.repository.CountPublicPosts(ctx)
+}
+
+// GetPublicPostsByAuthorID returns up to limit of the newest posts of the
+// author, leaving out those in private topics.
+func (p *PostService) GetPublicPostsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Post, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetPublicPostsByAuthorID")
+	defer span.End()
+
+	posts, err := p.repository.GetPublicPostsByAuthorID(ctx, authorID, limit)
 	if err != nil {
 		return nil, err
 	}
 	return posts, nil
 }
 
-func (p *PostService) CreatePost(title, content string, topicID, authorID int, authorName string) error {
+func (p *PostService) CountPublicPostsByAuthorID(ctx context.Context, authorID int) (int, error) {
+	ctx, span := tracer.Start(ctx, "PostService.CountPublicPostsByAuthorID")
+	defer span.End()
+
+	return p.repository.CountPublicPostsByAuthorID(ctx, authorID)
+}
+
+func (p *PostService) CreatePost(ctx context.Context, title, content string, topicID, authorID int, authorName string) (*model.Post, error) {
+	ctx, span := tracer.Start(ctx, "PostService.CreatePost")
+	defer span.End()
+
 	post := &model.Post{
 		Title:      title,
 		Content:    content,
@@ -48,40 +160,158 @@ func (p *PostService) CreatePost(title, content string, topicID, authorID int, a
 		UpdatedAt:  time.Now(),
 	}
 
-	postID, err := p.repository.InsertPost(post)
-	if err != nil {
+	var mentioned []*model.User
+
+	err := p.tx.WithinTx(ctx, func(ctx context.Context) error {
+		postID, err := p.repository.InsertPost(ctx, post)
+		if err != nil {
+			return err
+		}
+		post.ID = postID
+
+		mentioned, err = p.mention(ctx, post)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	post.ID = postID
-	return nil
+	metrics.PostsCreated.Inc()
+	p.events.Publish(ctx, events.PostCreated{PostID: post.ID, TopicID: post.TopicId, AuthorID: post.AuthorId})
+	p.notifyMentioned(ctx, post, mentioned)
+
+	return post, nil
 }
 
-func (p *PostService) EditPost(title, content string, postID int) error {
-	post, err := p.repository.GetPostByID(postID)
-	if err != nil {
+func (p *PostService) EditPost(ctx context.Context, title, content string, postID int) (*model.Post, error) {
+	ctx, span := tracer.Start(ctx, "PostService.EditPost")
+	defer span.End()
+
+	var post *model.Post
+	var mentioned []*model.User
+
+	err := p.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		post, err = p.repository.GetPostByID(ctx, postID)
+		if err != nil {
+			return err
+		}
+
+		post.Title = title
+		post.Content = content
+		post.UpdatedAt = time.Now()
+
+		err = p.repository.UpdatePost(ctx, post)
+		if err != nil {
+			return err
+		}
+
+		mentioned, err = p.mention(ctx, post)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	post.Title = title
-	post.Content = content
-	post.UpdatedAt = time.Now()
+	p.events.Publish(ctx, events.PostUpdated{PostID: post.ID, TopicID: post.TopicId})
+	p.notifyMentioned(ctx, post, mentioned)
+
+	return post, nil
+}
+
+func (p *PostService) DeletePost(ctx context.Context, postID int) error {
+	ctx, span := tracer.Start(ctx, "PostService.DeletePost")
+	defer span.End()
 
-	err = p.repository.UpdatePost(post)
+	var post *model.Post
+
+	err := p.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		post, err = p.repository.GetPostByID(ctx, postID)
+		if err != nil {
+			return err
+		}
+		return p.repository.DeletePost(ctx, post)
+	})
 	if err != nil {
 		return err
 	}
+
+	p.events.Publish(ctx, events.PostDeleted{PostID: post.ID, TopicID: post.TopicId})
 	return nil
 }
 
-func (p *PostService) DeletePost(postID int) error {
-	post, err := p.repository.GetPostByID(postID)
-	if err != nil {
-		return err
+// GetMentions returns the users the post has mentioned, including those
+// edited out since.
+func (p *PostService) GetMentions(ctx context.Context, postID int) ([]*model.Mention, error) {
+	ctx, span := tracer.Start(ctx, "PostService.GetMentions")
+	defer span.End()
+
+	if p.mentions == nil {
+		return nil, nil
+	}
+	return p.mentions.GetMentionsByPostID(ctx, postID)
+}
+
+// mention records the users the content of post mentions and returns
+// those it mentions for the first time. Names of no user are skipped, and
+// so is the author.
+func (p *PostService) mention(ctx context.Context, post *model.Post) ([]*model.User, error) {
+	if p.mentions == nil {
+		return nil, nil
+	}
+
+	names := mention.Find(post.Content)
+	if len(names) > maxMentions {
+		names = names[:maxMentions]
+	}
+
+	var mentioned []*model.User
+	for _, name := range names {
+		user, err := p.users.GetUserByUsername(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || user.ID == post.AuthorId {
+			continue
+		}
+
+		created, err := p.mentions.InsertMention(ctx, &model.Mention{PostID: post.ID, UserID: user.ID, CreatedAt: time.Now()})
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			mentioned = append(mentioned, user)
+		}
+	}
+
+	return mentioned, nil
+}
+
+func (p *PostService) notifyMentioned(ctx context.Context, post *model.Post, users []*model.User) {
+	if p.notifier == nil || len(users) == 0 {
+		return
 	}
-	err = p.repository.DeletePost(post)
+
+	postID := post.ID
+	topic, err := p.topics.GetTopicByID(ctx, post.TopicId)
 	if err != nil {
-		return err
+		// the post is saved already, only the mail is lost
+		p.l.ErrorContext(ctx, "Failed to notify about mention", "post_id", postID, "error", err.Error())
+		return
 	}
-	return nil
+
+	subject := fmt.Sprintf("%s mentioned you in %q", post.AuthorName, post.Title)
+	text := fmt.Sprintf("%s mentioned you in the post %q:\n\n%s%s",
+		post.AuthorName, post.Title, p.baseURL, post.Path(topic))
+
+	// mail delivery must not slow down or fail the post
+	go func() {
+		for _, user := range users {
+			err := p.notifier.Notify(user, subject, fmt.Sprintf("Hello %s,\n\n%s", user.Name, text))
+			if err != nil {
+				p.l.Error("Failed to notify about mention", "user_id", user.ID, "post_id", postID, "error", err.Error())
+			}
+		}
+	}()
 }
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"simple-forum/internal/events"
+	"simple-forum/internal/model"
+	"simple-forum/internal/repository/memory"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mentionNotifierStub struct {
+	notified chan string
+}
+
+func (n *mentionNotifierStub) Notify(user *model.User, subject, body string) error {
+	n.notified <- user.Name + ": " + body
+	return nil
+}
+
+func TestPostService_Mentions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := memory.NewStore()
+	users := memory.NewUserRepository(store)
+
+	newUser := func(name string) *model.User {
+		user := &model.User{Name: name, Email: name + "@example.com", PasswordHash: "hash", CreatedAt: time.Now(), Role: "user"}
+		_, err := users.InsertUser(ctx, user)
+		if err != nil {
+			t.Fatalf("unable to insert user: %v", err)
+		}
+		return user
+	}
+	ada := newUser("ada")
+	newUser("bob")
+	newUser("cy")
+
+	topic := &model.Topic{Name: "Go", Description: "Go", CreatedAt: time.Now(), AuthorId: ada.ID}
+	topics := memory.NewTopicRepository(store)
+	_, err := topics.InsertTopic(ctx, topic)
+	if err != nil {
+		t.Fatalf("unable to insert topic: %v", err)
+	}
+
+	notifier := &mentionNotifierStub{notified: make(chan string, 10)}
+	s := NewPostService(memory.NewPostRepository(store), memory.NewTransactor(store), events.NewLocalBus()).
+		WithMentions(memory.NewMentionRepository(store), users).
+		WithNotifier(slog.New(slog.NewTextHandler(io.Discard, nil)), notifier, topics, "https://forum.example.com/")
+
+	expectNotified := func(names ...string) {
+		t.Helper()
+		for _, name := range names {
+			select {
+			case got := <-notifier.notified:
+				if !strings.HasPrefix(got, name+": ") {
+					t.Errorf("expected %s to be notified, got %q", name, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expected %s to be notified", name)
+			}
+		}
+	}
+
+	post, err := s.CreatePost(ctx, "Hello", "@bob, @cy and @bob again; not @nobody, @ada or ada@example.com", topic.ID, ada.ID, ada.Name)
+	if err != nil {
+		t.Fatalf("unable to create post: %v", err)
+	}
+	expectNotified("bob", "cy")
+
+	mentions, err := s.GetMentions(ctx, post.ID)
+	if err != nil || len(mentions) != 2 || mentions[0].UserName != "bob" || mentions[1].UserName != "cy" {
+		t.Errorf("expected bob and cy mentioned, got %+v, %v", mentions, err)
+	}
+
+	// edits notify only who the post did not mention before
+	newUser("dee")
+	_, err = s.EditPost(ctx, "Hello", "@bob, now with @dee", post.ID)
+	if err != nil {
+		t.Fatalf("unable to edit post: %v", err)
+	}
+	expectNotified("dee")
+
+	mentions, _ = s.GetMentions(ctx, post.ID)
+	if len(mentions) != 3 {
+		t.Errorf("expected mentions edited out to be kept, got %+v", mentions)
+	}
+
+	_, err = s.EditPost(ctx, "Hello", "@cy @dee", post.ID)
+	if err != nil {
+		t.Fatalf("unable to edit post: %v", err)
+	}
+	_, err = s.CreatePost(ctx, "Again", "@cy", topic.ID, ada.ID, ada.Name)
+	if err != nil {
+		t.Fatalf("unable to create post: %v", err)
+	}
+	select {
+	case got := <-notifier.notified:
+		if got != "cy: Hello cy,\n\nada mentioned you in the post \"Again\":\n\nhttps://forum.example.com/topics/1-go/posts/2-again" {
+			t.Errorf("expected cy notified of the new post only, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected cy to be notified of the new post")
+	}
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"simple-forum/internal/avatar"
+	"simple-forum/internal/blob"
+	"simple-forum/internal/model"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrProfileTooLong = errors.New("profile field is too long")
+	ErrInvalidWebsite = errors.New("website must be an http or https address")
+	ErrAvatarTooLarge = errors.New("avatar file is too large")
+	ErrAvatarNotFound = errors.New("avatar not found")
+)
+
+// DefaultMaxAvatarBytes is the largest avatar upload accepted unless
+// WithMaxAvatarBytes says otherwise.
+const DefaultMaxAvatarBytes = 5 << 20
+
+// avatarFile matches the names model.Profile.AvatarFile gives avatars.
+var avatarFile = regexp.MustCompile(`^[0-9]+-[0-9a-f]+-[0-9]+\.jpg$`)
+
+// profileLimits are the most characters each profile field holds, as in
+// the user_profiles table.
+var profileLimits = []struct {
+	name  string
+	field func(p *model.Profile) *string
+	max   int
+}{
+	{"display name", func(p *model.Profile) *string { return &p.DisplayName }, 50},
+	{"bio", func(p *model.Profile) *string { return &p.Bio }, 2000},
+	{"location", func(p *model.Profile) *string { return &p.Location }, 100},
+	{"website", func(p *model.Profile) *string { return &p.Website }, 200},
+	{"signature", func(p *model.Profile) *string { return &p.Signature }, 300},
+}
+
+type ProfileStorage interface {
+	GetProfile(ctx context.Context, userID int) (*model.Profile, error)
+	SaveProfile(ctx context.Context, profile *model.Profile) error
+	SaveAvatar(ctx context.Context, userID int, avatar string, updatedAt time.Time) error
+}
+
+type ProfileService struct {
+	repository     ProfileStorage
+	users          UserStorage
+	blobs          BlobStore
+	maxAvatarBytes int64
+}
+
+func NewProfileService(repository ProfileStorage, users UserStorage, blobs BlobStore) *ProfileService {
+	return &ProfileService{repository: repository, users: users, blobs: blobs, maxAvatarBytes: DefaultMaxAvatarBytes}
+}
+
+// WithMaxAvatarBytes sets the largest avatar upload accepted.
+func (p *ProfileService) WithMaxAvatarBytes(n int64) *ProfileService {
+	p.maxAvatarBytes = n
+	return p
+}
+
+// GetUserProfile returns the user named username with their profile, or
+// ErrUserNotFound.
+func (p *ProfileService) GetUserProfile(ctx context.Context, username string) (*model.User, *model.Profile, error) {
+	ctx, span := tracer.Start(ctx, "ProfileService.GetUserProfile")
+	defer span.End()
+
+	user, err := p.users.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, ErrUserNotFound
+	}
+
+	profile, err := p.GetProfile(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, profile, nil
+}
+
+// GetProfile returns the profile of the user, which is empty when they
+// have not saved one.
+func (p *ProfileService) GetProfile(ctx context.Context, userID int) (*model.Profile, error) {
+	ctx, span := tracer.Start(ctx, "ProfileService.GetProfile")
+	defer span.End()
+
+	profile, err := p.repository.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		profile = &model.Profile{UserID: userID}
+	}
+	return profile, nil
+}
+
+// UpdateProfile saves profile after trimming its fields. A website without
+// a scheme is taken to be https.
+func (p *ProfileService) UpdateProfile(ctx context.Context, profile *model.Profile) error {
+	ctx, span := tracer.Start(ctx, "ProfileService.UpdateProfile")
+	defer span.End()
+
+	for _, limit := range profileLimits {
+		field := limit.field(profile)
+		*field = strings.TrimSpace(*field)
+		if utf8.RuneCountInString(*field) > limit.max {
+			return fmt.Errorf("%w: the %s is limited to %d characters", ErrProfileTooLong, limit.name, limit.max)
+		}
+	}
+
+	if profile.Website != "" {
+		if !strings.Contains(profile.Website, "://") {
+			profile.Website = "https://" + profile.Website
+		}
+		u, err := url.Parse(profile.Website)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidWebsite
+		}
+	}
+
+	profile.UpdatedAt = time.Now()
+
+	return p.repository.SaveProfile(ctx, profile)
+}
+
+// SetAvatar makes the picture r reads the avatar of the user, stored in
+// each of model.AvatarSizes, and returns their profile. Pictures that are
+// too large or no image give ErrAvatarTooLarge or an error of the avatar
+// package.
+func (p *ProfileService) SetAvatar(ctx context.Context, userID int, r io.Reader) (*model.Profile, error) {
+	ctx, span := tracer.Start(ctx, "ProfileService.SetAvatar")
+	defer span.End()
+
+	data, err := io.ReadAll(io.LimitReader(r, p.maxAvatarBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > p.maxAvatarBytes {
+		return nil, ErrAvatarTooLarge
+	}
+
+	images, err := avatar.Process(data, model.AvatarSizes)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := p.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// named after the upload, so the addresses of the files change with it
+	// and browsers may cache them for good
+	sum := sha256.Sum256(data)
+	version := hex.EncodeToString(sum[:8])
+	if profile.Avatar == version {
+		return profile, nil
+	}
+
+	updated := *profile
+	updated.Avatar = version
+	updated.UpdatedAt = time.Now()
+
+	for size, image := range images {
+		err = p.blobs.Put(ctx, avatarKey(&updated, size), bytes.NewReader(image), "image/jpeg")
+		if err != nil {
+			p.deleteAvatar(ctx, &updated)
+			return nil, err
+		}
+	}
+
+	err = p.repository.SaveAvatar(ctx, userID, updated.Avatar, updated.UpdatedAt)
+	if err != nil {
+		p.deleteAvatar(ctx, &updated)
+		return nil, err
+	}
+
+	p.deleteAvatar(ctx, profile)
+
+	return &updated, nil
+}
+
+// RemoveAvatar goes back to the identicon of the user and returns their
+// profile.
+func (p *ProfileService) RemoveAvatar(ctx context.Context, userID int) (*model.Profile, error) {
+	ctx, span := tracer.Start(ctx, "ProfileService.RemoveAvatar")
+	defer span.End()
+
+	profile, err := p.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Avatar == "" {
+		return profile, nil
+	}
+
+	updated := *profile
+	updated.Avatar = ""
+	updated.UpdatedAt = time.Now()
+
+	err = p.repository.SaveAvatar(ctx, userID, "", updated.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	p.deleteAvatar(ctx, profile)
+
+	return &updated, nil
+}
+
+// OpenAvatar returns the avatar named file, as in model.Profile.AvatarFile,
+// or ErrAvatarNotFound.
+func (p *ProfileService) OpenAvatar(ctx context.Context, file string) (io.ReadCloser, *blob.Info, error) {
+	ctx, span := tracer.Start(ctx, "ProfileService.OpenAvatar")
+	defer span.End()
+
+	if !avatarFile.MatchString(file) {
+		return nil, nil, ErrAvatarNotFound
+	}
+
+	r, info, err := p.blobs.Open(ctx, "avatars/"+file)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, nil, ErrAvatarNotFound
+	}
+	return r, info, err
+}
+
+// deleteAvatar removes the files of the avatar of profile, if it has one.
+// It carries on past errors; a file left behind only takes up space.
+func (p *ProfileService) deleteAvatar(ctx context.Context, profile *model.Profile) {
+	if profile.Avatar == "" {
+		return
+	}
+	for _, size := range model.AvatarSizes {
+		_ = p.blobs.Delete(context.WithoutCancel(ctx), avatarKey(profile, size))
+	}
+}
+
+func avatarKey(profile *model.Profile, size int) string {
+	return "avatars/" + profile.AvatarFile(size)
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"simple-forum/internal/avatar"
+	"simple-forum/internal/blob"
+	"simple-forum/internal/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+type profileStub struct {
+	saved *model.Profile
+}
+
+func (s *profileStub) GetProfile(ctx context.Context, userID int) (*model.Profile, error) {
+	if s.saved == nil {
+		return nil, nil
+	}
+	profile := *s.saved
+	return &profile, nil
+}
+
+func (s *profileStub) SaveProfile(ctx context.Context, profile *model.Profile) error {
+	s.saved = profile
+	return nil
+}
+
+func (s *profileStub) SaveAvatar(ctx context.Context, userID int, avatar string, updatedAt time.Time) error {
+	if s.saved == nil {
+		s.saved = &model.Profile{UserID: userID}
+	}
+	s.saved.Avatar = avatar
+	return nil
+}
+
+func TestProfileService_UpdateProfile(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		profile model.Profile
+		err     error
+		website string
+	}{
+		{name: "empty", profile: model.Profile{}},
+		{name: "https", profile: model.Profile{Website: " https://example.com/me "}, website: "https://example.com/me"},
+		{name: "no scheme", profile: model.Profile{Website: "example.com"}, website: "https://example.com"},
+		{name: "other scheme", profile: model.Profile{Website: "javascript:alert(1)"}, err: ErrInvalidWebsite},
+		{name: "ftp", profile: model.Profile{Website: "ftp://example.com"}, err: ErrInvalidWebsite},
+		{name: "no host", profile: model.Profile{Website: "https://"}, err: ErrInvalidWebsite},
+		{name: "display name", profile: model.Profile{DisplayName: strings.Repeat("é", 51)}, err: ErrProfileTooLong},
+		{name: "display name fits", profile: model.Profile{DisplayName: strings.Repeat("é", 50)}},
+		{name: "signature", profile: model.Profile{Signature: strings.Repeat("s", 301)}, err: ErrProfileTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stub := new(profileStub)
+			s := NewProfileService(stub, nil, nil)
+
+			profile := tt.profile
+			err := s.UpdateProfile(context.Background(), &profile)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				if stub.saved != nil {
+					t.Error("expected an invalid profile not to be saved")
+				}
+				return
+			}
+			if stub.saved == nil || stub.saved.Website != tt.website || stub.saved.UpdatedAt.IsZero() {
+				t.Errorf("expected website %q and the time of the update, got %+v", tt.website, stub.saved)
+			}
+		})
+	}
+}
+
+func TestProfileService_GetProfile(t *testing.T) {
+	t.Parallel()
+
+	s := NewProfileService(new(profileStub), nil, nil)
+
+	profile, err := s.GetProfile(context.Background(), 7)
+	if err != nil || profile == nil || profile.UserID != 7 || profile.ShowEmail {
+		t.Errorf("expected an empty profile hiding the email, got %+v, %v", profile, err)
+	}
+}
+
+func TestProfileService_SetAvatar(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	blobs, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create blob store: %v", err)
+	}
+	stub := new(profileStub)
+	s := NewProfileService(stub, nil, blobs).WithMaxAvatarBytes(1 << 20)
+
+	picture := func(w, h int) []byte {
+		var buf bytes.Buffer
+		_ = png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)))
+		return buf.Bytes()
+	}
+
+	first, err := s.SetAvatar(ctx, 7, bytes.NewReader(picture(40, 30)))
+	if err != nil || first.Avatar == "" || stub.saved.Avatar != first.Avatar {
+		t.Fatalf("expected an avatar to be saved, got %+v, %v", first, err)
+	}
+	for _, size := range model.AvatarSizes {
+		r, _, err := s.OpenAvatar(ctx, first.AvatarFile(size))
+		if err != nil {
+			t.Fatalf("expected avatar of size %d, got %v", size, err)
+		}
+		r.Close()
+	}
+
+	second, err := s.SetAvatar(ctx, 7, bytes.NewReader(picture(30, 40)))
+	if err != nil || second.Avatar == first.Avatar {
+		t.Fatalf("expected another avatar, got %+v, %v", second, err)
+	}
+	if _, _, err = s.OpenAvatar(ctx, first.AvatarFile(64)); !errors.Is(err, ErrAvatarNotFound) {
+		t.Errorf("expected the replaced avatar to be deleted, got %v", err)
+	}
+
+	_, err = s.SetAvatar(ctx, 7, io.LimitReader(zeros{}, 2<<20))
+	if !errors.Is(err, ErrAvatarTooLarge) {
+		t.Errorf("expected ErrAvatarTooLarge, got %v", err)
+	}
+	_, err = s.SetAvatar(ctx, 7, strings.NewReader("\x89PNG\r\n\x1a\n, but not really"))
+	if !errors.Is(err, avatar.ErrInvalidImage) {
+		t.Errorf("expected avatar.ErrInvalidImage, got %v", err)
+	}
+	if stub.saved.Avatar != second.Avatar {
+		t.Errorf("expected failed uploads to keep the avatar, got %q", stub.saved.Avatar)
+	}
+
+	removed, err := s.RemoveAvatar(ctx, 7)
+	if err != nil || removed.Avatar != "" || stub.saved.Avatar != "" {
+		t.Errorf("expected the avatar to be removed, got %+v, %v", removed, err)
+	}
+	if _, _, err = s.OpenAvatar(ctx, second.AvatarFile(64)); !errors.Is(err, ErrAvatarNotFound) {
+		t.Errorf("expected the removed avatar to be deleted, got %v", err)
+	}
+
+	for _, file := range []string{"../7-ab-64.jpg", "7-ab-64.png", ""} {
+		if _, _, err = s.OpenAvatar(ctx, file); !errors.Is(err, ErrAvatarNotFound) {
+			t.Errorf("%q: expected ErrAvatarNotFound, got %v", file, err)
+		}
+	}
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
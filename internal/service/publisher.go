@@ -0,0 +1,12 @@
+package service
+
+import (
+	"context"
+	"simple-forum/internal/events"
+)
+
+// Publisher announces what the services changed to the rest of the forum,
+// on this instance and the others.
+type Publisher interface {
+	Publish(ctx context.Context, event events.Event)
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"simple-forum/internal/model"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeRead     = "read"
+	ScopePost     = "post"
+	ScopeModerate = "moderate"
+
+	// tokenPrefix makes tokens recognizable to secret scanners.
+	tokenPrefix = "sfp_"
+)
+
+var Scopes = []string{ScopeRead, ScopePost, ScopeModerate}
+
+var (
+	ErrEmptyTokenName = errors.New("token name cannot be empty")
+	ErrNoScopes       = errors.New("at least one scope is required")
+	ErrInvalidScope   = errors.New("invalid scope")
+	ErrInvalidToken   = errors.New("invalid token")
+	ErrTokenExpired   = errors.New("token expired")
+)
+
+type TokenStorage interface {
+	GetTokensByUserID(ctx context.Context, userID int) ([]*model.AccessToken, error)
+	GetTokenByHash(ctx context.Context, hash string) (*model.AccessToken, error)
+	InsertToken(ctx context.Context, token *model.AccessToken) (int, error)
+	TouchToken(ctx context.Context, id int, usedAt time.Time) error
+	DeleteToken(ctx context.Context, id, userID int) error
+}
+
+type TokenService struct {
+	repository TokenStorage
+	users      UserStorage
+}
+
+func NewTokenService(repository TokenStorage, users UserStorage) *TokenService {
+	return &TokenService{repository: repository, users: users}
+}
+
+func (t *TokenService) GetTokensByUserID(ctx context.Context, userID int) ([]*model.AccessToken, error) {
+	ctx, span := tracer.Start(ctx, "TokenService.GetTokensByUserID")
+	defer span.End()
+
+	tokens, err := t.repository.GetTokensByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// CreateToken stores a new token for userID and returns it along with its
+// value, which cannot be recovered later. A nil expiresAt creates a token
+// that never expires.
+func (t *TokenService) CreateToken(ctx context.Context, userID int, name string, scopes []string, expiresAt *time.Time) (*model.AccessToken, string, error) {
+	ctx, span := tracer.Start(ctx, "TokenService.CreateToken")
+	defer span.End()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrEmptyTokenName
+	}
+
+	if len(scopes) == 0 {
+		return nil, "", ErrNoScopes
+	}
+
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopePost && scope != ScopeModerate {
+			return nil, "", ErrInvalidScope
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	value := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	token := &model.AccessToken{
+		UserID:    userID,
+		Name:      name,
+		Hash:      hashToken(value),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	id, err := t.repository.InsertToken(ctx, token)
+	if err != nil {
+		return nil, "", err
+	}
+	token.ID = id
+
+	return token, value, nil
+}
+
+func (t *TokenService) RevokeToken(ctx context.Context, userID, tokenID int) error {
+	ctx, span := tracer.Start(ctx, "TokenService.RevokeToken")
+	defer span.End()
+
+	err := t.repository.DeleteToken(ctx, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Authenticate resolves a token value to its owner and records its use.
+func (t *TokenService) Authenticate(ctx context.Context, value string) (*model.User, *model.AccessToken, error) {
+	ctx, span := tracer.Start(ctx, "TokenService.Authenticate")
+	defer span.End()
+
+	if !strings.HasPrefix(value, tokenPrefix) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	token, err := t.repository.GetTokenByHash(ctx, hashToken(value))
+	if err != nil {
+		return nil, nil, err
+	}
+	if token == nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if token.Expired(now) {
+		return nil, nil, ErrTokenExpired
+	}
+
+	user, err := t.users.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	err = t.repository.TouchToken(ctx, token.ID, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	token.LastUsedAt = &now
+
+	return user, token, nil
+}
+
+func hashToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
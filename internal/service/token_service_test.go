@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"simple-forum/internal/model"
+	"simple-forum/internal/repository/memory"
+	"testing"
+	"time"
+)
+
+func TestTokenService_CreateToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := memory.NewStore()
+	users := memory.NewUserRepository(store)
+	s := NewTokenService(memory.NewTokenRepository(store), users)
+
+	user := &model.User{Name: "ada", Email: "ada@example.com", PasswordHash: "hash", CreatedAt: time.Now(), Role: "user"}
+	_, err := users.InsertUser(ctx, user)
+	if err != nil {
+		t.Fatalf("unable to insert user: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		tokenName string
+		scopes    []string
+		err       error
+	}{
+		{name: "read", tokenName: "cli", scopes: []string{ScopeRead}},
+		{name: "all scopes", tokenName: "bot", scopes: Scopes},
+		{name: "blank name", tokenName: " ", scopes: []string{ScopeRead}, err: ErrEmptyTokenName},
+		{name: "no scopes", tokenName: "cli", err: ErrNoScopes},
+		{name: "unknown scope", tokenName: "cli", scopes: []string{ScopeRead, "admin"}, err: ErrInvalidScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, value, err := s.CreateToken(ctx, user.ID, tt.tokenName, tt.scopes, nil)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if token.Hash == value || token.Hash != hashToken(value) {
+				t.Errorf("expected the hash of the value to be stored, got %q", token.Hash)
+			}
+		})
+	}
+}
+
+func TestTokenService_Authenticate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := memory.NewStore()
+	users := memory.NewUserRepository(store)
+	tokens := memory.NewTokenRepository(store)
+	s := NewTokenService(tokens, users)
+
+	user := &model.User{Name: "ada", Email: "ada@example.com", PasswordHash: "hash", CreatedAt: time.Now(), Role: "admin"}
+	_, err := users.InsertUser(ctx, user)
+	if err != nil {
+		t.Fatalf("unable to insert user: %v", err)
+	}
+
+	newToken := func(expiresAt *time.Time) (*model.AccessToken, string) {
+		token, value, err := s.CreateToken(ctx, user.ID, "cli", []string{ScopeRead, ScopeModerate}, expiresAt)
+		if err != nil {
+			t.Fatalf("unable to create token: %v", err)
+		}
+		return token, value
+	}
+
+	tomorrow := time.Now().Add(24 * time.Hour)
+	yesterday := time.Now().Add(-24 * time.Hour)
+
+	_, valid := newToken(nil)
+	_, expiring := newToken(&tomorrow)
+	_, expired := newToken(&yesterday)
+	revokedToken, revoked := newToken(nil)
+	if err = s.RevokeToken(ctx, user.ID, revokedToken.ID); err != nil {
+		t.Fatalf("unable to revoke token: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{name: "valid", value: valid},
+		{name: "not expired yet", value: expiring},
+		{name: "expired", value: expired, err: ErrTokenExpired},
+		{name: "revoked", value: revoked, err: ErrInvalidToken},
+		{name: "unknown", value: tokenPrefix + "unknown", err: ErrInvalidToken},
+		{name: "no prefix", value: valid[len(tokenPrefix):], err: ErrInvalidToken},
+		{name: "empty", value: "", err: ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, token, err := s.Authenticate(ctx, tt.value)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				if owner != nil || token != nil {
+					t.Errorf("expected no owner or token, got %+v, %+v", owner, token)
+				}
+				return
+			}
+			if owner.ID != user.ID || owner.Role != "admin" {
+				t.Errorf("expected the owner to be ada, got %+v", owner)
+			}
+			if !token.HasScope(ScopeModerate) || token.HasScope(ScopePost) {
+				t.Errorf("expected the read and moderate scopes, got %v", token.Scopes)
+			}
+			if token.LastUsedAt == nil {
+				t.Error("expected the use to be recorded")
+			}
+		})
+	}
+}
@@ -1,89 +1,156 @@
 package service
 
 import (
+	"context"
+	"simple-forum/internal/events"
 	"simple-forum/internal/model"
 	"time"
 )
 
 type TopicStorage interface {
-	GetAllTopics() ([]*model.Topic, error)
-	GetTopicByID(topicID int) (*model.Topic, error)
-	GetTopicByPostID(postID int) (*model.Topic, error)
-	InsertTopic(topic *model.Topic) (int, error)
-	UpdateTopic(topic *model.Topic) error
-	DeleteTopic(topic *model.Topic) error
+	GetAllTopics(ctx context.Context) ([]*model.Topic, error)
+	GetPublicTopicsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Topic, error)
+	GetTopicByID(ctx context.Context, topicID int) (*model.Topic, error)
+	GetTopicByPostID(ctx context.Context, postID int) (*model.Topic, error)
+	GetTopicWithPosts(ctx context.Context, topicID int) (*model.Topic, []*model.Post, error)
+	InsertTopic(ctx context.Context, topic *model.Topic) (int, error)
+	UpdateTopic(ctx context.Context, topic *model.Topic) error
+	DeleteTopic(ctx context.Context, topic *model.Topic) error
 }
 
 type TopicService struct {
 	repository TopicStorage
+	tx         Transactor
+	events     Publisher
 }
 
-func NewTopicService(repository TopicStorage) *TopicService {
-	return &TopicService{repository: repository}
+func NewTopicService(repository TopicStorage, tx Transactor, events Publisher) *TopicService {
+	return &TopicService{repository: repository, tx: tx, events: events}
 }
 
-func (t *TopicService) GetAllTopics() ([]*model.Topic, error) {
-	topics, err := t.repository.GetAllTopics()
+func (t *TopicService) GetAllTopics(ctx context.Context) ([]*model.Topic, error) {
+	ctx, span := tracer.Start(ctx, "TopicService.GetAllTopics")
+	defer span.End()
+
+	topics, err := t.repository.GetAllTopics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+// GetPublicTopicsByAuthorID returns up to limit of the newest topics the
+// author started, leaving out private ones.
+func (t *TopicService) GetPublicTopicsByAuthorID(ctx context.Context, authorID, limit int) ([]*model.Topic, error) {
+	ctx, span := tracer.Start(ctx, "TopicService.GetPublicTopicsByAuthorID")
+	defer span.End()
+
+	topics, err := t.repository.GetPublicTopicsByAuthorID(ctx, authorID, limit)
 	if err != nil {
 		return nil, err
 	}
 	return topics, nil
 }
 
-func (t *TopicService) GetTopicByID(id int) (*model.Topic, error) {
-	topic, err := t.repository.GetTopicByID(id)
+func (t *TopicService) GetTopicByID(ctx context.Context, id int) (*model.Topic, error) {
+	ctx, span := tracer.Start(ctx, "TopicService.GetTopicByID")
+	defer span.End()
+
+	topic, err := t.repository.GetTopicByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 	return topic, nil
 }
 
-func (t *TopicService) GetTopicByPostID(id int) (*model.Topic, error) {
-	topic, err := t.repository.GetTopicByPostID(id)
+// GetTopicWithPosts returns the topic with the given id and its posts,
+// loaded together where the storage can.
+func (t *TopicService) GetTopicWithPosts(ctx context.Context, id int) (*model.Topic, []*model.Post, error) {
+	ctx, span := tracer.Start(ctx, "TopicService.GetTopicWithPosts")
+	defer span.End()
+
+	topic, posts, err := t.repository.GetTopicWithPosts(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return topic, posts, nil
+}
+
+func (t *TopicService) GetTopicByPostID(ctx context.Context, id int) (*model.Topic, error) {
+	ctx, span := tracer.Start(ctx, "TopicService.GetTopicByPostID")
+	defer span.End()
+
+	topic, err := t.repository.GetTopicByPostID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 	return topic, nil
 }
 
-func (t *TopicService) CreateTopic(name, description string, authorID int) error {
+func (t *TopicService) CreateTopic(ctx context.Context, name, description string, private bool, authorID int) (*model.Topic, error) {
+	ctx, span := tracer.Start(ctx, "TopicService.CreateTopic")
+	defer span.End()
+
 	topic := &model.Topic{
 		Name:        name,
 		Description: description,
 		AuthorId:    authorID,
 		CreatedAt:   time.Now(),
+		Private:     private,
 	}
 
-	_, err := t.repository.InsertTopic(topic)
+	id, err := t.repository.InsertTopic(ctx, topic)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	topic.ID = id
 
-	return nil
+	t.events.Publish(ctx, events.TopicCreated{TopicID: topic.ID})
+	return topic, nil
 }
 
-func (t *TopicService) EditTopic(id int, name, description string) error {
-	topic := &model.Topic{
-		ID:          id,
-		Name:        name,
-		Description: description,
-	}
+func (t *TopicService) EditTopic(ctx context.Context, id int, name, description string, private bool) (*model.Topic, error) {
+	ctx, span := tracer.Start(ctx, "TopicService.EditTopic")
+	defer span.End()
 
-	err := t.repository.UpdateTopic(topic)
+	var topic *model.Topic
+
+	err := t.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		topic, err = t.repository.GetTopicByID(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		topic.Name = name
+		topic.Description = description
+		topic.Private = private
+
+		return t.repository.UpdateTopic(ctx, topic)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+
+	t.events.Publish(ctx, events.TopicUpdated{TopicID: topic.ID})
+	return topic, nil
 }
 
-func (t *TopicService) DeleteTopic(id int) error {
-	topic, err := t.repository.GetTopicByID(id)
-	if err != nil {
-		return err
-	}
-	err = t.repository.DeleteTopic(topic)
+func (t *TopicService) DeleteTopic(ctx context.Context, id int) error {
+	ctx, span := tracer.Start(ctx, "TopicService.DeleteTopic")
+	defer span.End()
+
+	err := t.tx.WithinTx(ctx, func(ctx context.Context) error {
+		topic, err := t.repository.GetTopicByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		return t.repository.DeleteTopic(ctx, topic)
+	})
 	if err != nil {
 		return err
 	}
+
+	t.events.Publish(ctx, events.TopicDeleted{TopicID: id})
 	return nil
 }
@@ -0,0 +1,7 @@
+package service
+
+import "go.opentelemetry.io/otel"
+
+// tracer starts a span for every service method. It uses the global
+// provider, so spans are dropped until tracing is set up.
+var tracer = otel.Tracer("simple-forum/internal/service")
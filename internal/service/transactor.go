@@ -0,0 +1,10 @@
+package service
+
+import "context"
+
+// Transactor runs fn atomically. Storage methods called with the ctx passed
+// to fn take part in the same transaction, and fn may be run again if the
+// transaction conflicts with another one.
+type Transactor interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -1,95 +1,364 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/bcrypt"
+	"regexp"
+	"simple-forum/internal/metrics"
 	"simple-forum/internal/model"
+	"strings"
+	"sync"
 	"time"
 )
 
 var (
 	ErrUserNotFound           = errors.New("user not found")
-	ErrWrongPassword          = errors.New("wrong password")
 	ErrMismatchPassword       = errors.New("passwords do not match")
 	ErrUserEmailAlreadyExists = errors.New("user email already exists")
 	ErrUserNameAlreadyExists  = errors.New("username already exists")
+	ErrIdentityNotLinked      = errors.New("external identity is not linked to a user")
 )
 
+// suggestionLimit is how many usernames SuggestUsernames returns.
+const suggestionLimit = 10
+
+var usernameDisallowed = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// dummyHash is compared against when the email is unknown, so that the
+// response time does not reveal whether an account exists.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
 type UserStorage interface {
-	GetUserByEmail(email string) (*model.User, error)
-	GetUserByUsername(username string) (*model.User, error)
-	GetUserByID(id int) (*model.User, error)
-	InsertUser(user *model.User) (int, error)
+	GetUserByEmail(ctx context.Context, email string) (*model.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*model.User, error)
+	GetUserByID(ctx context.Context, id int) (*model.User, error)
+	GetUsernamesByPrefix(ctx context.Context, prefix string, limit int) ([]string, error)
+	InsertUser(ctx context.Context, user *model.User) (int, error)
+	UpdateUserRole(ctx context.Context, id int, role string) error
+}
+
+type IdentityStorage interface {
+	GetIdentity(ctx context.Context, provider, subject string) (*model.Identity, error)
+	InsertIdentity(ctx context.Context, identity *model.Identity) (int, error)
+}
+
+// IdentityPolicy controls what happens when someone signs in through an
+// external identity provider.
+type IdentityPolicy struct {
+	// AutoProvision creates a forum account for identities that match no user.
+	AutoProvision bool
+	// RoleMapping maps provider group names to forum roles. When set, it
+	// decides the role on every sign-in; users in no mapped group get "user".
+	RoleMapping map[string]string
 }
 
 type UserService struct {
 	repository UserStorage
+	identities IdentityStorage
+	policy     IdentityPolicy
+	guard      *LoginGuard
+	tx         Transactor
 }
 
-func NewUserService(repository UserStorage) *UserService {
+func NewUserService(repository UserStorage, identities IdentityStorage, policy IdentityPolicy,
+	guard *LoginGuard, tx Transactor) *UserService {
 	return &UserService{
 		repository: repository,
+		identities: identities,
+		policy:     policy,
+		guard:      guard,
+		tx:         tx,
 	}
 }
 
-func (u *UserService) Login(email, password string) (*model.User, error) {
-	user, err := u.repository.GetUserByEmail(email)
+// Login checks the password of the account with email. Unknown emails and
+// wrong passwords both return ErrInvalidCredentials; repeated failures from
+// ip or for email turn further attempts away for a while and then lock them
+// out, returning a LoginRetryError.
+func (u *UserService) Login(ctx context.Context, email, password, ip string) (*model.User, error) {
+	ctx, span := tracer.Start(ctx, "UserService.Login")
+	defer span.End()
+
+	err := u.guard.Check(ctx, email, ip)
 	if err != nil {
+		if errors.Is(err, ErrLoginLocked) || errors.Is(err, ErrLoginThrottled) {
+			metrics.Logins.WithLabelValues(metrics.LoginBlocked).Inc()
+		}
 		return nil, err
 	}
 
-	if user == nil {
-		return nil, ErrUserNotFound
+	user, err := u.repository.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	hash := dummyHash()
+	if user != nil {
+		hash = []byte(user.PasswordHash)
+	}
+
+	err = bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if err != nil || user == nil {
+		// a client hanging up must not keep the failure from being counted
+		err = u.guard.Fail(context.WithoutCancel(ctx), email, ip, user)
+		if err != nil {
+			return nil, err
+		}
+		metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+		return nil, ErrInvalidCredentials
+	}
+
+	err = u.guard.Succeed(ctx, email)
 	if err != nil {
-		return nil, ErrWrongPassword
+		return nil, err
 	}
 
+	metrics.Logins.WithLabelValues(metrics.LoginSuccess).Inc()
+
 	return user, nil
 }
 
-func (u *UserService) Register(username, email, password1, password2 string) error {
+func (u *UserService) Register(ctx context.Context, username, email, password1, password2 string) error {
+	ctx, span := tracer.Start(ctx, "UserService.Register")
+	defer span.End()
+
 	if password1 != password2 {
 		return ErrMismatchPassword
 	}
 
-	existingUserByUsername, err := u.repository.GetUserByUsername(username)
+	// hashed up front, the transaction may run more than once
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password1), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
-	if existingUserByUsername != nil {
-		return ErrUserNameAlreadyExists
-	}
 
-	existingUserByEmail, err := u.repository.GetUserByEmail(email)
+	err = u.tx.WithinTx(ctx, func(ctx context.Context) error {
+		existingUserByUsername, err := u.repository.GetUserByUsername(ctx, username)
+		if err != nil {
+			return err
+		}
+		if existingUserByUsername != nil {
+			return ErrUserNameAlreadyExists
+		}
+
+		existingUserByEmail, err := u.repository.GetUserByEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+		if existingUserByEmail != nil {
+			return ErrUserEmailAlreadyExists
+		}
+
+		user := &model.User{
+			Name:         username,
+			Email:        email,
+			Role:         "user",
+			PasswordHash: string(hashedPassword),
+			CreatedAt:    time.Now(),
+		}
+
+		_, err = u.repository.InsertUser(ctx, user)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	if existingUserByEmail != nil {
-		return ErrUserEmailAlreadyExists
+
+	metrics.Registrations.Inc()
+
+	return nil
+}
+
+// LoginWithIdentity resolves the forum user behind an external identity. An
+// unknown identity is linked to the user with the same verified email or, if
+// allowed by the policy, to a newly provisioned user. The role is then set
+// from the provider groups, see applyRoleMapping, and the role the user had
+// before is returned along with them.
+func (u *UserService) LoginWithIdentity(ctx context.Context, external *model.ExternalIdentity) (*model.User, string, error) {
+	ctx, span := tracer.Start(ctx, "UserService.LoginWithIdentity")
+	defer span.End()
+
+	identity, err := u.identities.GetIdentity(ctx, external.Provider, external.Subject)
+	if err != nil {
+		return nil, "", err
 	}
 
-	user := &model.User{
-		Name:      username,
-		Email:     email,
-		Role:      "user",
+	var user *model.User
+
+	if identity != nil {
+		user, err = u.repository.GetUserByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, "", err
+		}
+		if user == nil {
+			return nil, "", ErrUserNotFound
+		}
+	} else {
+		// the user may be created along with the link, so both or neither are stored
+		err = u.tx.WithinTx(ctx, func(ctx context.Context) error {
+			user, err = u.linkIdentity(ctx, external)
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// the login only counts once the role is settled, as it fails otherwise
+	previousRole, err := u.applyRoleMapping(ctx, user, external.Groups)
+	if err != nil {
+		return nil, "", err
+	}
+
+	metrics.Logins.WithLabelValues(metrics.LoginSuccess).Inc()
+
+	return user, previousRole, nil
+}
+
+// applyRoleMapping sets the role of user from the provider groups if the
+// policy maps groups to roles. It returns the role the user had before.
+func (u *UserService) applyRoleMapping(ctx context.Context, user *model.User, groups []string) (string, error) {
+	previous := user.Role
+
+	if len(u.policy.RoleMapping) == 0 {
+		return previous, nil
+	}
+
+	role := u.mapRole(groups)
+	if role == previous {
+		return previous, nil
+	}
+
+	err := u.repository.UpdateUserRole(ctx, user.ID, role)
+	if err != nil {
+		return previous, err
+	}
+	user.Role = role
+
+	return previous, nil
+}
+
+// SuggestUsernames returns up to ten usernames starting with prefix, an
+// @ in front of it left out, for completing mentions.
+func (u *UserService) SuggestUsernames(ctx context.Context, prefix string) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "UserService.SuggestUsernames")
+	defer span.End()
+
+	prefix = strings.TrimPrefix(strings.TrimSpace(prefix), "@")
+	if prefix == "" || usernameDisallowed.MatchString(prefix) {
+		return nil, nil
+	}
+	return u.repository.GetUsernamesByPrefix(ctx, prefix, suggestionLimit)
+}
+
+func (u *UserService) linkIdentity(ctx context.Context, external *model.ExternalIdentity) (*model.User, error) {
+	var user *model.User
+
+	if external.Email != "" && external.EmailVerified {
+		existing, err := u.repository.GetUserByEmail(ctx, external.Email)
+		if err != nil {
+			return nil, err
+		}
+		user = existing
+	}
+
+	if user == nil {
+		if !u.policy.AutoProvision || external.Email == "" {
+			return nil, ErrIdentityNotLinked
+		}
+
+		provisioned, err := u.provisionUser(ctx, external)
+		if err != nil {
+			return nil, err
+		}
+		user = provisioned
+	}
+
+	identity := &model.Identity{
+		UserID:    user.ID,
+		Provider:  external.Provider,
+		Subject:   external.Subject,
+		Email:     external.Email,
 		CreatedAt: time.Now(),
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password1), bcrypt.DefaultCost)
+	_, err := u.identities.InsertIdentity(ctx, identity)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// provisionUser creates a password-less user. The empty hash never matches
+// in Login, so the account can only be used through its identity provider.
+func (u *UserService) provisionUser(ctx context.Context, external *model.ExternalIdentity) (*model.User, error) {
+	existing, err := u.repository.GetUserByEmail(ctx, external.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrUserEmailAlreadyExists
 	}
 
-	user.PasswordHash = string(hashedPassword)
+	base := external.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(external.Email, "@")
+	}
+	base = usernameDisallowed.ReplaceAllString(base, "")
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	if base == "" {
+		base = "user"
+	}
 
-	_, err = u.repository.InsertUser(user)
+	username := base
+	for i := 2; ; i++ {
+		taken, err := u.repository.GetUserByUsername(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		if taken == nil {
+			break
+		}
+		if i > 100 {
+			return nil, ErrUserNameAlreadyExists
+		}
+		username = fmt.Sprintf("%s-%d", base, i)
+	}
 
+	user := &model.User{
+		Name:      username,
+		Email:     external.Email,
+		Role:      "user",
+		CreatedAt: time.Now(),
+	}
+
+	user.ID, err = u.repository.InsertUser(ctx, user)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return user, nil
+}
+
+func (u *UserService) mapRole(groups []string) string {
+	role := "user"
+	for _, group := range groups {
+		mapped, ok := u.policy.RoleMapping[group]
+		if !ok {
+			continue
+		}
+		if mapped == "admin" {
+			return mapped
+		}
+		role = mapped
+	}
+	return role
 }
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"simple-forum/internal/metrics"
+	"simple-forum/internal/model"
+	"simple-forum/internal/repository/memory"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// roleFailingUsers fails every role change, as a database gone away would.
+type roleFailingUsers struct {
+	UserStorage
+}
+
+func (r roleFailingUsers) UpdateUserRole(ctx context.Context, id int, role string) error {
+	return errors.New("connection refused")
+}
+
+// TestUserService_LoginWithIdentity is not parallel, as it counts logins on
+// the shared metric.
+func TestUserService_LoginWithIdentity(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	users := memory.NewUserRepository(store)
+	policy := IdentityPolicy{AutoProvision: true, RoleMapping: map[string]string{"forum-admins": "admin"}}
+
+	s := NewUserService(users, memory.NewIdentityRepository(store), policy, nil, memory.NewTransactor(store))
+	successes := metrics.Logins.WithLabelValues(metrics.LoginSuccess)
+
+	before := testutil.ToFloat64(successes)
+	external := &model.ExternalIdentity{Provider: "sso", Subject: "ada", Email: "ada@example.com", EmailVerified: true, Groups: []string{"forum-admins"}}
+	user, previous, err := s.LoginWithIdentity(ctx, external)
+	if err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+	if user.Role != "admin" || previous != "user" {
+		t.Errorf("expected the role changed from user to admin, got %q to %q", previous, user.Role)
+	}
+	if got := testutil.ToFloat64(successes) - before; got != 1 {
+		t.Errorf("expected one login counted, got %v", got)
+	}
+
+	// the identity is known now; taking the user out of the group fails to save
+	s = NewUserService(roleFailingUsers{users}, memory.NewIdentityRepository(store), policy, nil, memory.NewTransactor(store))
+	external.Groups = nil
+
+	before = testutil.ToFloat64(successes)
+	_, _, err = s.LoginWithIdentity(ctx, external)
+	if err == nil {
+		t.Error("expected the failed role change to fail the login")
+	}
+	if got := testutil.ToFloat64(successes) - before; got != 0 {
+		t.Errorf("expected the failed login not counted as a success, got %v", got)
+	}
+}
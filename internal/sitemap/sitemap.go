@@ -0,0 +1,68 @@
+// Package sitemap renders sitemaps and sitemap indexes as search engines
+// read them, see https://www.sitemaps.org/protocol.html.
+package sitemap
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// MaxURLs is the most URLs a single sitemap may list. Larger sites are
+// split into several sitemaps listed by an index.
+const MaxURLs = 50000
+
+const namespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// URL is a page of a sitemap, or a sitemap of an index. LastMod is left
+// out when it is zero.
+type URL struct {
+	Loc     string
+	LastMod time.Time
+}
+
+type urlSet struct {
+	XMLName xml.Name `xml:"urlset"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	URLs    []entry  `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name `xml:"sitemapindex"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	Sitemaps []entry  `xml:"sitemap"`
+}
+
+type entry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Sitemap renders urls as a sitemap.
+func Sitemap(urls []URL) ([]byte, error) {
+	return marshal(urlSet{XMLNS: namespace, URLs: entries(urls)})
+}
+
+// Index renders sitemaps as a sitemap index.
+func Index(sitemaps []URL) ([]byte, error) {
+	return marshal(sitemapIndex{XMLNS: namespace, Sitemaps: entries(sitemaps)})
+}
+
+func entries(urls []URL) []entry {
+	entries := make([]entry, 0, len(urls))
+	for _, u := range urls {
+		e := entry{Loc: u.Loc}
+		if !u.LastMod.IsZero() {
+			e.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func marshal(doc any) ([]byte, error) {
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
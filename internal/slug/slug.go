@@ -0,0 +1,111 @@
+// Package slug turns names and titles into the readable part of URLs, as
+// in /topics/12-go-generics. The id before the slug is what identifies a
+// page; the slug only describes it and may change with the title.
+package slug
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength is the longest a slug gets; longer ones are cut after the
+// last whole word that fits.
+const MaxLength = 60
+
+var ErrInvalidID = errors.New("invalid id")
+
+// transliterations spell letters that do not decompose into a Latin letter
+// and marks in ASCII. Keys are lower case.
+var transliterations = map[rune]string{
+	// Latin
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'ł': "l", 'þ': "th", 'ı': "i", 'ŋ': "ng",
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e", 'є': "ye", 'ж': "zh", 'з': "z",
+	'и': "i", 'і': "i", 'ї': "yi", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p",
+	'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh",
+	'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i", 'κ': "k",
+	'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t",
+	'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Make returns the slug of text: its letters and digits in lower-case
+// ASCII, with words separated by hyphens. Accents are dropped, Cyrillic and
+// Greek are transliterated, and other scripts are left out, so the slug may
+// be empty.
+func Make(text string) string {
+	var b strings.Builder
+	hyphen := false
+
+	write := func(s string) {
+		if s == "" {
+			return
+		}
+		if hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		hyphen = false
+		b.WriteString(s)
+	}
+
+	for _, r := range strings.ToLower(text) {
+		if s, ok := transliterations[r]; ok {
+			write(s)
+			continue
+		}
+
+		// é becomes e and a combining acute accent, which is dropped, and
+		// compatibility characters such as ﬁ or № are spelled out
+		for _, d := range norm.NFKD.String(string(r)) {
+			d = unicode.ToLower(d)
+			switch s, ok := transliterations[d]; {
+			case ok:
+				write(s)
+			case unicode.Is(unicode.Mn, d):
+			case 'a' <= d && d <= 'z', '0' <= d && d <= '9':
+				write(string(d))
+			default:
+				hyphen = true
+			}
+		}
+	}
+
+	return truncate(b.String())
+}
+
+func truncate(s string) string {
+	if len(s) <= MaxLength {
+		return s
+	}
+
+	s = s[:MaxLength+1]
+	if i := strings.LastIndexByte(s, '-'); i > 0 {
+		return s[:i]
+	}
+	return s[:MaxLength]
+}
+
+// WithID returns the path segment of a page: its id, followed by the slug
+// of text when it has one.
+func WithID(id int, text string) string {
+	s := Make(text)
+	if s == "" {
+		return strconv.Itoa(id)
+	}
+	return strconv.Itoa(id) + "-" + s
+}
+
+// ID returns the id of a path segment WithID made, ignoring the slug.
+func ID(segment string) (int, error) {
+	stringID, _, _ := strings.Cut(segment, "-")
+	id, err := strconv.Atoi(stringID)
+	if err != nil {
+		return 0, ErrInvalidID
+	}
+	return id, nil
+}
@@ -0,0 +1,54 @@
+package slug
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text string
+		slug string
+	}{
+		{text: "Go Generics", slug: "go-generics"},
+		{text: "  How to... use `any`?! ", slug: "how-to-use-any"},
+		{text: "Crème brûlée à la carte", slug: "creme-brulee-a-la-carte"},
+		{text: "Straße in Łódź, Ørsted", slug: "strasse-in-lodz-orsted"},
+		{text: "Привет, мир", slug: "privet-mir"},
+		{text: "Ελληνικά", slug: "ellinika"},
+		{text: "ﬁle №5", slug: "file-no5"},
+		{text: "Go 言語 入門", slug: "go"},
+		{text: "日本語", slug: ""},
+		{text: strings.Repeat("word ", 20), slug: strings.TrimSuffix(strings.Repeat("word-", 12), "-")},
+		{text: strings.Repeat("x", 80), slug: strings.Repeat("x", MaxLength)},
+	}
+
+	for _, tt := range tests {
+		if got := Make(tt.text); got != tt.slug {
+			t.Errorf("%q: expected slug %q, got %q", tt.text, tt.slug, got)
+		}
+	}
+}
+
+func TestID(t *testing.T) {
+	t.Parallel()
+
+	for _, segment := range []string{"12", "12-go-generics", WithID(12, "Go Generics")} {
+		id, err := ID(segment)
+		if err != nil || id != 12 {
+			t.Errorf("%q: expected id 12, got %d, %v", segment, id, err)
+		}
+	}
+
+	for _, segment := range []string{"", "go-generics", "-12", "12x"} {
+		if _, err := ID(segment); err == nil {
+			t.Errorf("%q: expected an error", segment)
+		}
+	}
+
+	if got := WithID(7, "日本語"); got != "7" {
+		t.Errorf("expected only the id without a slug, got %q", got)
+	}
+}
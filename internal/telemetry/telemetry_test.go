@@ -0,0 +1,95 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup_OTLP(t *testing.T) {
+	var exports atomic.Int32
+
+	// collector stand-in accepting OTLP/HTTP trace exports
+	collector := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/traces" {
+			exports.Add(1)
+		}
+		rw.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+
+	shutdown, err := Setup(context.Background(), Config{
+		ServiceName: "forum-test",
+		Exporter:    ExporterOTLP,
+		Endpoint:    collector.URL,
+		SampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "request")
+	span.End()
+
+	err = shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("no error expected, but got %s", err)
+	}
+
+	if exports.Load() == 0 {
+		t.Error("expected spans to be exported to the collector")
+	}
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	t.Parallel()
+
+	_, err := Setup(context.Background(), Config{Exporter: "zipkin"})
+	if !errors.Is(err, ErrUnknownExporter) {
+		t.Errorf("expected %s, got %v", ErrUnknownExporter, err)
+	}
+}
+
+func TestLogHandler(t *testing.T) {
+	t.Parallel()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	spanCtx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		traceID any
+	}{
+		{name: "With Span", ctx: spanCtx, traceID: traceID.String()},
+		{name: "Without Span", ctx: context.Background(), traceID: nil},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		l := slog.New(NewLogHandler(slog.NewJSONHandler(&buf, nil))).With("component", "test")
+
+		l.InfoContext(tt.ctx, "message")
+
+		var record map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatalf("%s: invalid log record: %s", tt.name, err)
+		}
+		if record["trace_id"] != tt.traceID {
+			t.Errorf("%s: expected trace_id %v, got %v", tt.name, tt.traceID, record["trace_id"])
+		}
+	}
+}
@@ -1,17 +1,27 @@
 package template
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/justinas/nosurf"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"html/template"
 	"net/http"
 	"path/filepath"
 	"simple-forum/internal/auth"
+	"simple-forum/internal/metrics"
 	"simple-forum/internal/model"
+	"strings"
+	"sync"
+	"time"
 )
 
+var tracer = otel.Tracer("simple-forum/internal/template")
+
 var (
 	ErrInvalidUser     = errors.New("invalid user type")
 	ErrInvalidRole     = errors.New("invalid role type")
@@ -22,11 +32,26 @@ type Authenticator interface {
 	GetClaimsFromRequest(r *http.Request) (jwt.MapClaims, error)
 }
 
+// UnreadCounter counts the private messages a user has not read, shown
+// next to the Messages link.
+type UnreadCounter interface {
+	CountUnread(ctx context.Context, userID int) (int, error)
+}
+
+// defaultDescription describes pages that do not describe themselves.
+const defaultDescription = "SimpleForum is a place to discuss topics and share posts."
+
 type Templates struct {
 	basePath string
 	inProd   bool
 	auther   Authenticator
-	cache    map[string]*template.Template
+	// mu guards cache, which Render replaces when it parses again
+	mu    sync.RWMutex
+	cache map[string]*template.Template
+	// baseURL makes canonical links absolute; the host of the request is
+	// used when it is empty
+	baseURL string
+	unread  UnreadCounter
 }
 
 func NewTemplates(basePath string, inProd bool, auther *auth.JWTAuthenticator) (*Templates, error) {
@@ -50,6 +75,28 @@ func NewTemplates(basePath string, inProd bool, auther *auth.JWTAuthenticator) (
 	}, nil
 }
 
+// WithBaseURL sets the public address of the forum canonical links and
+// Open Graph URLs are built from.
+func (m *Templates) WithBaseURL(baseURL string) *Templates {
+	m.baseURL = strings.TrimSuffix(baseURL, "/")
+	return m
+}
+
+// WithUnreadCounter shows signed-in users how many private messages they
+// have not read.
+func (m *Templates) WithUnreadCounter(unread UnreadCounter) *Templates {
+	m.unread = unread
+	return m
+}
+
+// Loaded reports whether the page templates have been parsed.
+func (m *Templates) Loaded() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.cache) > 0
+}
+
 func parseTemplates(basePath string) (map[string]*template.Template, error) {
 	templates := map[string]*template.Template{}
 
@@ -83,6 +130,35 @@ func parseTemplates(basePath string) (map[string]*template.Template, error) {
 	return templates, nil
 }
 
+// addMeta fills in the description, canonical link and Open Graph type of
+// td where the handler left them empty, and makes the canonical link
+// absolute.
+func (m *Templates) addMeta(td *model.Page, r *http.Request) {
+	if td.Description == "" {
+		td.Description = defaultDescription
+	}
+	if td.OGType == "" {
+		td.OGType = "website"
+	}
+	if td.Canonical == "" {
+		// the path as requested, before any prefix was stripped
+		td.Canonical, _, _ = strings.Cut(r.RequestURI, "?")
+	}
+	if strings.HasPrefix(td.Canonical, "/") {
+		td.Canonical = m.siteURL(r) + td.Canonical
+	}
+}
+
+func (m *Templates) siteURL(r *http.Request) string {
+	if m.baseURL != "" {
+		return m.baseURL
+	}
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
 func (m *Templates) addDefaultData(td *model.Page, r *http.Request) (*model.Page, error) {
 	claims, err := m.auther.GetClaimsFromRequest(r)
 	if err != nil {
@@ -117,6 +193,16 @@ func (m *Templates) addDefaultData(td *model.Page, r *http.Request) (*model.Page
 		td.IsAdmin = true
 	}
 
+	// a failed count leaves the number out rather than failing the page
+	if id, ok := user["id"].(float64); ok && m.unread != nil {
+		if count, err := m.unread.CountUnread(r.Context(), int(id)); err == nil {
+			if td.IntMap == nil {
+				td.IntMap = make(map[string]int)
+			}
+			td.IntMap["unread"] = count
+		}
+	}
+
 	td.CSRFToken = nosurf.Token(r)
 
 	return td, nil
@@ -141,20 +227,33 @@ func (m *Templates) Render(rw http.ResponseWriter, r *http.Request, tmpl string,
 		if err != nil {
 			return err
 		}
+		m.mu.Lock()
 		m.cache = templates
+		m.mu.Unlock()
 	}
 
 	// get requested template
+	m.mu.RLock()
 	rt, ok := m.cache[tmpl+".gohtml"]
+	m.mu.RUnlock()
 	if !ok {
 		return fmt.Errorf("%s.gohtml not found", tmpl)
 	}
 
+	m.addMeta(td, r)
+
 	td, err := m.addDefaultData(td, r)
 	if err != nil {
 		return err
 	}
 
 	// rendering template
-	return rt.Execute(rw, td)
+	_, span := tracer.Start(r.Context(), "Templates.Render", trace.WithAttributes(attribute.String("template", tmpl)))
+	defer span.End()
+
+	start := time.Now()
+	err = rt.Execute(rw, td)
+	metrics.TemplateDuration.WithLabelValues(tmpl).Observe(time.Since(start).Seconds())
+
+	return err
 }
@@ -0,0 +1,40 @@
+package template
+
+import (
+	"net/http/httptest"
+	"simple-forum/internal/auth"
+	"sync"
+	"testing"
+)
+
+// TestTemplates_ConcurrentRender is meant for -race: rendering parses the
+// templates again and must not race with other renders or Loaded.
+func TestTemplates_ConcurrentRender(t *testing.T) {
+	t.Parallel()
+
+	a := auth.NewJWTAuthenticator("secret", 1)
+
+	m, err := NewTemplates("../../web/templates", true, a)
+	if err != nil {
+		t.Fatalf("unable to parse templates: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rw := httptest.NewRecorder()
+			if err := m.Render(rw, httptest.NewRequest("GET", "/login", nil), "login.page", nil); err != nil {
+				t.Errorf("no error expected, but got %s", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if !m.Loaded() {
+				t.Error("expected the templates to be loaded")
+			}
+		}()
+	}
+	wg.Wait()
+}
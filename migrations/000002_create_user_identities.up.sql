@@ -0,0 +1,12 @@
+CREATE TABLE user_identities
+(
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider   VARCHAR(50)  NOT NULL,
+    subject    VARCHAR(255) NOT NULL,
+    email      VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
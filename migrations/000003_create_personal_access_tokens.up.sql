@@ -0,0 +1,13 @@
+CREATE TABLE personal_access_tokens
+(
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         VARCHAR(100) NOT NULL,
+    token_hash   CHAR(64)     NOT NULL UNIQUE,
+    scopes       VARCHAR(100) NOT NULL,
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX personal_access_tokens_user_id_idx ON personal_access_tokens (user_id);
@@ -0,0 +1,7 @@
+CREATE TABLE login_throttles
+(
+    key             VARCHAR(160) PRIMARY KEY,
+    failures        INTEGER      NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ  NOT NULL,
+    locked_until    TIMESTAMPTZ
+);
@@ -0,0 +1,31 @@
+CREATE TABLE audit_log
+(
+    id          BIGSERIAL PRIMARY KEY,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    actor_id    INTEGER,
+    actor_name  VARCHAR(50) NOT NULL DEFAULT '',
+    action      VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20) NOT NULL,
+    target_id   INTEGER,
+    before      TEXT,
+    after       TEXT,
+    ip          VARCHAR(45) NOT NULL DEFAULT '',
+    request_id  VARCHAR(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX audit_log_actor_id_idx ON audit_log (actor_id);
+CREATE INDEX audit_log_target_idx ON audit_log (target_type, target_id);
+
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE
+    ON audit_log
+    FOR EACH ROW
+EXECUTE FUNCTION audit_log_append_only();
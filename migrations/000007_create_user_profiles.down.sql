@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS topics_author_id_idx;
+DROP INDEX IF EXISTS posts_author_id_idx;
+
+DROP TABLE IF EXISTS user_profiles;
@@ -0,0 +1,17 @@
+-- post_id is set to NULL rather than deleted with the post, so that the
+-- forum can still remove the blobs of the attachments it loses
+CREATE TABLE attachments
+(
+    id           SERIAL PRIMARY KEY,
+    post_id      INTEGER      REFERENCES posts (id) ON DELETE SET NULL,
+    user_id      INTEGER      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    filename     VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size         BIGINT       NOT NULL CHECK (size >= 0),
+    sha256       CHAR(64)     NOT NULL,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX attachments_post_id_idx ON attachments (post_id);
+CREATE INDEX attachments_user_id_idx ON attachments (user_id);
+CREATE INDEX attachments_sha256_idx ON attachments (sha256);
@@ -0,0 +1,11 @@
+-- rows are kept when a mention is edited out of its post, so that nobody
+-- is notified twice about the same post
+CREATE TABLE post_mentions
+(
+    post_id    INTEGER     NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    user_id    INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (post_id, user_id)
+);
+
+CREATE INDEX post_mentions_user_id_idx ON post_mentions (user_id);
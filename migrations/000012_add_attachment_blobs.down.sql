@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS attachment_blobs;
+
+ALTER TABLE attachments DROP COLUMN IF EXISTS pending;
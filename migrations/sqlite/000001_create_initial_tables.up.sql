@@ -0,0 +1,40 @@
+-- timestamps are stored as Unix milliseconds, see database.Connect
+
+CREATE TABLE users
+(
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    username      VARCHAR(50)  UNIQUE NOT NULL,
+    email         VARCHAR(100) UNIQUE NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at    TIMESTAMP DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER)),
+    role          VARCHAR(20)  NOT NULL CHECK (role IN ('user', 'admin')) DEFAULT 'user'
+);
+
+CREATE TABLE topics
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    name        VARCHAR(255) NOT NULL,
+    description TEXT         NOT NULL,
+    created_at  TIMESTAMP    NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER)),
+    author_id   INTEGER      NOT NULL REFERENCES users (id)
+);
+
+CREATE TABLE posts
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    title       VARCHAR(255) NOT NULL,
+    content     TEXT         NOT NULL,
+    author_id   INTEGER      NOT NULL,
+    author_name VARCHAR(50)  NOT NULL,
+    topic_id    INTEGER      NOT NULL REFERENCES topics (id) ON DELETE CASCADE,
+    created_at  TIMESTAMP    NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER)),
+    updated_at  TIMESTAMP DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER))
+);
+
+INSERT INTO users (username, email, password_hash, role)
+VALUES (
+  'admin',
+  'admin@email.com',
+  '$2a$10$sMn.IWt9q3EiisAecQoOLOsvnA0wsl2oMRDGcHIrAR6XNOBVpxILK',
+  'admin'
+);
@@ -0,0 +1,12 @@
+CREATE TABLE user_identities
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider   VARCHAR(50)  NOT NULL,
+    subject    VARCHAR(255) NOT NULL,
+    email      VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER)),
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
@@ -0,0 +1,32 @@
+CREATE TABLE audit_log
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at  TIMESTAMP   NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER)),
+    actor_id    INTEGER,
+    actor_name  VARCHAR(50) NOT NULL DEFAULT '',
+    action      VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20) NOT NULL,
+    target_id   INTEGER,
+    before      TEXT,
+    after       TEXT,
+    ip          VARCHAR(45) NOT NULL DEFAULT '',
+    request_id  VARCHAR(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX audit_log_actor_id_idx ON audit_log (actor_id);
+CREATE INDEX audit_log_target_idx ON audit_log (target_type, target_id);
+
+CREATE TRIGGER audit_log_no_update
+    BEFORE UPDATE
+    ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete
+    BEFORE DELETE
+    ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
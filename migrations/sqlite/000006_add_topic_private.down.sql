@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS posts_updated_at_idx;
+
+ALTER TABLE topics DROP COLUMN private;
@@ -0,0 +1,3 @@
+ALTER TABLE topics ADD COLUMN private BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX posts_updated_at_idx ON posts (updated_at DESC);
@@ -0,0 +1,14 @@
+CREATE TABLE user_profiles
+(
+    user_id      INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    display_name VARCHAR(50)  NOT NULL DEFAULT '',
+    bio          TEXT         NOT NULL DEFAULT '',
+    location     VARCHAR(100) NOT NULL DEFAULT '',
+    website      VARCHAR(200) NOT NULL DEFAULT '',
+    signature    VARCHAR(300) NOT NULL DEFAULT '',
+    show_email   BOOLEAN      NOT NULL DEFAULT FALSE,
+    updated_at   TIMESTAMP    NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER))
+);
+
+CREATE INDEX posts_author_id_idx ON posts (author_id);
+CREATE INDEX topics_author_id_idx ON topics (author_id);
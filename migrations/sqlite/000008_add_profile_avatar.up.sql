@@ -0,0 +1 @@
+ALTER TABLE user_profiles ADD COLUMN avatar VARCHAR(64) NOT NULL DEFAULT '';
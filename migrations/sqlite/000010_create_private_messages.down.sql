@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS messaging_bans;
+DROP TABLE IF EXISTS user_blocks;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversation_participants;
+DROP TABLE IF EXISTS conversations;
@@ -0,0 +1,53 @@
+CREATE TABLE conversations
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    subject         VARCHAR(150) NOT NULL,
+    created_at      TIMESTAMP    NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER)),
+    last_message_at TIMESTAMP    NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER))
+);
+
+-- last_read_id is the newest message the participant has seen; everything
+-- after it from someone else is unread
+CREATE TABLE conversation_participants
+(
+    conversation_id INTEGER     NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+    user_id         INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    last_read_id    INTEGER     NOT NULL DEFAULT 0,
+    muted           BOOLEAN     NOT NULL DEFAULT FALSE,
+    left_at         TIMESTAMP,
+    joined_at       TIMESTAMP   NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER)),
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX conversation_participants_user_id_idx ON conversation_participants (user_id);
+
+CREATE TABLE messages
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_id INTEGER     NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+    user_id         INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    body            TEXT        NOT NULL,
+    created_at      TIMESTAMP   NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER))
+);
+
+CREATE INDEX messages_conversation_id_idx ON messages (conversation_id, id);
+
+-- blocked_user_id may not message user_id
+CREATE TABLE user_blocks
+(
+    user_id         INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    blocked_user_id INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at      TIMESTAMP   NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER)),
+    PRIMARY KEY (user_id, blocked_user_id),
+    CHECK (user_id <> blocked_user_id)
+);
+
+CREATE INDEX user_blocks_blocked_user_id_idx ON user_blocks (blocked_user_id);
+
+CREATE TABLE messaging_bans
+(
+    user_id    INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    banned_by  INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    reason     VARCHAR(200) NOT NULL DEFAULT '',
+    created_at TIMESTAMP    NOT NULL DEFAULT (CAST(unixepoch('subsec') * 1000 AS INTEGER))
+);
@@ -0,0 +1,12 @@
+-- attachments are saved as pending before their post exists, so that they
+-- count against the quota and keep their blob from the start
+ALTER TABLE attachments ADD COLUMN pending BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- one row per stored blob. Storing an attachment writes the row of its
+-- blob and removing the blob deletes it, so the two cannot interleave
+CREATE TABLE attachment_blobs
+(
+    sha256 CHAR(64) PRIMARY KEY
+);
+
+INSERT INTO attachment_blobs (sha256) SELECT DISTINCT sha256 FROM attachments;